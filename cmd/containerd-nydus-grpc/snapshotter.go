@@ -17,6 +17,8 @@ import (
 
 	"github.com/containerd/nydus-snapshotter/config"
 	"github.com/containerd/nydus-snapshotter/pkg/auth"
+	"github.com/containerd/nydus-snapshotter/pkg/events"
+	"github.com/containerd/nydus-snapshotter/pkg/tracing"
 	"github.com/containerd/nydus-snapshotter/pkg/utils/signals"
 	"github.com/containerd/nydus-snapshotter/snapshot"
 
@@ -30,6 +32,23 @@ import (
 func Start(ctx context.Context, cfg *config.SnapshotterConfig) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+
+	// Start publishing events before daemons are recovered by the snapshotter.
+	if err := events.Init(ctx, cfg.EventsConfig); err != nil {
+		return errors.Wrap(err, "init event publisher")
+	}
+
+	shutdownTracing, err := tracing.Init(ctx, cfg.TracingConfig)
+	if err != nil {
+		return errors.Wrap(err, "init tracing")
+	}
+	defer func() {
+		// Flush pending spans even if ctx has been canceled.
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.L.WithError(err).Warn("failed to shut down tracing")
+		}
+	}()
+
 	rs, err := snapshot.NewSnapshotter(ctx, cfg)
 	if err != nil {
 		return errors.Wrap(err, "failed to initialize snapshotter")
@@ -42,6 +61,7 @@ func Start(ctx context.Context, cfg *config.SnapshotterConfig) error {
 		ListeningSocketGID:  cfg.GID,
 		EnableCRIKeychain:   cfg.RemoteConfig.AuthConfig.EnableCRIKeychain,
 		ImageServiceAddress: cfg.RemoteConfig.AuthConfig.ImageServiceAddress,
+		ServerOptions:       tracing.ServerOptions(cfg.TracingConfig),
 	}
 
 	if cfg.RemoteConfig.AuthConfig.EnableKubeconfigKeychain {
@@ -50,6 +70,13 @@ func Start(ctx context.Context, cfg *config.SnapshotterConfig) error {
 		}
 	}
 
+	if authConfig := cfg.RemoteConfig.AuthConfig; authConfig.EnableKubeletCredentialProviders {
+		if err := auth.InitKubeletCredentialProviders(authConfig.KubeletCredentialProviderConfig,
+			authConfig.KubeletCredentialProviderBinDir); err != nil {
+			return errors.Wrap(err, "init kubelet credential providers")
+		}
+	}
+
 	return Serve(ctx, rs, opt, stopSignal)
 }
 
@@ -59,6 +86,7 @@ type ServeOptions struct {
 	ListeningSocketGID  int
 	EnableCRIKeychain   bool
 	ImageServiceAddress string
+	ServerOptions       []grpc.ServerOption
 }
 
 func Serve(ctx context.Context, sn snapshots.Snapshotter, options ServeOptions, stop <-chan struct{}) error {
@@ -66,7 +94,7 @@ func Serve(ctx context.Context, sn snapshots.Snapshotter, options ServeOptions,
 	if err != nil {
 		return err
 	}
-	rpc := grpc.NewServer()
+	rpc := grpc.NewServer(options.ServerOptions...)
 	if rpc == nil {
 		return errors.New("start gRPC server")
 	}
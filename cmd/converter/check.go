@@ -0,0 +1,198 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/pkg/archive/compression"
+	"github.com/containerd/log"
+	"github.com/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/containerd/nydus-snapshotter/pkg/encryption"
+)
+
+const encryptionKeysAnnotationPrefix = "org.opencontainers.image.enc.keys."
+
+// Check validates all nydus manifests of the image matching the platform.
+func check(ctx context.Context, cs content.Store, desc ocispec.Descriptor, platformMC platforms.MatchComparer) error {
+	var manifests []ocispec.Descriptor
+
+	switch {
+	case images.IsIndexType(desc.MediaType):
+		var index ocispec.Index
+		if err := readJSON(ctx, cs, &index, desc); err != nil {
+			return errors.Wrap(err, "read image index")
+		}
+		for _, m := range index.Manifests {
+			if !images.IsManifestType(m.MediaType) {
+				continue
+			}
+			if m.Platform != nil && !platformMC.Match(*m.Platform) {
+				continue
+			}
+			// The original OCI manifest is kept beside the nydus one
+			// in an index produced with `--merge-manifest`.
+			if m.Platform != nil && !hasNydusFeature(m.Platform) && len(index.Manifests) > 1 {
+				continue
+			}
+			manifests = append(manifests, m)
+		}
+	case images.IsManifestType(desc.MediaType):
+		manifests = append(manifests, desc)
+	default:
+		return errors.Errorf("unsupported media type %s", desc.MediaType)
+	}
+
+	if len(manifests) == 0 {
+		return errors.New("no nydus manifest found for the platform")
+	}
+
+	for _, m := range manifests {
+		if err := checkManifest(ctx, cs, m); err != nil {
+			return errors.Wrapf(err, "check manifest %s", m.Digest)
+		}
+		log.L.Infof("manifest %s is a valid nydus manifest", m.Digest)
+	}
+
+	return nil
+}
+
+func checkManifest(ctx context.Context, cs content.Store, desc ocispec.Descriptor) error {
+	var manifest ocispec.Manifest
+	if err := readJSON(ctx, cs, &manifest, desc); err != nil {
+		return errors.Wrap(err, "read manifest")
+	}
+
+	if len(manifest.Layers) == 0 {
+		return errors.New("no layer in manifest")
+	}
+	bootstrap := manifest.Layers[len(manifest.Layers)-1]
+	if !converter.IsNydusBootstrap(bootstrap) {
+		return errors.Errorf("the last layer %s is not a nydus bootstrap", bootstrap.Digest)
+	}
+	if _, ok := bootstrap.Annotations[converter.LayerAnnotationFSVersion]; !ok {
+		return errors.Errorf("bootstrap layer %s has no annotation %s", bootstrap.Digest, converter.LayerAnnotationFSVersion)
+	}
+
+	for _, layer := range manifest.Layers[:len(manifest.Layers)-1] {
+		if !converter.IsNydusBlob(layer) {
+			return errors.Errorf("layer %s is neither a nydus blob nor a nydus bootstrap", layer.Digest)
+		}
+		if err := checkBlob(ctx, cs, layer); err != nil {
+			return err
+		}
+	}
+
+	if err := checkBlob(ctx, cs, bootstrap); err != nil {
+		return err
+	}
+	// The content of encrypted bootstraps can only be checked with the private keys.
+	if encryption.IsEncryptedMediaType(bootstrap.MediaType) {
+		if !hasWrappedKeys(bootstrap) {
+			return errors.Errorf("encrypted bootstrap layer %s has no wrapped keys", bootstrap.Digest)
+		}
+		log.L.Infof("bootstrap layer %s is encrypted, skip checking its content", bootstrap.Digest)
+	} else if err := checkBootstrap(ctx, cs, bootstrap); err != nil {
+		return errors.Wrapf(err, "check bootstrap layer %s", bootstrap.Digest)
+	}
+
+	var config ocispec.Image
+	if err := readJSON(ctx, cs, &config, manifest.Config); err != nil {
+		return errors.Wrap(err, "read image config")
+	}
+	if len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+		return errors.Errorf("image config has %d diff IDs but manifest has %d layers",
+			len(config.RootFS.DiffIDs), len(manifest.Layers))
+	}
+
+	return nil
+}
+
+// Nydus blobs may be pushed to a storage backend instead of the layout,
+// only check the size if the blob is present.
+func checkBlob(ctx context.Context, cs content.Store, desc ocispec.Descriptor) error {
+	info, err := cs.Info(ctx, desc.Digest)
+	if err != nil {
+		if converter.IsNydusBlob(desc) {
+			log.L.Warnf("nydus blob %s is not in the image layout", desc.Digest)
+			return nil
+		}
+		return errors.Wrapf(err, "get info of layer %s", desc.Digest)
+	}
+	if info.Size != desc.Size {
+		return errors.Errorf("size of layer %s mismatches, expected %d, actual %d", desc.Digest, desc.Size, info.Size)
+	}
+	return nil
+}
+
+func checkBootstrap(ctx context.Context, cs content.Store, desc ocispec.Descriptor) error {
+	ra, err := cs.ReaderAt(ctx, desc)
+	if err != nil {
+		return errors.Wrap(err, "get reader")
+	}
+	defer ra.Close()
+
+	rd, err := compression.DecompressStream(content.NewReader(ra))
+	if err != nil {
+		return errors.Wrap(err, "decompress layer")
+	}
+	defer rd.Close()
+
+	tr := tar.NewReader(rd)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				return errors.Errorf("no %s found", converter.BootstrapFileNameInLayer)
+			}
+			return errors.Wrap(err, "read tar")
+		}
+		if hdr.Name == converter.BootstrapFileNameInLayer {
+			if hdr.Size == 0 {
+				return errors.New("bootstrap is empty")
+			}
+			return nil
+		}
+	}
+}
+
+// Ocicrypt annotates encrypted layers with the layer key wrapped for each recipient.
+func hasWrappedKeys(desc ocispec.Descriptor) bool {
+	for k := range desc.Annotations {
+		if strings.HasPrefix(k, encryptionKeysAnnotationPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasNydusFeature(p *ocispec.Platform) bool {
+	for _, f := range p.OSFeatures {
+		if f == converter.ManifestOSFeatureNydus {
+			return true
+		}
+	}
+	return false
+}
+
+func readJSON(ctx context.Context, cs content.Store, x interface{}, desc ocispec.Descriptor) error {
+	data, err := content.ReadBlob(ctx, cs, desc)
+	if err != nil {
+		return errors.Wrapf(err, "read blob %s", desc.Digest)
+	}
+	return json.Unmarshal(data, x)
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+
+	"github.com/containerd/nydus-snapshotter/pkg/converter"
+)
+
+type imageOpt struct {
+	// Encrypt the bootstrap layer, with wrapped keys if `keys` is set.
+	encrypted bool
+	keys      bool
+	// Leave out the fs version annotation of the bootstrap layer.
+	noFsVersion bool
+	// Put the bootstrap file in the layer, which is empty if it's nil.
+	bootstrap []byte
+}
+
+// Write a nydus image of a blob layer and a bootstrap layer to the layout
+// and tag it as `ref`.
+func writeNydusImage(t *testing.T, l *layout, ref string, opt imageOpt) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: converter.BootstrapFileNameInLayer, Mode: 0644, Size: int64(len(opt.bootstrap))}))
+	_, err := tw.Write(opt.bootstrap)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	bootstrapType := ocispec.MediaTypeImageLayerGzip
+	if opt.encrypted {
+		bootstrapType += "+encrypted"
+	}
+	bootstrap := writeBlob(t, l, bootstrapType, buf.Bytes())
+	bootstrap.Annotations = map[string]string{converter.LayerAnnotationNydusBootstrap: "true"}
+	if !opt.noFsVersion {
+		bootstrap.Annotations[converter.LayerAnnotationFSVersion] = "6"
+	}
+	if opt.keys {
+		bootstrap.Annotations[encryptionKeysAnnotationPrefix+"jwe"] = "wrapped"
+	}
+
+	// Nydus blobs may be left in a storage backend.
+	blob := ocispec.Descriptor{
+		MediaType:   converter.MediaTypeNydusBlob,
+		Digest:      digest.FromString("blob"),
+		Size:        4,
+		Annotations: map[string]string{converter.LayerAnnotationNydusBlob: "true"},
+	}
+
+	config := writeJSON(t, l, ocispec.MediaTypeImageConfig, ocispec.Image{
+		RootFS: ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{blob.Digest, bootstrap.Digest}},
+	})
+	manifest := writeJSON(t, l, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{blob, bootstrap},
+	})
+	manifest.MediaType = ocispec.MediaTypeImageManifest
+	platform := platforms.DefaultSpec()
+	platform.OSFeatures = []string{converter.ManifestOSFeatureNydus}
+	manifest.Platform = &platform
+	index := writeJSON(t, l, ocispec.MediaTypeImageIndex, ocispec.Index{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{manifest},
+	})
+	index.MediaType = ocispec.MediaTypeImageIndex
+	require.NoError(t, l.tag(ref, index))
+}
+
+func TestCheck(t *testing.T) {
+	l, err := openLayout(t.TempDir(), newMemoryLabelStore())
+	require.NoError(t, err)
+	writeNydusImage(t, l, "valid", imageOpt{bootstrap: []byte("bootstrap")})
+	writeNydusImage(t, l, "encrypted", imageOpt{encrypted: true, keys: true})
+	writeNydusImage(t, l, "no-keys", imageOpt{encrypted: true})
+	writeNydusImage(t, l, "empty-bootstrap", imageOpt{})
+	writeNydusImage(t, l, "no-fs-version", imageOpt{bootstrap: []byte("bootstrap"), noFsVersion: true})
+
+	config := writeJSON(t, l, ocispec.MediaTypeImageConfig, ocispec.Image{})
+	oci := writeJSON(t, l, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{writeBlob(t, l, ocispec.MediaTypeImageLayerGzip, []byte("layer"))},
+	})
+	oci.MediaType = ocispec.MediaTypeImageManifest
+	require.NoError(t, l.tag("oci", oci))
+	l.cleanup()
+
+	run := func(ref string, args ...string) error {
+		return newApp().Run(append([]string{"converter", "check", "--source", l.dir, "--source-ref", ref}, args...))
+	}
+
+	require.NoError(t, run("valid"))
+	// The content of encrypted bootstraps is not checked.
+	require.NoError(t, run("encrypted"))
+	require.ErrorContains(t, run("no-keys"), "no wrapped keys")
+	require.ErrorContains(t, run("empty-bootstrap"), "bootstrap is empty")
+	require.ErrorContains(t, run("no-fs-version"), converter.LayerAnnotationFSVersion)
+	require.ErrorContains(t, run("oci"), "not a nydus bootstrap")
+	require.ErrorContains(t, run("valid", "--platform", "windows/arm64"), "no nydus manifest found")
+	require.Error(t, run("missing"))
+}
+
+func TestPlatformMatcher(t *testing.T) {
+	var matcher platforms.MatchComparer
+	app := newApp()
+	app.Commands[2].Action = func(c *cli.Context) error {
+		var err error
+		matcher, err = platformMatcher(c)
+		return err
+	}
+
+	windows := ocispec.Platform{OS: "windows", Architecture: "arm64"}
+	require.NoError(t, app.Run([]string{"converter", "check", "--source", t.TempDir()}))
+	require.True(t, matcher.Match(platforms.DefaultSpec()))
+	require.False(t, matcher.Match(windows))
+
+	require.NoError(t, app.Run([]string{"converter", "check", "--source", t.TempDir(), "--platform", "windows/arm64"}))
+	require.True(t, matcher.Match(windows))
+
+	require.NoError(t, app.Run([]string{"converter", "check", "--source", t.TempDir(), "--all-platforms"}))
+	require.True(t, matcher.Match(windows))
+
+	require.Error(t, app.Run([]string{"converter", "check", "--source", t.TempDir(), "--platform", "/"}))
+}
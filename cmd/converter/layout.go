@@ -0,0 +1,227 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/plugins/content/local"
+	"github.com/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// The blob directory of an OCI image layout has exactly the same structure
+// as containerd's local content store, so the store is used to read and
+// write blobs in place.
+type layout struct {
+	dir string
+	cs  content.Store
+}
+
+func openLayout(dir string, ls local.LabelStore) (*layout, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "create layout dir %s", dir)
+	}
+	cs, err := local.NewLabeledStore(dir, ls)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open content store %s", dir)
+	}
+	return &layout{dir: dir, cs: cs}, nil
+}
+
+func (l *layout) readIndex() (*ocispec.Index, error) {
+	data, err := os.ReadFile(filepath.Join(l.dir, ocispec.ImageIndexFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &ocispec.Index{
+				Versioned: specs.Versioned{SchemaVersion: 2},
+				MediaType: ocispec.MediaTypeImageIndex,
+			}, nil
+		}
+		return nil, errors.Wrap(err, "read image index")
+	}
+
+	var index ocispec.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, errors.Wrap(err, "unmarshal image index")
+	}
+	return &index, nil
+}
+
+// Resolve the descriptor tagged by `ref` in index.json. An empty `ref` is
+// allowed if the layout holds only one image.
+func (l *layout) resolve(ref string) (ocispec.Descriptor, error) {
+	index, err := l.readIndex()
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	if ref == "" {
+		if len(index.Manifests) != 1 {
+			return ocispec.Descriptor{}, errors.Errorf("found %d images in %s, please specify a reference",
+				len(index.Manifests), l.dir)
+		}
+		return index.Manifests[0], nil
+	}
+
+	for _, desc := range index.Manifests {
+		if refName(desc) == ref {
+			return desc, nil
+		}
+	}
+
+	return ocispec.Descriptor{}, errors.Wrapf(errdefs.ErrNotFound, "image %s in %s", ref, l.dir)
+}
+
+// Tag the descriptor as `ref` in index.json, replacing any image previously
+// tagged with the same name.
+func (l *layout) tag(ref string, desc ocispec.Descriptor) error {
+	index, err := l.readIndex()
+	if err != nil {
+		return err
+	}
+
+	desc.Annotations = map[string]string{ocispec.AnnotationRefName: ref}
+	manifests := []ocispec.Descriptor{}
+	for _, m := range index.Manifests {
+		if refName(m) != ref {
+			manifests = append(manifests, m)
+		}
+	}
+	index.Manifests = append(manifests, desc)
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal image index")
+	}
+	if err := writeFileAtomic(filepath.Join(l.dir, ocispec.ImageIndexFile), data); err != nil {
+		return errors.Wrap(err, "write image index")
+	}
+
+	data, err = json.Marshal(ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+	if err != nil {
+		return errors.Wrap(err, "marshal image layout")
+	}
+	if err := writeFileAtomic(filepath.Join(l.dir, ocispec.ImageLayoutFile), data); err != nil {
+		return errors.Wrap(err, "write image layout")
+	}
+
+	return nil
+}
+
+// Copy the image tree of `desc` from `src` layout.
+func (l *layout) fetch(ctx context.Context, src *layout, desc ocispec.Descriptor) error {
+	if src.dir == l.dir {
+		return nil
+	}
+
+	copyHandler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if _, err := l.cs.Info(ctx, desc.Digest); err == nil {
+			return nil, nil
+		}
+		ra, err := src.cs.ReaderAt(ctx, desc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get reader for %s", desc.Digest)
+		}
+		defer ra.Close()
+		if err := content.WriteBlob(ctx, l.cs, desc.Digest.String(), content.NewReader(ra), desc); err != nil {
+			return nil, errors.Wrapf(err, "copy blob %s", desc.Digest)
+		}
+		return nil, nil
+	})
+
+	return images.Dispatch(ctx, images.Handlers(copyHandler, images.ChildrenHandler(src.cs)), nil, desc)
+}
+
+// The local content store leaves an empty ingest directory behind,
+// which doesn't belong to an OCI image layout.
+func (l *layout) cleanup() {
+	os.Remove(filepath.Join(l.dir, "ingest"))
+}
+
+func refName(desc ocispec.Descriptor) string {
+	if name := desc.Annotations[ocispec.AnnotationRefName]; name != "" {
+		return name
+	}
+	return desc.Annotations[images.AnnotationImageName]
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// The converter keeps information such as the uncompressed digest of layers
+// in content labels, which are only needed during the conversion.
+type memoryLabelStore struct {
+	lock   sync.Mutex
+	labels map[digest.Digest]map[string]string
+}
+
+func newMemoryLabelStore() local.LabelStore {
+	return &memoryLabelStore{
+		labels: make(map[digest.Digest]map[string]string),
+	}
+}
+
+func (s *memoryLabelStore) Get(d digest.Digest) (map[string]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return copyLabels(s.labels[d]), nil
+}
+
+func (s *memoryLabelStore) Set(d digest.Digest, labels map[string]string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.labels[d] = copyLabels(labels)
+	return nil
+}
+
+func (s *memoryLabelStore) Update(d digest.Digest, update map[string]string) (map[string]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	labels := s.labels[d]
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	for k, v := range update {
+		if v == "" {
+			delete(labels, k)
+		} else {
+			labels[k] = v
+		}
+	}
+	s.labels[d] = labels
+
+	return copyLabels(labels), nil
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	c := make(map[string]string, len(labels))
+	for k, v := range labels {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// Write the blob to the layout, returns its descriptor.
+func writeBlob(t *testing.T, l *layout, mediaType string, data []byte) ocispec.Descriptor {
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	require.NoError(t, content.WriteBlob(context.Background(), l.cs, desc.Digest.String(), bytes.NewReader(data), desc))
+	return desc
+}
+
+func writeJSON(t *testing.T, l *layout, mediaType string, v interface{}) ocispec.Descriptor {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return writeBlob(t, l, mediaType, data)
+}
+
+func TestLayout(t *testing.T) {
+	ctx := context.Background()
+	src, err := openLayout(t.TempDir(), newMemoryLabelStore())
+	require.NoError(t, err)
+
+	// An empty layout has no image.
+	_, err = src.resolve("")
+	require.Error(t, err)
+
+	config := writeJSON(t, src, ocispec.MediaTypeImageConfig, ocispec.Image{})
+	layer := writeBlob(t, src, ocispec.MediaTypeImageLayerGzip, []byte("layer"))
+	manifest := writeJSON(t, src, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{layer},
+	})
+	manifest.MediaType = ocispec.MediaTypeImageManifest
+	require.NoError(t, src.tag("app:v1", manifest))
+	src.cleanup()
+
+	desc, err := src.resolve("")
+	require.NoError(t, err)
+	require.Equal(t, manifest.Digest, desc.Digest)
+	_, err = src.resolve("app:v2")
+	require.True(t, errdefs.IsNotFound(err))
+	require.FileExists(t, filepath.Join(src.dir, ocispec.ImageLayoutFile))
+	require.NoDirExists(t, filepath.Join(src.dir, "ingest"))
+
+	// Retagging replaces the previous image of the same name.
+	require.NoError(t, src.tag("app:v2", manifest))
+	require.NoError(t, src.tag("app:v2", layer))
+	_, err = src.resolve("")
+	require.Error(t, err)
+	desc, err = src.resolve("app:v2")
+	require.NoError(t, err)
+	require.Equal(t, layer.Digest, desc.Digest)
+
+	// The whole image tree is copied to another layout.
+	dst, err := openLayout(t.TempDir(), newMemoryLabelStore())
+	require.NoError(t, err)
+	require.NoError(t, dst.fetch(ctx, src, manifest))
+	for _, d := range []digest.Digest{manifest.Digest, config.Digest, layer.Digest} {
+		_, err := os.Stat(filepath.Join(dst.dir, "blobs", d.Algorithm().String(), d.Encoded()))
+		require.NoError(t, err)
+	}
+}
+
+func TestMemoryLabelStore(t *testing.T) {
+	s := newMemoryLabelStore()
+	d := digest.FromString("blob")
+
+	labels, err := s.Get(d)
+	require.NoError(t, err)
+	require.Nil(t, labels)
+
+	require.NoError(t, s.Set(d, map[string]string{"a": "1"}))
+	labels, err = s.Update(d, map[string]string{"a": "", "b": "2"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"b": "2"}, labels)
+
+	// Labels returned are copies.
+	labels["c"] = "3"
+	labels, err = s.Get(d)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"b": "2"}, labels)
+}
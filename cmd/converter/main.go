@@ -1,10 +1,414 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
 package main
 
-// Import the converter package so that it can be compiled during
-// `go build` to ensure cross-compilation compatibility.
 import (
-	_ "github.com/containerd/nydus-snapshotter/pkg/converter"
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/content"
+	containerdconverter "github.com/containerd/containerd/v2/core/images/converter"
+	"github.com/containerd/log"
+	"github.com/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	"github.com/containerd/nydus-snapshotter/pkg/backend"
+	"github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/containerd/nydus-snapshotter/pkg/encryption"
+	"github.com/containerd/nydus-snapshotter/version"
 )
 
+var sourceFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:     "source",
+		Usage:    "source OCI image layout directory",
+		Required: true,
+	},
+	&cli.StringFlag{
+		Name:  "source-ref",
+		Usage: "reference name of the source image in the layout, can be omitted if the layout holds only one image",
+	},
+	&cli.StringSliceFlag{
+		Name:  "platform",
+		Usage: "only handle the specified platforms, defaults to the current platform",
+	},
+	&cli.BoolFlag{
+		Name:  "all-platforms",
+		Usage: "handle all platforms of the image",
+	},
+}
+
+var targetFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "target",
+		Usage: "target OCI image layout directory, defaults to the source directory",
+	},
+	&cli.StringFlag{
+		Name:     "target-ref",
+		Usage:    "reference name of the target image in the layout",
+		Required: true,
+	},
+	&cli.BoolFlag{
+		Name:  "oci",
+		Usage: "convert Docker media types to OCI media types",
+	},
+	&cli.StringFlag{
+		Name:  "work-dir",
+		Usage: "work directory for conversion, defaults to a temporary directory",
+	},
+	&cli.StringFlag{
+		Name:  "builder",
+		Usage: "path of the nydus-image binary, searched in $PATH by default",
+	},
+	&cli.DurationFlag{
+		Name:  "timeout",
+		Usage: "timeout of converting a single layer, no timeout by default",
+	},
+	&cli.StringFlag{
+		Name:  "backend-type",
+		Usage: "upload nydus blobs to a storage backend, possible values: oss, s3, localfs",
+	},
+	&cli.StringFlag{
+		Name:  "backend-config-file",
+		Usage: "path of the storage backend config file in JSON",
+	},
+	&cli.BoolFlag{
+		Name:  "backend-force-push",
+		Usage: "push blobs to the storage backend even if they already exist",
+	},
+}
+
+var convertFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "fs-version",
+		Usage: "nydus RAFS format version, possible values: 5, 6",
+		Value: "6",
+	},
+	&cli.StringFlag{
+		Name:  "compressor",
+		Usage: "nydus blob compression algorithm, possible values: none, lz4_block, zstd",
+	},
+	&cli.StringFlag{
+		Name:  "chunk-dict",
+		Usage: "bootstrap path of the chunk dict image",
+	},
+	&cli.StringFlag{
+		Name:  "chunk-size",
+		Usage: "size of data chunks, must be power of two and between 0x1000-0x1000000",
+	},
+	&cli.StringFlag{
+		Name:  "batch-size",
+		Usage: "size of batch data chunks, must be power of two and between 0x1000-0x1000000 or zero",
+	},
+	&cli.BoolFlag{
+		Name:  "aligned-chunk",
+		Usage: "align uncompressed data chunks to 4K, only for RAFS V5",
+	},
+	&cli.StringFlag{
+		Name:  "prefetch-patterns",
+		Usage: "file path patterns to prefetch, separated by newlines",
+	},
+	&cli.BoolFlag{
+		Name:  "oci-ref",
+		Usage: "convert OCI tar(.gz) layers to nydus blobs referencing the original layers",
+	},
+	&cli.BoolFlag{
+		Name:  "merge-manifest",
+		Usage: "merge the nydus manifest with the original OCI manifest into a single index",
+	},
+	&cli.BoolFlag{
+		Name:  "with-referrer",
+		Usage: "associate the nydus manifest to the original OCI manifest by the subject field",
+	},
+	&cli.StringSliceFlag{
+		Name:  "encrypt-recipients",
+		Usage: "encrypt the nydus image for the recipients, e.g. jwe:<public key path>",
+	},
+}
+
+var reconvertFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "compressor",
+		Usage: "OCI layer compression algorithm, possible values: gzip, zstd, uncompressed",
+		Value: "gzip",
+	},
+	&cli.BoolFlag{
+		Name:  "stream",
+		Usage: "serve nydus blob data over HTTP to the builder instead of unpacking it to disk",
+	},
+}
+
+func concatFlags(flags ...[]cli.Flag) []cli.Flag {
+	var all []cli.Flag
+	for _, f := range flags {
+		all = append(all, f...)
+	}
+	return all
+}
+
+func platformMatcher(c *cli.Context) (platforms.MatchComparer, error) {
+	if c.Bool("all-platforms") {
+		return platforms.All, nil
+	}
+	if ps := c.StringSlice("platform"); len(ps) > 0 {
+		var specs []ocispec.Platform
+		for _, p := range ps {
+			spec, err := platforms.Parse(p)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parse platform %s", p)
+			}
+			specs = append(specs, spec)
+		}
+		return platforms.Ordered(specs...), nil
+	}
+	return platforms.DefaultStrict(), nil
+}
+
+func newBackend(c *cli.Context) (converter.Backend, error) {
+	backendType := c.String("backend-type")
+	if backendType == "" {
+		return nil, nil
+	}
+	config, err := os.ReadFile(c.String("backend-config-file"))
+	if err != nil {
+		return nil, errors.Wrap(err, "read backend config file")
+	}
+	return backend.NewBackend(backendType, config, c.Bool("backend-force-push"))
+}
+
+func workDir(c *cli.Context) (string, func(), error) {
+	if dir := c.String("work-dir"); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", nil, errors.Wrapf(err, "create work dir %s", dir)
+		}
+		return dir, func() {}, nil
+	}
+	dir, err := os.MkdirTemp("", "nydus-converter-")
+	if err != nil {
+		return "", nil, errors.Wrap(err, "create work dir")
+	}
+	return dir, func() { os.RemoveAll(dir) }, nil
+}
+
+func timeout(c *cli.Context) *time.Duration {
+	if !c.IsSet("timeout") {
+		return nil
+	}
+	t := c.Duration("timeout")
+	return &t
+}
+
+// Resolve the source image and make it available in the target layout,
+// then run the index convert function against the target layout.
+func runConvert(c *cli.Context, convertFunc containerdconverter.ConvertFunc) error {
+	ctx := context.Background()
+
+	ls := newMemoryLabelStore()
+	src, err := openLayout(c.String("source"), ls)
+	if err != nil {
+		return err
+	}
+	defer src.cleanup()
+
+	dst := src
+	if target := c.String("target"); target != "" && target != src.dir {
+		if dst, err = openLayout(target, ls); err != nil {
+			return err
+		}
+		defer dst.cleanup()
+	}
+
+	srcDesc, err := src.resolve(c.String("source-ref"))
+	if err != nil {
+		return errors.Wrap(err, "resolve source image")
+	}
+	if err := dst.fetch(ctx, src, srcDesc); err != nil {
+		return errors.Wrap(err, "copy source image to target layout")
+	}
+
+	newDesc, err := convertFunc(ctx, dst.cs, srcDesc)
+	if err != nil {
+		return err
+	}
+	if newDesc == nil {
+		newDesc = &srcDesc
+	}
+
+	targetRef := c.String("target-ref")
+	if err := dst.tag(targetRef, *newDesc); err != nil {
+		return errors.Wrapf(err, "tag image %s", targetRef)
+	}
+	log.L.Infof("image %s is written to %s, digest %s", targetRef, dst.dir, newDesc.Digest)
+
+	return nil
+}
+
+func convertAction(c *cli.Context) error {
+	platformMC, err := platformMatcher(c)
+	if err != nil {
+		return err
+	}
+	be, err := newBackend(c)
+	if err != nil {
+		return errors.Wrap(err, "create storage backend")
+	}
+	dir, cleanup, err := workDir(c)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	recipients := c.StringSlice("encrypt-recipients")
+	var encrypter converter.Encrypter
+	if len(recipients) > 0 {
+		encrypter = func(ctx context.Context, cs content.Store, desc ocispec.Descriptor) (ocispec.Descriptor, error) {
+			return encryption.EncryptNydusBootstrap(ctx, cs, desc, recipients)
+		}
+	}
+
+	packOpt := converter.PackOption{
+		WorkDir:          dir,
+		BuilderPath:      c.String("builder"),
+		FsVersion:        c.String("fs-version"),
+		ChunkDictPath:    c.String("chunk-dict"),
+		PrefetchPatterns: c.String("prefetch-patterns"),
+		Compressor:       c.String("compressor"),
+		OCIRef:           c.Bool("oci-ref"),
+		AlignedChunk:     c.Bool("aligned-chunk"),
+		ChunkSize:        c.String("chunk-size"),
+		BatchSize:        c.String("batch-size"),
+		Backend:          be,
+		Timeout:          timeout(c),
+		Encrypt:          len(recipients) > 0,
+	}
+	mergeOpt := converter.MergeOption{
+		WorkDir:          dir,
+		BuilderPath:      packOpt.BuilderPath,
+		FsVersion:        packOpt.FsVersion,
+		ChunkDictPath:    packOpt.ChunkDictPath,
+		PrefetchPatterns: packOpt.PrefetchPatterns,
+		OCI:              c.Bool("oci"),
+		OCIRef:           packOpt.OCIRef,
+		WithReferrer:     c.Bool("with-referrer"),
+		Backend:          be,
+		Timeout:          packOpt.Timeout,
+		Encrypt:          encrypter,
+		MergeManifest:    c.Bool("merge-manifest"),
+	}
+
+	convertFunc := containerdconverter.IndexConvertFuncWithHook(
+		converter.LayerConvertFunc(packOpt),
+		c.Bool("oci"),
+		platformMC,
+		containerdconverter.ConvertHooks{
+			PostConvertHook: converter.ConvertHookFunc(mergeOpt),
+		},
+	)
+
+	return runConvert(c, convertFunc)
+}
+
+func reconvertAction(c *cli.Context) error {
+	platformMC, err := platformMatcher(c)
+	if err != nil {
+		return err
+	}
+	be, err := newBackend(c)
+	if err != nil {
+		return errors.Wrap(err, "create storage backend")
+	}
+	dir, cleanup, err := workDir(c)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	unpackOpt := converter.UnpackOption{
+		WorkDir:     dir,
+		BuilderPath: c.String("builder"),
+		Timeout:     timeout(c),
+		Stream:      c.Bool("stream"),
+		Compressor:  c.String("compressor"),
+		Backend:     be,
+	}
+
+	convertFunc := converter.DefaultIndexConvertFunc(
+		converter.LayerReconvertFunc(unpackOpt),
+		c.Bool("oci"),
+		platformMC,
+	)
+
+	return runConvert(c, convertFunc)
+}
+
+func checkAction(c *cli.Context) error {
+	platformMC, err := platformMatcher(c)
+	if err != nil {
+		return err
+	}
+
+	src, err := openLayout(c.String("source"), newMemoryLabelStore())
+	if err != nil {
+		return err
+	}
+	defer src.cleanup()
+
+	desc, err := src.resolve(c.String("source-ref"))
+	if err != nil {
+		return errors.Wrap(err, "resolve image")
+	}
+
+	return check(context.Background(), src.cs, desc, platformMC)
+}
+
+func newApp() *cli.App {
+	return &cli.App{
+		Name:    "converter",
+		Usage:   "Convert images between OCI and nydus formats in OCI image layout directories",
+		Version: fmt.Sprintf("%s.%s", version.Version, version.Revision),
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "log-level",
+				Usage: "logging level, possible values: trace, debug, info, warn, error",
+				Value: "info",
+			},
+		},
+		Before: func(c *cli.Context) error {
+			return log.SetLevel(c.String("log-level"))
+		},
+		Commands: []*cli.Command{
+			{
+				Name:   "convert",
+				Usage:  "Convert an OCI image to a nydus image",
+				Flags:  concatFlags(sourceFlags, targetFlags, convertFlags),
+				Action: convertAction,
+			},
+			{
+				Name:   "reconvert",
+				Usage:  "Convert a nydus image back to an OCI image",
+				Flags:  concatFlags(sourceFlags, targetFlags, reconvertFlags),
+				Action: reconvertAction,
+			},
+			{
+				Name:   "check",
+				Usage:  "Validate a nydus image",
+				Flags:  sourceFlags,
+				Action: checkAction,
+			},
+		},
+	}
+}
+
 func main() {
+	if err := newApp().Run(os.Args); err != nil {
+		log.L.Fatal(err)
+	}
 }
@@ -0,0 +1,178 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
+	"github.com/containerd/nydus-snapshotter/pkg/prefetch/types"
+	"github.com/containerd/nydus-snapshotter/pkg/system"
+	"github.com/containerd/nydus-snapshotter/pkg/warmup"
+)
+
+const (
+	endpointDaemons        = "/api/v1/daemons"
+	endpointDaemonRecords  = "/api/v1/daemons/records"
+	endpointDaemonsUpgrade = "/api/v1/daemons/upgrade"
+	endpointGetBackend     = "/api/v1/daemons/%s/backend"
+	endpointInstances      = "/api/v1/instances"
+	endpointPrefetch       = "/api/v1/prefetch"
+	endpointWarmups        = "/api/v1/warmups"
+	endpointWarmup         = "/api/v1/warmups/%s"
+
+	defaultTimeout = 30 * time.Second
+)
+
+// Client of the system controller of nydus-snapshotter.
+type client struct {
+	httpClient *http.Client
+}
+
+func newClient(sock string, timeout time.Duration) *client {
+	return &client{
+		httpClient: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", sock)
+				},
+			},
+		},
+	}
+}
+
+// Send a request to the system controller and decode the JSON response into `result` if it's not nil.
+func (c *client) request(method, endpoint string, query url.Values, body, result interface{}) error {
+	u := url.URL{Scheme: "http", Host: "unix", Path: endpoint, RawQuery: query.Encode()}
+
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrap(err, "marshal request body")
+		}
+		rd = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, u.String(), rd)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "request %s %s", method, endpoint)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "read response body")
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var m system.ErrorMessage
+		if err := json.Unmarshal(data, &m); err == nil && m.Message != "" {
+			return errors.Errorf("%s %s: %s", method, endpoint, m.Message)
+		}
+		return errors.Errorf("%s %s: %s", method, endpoint, resp.Status)
+	}
+
+	if result != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, result); err != nil {
+			return errors.Wrapf(err, "unmarshal response of %s", endpoint)
+		}
+	}
+
+	return nil
+}
+
+func (c *client) getDaemons() ([]system.DaemonInfo, error) {
+	var daemons []system.DaemonInfo
+	err := c.request(http.MethodGet, endpointDaemons, nil, nil, &daemons)
+	return daemons, err
+}
+
+func (c *client) getInstances() ([]system.RafsInstanceInfo, error) {
+	var instances []system.RafsInstanceInfo
+	err := c.request(http.MethodGet, endpointInstances, nil, nil, &instances)
+	return instances, err
+}
+
+func (c *client) getBackend(daemonID string) (*system.BackendInfo, error) {
+	backend := system.BackendInfo{Config: &daemonconfig.BackendConfig{}}
+	err := c.request(http.MethodGet, fmt.Sprintf(endpointGetBackend, url.PathEscape(daemonID)), nil, nil, &backend)
+	return &backend, err
+}
+
+func (c *client) getRecords() (*system.DaemonRecords, error) {
+	var records system.DaemonRecords
+	err := c.request(http.MethodGet, endpointDaemonRecords, nil, nil, &records)
+	return &records, err
+}
+
+func (c *client) upgradeDaemons(req system.UpgradeRequest) error {
+	return c.request(http.MethodPut, endpointDaemonsUpgrade, nil, req, nil)
+}
+
+func (c *client) getPrefetchLists() ([]types.Entry, error) {
+	var entries []types.Entry
+	err := c.request(http.MethodGet, endpointPrefetch, nil, nil, &entries)
+	return entries, err
+}
+
+func (c *client) putPrefetchLists(entries []types.Entry) error {
+	return c.request(http.MethodPut, endpointPrefetch, nil, entries, nil)
+}
+
+func (c *client) deletePrefetchList(image, digest string) error {
+	query := url.Values{}
+	query.Set("image", image)
+	if digest != "" {
+		query.Set("digest", digest)
+	}
+	return c.request(http.MethodDelete, endpointPrefetch, query, nil, nil)
+}
+
+func (c *client) startWarmup(image string) (*warmup.Status, error) {
+	var status warmup.Status
+	err := c.request(http.MethodPost, endpointWarmups, nil, system.WarmupRequest{Image: image}, &status)
+	return &status, err
+}
+
+func (c *client) getWarmups() ([]warmup.Status, error) {
+	var jobs []warmup.Status
+	err := c.request(http.MethodGet, endpointWarmups, nil, nil, &jobs)
+	return jobs, err
+}
+
+func (c *client) getWarmup(id string) (*warmup.Status, error) {
+	var status warmup.Status
+	err := c.request(http.MethodGet, fmt.Sprintf(endpointWarmup, url.PathEscape(id)), nil, nil, &status)
+	return &status, err
+}
+
+func (c *client) cancelWarmup(id string) (*warmup.Status, error) {
+	var status warmup.Status
+	err := c.request(http.MethodDelete, fmt.Sprintf(endpointWarmup, url.PathEscape(id)), nil, nil, &status)
+	return &status, err
+}
@@ -0,0 +1,443 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
+	"github.com/containerd/nydus-snapshotter/pkg/prefetch/types"
+	"github.com/containerd/nydus-snapshotter/pkg/system"
+	"github.com/containerd/nydus-snapshotter/pkg/warmup"
+	"github.com/containerd/nydus-snapshotter/version"
+)
+
+const defaultSystemControllerAddress = "/run/containerd-nydus/system.sock"
+
+func newClientFromContext(c *cli.Context) *client {
+	return newClient(c.String("address"), c.Duration("timeout"))
+}
+
+func newPrinterFromContext(c *cli.Context) (*printer, error) {
+	return newPrinter(c.App.Writer, c.String("output"))
+}
+
+func daemonsAction(c *cli.Context) error {
+	p, err := newPrinterFromContext(c)
+	if err != nil {
+		return err
+	}
+	daemons, err := newClientFromContext(c).getDaemons()
+	if err != nil {
+		return err
+	}
+	return p.printDaemons(daemons)
+}
+
+func instancesAction(c *cli.Context) error {
+	p, err := newPrinterFromContext(c)
+	if err != nil {
+		return err
+	}
+	instances, err := newClientFromContext(c).getInstances()
+	if err != nil {
+		return err
+	}
+	return p.printInstances(instances)
+}
+
+func backendAction(c *cli.Context) error {
+	daemonID := c.Args().First()
+	if daemonID == "" {
+		return errors.New("daemon ID is required")
+	}
+	backend, err := newClientFromContext(c).getBackend(daemonID)
+	if err != nil {
+		return err
+	}
+	// Credentials are redacted by the system controller, filter them anyway for older ones.
+	backend.Config = daemonconfig.SerializeWithSecretFilter(backend.Config)
+	return printJSON(c.App.Writer, backend)
+}
+
+func recordsAction(c *cli.Context) error {
+	p, err := newPrinterFromContext(c)
+	if err != nil {
+		return err
+	}
+	records, err := newClientFromContext(c).getRecords()
+	if err != nil {
+		return err
+	}
+	return p.printRecords(records)
+}
+
+func upgradeAction(c *cli.Context) error {
+	req := system.UpgradeRequest{
+		NydusdPath: c.String("nydusd-path"),
+		Version:    c.String("version"),
+		Policy:     c.String("policy"),
+	}
+	if _, err := os.Stat(req.NydusdPath); err != nil {
+		return errors.Wrapf(err, "check nydusd binary %s", req.NydusdPath)
+	}
+
+	// Rolling upgrade takes a while since daemons are upgraded one by one.
+	cl := newClient(c.String("address"), 0)
+	if err := cl.upgradeDaemons(req); err != nil {
+		return err
+	}
+
+	log.L.Infof("upgraded all daemons to %s", req.NydusdPath)
+	return nil
+}
+
+func prefetchListAction(c *cli.Context) error {
+	p, err := newPrinterFromContext(c)
+	if err != nil {
+		return err
+	}
+	entries, err := newClientFromContext(c).getPrefetchLists()
+	if err != nil {
+		return err
+	}
+	return p.printPrefetchLists(entries)
+}
+
+// Read files to prefetch line by line, which is the format of downloaded access traces.
+func readPrefetchFiles(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var files []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, scanner.Err()
+}
+
+func prefetchPushAction(c *cli.Context) error {
+	files := c.StringSlice("file")
+	if path := c.String("files-from"); path != "" {
+		lines, err := readPrefetchFiles(path)
+		if err != nil {
+			return errors.Wrapf(err, "read prefetch files from %s", path)
+		}
+		files = append(files, lines...)
+	}
+	if len(files) == 0 {
+		return errors.New("no file to prefetch, please specify --file or --files-from")
+	}
+
+	manifestDigest := digest.Digest(c.String("digest"))
+	if manifestDigest != "" {
+		if err := manifestDigest.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid digest %s", manifestDigest)
+		}
+	}
+
+	entry := types.Entry{
+		ImageRef:       c.String("image"),
+		ManifestDigest: manifestDigest,
+		PrefetchFiles:  strings.Join(files, ","),
+	}
+	if err := newClientFromContext(c).putPrefetchLists([]types.Entry{entry}); err != nil {
+		return err
+	}
+
+	log.L.Infof("pushed prefetch list of %s with %d files", entry.Key(), len(files))
+	return nil
+}
+
+func prefetchDeleteAction(c *cli.Context) error {
+	return newClientFromContext(c).deletePrefetchList(c.String("image"), c.String("digest"))
+}
+
+func warmupListAction(c *cli.Context) error {
+	p, err := newPrinterFromContext(c)
+	if err != nil {
+		return err
+	}
+	jobs, err := newClientFromContext(c).getWarmups()
+	if err != nil {
+		return err
+	}
+	return p.printWarmups(jobs)
+}
+
+func warmupStatusAction(c *cli.Context) error {
+	id := c.Args().First()
+	if id == "" {
+		return errors.New("warm-up job ID is required")
+	}
+	p, err := newPrinterFromContext(c)
+	if err != nil {
+		return err
+	}
+	status, err := newClientFromContext(c).getWarmup(id)
+	if err != nil {
+		return err
+	}
+	return p.printWarmups([]warmup.Status{*status})
+}
+
+func warmupCancelAction(c *cli.Context) error {
+	id := c.Args().First()
+	if id == "" {
+		return errors.New("warm-up job ID is required")
+	}
+	// Wait until the transient RAFS instance of the job is umounted.
+	status, err := newClient(c.String("address"), 0).cancelWarmup(id)
+	if err != nil {
+		return err
+	}
+	log.L.Infof("warm-up job %s is %s", status.ID, strings.ToLower(status.State))
+	return nil
+}
+
+func warmupStartAction(c *cli.Context) error {
+	image := c.Args().First()
+	if image == "" {
+		return errors.New("image reference is required")
+	}
+	cl := newClientFromContext(c)
+	status, err := cl.startWarmup(image)
+	if err != nil {
+		return err
+	}
+	log.L.Infof("started warm-up job %s for image %s", status.ID, status.Image)
+	if !c.Bool("wait") {
+		fmt.Fprintln(c.App.Writer, status.ID)
+		return nil
+	}
+
+	// Report progress until the job finishes, and cancel the job on interruption.
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	ticker := time.NewTicker(c.Duration("interval"))
+	defer ticker.Stop()
+
+	for !status.Finished() {
+		select {
+		case <-sigCh:
+			log.L.Infof("canceling warm-up job %s", status.ID)
+			status, err = newClient(c.String("address"), 0).cancelWarmup(status.ID)
+			if err != nil {
+				return err
+			}
+		case <-ticker.C:
+			status, err = cl.getWarmup(status.ID)
+			if err != nil {
+				return err
+			}
+			log.L.Infof("warm-up job %s: %s, fetched %d of %d bytes (%s)", status.ID,
+				strings.ToLower(status.State), status.FetchedBytes, status.TotalBytes, warmupProgress(status))
+		}
+	}
+
+	switch status.State {
+	case warmup.StateCompleted:
+		log.L.Infof("image %s is warmed up", status.Image)
+		return nil
+	case warmup.StateFailed:
+		return errors.Errorf("warm-up job %s failed: %s", status.ID, status.Error)
+	default:
+		return errors.Errorf("warm-up job %s is %s", status.ID, strings.ToLower(status.State))
+	}
+}
+
+func newApp() *cli.App {
+	outputFlag := &cli.StringFlag{
+		Name:    "output",
+		Aliases: []string{"o"},
+		Usage:   "output format, possible values: table, json",
+		Value:   outputTable,
+	}
+	imageFlags := []cli.Flag{
+		&cli.StringFlag{
+			Name:     "image",
+			Usage:    "image reference",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "digest",
+			Usage: "image manifest digest, the prefetch list applies to all images of the reference if omitted",
+		},
+	}
+
+	return &cli.App{
+		Name:    "nydusctl",
+		Usage:   "Administrate nydus-snapshotter through its system controller",
+		Version: fmt.Sprintf("%s.%s", version.Version, version.Revision),
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "address",
+				Usage: "unix socket address of the system controller",
+				Value: defaultSystemControllerAddress,
+			},
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "timeout of requests to the system controller",
+				Value: defaultTimeout,
+			},
+			&cli.StringFlag{
+				Name:  "log-level",
+				Usage: "logging level, possible values: trace, debug, info, warn, error",
+				Value: "info",
+			},
+		},
+		Before: func(c *cli.Context) error {
+			return log.SetLevel(c.String("log-level"))
+		},
+		Commands: []*cli.Command{
+			{
+				Name:   "daemons",
+				Usage:  "List nydusd daemons and their RAFS instances",
+				Flags:  []cli.Flag{outputFlag},
+				Action: daemonsAction,
+			},
+			{
+				Name:   "instances",
+				Usage:  "List RAFS instances",
+				Flags:  []cli.Flag{outputFlag},
+				Action: instancesAction,
+			},
+			{
+				Name:      "backend",
+				Usage:     "Show storage backend configuration of a nydusd daemon with secrets filtered",
+				ArgsUsage: "DAEMON_ID",
+				Action:    backendAction,
+			},
+			{
+				Name:   "records",
+				Usage:  "Dump nydusd daemons and RAFS instances persisted in database",
+				Flags:  []cli.Flag{outputFlag},
+				Action: recordsAction,
+			},
+			{
+				Name:  "upgrade",
+				Usage: "Live upgrade all nydusd daemons to a new nydusd binary",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "nydusd-path",
+						Usage:    "path of the new nydusd binary",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "version",
+						Usage: "version of the new nydusd binary",
+					},
+					&cli.StringFlag{
+						Name:  "policy",
+						Usage: "upgrade policy, possible values: rolling, immediate",
+						Value: "rolling",
+					},
+				},
+				Action: upgradeAction,
+			},
+			{
+				Name:  "prefetch",
+				Usage: "Manage prefetch lists of images",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "list",
+						Usage:  "List prefetch lists",
+						Flags:  []cli.Flag{outputFlag},
+						Action: prefetchListAction,
+					},
+					{
+						Name:  "push",
+						Usage: "Push a prefetch list of an image, which is applied when the image is mounted",
+						Flags: append([]cli.Flag{
+							&cli.StringSliceFlag{
+								Name:  "file",
+								Usage: "absolute path of a file to prefetch in the image, can be specified multiple times",
+							},
+							&cli.StringFlag{
+								Name:  "files-from",
+								Usage: "read files to prefetch from a file, one path per line",
+							},
+						}, imageFlags...),
+						Action: prefetchPushAction,
+					},
+					{
+						Name:   "delete",
+						Usage:  "Delete the prefetch list of an image",
+						Flags:  imageFlags,
+						Action: prefetchDeleteAction,
+					},
+				},
+			},
+			{
+				Name:  "warmup",
+				Usage: "Download whole images into the local blob cache before containers start",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "start",
+						Usage:     "Start warming up an image, print the job ID unless waiting for it",
+						ArgsUsage: "IMAGE",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "wait",
+								Usage: "wait for the job to finish and report its progress, interrupting cancels the job",
+							},
+							&cli.DurationFlag{
+								Name:  "interval",
+								Usage: "interval to report progress when waiting",
+								Value: 2 * time.Second,
+							},
+						},
+						Action: warmupStartAction,
+					},
+					{
+						Name:   "list",
+						Usage:  "List warm-up jobs",
+						Flags:  []cli.Flag{outputFlag},
+						Action: warmupListAction,
+					},
+					{
+						Name:      "status",
+						Usage:     "Show status of a warm-up job",
+						ArgsUsage: "JOB_ID",
+						Flags:     []cli.Flag{outputFlag},
+						Action:    warmupStatusAction,
+					},
+					{
+						Name:      "cancel",
+						Usage:     "Cancel a warm-up job, blob caches already downloaded are kept",
+						ArgsUsage: "JOB_ID",
+						Action:    warmupCancelAction,
+					},
+				},
+			},
+		},
+	}
+}
+
+func main() {
+	if err := newApp().Run(os.Args); err != nil {
+		log.L.Fatal(err)
+	}
+}
@@ -0,0 +1,232 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/pkg/prefetch/types"
+	"github.com/containerd/nydus-snapshotter/pkg/rafs"
+	"github.com/containerd/nydus-snapshotter/pkg/system"
+	"github.com/containerd/nydus-snapshotter/pkg/warmup"
+)
+
+// A fake system controller serving on a unix socket.
+type fakeController struct {
+	sock string
+
+	mu       sync.Mutex
+	prefetch []types.Entry
+	deleted  string
+	warmups  map[string]*warmup.Status
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	require.NoError(t, json.NewEncoder(w).Encode(v))
+}
+
+func newFakeController(t *testing.T) *fakeController {
+	fc := &fakeController{
+		sock:    filepath.Join(t.TempDir(), "system.sock"),
+		warmups: map[string]*warmup.Status{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/daemons", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, http.StatusOK, []system.DaemonInfo{
+			{ID: "d2", Pid: 200, Reference: 0, HostMountpoint: "/mnt/d2"},
+			{ID: "d1", Pid: 100, Reference: 2, HostMountpoint: "/mnt/d1", Instances: map[string]system.RafsInstanceInfo{
+				"2": {SnapshotID: "2", ImageID: "busybox"},
+				"1": {SnapshotID: "1", ImageID: "nginx"},
+			}},
+		})
+	})
+	mux.HandleFunc("GET /api/v1/instances", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(t, w, http.StatusOK, []system.RafsInstanceInfo{
+			{SnapshotID: "1", DaemonID: "d1", ImageID: "nginx", Mountpoint: "/mnt/d1/1",
+				Recovery: &rafs.RecoveryState{State: "RECOVERING"}},
+		})
+	})
+	mux.HandleFunc("GET /api/v1/daemons/{id}/backend", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") != "d1" {
+			writeJSON(t, w, http.StatusNotFound, system.ErrorMessage{Code: "Unknown", Message: "not found"})
+			return
+		}
+		// Credentials are redacted by the system controller.
+		writeJSON(t, w, http.StatusOK, map[string]interface{}{
+			"type":   "registry",
+			"config": map[string]interface{}{"host": "registry.example.com", "repo": "library/nginx"},
+		})
+	})
+	mux.HandleFunc("GET /api/v1/prefetch", func(w http.ResponseWriter, _ *http.Request) {
+		fc.mu.Lock()
+		defer fc.mu.Unlock()
+		writeJSON(t, w, http.StatusOK, fc.prefetch)
+	})
+	mux.HandleFunc("PUT /api/v1/prefetch", func(w http.ResponseWriter, r *http.Request) {
+		var entries []types.Entry
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&entries))
+		fc.mu.Lock()
+		defer fc.mu.Unlock()
+		fc.prefetch = append(fc.prefetch, entries...)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /api/v1/prefetch", func(w http.ResponseWriter, r *http.Request) {
+		fc.mu.Lock()
+		defer fc.mu.Unlock()
+		fc.deleted = r.URL.Query().Encode()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /api/v1/warmups", func(w http.ResponseWriter, r *http.Request) {
+		var req system.WarmupRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		fc.mu.Lock()
+		defer fc.mu.Unlock()
+		status := &warmup.Status{ID: "job1", Image: req.Image, State: warmup.StateRunning, TotalBytes: 100}
+		fc.warmups[status.ID] = status
+		writeJSON(t, w, http.StatusAccepted, status)
+	})
+	mux.HandleFunc("GET /api/v1/warmups/{id}", func(w http.ResponseWriter, r *http.Request) {
+		fc.mu.Lock()
+		defer fc.mu.Unlock()
+		status, ok := fc.warmups[r.PathValue("id")]
+		if !ok {
+			writeJSON(t, w, http.StatusNotFound, system.ErrorMessage{Code: "Unknown", Message: "no such job"})
+			return
+		}
+		// The job completes once its status is queried.
+		status.State = warmup.StateCompleted
+		status.FetchedBytes = uint64(status.TotalBytes)
+		writeJSON(t, w, http.StatusOK, status)
+	})
+
+	ts := httptest.NewUnstartedServer(mux)
+	listener, err := net.Listen("unix", fc.sock)
+	require.NoError(t, err)
+	ts.Listener = listener
+	ts.Start()
+	t.Cleanup(ts.Close)
+
+	return fc
+}
+
+// Run nydusctl against the controller and return its output.
+func (fc *fakeController) run(args ...string) (string, error) {
+	var out bytes.Buffer
+	app := newApp()
+	app.Writer = &out
+	err := app.Run(append([]string{"nydusctl", "--address", fc.sock}, args...))
+	return out.String(), err
+}
+
+func TestDaemons(t *testing.T) {
+	fc := newFakeController(t)
+
+	out, err := fc.run("daemons")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 4)
+	require.Regexp(t, `^DAEMON\s+PID\s+REF`, lines[0])
+	// Daemons are sorted and instances of a daemon are listed in following rows.
+	require.Regexp(t, `^d1\s+100\s+2\s+/mnt/d1\s+0\s+0\s+1\s+nginx$`, lines[1])
+	require.Regexp(t, `^\s+2\s+busybox$`, lines[2])
+	require.Regexp(t, `^d2\s+200\s+0\s+/mnt/d2`, lines[3])
+
+	out, err = fc.run("daemons", "-o", "json")
+	require.NoError(t, err)
+	var daemons []system.DaemonInfo
+	require.NoError(t, json.Unmarshal([]byte(out), &daemons))
+	require.Len(t, daemons, 2)
+
+	_, err = fc.run("daemons", "-o", "yaml")
+	require.ErrorContains(t, err, "unsupported output format")
+
+	out, err = fc.run("instances")
+	require.NoError(t, err)
+	require.Regexp(t, `\n1\s+d1\s+nginx\s+/mnt/d1/1\s+RECOVERING\n$`, out)
+}
+
+func TestBackend(t *testing.T) {
+	fc := newFakeController(t)
+
+	out, err := fc.run("backend", "d1")
+	require.NoError(t, err)
+	var backend struct {
+		Type   string                 `json:"type"`
+		Config map[string]interface{} `json:"config"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(out), &backend))
+	require.Equal(t, "registry", backend.Type)
+	require.Equal(t, "registry.example.com", backend.Config["host"])
+	require.NotContains(t, backend.Config, "auth")
+
+	_, err = fc.run("backend", "d3")
+	require.ErrorContains(t, err, "not found")
+	_, err = fc.run("backend")
+	require.ErrorContains(t, err, "daemon ID is required")
+}
+
+func TestPrefetch(t *testing.T) {
+	fc := newFakeController(t)
+
+	filesFrom := filepath.Join(t.TempDir(), "files")
+	require.NoError(t, os.WriteFile(filesFrom, []byte("/usr/bin/nginx\n\n  /etc/nginx  \n"), 0644))
+	_, err := fc.run("prefetch", "push", "--image", "nginx:latest", "--file", "/bin/sh", "--files-from", filesFrom)
+	require.NoError(t, err)
+	require.Len(t, fc.prefetch, 1)
+	require.Equal(t, "nginx:latest", fc.prefetch[0].ImageRef)
+	require.Equal(t, "/bin/sh,/usr/bin/nginx,/etc/nginx", fc.prefetch[0].PrefetchFiles)
+
+	_, err = fc.run("prefetch", "push", "--image", "nginx:latest")
+	require.ErrorContains(t, err, "no file to prefetch")
+	_, err = fc.run("prefetch", "push", "--image", "nginx:latest", "--file", "/bin/sh", "--digest", "sha256:bad")
+	require.ErrorContains(t, err, "invalid digest")
+
+	out, err := fc.run("prefetch", "list")
+	require.NoError(t, err)
+	require.Contains(t, out, "/bin/sh,/usr/bin/nginx,/etc/nginx")
+
+	_, err = fc.run("prefetch", "delete", "--image", "nginx:latest")
+	require.NoError(t, err)
+	require.Equal(t, "image=nginx%3Alatest", fc.deleted)
+}
+
+func TestWarmup(t *testing.T) {
+	fc := newFakeController(t)
+
+	out, err := fc.run("warmup", "start", "nginx:latest")
+	require.NoError(t, err)
+	require.Equal(t, "job1\n", out)
+
+	_, err = fc.run("warmup", "start", "--wait", "--interval", "10ms", "nginx:latest")
+	require.NoError(t, err)
+
+	out, err = fc.run("warmup", "status", "-o", "json", "job1")
+	require.NoError(t, err)
+	var jobs []warmup.Status
+	require.NoError(t, json.Unmarshal([]byte(out), &jobs))
+	require.Len(t, jobs, 1)
+	require.Equal(t, warmup.StateCompleted, jobs[0].State)
+
+	_, err = fc.run("warmup", "status", "job2")
+	require.ErrorContains(t, err, "no such job")
+	_, err = fc.run("warmup", "start")
+	require.ErrorContains(t, err, "image reference is required")
+}
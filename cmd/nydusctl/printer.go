@@ -0,0 +1,189 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/prefetch/types"
+	"github.com/containerd/nydus-snapshotter/pkg/system"
+	"github.com/containerd/nydus-snapshotter/pkg/warmup"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+
+	// Truncate long prefetch lists in table output.
+	maxPrefetchFilesWidth = 64
+)
+
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+func newPrinter(w io.Writer, format string) (*printer, error) {
+	switch format {
+	case outputTable:
+		return &printer{w: w}, nil
+	case outputJSON:
+		return &printer{w: w, json: true}, nil
+	default:
+		return nil, errors.Errorf("unsupported output format %q", format)
+	}
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// Print rows in aligned columns, the first row is the header.
+func (p *printer) printTable(rows [][]string) error {
+	tw := tabwriter.NewWriter(p.w, 0, 8, 2, ' ', 0)
+	for _, row := range rows {
+		if _, err := fmt.Fprintln(tw, strings.Join(row, "\t")); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+func (p *printer) printDaemons(daemons []system.DaemonInfo) error {
+	if p.json {
+		return printJSON(p.w, daemons)
+	}
+
+	sort.Slice(daemons, func(i, j int) bool {
+		return daemons[i].ID < daemons[j].ID
+	})
+
+	rows := [][]string{{"DAEMON", "PID", "REF", "MOUNTPOINT", "RSS(KB)", "READ(KB)", "SNAPSHOT", "IMAGE"}}
+	for _, d := range daemons {
+		row := []string{d.ID, fmt.Sprint(d.Pid), fmt.Sprint(d.Reference), d.HostMountpoint,
+			fmt.Sprintf("%.0f", d.MemoryRSS), fmt.Sprintf("%.0f", d.ReadData)}
+
+		ids := make([]string, 0, len(d.Instances))
+		for id := range d.Instances {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		if len(ids) == 0 {
+			rows = append(rows, append(row, "", ""))
+			continue
+		}
+		// Instances of a shared daemon are listed in following rows.
+		for i, id := range ids {
+			if i > 0 {
+				row = []string{"", "", "", "", "", ""}
+			}
+			rows = append(rows, append(row, id, d.Instances[id].ImageID))
+		}
+	}
+
+	return p.printTable(rows)
+}
+
+func (p *printer) printInstances(instances []system.RafsInstanceInfo) error {
+	if p.json {
+		return printJSON(p.w, instances)
+	}
+
+	rows := [][]string{{"SNAPSHOT", "DAEMON", "FS DRIVER", "IMAGE", "MOUNTPOINT", "RECOVERY"}}
+	for _, i := range instances {
+		var recovery string
+		if i.Recovery != nil {
+			recovery = i.Recovery.State
+		}
+		rows = append(rows, []string{i.SnapshotID, i.DaemonID, i.FsDriver, i.ImageID, i.Mountpoint, recovery})
+	}
+
+	return p.printTable(rows)
+}
+
+func (p *printer) printRecords(records *system.DaemonRecords) error {
+	if p.json {
+		return printJSON(p.w, records)
+	}
+
+	rows := [][]string{{"DAEMON", "PID", "MODE", "FS DRIVER", "MOUNTPOINT", "API SOCKET"}}
+	for _, d := range records.Daemons {
+		rows = append(rows, []string{d.ID, fmt.Sprint(d.ProcessID), string(d.DaemonMode), d.FsDriver, d.Mountpoint, d.APISocket})
+	}
+	if err := p.printTable(rows); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintln(p.w); err != nil {
+		return err
+	}
+
+	rows = [][]string{{"SEQ", "SNAPSHOT", "DAEMON", "FS DRIVER", "IMAGE", "MOUNTPOINT"}}
+	for _, r := range records.Instances {
+		rows = append(rows, []string{fmt.Sprint(r.Seq), r.SnapshotID, r.DaemonID, r.GetFsDriver(), r.ImageID, r.GetMountpoint()})
+	}
+
+	return p.printTable(rows)
+}
+
+func (p *printer) printPrefetchLists(entries []types.Entry) error {
+	if p.json {
+		return printJSON(p.w, entries)
+	}
+
+	rows := [][]string{{"IMAGE", "DIGEST", "UPDATED", "FILES"}}
+	for _, e := range entries {
+		files := e.PrefetchFiles
+		if len(files) > maxPrefetchFilesWidth {
+			files = files[:maxPrefetchFilesWidth-3] + "..."
+		}
+		rows = append(rows, []string{e.ImageRef, e.ManifestDigest.String(), e.UpdatedAt.Format(time.RFC3339), files})
+	}
+
+	return p.printTable(rows)
+}
+
+// Progress of a warm-up job in percentage, nydusd may fetch a bit more than the blob sizes.
+func warmupProgress(s *warmup.Status) string {
+	if s.State == warmup.StateCompleted {
+		return "100%"
+	}
+	if s.TotalBytes <= 0 {
+		return "-"
+	}
+	percent := float64(s.FetchedBytes) * 100 / float64(s.TotalBytes)
+	if percent > 99 {
+		percent = 99
+	}
+	return fmt.Sprintf("%.0f%%", percent)
+}
+
+func (p *printer) printWarmups(jobs []warmup.Status) error {
+	if p.json {
+		return printJSON(p.w, jobs)
+	}
+
+	rows := [][]string{{"ID", "IMAGE", "STATE", "BLOBS", "FETCHED", "TOTAL", "PROGRESS", "ERROR"}}
+	for i := range jobs {
+		j := &jobs[i]
+		rows = append(rows, []string{j.ID, j.Image, j.State, fmt.Sprint(j.Blobs), fmt.Sprint(j.FetchedBytes),
+			fmt.Sprint(j.TotalBytes), warmupProgress(j), j.Error})
+	}
+
+	return p.printTable(rows)
+}
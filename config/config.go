@@ -8,11 +8,15 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"runtime"
+	"time"
 
 	"dario.cat/mergo"
 	"github.com/pelletier/go-toml"
 	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
 
 	"github.com/containerd/nydus-snapshotter/internal/constant"
 	"github.com/containerd/nydus-snapshotter/internal/flags"
@@ -117,6 +121,30 @@ const (
 	FsDriverProxy    string = constant.FsDriverProxy
 )
 
+const (
+	AccessTraceSourceNydusd   string = constant.AccessTraceSourceNydusd
+	AccessTraceSourceFanotify string = constant.AccessTraceSourceFanotify
+	AccessTraceSourceAuto     string = constant.AccessTraceSourceAuto
+)
+
+const (
+	// Mounting fails if the bootstrap signature is missing or invalid.
+	SignaturePolicyEnforce string = constant.SignaturePolicyEnforce
+	// Only warn if the bootstrap signature is missing or invalid.
+	SignaturePolicyWarn string = constant.SignaturePolicyWarn
+	// Do not verify bootstrap signatures.
+	SignaturePolicySkip string = constant.SignaturePolicySkip
+)
+
+const (
+	// Publish an event on nydusd having hung IO.
+	HungIOActionEvent string = constant.HungIOActionEvent
+	// Also dump states and logs of nydusd to a diagnostics bundle.
+	HungIOActionDump string = constant.HungIOActionDump
+	// Also kill nydusd to restart or fail over it by the recover policy.
+	HungIOActionRecover string = constant.HungIOActionRecover
+)
+
 const (
 	FailoverPolicyNone   string = constant.FailoverPolicyNone
 	FailoverPolicyResend string = constant.FailoverPolicyResend
@@ -124,10 +152,22 @@ const (
 )
 
 type Experimental struct {
-	EnableStargz         bool        `toml:"enable_stargz"`
-	EnableReferrerDetect bool        `toml:"enable_referrer_detect"`
-	TarfsConfig          TarfsConfig `toml:"tarfs"`
-	EnableBackendSource  bool        `toml:"enable_backend_source"`
+	EnableStargz         bool           `toml:"enable_stargz"`
+	EnableReferrerDetect bool           `toml:"enable_referrer_detect"`
+	TarfsConfig          TarfsConfig    `toml:"tarfs"`
+	EnableBackendSource  bool           `toml:"enable_backend_source"`
+	P2PConfig            P2PConfig      `toml:"p2p"`
+	ReferrerConfig       ReferrerConfig `toml:"referrer"`
+}
+
+// Select the nydus image among referrers of OCI images, used by `enable_referrer_detect`.
+type ReferrerConfig struct {
+	// Only select referrers of the artifact type if it's not empty
+	ArtifactType string `toml:"artifact_type"`
+	// Only select referrers having all the annotations, e.g. the nydus fs version
+	Annotations map[string]string `toml:"annotations"`
+	// How long the selected nydus image of an OCI image is cached, e.g. "10m"
+	CacheTTL string `toml:"cache_ttl"`
 }
 
 type TarfsConfig struct {
@@ -138,9 +178,32 @@ type TarfsConfig struct {
 	ExportMode        string `toml:"export_mode"`
 }
 
+// Share fully downloaded blob caches between nodes.
+type P2PConfig struct {
+	Enable bool `toml:"enable"`
+	// Address to serve blob caches to peers, e.g. ":9753"
+	Address string `toml:"address"`
+	// Peers are discovered from a static list of "host:port", a file listing
+	// one peer per line, and a DNS SRV record.
+	Peers     []string `toml:"peers"`
+	PeersFile string   `toml:"peers_file"`
+	DNSSRV    string   `toml:"dns_srv"`
+	// How often to discover peers and blobs they serve
+	RefreshInterval string `toml:"refresh_interval"`
+	// File of the secret shared by all peers to authenticate each other
+	TokenFile string `toml:"token_file"`
+}
+
 type CgroupConfig struct {
 	Enable      bool   `toml:"enable"`
 	MemoryLimit string `toml:"memory_limit"`
+	// Number of CPUs like "1.5", or percentage of all CPUs like "50%"
+	CPUQuota string `toml:"cpu_quota"`
+	// IO limits of block devices in the format of cgroup v2 `io.max`
+	IOMax   []string `toml:"io_max"`
+	PidsMax int64    `toml:"pids_max"`
+	// Create a child cgroup for each nydusd, limits are applied to each nydusd
+	PerDaemon bool `toml:"per_daemon"`
 }
 
 // Configure how to start and recover nydusd daemons
@@ -153,6 +216,23 @@ type DaemonConfig struct {
 	ThreadsNumber    int    `toml:"threads_number"`
 	LogRotationSize  int    `toml:"log_rotation_size"`
 	FailoverPolicy   string `toml:"failover_policy"`
+	// Act on nydusd whose FUSE requests are hung, rather than leaving containers
+	// blocked until someone notices.
+	HungIOWatchdog HungIOWatchdogConfig `toml:"hung_io_watchdog"`
+}
+
+// Watch inflight FUSE requests of nydusd, and take the action if some of them are
+// pending longer than `Threshold`.
+type HungIOWatchdogConfig struct {
+	Enable bool `toml:"enable"`
+	// How often inflight requests are checked, e.g. "10s".
+	Interval string `toml:"interval"`
+	// How long a request has been pending before it's regarded as hung, e.g. "60s".
+	Threshold string `toml:"threshold"`
+	// "event", "dump" or "recover". Each action includes the previous ones.
+	Action string `toml:"action"`
+	// Where diagnostics bundles are saved, `<root>/diagnostics` by default.
+	DumpDir string `toml:"dump_dir"`
 }
 
 type LoggingConfig struct {
@@ -170,6 +250,72 @@ type LoggingConfig struct {
 type ImageConfig struct {
 	PublicKeyFile     string `toml:"public_key_file"`
 	ValidateSignature bool   `toml:"validate_signature"`
+	// More trusted public keys besides `PublicKeyFile`. A signature is accepted
+	// if any of the keys verifies it, so that keys can be rotated smoothly.
+	PublicKeyFiles []string `toml:"public_key_files"`
+	// Fetch bootstrap signatures attached to image manifests as OCI referrers
+	// in addition to the signature passed by snapshot label.
+	EnableReferrerSignature bool `toml:"enable_referrer_signature"`
+	// Default signature policy: "enforce", "warn" or "skip". If it's empty, the policy
+	// is "enforce" when `ValidateSignature` is true, otherwise "skip".
+	SignaturePolicy string `toml:"signature_policy"`
+	// Signature policies overriding the default one per registry host.
+	RegistrySignaturePolicies map[string]string `toml:"registry_signature_policies"`
+	// Directory of node-local private keys to decrypt encrypted bootstraps, in any
+	// format supported by ocicrypt, e.g. JWE and PKCS7 private keys or GPG key rings.
+	DecryptionKeysDir string `toml:"decryption_keys_dir"`
+	// Ocicrypt keyprovider configuration file, whose gRPC or command key providers
+	// are also tried to decrypt encrypted bootstraps.
+	KeyProviderConfig string `toml:"keyprovider_config"`
+}
+
+// Whether encrypted bootstraps are decrypted by nydus-snapshotter.
+func (c *ImageConfig) DecryptionEnabled() bool {
+	return c.DecryptionKeysDir != "" || c.KeyProviderConfig != ""
+}
+
+// Get the signature policy for images from the registry host.
+func (c *ImageConfig) GetSignaturePolicy(host string) string {
+	if policy, ok := c.RegistrySignaturePolicies[host]; ok {
+		return policy
+	}
+	if c.SignaturePolicy != "" {
+		return c.SignaturePolicy
+	}
+	if c.ValidateSignature {
+		return SignaturePolicyEnforce
+	}
+	return SignaturePolicySkip
+}
+
+// Whether bootstrap signatures of images from any registry are verified.
+func (c *ImageConfig) SignatureVerificationEnabled() bool {
+	if c.GetSignaturePolicy("") != SignaturePolicySkip {
+		return true
+	}
+	for _, policy := range c.RegistrySignaturePolicies {
+		if policy != SignaturePolicySkip {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ImageConfig) PublicKeys() []string {
+	keys := make([]string, 0, len(c.PublicKeyFiles)+1)
+	if c.PublicKeyFile != "" {
+		keys = append(keys, c.PublicKeyFile)
+	}
+	return append(keys, c.PublicKeyFiles...)
+}
+
+func validateSignaturePolicy(policy string) error {
+	switch policy {
+	case SignaturePolicyEnforce, SignaturePolicyWarn, SignaturePolicySkip:
+		return nil
+	default:
+		return errors.Errorf("invalid signature policy %q", policy)
+	}
 }
 
 // Configure containerd snapshots interfaces and how to process the snapshots
@@ -188,6 +334,44 @@ type CacheManagerConfig struct {
 	// Example format: 24h, 120min
 	GCPeriod string `toml:"gc_period"`
 	CacheDir string `toml:"cache_dir"`
+	// Evict least recently used blob caches once the cache directory exceeds the size.
+	// Example format: 209715200, 200Gi, 80%
+	MaxSize string `toml:"max_size"`
+}
+
+type AccessTraceConfig struct {
+	// Record files accessed by containers after an image is mounted, stored per image
+	// manifest digest. The lists can be used as prefetch patterns when converting images.
+	Enable bool `toml:"enable"`
+	// How long to record after the image is mounted. Example format: 60s, 5m
+	Window string `toml:"window"`
+	// Where to get file accesses: "nydusd", "fanotify" or "auto"
+	Source string `toml:"source"`
+}
+
+// Publish events of nydusd daemons, e.g. crashes, recoveries and mount failures, so that
+// users can see why their containers are stuck.
+type EventsConfig struct {
+	Enable bool `toml:"enable"`
+	// Events are published to the events service of containerd at the address.
+	ContainerdAddress string `toml:"containerd_address"`
+	// Containerd namespace to publish events in
+	Namespace string `toml:"namespace"`
+	// Events are also recorded as Kubernetes Events on pods of the node running affected
+	// images if the kubeconfig is set.
+	KubeconfigPath string `toml:"kubeconfig_path"`
+}
+
+// Export OpenTelemetry traces of snapshotter operations, e.g. preparing snapshots, mounting
+// images and fetching from registries, to find out where the time of starting containers goes.
+type TracingConfig struct {
+	Enable bool `toml:"enable"`
+	// OTLP gRPC endpoint to export spans to, e.g. "localhost:4317"
+	Endpoint string `toml:"endpoint"`
+	// Connect to the OTLP endpoint without TLS
+	Insecure bool `toml:"insecure"`
+	// Write spans to the file as JSON lines instead of exporting them, for offline testing
+	ExportFile string `toml:"export_file"`
 }
 
 // Configure how nydus-snapshotter receive auth information
@@ -198,6 +382,12 @@ type AuthConfig struct {
 	// CRI proxy mode
 	EnableCRIKeychain   bool   `toml:"enable_cri_keychain"`
 	ImageServiceAddress string `toml:"image_service_address"`
+	// kubelet credential provider plugins
+	EnableKubeletCredentialProviders bool   `toml:"enable_kubelet_credential_providers"`
+	KubeletCredentialProviderConfig  string `toml:"kubelet_credential_provider_config"`
+	KubeletCredentialProviderBinDir  string `toml:"kubelet_credential_provider_bin_dir"`
+	// Interval to refresh registry auth of running nydusd, refreshing is disabled if empty
+	CredentialRefreshInterval string `toml:"credential_refresh_interval"`
 }
 
 // Configure remote storage like container registry
@@ -252,6 +442,9 @@ type SnapshotterConfig struct {
 	RemoteConfig           RemoteConfig           `toml:"remote"`
 	ImageConfig            ImageConfig            `toml:"image"`
 	CacheManagerConfig     CacheManagerConfig     `toml:"cache_manager"`
+	AccessTraceConfig      AccessTraceConfig      `toml:"access_trace"`
+	EventsConfig           EventsConfig           `toml:"events"`
+	TracingConfig          TracingConfig          `toml:"tracing"`
 	LoggingConfig          LoggingConfig          `toml:"log"`
 	CgroupConfig           CgroupConfig           `toml:"cgroup"`
 	Experimental           Experimental           `toml:"experimental"`
@@ -291,11 +484,24 @@ func ValidateConfig(c *SnapshotterConfig) error {
 		return errors.Wrapf(errdefs.ErrInvalidArgument, "configuration is none")
 	}
 
-	if c.ImageConfig.ValidateSignature {
-		if c.ImageConfig.PublicKeyFile == "" {
+	if c.ImageConfig.SignaturePolicy != "" {
+		if err := validateSignaturePolicy(c.ImageConfig.SignaturePolicy); err != nil {
+			return err
+		}
+	}
+	for host, policy := range c.ImageConfig.RegistrySignaturePolicies {
+		if err := validateSignaturePolicy(policy); err != nil {
+			return errors.Wrapf(err, "registry %s", host)
+		}
+	}
+	if c.ImageConfig.SignatureVerificationEnabled() {
+		if len(c.ImageConfig.PublicKeys()) == 0 {
 			return errors.New("public key file for signature validation is not provided")
-		} else if _, err := os.Stat(c.ImageConfig.PublicKeyFile); err != nil {
-			return errors.Wrapf(err, "check publicKey file %q", c.ImageConfig.PublicKeyFile)
+		}
+		for _, f := range c.ImageConfig.PublicKeys() {
+			if _, err := os.Stat(f); err != nil {
+				return errors.Wrapf(err, "check publicKey file %q", f)
+			}
 		}
 	}
 
@@ -323,11 +529,73 @@ func ValidateConfig(c *SnapshotterConfig) error {
 		c.DaemonConfig.FailoverPolicy != FailoverPolicyFlush {
 		return errors.Errorf("invalid failover policy %q", c.DaemonConfig.FailoverPolicy)
 	}
+	if watchdog := c.DaemonConfig.HungIOWatchdog; watchdog.Enable {
+		if d, err := time.ParseDuration(watchdog.Interval); err != nil || d <= 0 {
+			return errors.Errorf("invalid hung io watchdog interval %q", watchdog.Interval)
+		}
+		if d, err := time.ParseDuration(watchdog.Threshold); err != nil || d <= 0 {
+			return errors.Errorf("invalid hung io watchdog threshold %q", watchdog.Threshold)
+		}
+		switch watchdog.Action {
+		case HungIOActionEvent, HungIOActionDump:
+		case HungIOActionRecover:
+			if c.DaemonConfig.RecoverPolicy == RecoverPolicyNone.String() {
+				return errors.Errorf("hung io watchdog action %q requires a recover policy", watchdog.Action)
+			}
+		default:
+			return errors.Errorf("invalid hung io watchdog action %q", watchdog.Action)
+		}
+	}
 
 	if c.RemoteConfig.AuthConfig.EnableCRIKeychain && c.RemoteConfig.AuthConfig.EnableKubeconfigKeychain {
 		return errors.Wrapf(errdefs.ErrInvalidArgument,
 			"\"enable_cri_keychain\" and \"enable_kubeconfig_keychain\" can't be set at the same time")
 	}
+	if c.RemoteConfig.AuthConfig.EnableKubeletCredentialProviders &&
+		(c.RemoteConfig.AuthConfig.KubeletCredentialProviderConfig == "" || c.RemoteConfig.AuthConfig.KubeletCredentialProviderBinDir == "") {
+		return errors.Wrapf(errdefs.ErrInvalidArgument,
+			"\"kubelet_credential_provider_config\" and \"kubelet_credential_provider_bin_dir\" are required by kubelet credential providers")
+	}
+	if interval := c.RemoteConfig.AuthConfig.CredentialRefreshInterval; interval != "" {
+		if d, err := time.ParseDuration(interval); err != nil || d <= 0 {
+			return errors.Errorf("invalid credential refresh interval %q", interval)
+		}
+	}
+
+	if c.Experimental.P2PConfig.Enable {
+		if c.Experimental.P2PConfig.Address == "" {
+			return errors.New("p2p address is required")
+		}
+		if c.Experimental.P2PConfig.TokenFile == "" {
+			return errors.New("p2p token file is required")
+		}
+		if interval := c.Experimental.P2PConfig.RefreshInterval; interval != "" {
+			if d, err := time.ParseDuration(interval); err != nil || d <= 0 {
+				return errors.Errorf("invalid p2p refresh interval %q", interval)
+			}
+		}
+	}
+
+	if ttl := c.Experimental.ReferrerConfig.CacheTTL; ttl != "" {
+		if d, err := time.ParseDuration(ttl); err != nil || d <= 0 {
+			return errors.Errorf("invalid referrer cache ttl %q", ttl)
+		}
+	}
+
+	if c.TracingConfig.Enable && (c.TracingConfig.Endpoint == "") == (c.TracingConfig.ExportFile == "") {
+		return errors.New("exactly one of tracing endpoint and export file is required")
+	}
+
+	if c.AccessTraceConfig.Enable {
+		if _, err := time.ParseDuration(c.AccessTraceConfig.Window); err != nil {
+			return errors.Wrapf(err, "invalid access trace window %q", c.AccessTraceConfig.Window)
+		}
+		switch c.AccessTraceConfig.Source {
+		case AccessTraceSourceNydusd, AccessTraceSourceFanotify, AccessTraceSourceAuto:
+		default:
+			return errors.Errorf("invalid access trace source %q", c.AccessTraceConfig.Source)
+		}
+	}
 
 	if c.RemoteConfig.MirrorsConfig.Dir != "" {
 		dirExisted, err := file.IsDirExisted(c.RemoteConfig.MirrorsConfig.Dir)
@@ -402,6 +670,9 @@ func ParseParameters(args *flags.Args, cfg *SnapshotterConfig) error {
 	return nil
 }
 
+// CPU time of the cgroup is limited in every 100ms.
+const defaultCPUPeriod = uint64(100000)
+
 func ParseCgroupConfig(config CgroupConfig) (cgroup.Config, error) {
 	totalMemory, err := sysinfo.GetTotalMemoryBytes()
 	if err != nil {
@@ -413,7 +684,85 @@ func ParseCgroupConfig(config CgroupConfig) (cgroup.Config, error) {
 		return cgroup.Config{}, err
 	}
 
+	cpuQuota, err := parser.CPUQuotaConfigToMicroseconds(config.CPUQuota, defaultCPUPeriod, runtime.NumCPU())
+	if err != nil {
+		return cgroup.Config{}, err
+	}
+
+	ioLimits := make([]cgroup.IOLimit, 0, len(config.IOMax))
+	for _, data := range config.IOMax {
+		limits, err := parser.IOMaxConfigToLimits(data)
+		if err != nil {
+			return cgroup.Config{}, err
+		}
+		major, minor, err := parseBlockDevice(limits.Device)
+		if err != nil {
+			return cgroup.Config{}, err
+		}
+		ioLimits = append(ioLimits, cgroup.IOLimit{
+			Major:     major,
+			Minor:     minor,
+			ReadBps:   limits.ReadBps,
+			ReadIOPS:  limits.ReadIOPS,
+			WriteBps:  limits.WriteBps,
+			WriteIOPS: limits.WriteIOPS,
+		})
+	}
+
+	pidsMax := int64(-1)
+	if config.PidsMax > 0 {
+		pidsMax = config.PidsMax
+	}
+
 	return cgroup.Config{
 		MemoryLimitInBytes: memoryLimitInBytes,
+		CPUQuota:           cpuQuota,
+		CPUPeriod:          defaultCPUPeriod,
+		IOLimits:           ioLimits,
+		PidsMax:            pidsMax,
+		PerDaemon:          config.PerDaemon,
 	}, nil
 }
+
+// Get major and minor numbers of a block device, which is either a device path or "major:minor".
+func parseBlockDevice(device string) (int64, int64, error) {
+	var major, minor int64
+	if n, err := fmt.Sscanf(device, "%d:%d", &major, &minor); err == nil && n == 2 {
+		return major, minor, nil
+	}
+
+	var st unix.Stat_t
+	if err := unix.Stat(device, &st); err != nil {
+		return 0, 0, errors.Wrapf(err, "stat block device %s", device)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFBLK {
+		return 0, 0, errors.Errorf("%s is not a block device", device)
+	}
+
+	return int64(unix.Major(st.Rdev)), int64(unix.Minor(st.Rdev)), nil
+}
+
+// Parse the cache size limit in bytes, percentage is relative to the filesystem
+// hosting the cache directory. Returns -1 if no limit is configured.
+func ParseCacheMaxSize(config CacheManagerConfig) (int64, error) {
+	if config.MaxSize == "" {
+		return -1, nil
+	}
+
+	// Ensure cache directory exists so that its filesystem can be inspected.
+	if err := os.MkdirAll(config.CacheDir, 0755); err != nil {
+		return 0, errors.Wrapf(err, "create cache dir %s", config.CacheDir)
+	}
+
+	totalBytes, err := sysinfo.GetFilesystemTotalBytes(config.CacheDir)
+	if err != nil {
+		return 0, errors.Wrapf(err, "get filesystem size of %s", config.CacheDir)
+	}
+
+	maxSize, err := parser.MemoryConfigToBytes(config.MaxSize, int(totalBytes))
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid cache max size %q", config.MaxSize)
+	}
+
+	return maxSize, nil
+}
@@ -13,6 +13,7 @@ import (
 
 	"github.com/containerd/nydus-snapshotter/internal/constant"
 	"github.com/containerd/nydus-snapshotter/internal/flags"
+	"github.com/containerd/nydus-snapshotter/pkg/cgroup"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -70,14 +71,23 @@ func TestLoadSnapshotterTOMLConfig(t *testing.T) {
 			},
 		},
 		ImageConfig: ImageConfig{
-			PublicKeyFile:     "",
-			ValidateSignature: false,
+			PublicKeyFile:             "",
+			ValidateSignature:         false,
+			PublicKeyFiles:            []string{},
+			EnableReferrerSignature:   false,
+			SignaturePolicy:           "",
+			RegistrySignaturePolicies: map[string]string{},
 		},
 		CacheManagerConfig: CacheManagerConfig{
 			Disable:  false,
 			GCPeriod: "24h",
 			CacheDir: "",
 		},
+		AccessTraceConfig: AccessTraceConfig{
+			Enable: false,
+			Window: "60s",
+			Source: "auto",
+		},
 		LoggingConfig: LoggingConfig{
 			LogLevel:            "info",
 			RotateLogCompress:   true,
@@ -270,3 +280,24 @@ func TestProcessConfigurations(t *testing.T) {
 	err = ValidateConfig(&snapshotterConfig4)
 	A.Error(err)
 }
+
+func TestParseCgroupConfig(t *testing.T) {
+	A := assert.New(t)
+
+	cfg, err := ParseCgroupConfig(CgroupConfig{
+		Enable:    true,
+		CPUQuota:  "0.5",
+		IOMax:     []string{"8:16 rbps=10MiB riops=100"},
+		PerDaemon: true,
+	})
+	A.NoError(err)
+	A.Equal(int64(-1), cfg.MemoryLimitInBytes)
+	A.Equal(int64(50000), cfg.CPUQuota)
+	A.Equal(uint64(100000), cfg.CPUPeriod)
+	A.Equal([]cgroup.IOLimit{{Major: 8, Minor: 16, ReadBps: 10 << 20, ReadIOPS: 100}}, cfg.IOLimits)
+	A.Equal(int64(-1), cfg.PidsMax)
+	A.True(cfg.PerDaemon)
+
+	_, err = ParseCgroupConfig(CgroupConfig{IOMax: []string{"/dev/null rbps=1"}})
+	A.Error(err)
+}
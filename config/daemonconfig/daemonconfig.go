@@ -13,10 +13,13 @@ import (
 	"reflect"
 	"strings"
 
+	"github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
 
 	"github.com/containerd/nydus-snapshotter/config"
 	"github.com/containerd/nydus-snapshotter/pkg/auth"
+	"github.com/containerd/nydus-snapshotter/pkg/label"
+	"github.com/containerd/nydus-snapshotter/pkg/prefetch"
 	"github.com/containerd/nydus-snapshotter/pkg/utils/registry"
 )
 
@@ -131,7 +134,7 @@ type DeviceConfig struct {
 // is passed through HTTP API.
 func DumpConfigFile(c interface{}, path string) error {
 	if config.IsBackendSourceEnabled() {
-		c = serializeWithSecretFilter(c)
+		c = SerializeWithSecretFilter(c)
 	}
 	b, err := json.Marshal(c)
 	if err != nil {
@@ -155,17 +158,22 @@ func SupplementDaemonConfig(c DaemonConfig, imageID, snapshotID string,
 		return errors.Wrapf(err, "parse image %s", imageID)
 	}
 
+	// Prefetch lists recorded for the image are applied whenever it is mounted.
+	if _, ok := params[PrefetchFiles]; !ok && params != nil {
+		manifestDigest := digest.Digest(labels[label.CRIManifestDigest])
+		if files := prefetch.Pm.GetPrefetchInfo(imageID, manifestDigest); files != "" {
+			params[PrefetchFiles] = files
+		}
+	}
+	if label.IsPrefetchAll(labels) && params != nil {
+		params[PrefetchAll] = "true"
+	}
+
 	backendType, _ := c.StorageBackend()
 
 	switch backendType {
 	case backendTypeRegistry:
-		registryHost := image.Host
-		if vpcRegistry {
-			registryHost = registry.ConvertToVPCHost(registryHost)
-		} else if registryHost == "docker.io" {
-			// For docker.io images, we should use index.docker.io
-			registryHost = "index.docker.io"
-		}
+		registryHost := RegistryHost(image.Host, vpcRegistry)
 
 		if err := c.UpdateMirrors(config.GetMirrorsConfigDir(), registryHost); err != nil {
 			return errors.Wrap(err, "update mirrors config")
@@ -188,7 +196,19 @@ func SupplementDaemonConfig(c DaemonConfig, imageID, snapshotID string,
 	return nil
 }
 
-func serializeWithSecretFilter(obj interface{}) map[string]interface{} {
+// RegistryHost returns the registry host which nydusd fetches image blobs from.
+func RegistryHost(imageHost string, vpcRegistry bool) string {
+	if vpcRegistry {
+		return registry.ConvertToVPCHost(imageHost)
+	} else if imageHost == "docker.io" {
+		// For docker.io images, we should use index.docker.io
+		return "index.docker.io"
+	}
+	return imageHost
+}
+
+// SerializeWithSecretFilter converts the configuration to a map without fields tagged as secret.
+func SerializeWithSecretFilter(obj interface{}) map[string]interface{} {
 	result := make(map[string]interface{})
 	value := reflect.ValueOf(obj)
 	typeOfObj := reflect.TypeOf(obj)
@@ -227,9 +247,9 @@ func serializeWithSecretFilter(obj interface{}) map[string]interface{} {
 		//nolint:exhaustive
 		switch fieldType.Type.Kind() {
 		case reflect.Struct:
-			result[jsonTags[0]] = serializeWithSecretFilter(field.Interface())
+			result[jsonTags[0]] = SerializeWithSecretFilter(field.Interface())
 		case reflect.Ptr:
-			result[jsonTags[0]] = serializeWithSecretFilter(field.Elem().Interface())
+			result[jsonTags[0]] = SerializeWithSecretFilter(field.Elem().Interface())
 		default:
 			result[jsonTags[0]] = field.Interface()
 		}
@@ -134,7 +134,7 @@ func TestSerializeWithSecretFilter(t *testing.T) {
 }`)
 	var cfg FuseDaemonConfig
 	_ = json.Unmarshal(buf, &cfg)
-	filter := serializeWithSecretFilter(&cfg)
+	filter := SerializeWithSecretFilter(&cfg)
 	jsonData, err := json.Marshal(filter)
 	require.Nil(t, err)
 	var newCfg FuseDaemonConfig
@@ -16,7 +16,11 @@ import (
 	"github.com/containerd/nydus-snapshotter/pkg/auth"
 )
 
-const CacheDir string = "cachedir"
+const (
+	CacheDir      string = "cachedir"
+	PrefetchFiles string = "prefetch_files"
+	PrefetchAll   string = "prefetch_all"
+)
 
 // Used when nydusd works as a FUSE daemon or vhost-user-fs backend
 type FuseDaemonConfig struct {
@@ -68,6 +72,10 @@ func (c *FuseDaemonConfig) Supplement(host, repo, _ string, params map[string]st
 		c.Device.Backend.Config.Repo = repo
 	}
 	c.Device.Cache.Config.WorkDir = params[CacheDir]
+	if params[PrefetchAll] == "true" {
+		c.FSPrefetch.Enable = true
+		c.FSPrefetch.PrefetchAll = true
+	}
 }
 
 func (c *FuseDaemonConfig) FillAuth(kc *auth.PassKeyChain) {
@@ -235,6 +235,19 @@ func loadHostDir(hostsDir string) ([]hostConfig, error) {
 	return hosts, nil
 }
 
+// HostsFile returns the path of `hosts.toml` which configures mirrors of the registry host,
+// or an empty string if no host directory is found. The file itself may not exist.
+func HostsFile(mirrorsConfigDir, registryHost string) (string, error) {
+	if mirrorsConfigDir == "" {
+		return "", nil
+	}
+	hostDir, err := hostDirFromRoot(mirrorsConfigDir, registryHost)
+	if err != nil || hostDir == "" {
+		return "", err
+	}
+	return filepath.Join(hostDir, "hosts.toml"), nil
+}
+
 func LoadMirrorsConfig(mirrorsConfigDir, registryHost string) ([]MirrorConfig, error) {
 	var mirrors []MirrorConfig
 
@@ -45,6 +45,16 @@ func (c *SnapshotterConfig) FillUpWithDefaults() error {
 	daemonConfig.FsDriver = constant.DefaultFsDriver
 	daemonConfig.LogRotationSize = constant.DefaultDaemonRotateLogMaxSize
 	daemonConfig.FailoverPolicy = constant.DefaultFailoverPolicy
+	watchdogConfig := &daemonConfig.HungIOWatchdog
+	if watchdogConfig.Interval == "" {
+		watchdogConfig.Interval = constant.DefaultHungIOWatchdogInterval
+	}
+	if watchdogConfig.Threshold == "" {
+		watchdogConfig.Threshold = constant.DefaultHungIOWatchdogThreshold
+	}
+	if watchdogConfig.Action == "" {
+		watchdogConfig.Action = constant.DefaultHungIOWatchdogAction
+	}
 
 	// cache configuration
 	cacheConfig := &c.CacheManagerConfig
@@ -52,6 +62,15 @@ func (c *SnapshotterConfig) FillUpWithDefaults() error {
 		cacheConfig.GCPeriod = constant.DefaultGCPeriod
 	}
 
+	// access trace configuration
+	traceConfig := &c.AccessTraceConfig
+	if traceConfig.Window == "" {
+		traceConfig.Window = constant.DefaultAccessTraceWindow
+	}
+	if traceConfig.Source == "" {
+		traceConfig.Source = constant.DefaultAccessTraceSource
+	}
+
 	return c.SetupNydusBinaryPaths()
 }
 
@@ -37,6 +37,13 @@ const (
 	DefaultLogLevel string = "info"
 	DefaultGCPeriod string = "24h"
 
+	DefaultAccessTraceWindow string = "60s"
+	DefaultAccessTraceSource string = AccessTraceSourceAuto
+
+	DefaultHungIOWatchdogInterval  string = "10s"
+	DefaultHungIOWatchdogThreshold string = "60s"
+	DefaultHungIOWatchdogAction    string = HungIOActionEvent
+
 	DefaultNydusDaemonConfigPath string = "/etc/nydus/nydusd-config.json"
 	NydusdBinaryName             string = "nydusd"
 	NydusImageBinaryName         string = "nydus-image"
@@ -60,3 +67,21 @@ const (
 	FailoverPolicyFlush   string = "flush"
 	DefaultFailoverPolicy string = FailoverPolicyResend
 )
+
+const (
+	HungIOActionEvent   string = "event"
+	HungIOActionDump    string = "dump"
+	HungIOActionRecover string = "recover"
+)
+
+const (
+	AccessTraceSourceNydusd   string = "nydusd"
+	AccessTraceSourceFanotify string = "fanotify"
+	AccessTraceSourceAuto     string = "auto"
+)
+
+const (
+	SignaturePolicyEnforce string = "enforce"
+	SignaturePolicyWarn    string = "warn"
+	SignaturePolicySkip    string = "skip"
+)
@@ -0,0 +1,155 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package accesstrace
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon"
+	"github.com/containerd/nydus-snapshotter/pkg/rafs"
+)
+
+const pollInterval = time.Second
+
+// Watch open events on the RAFS filesystem until the window elapses.
+//
+// Containers access image files through overlayfs, which opens lower files
+// from a private clone of the RAFS mount. So the whole filesystem rather than
+// the mount is watched, and the reported paths are matched against prefixes
+// relative to both the mountpoint and the filesystem root.
+func watchFanotify(ctx context.Context, r *rafs.Rafs, d *daemon.Daemon, window time.Duration) ([]string, error) {
+	mountpoint := r.GetMountpoint()
+
+	fd, err := unix.FanotifyInit(unix.FAN_CLASS_NOTIF|unix.FAN_CLOEXEC|unix.FAN_NONBLOCK,
+		unix.O_RDONLY|unix.O_LARGEFILE|unix.O_CLOEXEC)
+	if err != nil {
+		return nil, errors.Wrap(err, "init fanotify")
+	}
+	defer unix.Close(fd)
+
+	if err := unix.FanotifyMark(fd, unix.FAN_MARK_ADD|unix.FAN_MARK_FILESYSTEM,
+		unix.FAN_OPEN, unix.AT_FDCWD, mountpoint); err != nil {
+		return nil, errors.Wrapf(err, "mark fanotify on %s", mountpoint)
+	}
+
+	prefixes := []string{mountpoint}
+	if d.IsSharedDaemon() && r.GetFsDriver() != config.FsDriverFscache {
+		// All instances of a shared FUSE daemon are in one filesystem.
+		prefixes = append(prefixes, r.RelaMountpoint())
+	} else {
+		prefixes = append(prefixes, "/")
+	}
+
+	w := fanotifyWatcher{
+		pid:      int32(os.Getpid()),
+		prefixes: prefixes,
+		seen:     make(map[string]struct{}),
+	}
+
+	deadline := time.Now().Add(window)
+	buf := make([]byte, 64*1024)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return w.files, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		timeout := pollInterval
+		if remaining < timeout {
+			timeout = remaining
+		}
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		n, err := unix.Poll(fds, int(timeout.Milliseconds()))
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return nil, errors.Wrap(err, "poll fanotify")
+		}
+		if n == 0 {
+			continue
+		}
+
+		n, err = unix.Read(fd, buf)
+		if err != nil {
+			if err == unix.EAGAIN || err == unix.EINTR {
+				continue
+			}
+			return nil, errors.Wrap(err, "read fanotify events")
+		}
+		if err := w.handleEvents(buf[:n]); err != nil {
+			return nil, err
+		}
+	}
+}
+
+type fanotifyWatcher struct {
+	pid      int32
+	prefixes []string
+	seen     map[string]struct{}
+	files    []string
+}
+
+func (w *fanotifyWatcher) handleEvents(buf []byte) error {
+	metaLen := int(unsafe.Sizeof(unix.FanotifyEventMetadata{}))
+	for off := 0; off+metaLen <= len(buf); {
+		meta := (*unix.FanotifyEventMetadata)(unsafe.Pointer(&buf[off]))
+		if meta.Vers != unix.FANOTIFY_METADATA_VERSION {
+			return errors.Errorf("unsupported fanotify metadata version %d", meta.Vers)
+		}
+		if meta.Event_len < uint32(metaLen) {
+			return errors.Errorf("invalid fanotify event length %d", meta.Event_len)
+		}
+		if meta.Fd >= 0 {
+			path, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", meta.Fd))
+			unix.Close(int(meta.Fd))
+			if err == nil && meta.Pid != w.pid {
+				w.add(path)
+			}
+		}
+		off += int(meta.Event_len)
+	}
+	return nil
+}
+
+func (w *fanotifyWatcher) add(path string) {
+	for _, prefix := range w.prefixes {
+		rel, ok := trimPathPrefix(path, prefix)
+		if !ok {
+			continue
+		}
+		if _, ok := w.seen[rel]; !ok {
+			w.seen[rel] = struct{}{}
+			w.files = append(w.files, rel)
+		}
+		return
+	}
+}
+
+// Return the path relative to `prefix` as an absolute path.
+func trimPathPrefix(path, prefix string) (string, bool) {
+	if prefix == "/" {
+		return filepath.Clean(path), strings.HasPrefix(path, "/")
+	}
+	if path == prefix || !strings.HasPrefix(path, prefix+"/") {
+		return "", false
+	}
+	return filepath.Clean(strings.TrimPrefix(path, prefix)), true
+}
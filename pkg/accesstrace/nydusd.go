@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package accesstrace
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+	"github.com/containerd/nydus-snapshotter/pkg/rafs"
+)
+
+// Nydusd identifies the RAFS instance of a shared daemon by the snapshot ID.
+func instanceID(r *rafs.Rafs, d *daemon.Daemon) string {
+	if d.IsSharedDaemon() {
+		return r.SnapshotID
+	}
+	return ""
+}
+
+func accessPatternEnabled(r *rafs.Rafs, d *daemon.Daemon) bool {
+	if r.GetFsDriver() != config.FsDriverFusedev {
+		return false
+	}
+	m, err := d.GetFsMetrics(instanceID(r, d))
+	if err != nil {
+		log.L.WithError(err).Debugf("failed to get fs metrics of snapshot %s", r.SnapshotID)
+		return false
+	}
+	return m.AccessPatternEnabled
+}
+
+func collectAccessPatterns(ctx context.Context, r *rafs.Rafs, d *daemon.Daemon) ([]string, error) {
+	patterns, err := d.GetAccessPatterns(instanceID(r, d))
+	if err != nil {
+		return nil, errors.Wrap(err, "get access patterns")
+	}
+	return resolveInodes(ctx, r.GetMountpoint(), patterns)
+}
+
+// Nydusd only knows inode numbers, so walk the mounted filesystem to find
+// out their paths. Returned paths are sorted by the time of first access.
+func resolveInodes(ctx context.Context, mountpoint string, patterns []types.AccessPattern) ([]string, error) {
+	wanted := make(map[uint64]struct{}, len(patterns))
+	for _, p := range patterns {
+		if p.NrRead > 0 {
+			wanted[p.Ino] = struct{}{}
+		}
+	}
+
+	paths := make(map[uint64]string, len(wanted))
+	err := filepath.WalkDir(mountpoint, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if len(paths) == len(wanted) {
+			return filepath.SkipAll
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+		if _, ok := wanted[st.Ino]; !ok {
+			return nil
+		}
+		rel, err := filepath.Rel(mountpoint, path)
+		if err != nil {
+			return nil
+		}
+		paths[st.Ino] = filepath.Join("/", rel)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "walk %s", mountpoint)
+	}
+
+	sorted := make([]types.AccessPattern, 0, len(patterns))
+	for _, p := range patterns {
+		if _, ok := paths[p.Ino]; ok {
+			sorted = append(sorted, p)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.FirstAccessTimeSecs != b.FirstAccessTimeSecs {
+			return a.FirstAccessTimeSecs < b.FirstAccessTimeSecs
+		}
+		return a.FirstAccessTimeNanos < b.FirstAccessTimeNanos
+	})
+
+	files := make([]string, 0, len(sorted))
+	for _, p := range sorted {
+		files = append(files, paths[p.Ino])
+	}
+	return files, nil
+}
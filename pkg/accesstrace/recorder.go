@@ -0,0 +1,305 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package accesstrace records which files a container reads within a time
+// window after its image is mounted. The file lists are stored per image
+// manifest digest and are suitable to be used as prefetch patterns when
+// converting the image.
+package accesstrace
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon"
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/rafs"
+)
+
+const (
+	// Get file accesses from nydusd, requires access pattern to be enabled
+	// in nydusd configuration. Only works for FUSE.
+	SourceNydusd = config.AccessTraceSourceNydusd
+	// Get file accesses by watching the RAFS filesystem with fanotify.
+	SourceFanotify = config.AccessTraceSourceFanotify
+	// Use nydusd if it records access patterns, otherwise fanotify.
+	SourceAuto = config.AccessTraceSourceAuto
+)
+
+const metaFileSuffix = ".json"
+
+// Trace describes a recorded file access list.
+type Trace struct {
+	Digest    digest.Digest `json:"digest"`
+	ImageRef  string        `json:"image_ref"`
+	Source    string        `json:"source"`
+	Files     int           `json:"files"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+type session struct {
+	digest digest.Digest
+	cancel context.CancelFunc
+}
+
+type Recorder struct {
+	dir    string
+	window time.Duration
+	source string
+
+	mu sync.Mutex
+	// Recording sessions indexed by snapshot ID.
+	sessions map[string]*session
+}
+
+func NewRecorder(dir string, window time.Duration, source string) (*Recorder, error) {
+	switch source {
+	case SourceNydusd, SourceFanotify, SourceAuto:
+	default:
+		return nil, errors.Errorf("invalid access trace source %q", source)
+	}
+	if window <= 0 {
+		return nil, errors.Errorf("invalid access trace window %s", window)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "create access trace dir %s", dir)
+	}
+
+	return &Recorder{
+		dir:      dir,
+		window:   window,
+		source:   source,
+		sessions: make(map[string]*session),
+	}, nil
+}
+
+// Start recording file accesses on the RAFS instance served by daemon `d` in background.
+// Nothing is recorded if the image already has a trace or is being recorded.
+func (rc *Recorder) Start(r *rafs.Rafs, d *daemon.Daemon, manifestDigest digest.Digest) {
+	if err := manifestDigest.Validate(); err != nil {
+		log.L.Debugf("skip recording access trace for snapshot %s, invalid manifest digest %q", r.SnapshotID, manifestDigest)
+		return
+	}
+	if _, err := os.Stat(rc.listFile(manifestDigest)); err == nil {
+		return
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for _, s := range rc.sessions {
+		if s.digest == manifestDigest {
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rc.sessions[r.SnapshotID] = &session{digest: manifestDigest, cancel: cancel}
+
+	go func() {
+		defer rc.finish(r.SnapshotID)
+		if err := rc.record(ctx, r, d, manifestDigest); err != nil {
+			if errors.Is(err, context.Canceled) {
+				log.L.Infof("recording access trace of image %s is aborted", r.ImageID)
+				return
+			}
+			log.L.WithError(err).Warnf("failed to record access trace of image %s", r.ImageID)
+		}
+	}()
+}
+
+// Stop aborts the recording session on the snapshot, it must be called
+// before the RAFS instance is umounted.
+func (rc *Recorder) Stop(snapshotID string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if s, ok := rc.sessions[snapshotID]; ok {
+		s.cancel()
+	}
+}
+
+func (rc *Recorder) finish(snapshotID string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if s, ok := rc.sessions[snapshotID]; ok {
+		s.cancel()
+		delete(rc.sessions, snapshotID)
+	}
+}
+
+func (rc *Recorder) record(ctx context.Context, r *rafs.Rafs, d *daemon.Daemon, manifestDigest digest.Digest) error {
+	source := rc.source
+	if source == SourceAuto {
+		source = SourceFanotify
+		if accessPatternEnabled(r, d) {
+			source = SourceNydusd
+		}
+	}
+
+	log.L.Infof("start recording access trace of image %s by %s for %s", r.ImageID, source, rc.window)
+
+	var files []string
+	var err error
+	switch source {
+	case SourceNydusd:
+		select {
+		case <-time.After(rc.window):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		files, err = collectAccessPatterns(ctx, r, d)
+	case SourceFanotify:
+		files, err = watchFanotify(ctx, r, d, rc.window)
+	}
+	if err != nil {
+		return err
+	}
+
+	trace := Trace{
+		Digest:    manifestDigest,
+		ImageRef:  r.ImageID,
+		Source:    source,
+		Files:     len(files),
+		CreatedAt: time.Now(),
+	}
+	if err := rc.save(trace, files); err != nil {
+		return errors.Wrap(err, "save access trace")
+	}
+
+	log.L.Infof("recorded access trace of image %s, %d files", r.ImageID, len(files))
+
+	return nil
+}
+
+func (rc *Recorder) listFile(d digest.Digest) string {
+	return filepath.Join(rc.dir, d.Encoded())
+}
+
+func (rc *Recorder) metaFile(d digest.Digest) string {
+	return rc.listFile(d) + metaFileSuffix
+}
+
+// The list file is written last since its existence tells that a trace is complete.
+func (rc *Recorder) save(trace Trace, files []string) error {
+	meta, err := json.Marshal(trace)
+	if err != nil {
+		return errors.Wrap(err, "marshal trace")
+	}
+	if err := writeFileAtomic(rc.metaFile(trace.Digest), []byte(meta)); err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	for _, f := range files {
+		b.WriteString(f)
+		b.WriteByte('\n')
+	}
+	return writeFileAtomic(rc.listFile(trace.Digest), []byte(b.String()))
+}
+
+// List all recorded traces, the most recent comes first.
+func (rc *Recorder) List() ([]Trace, error) {
+	entries, err := os.ReadDir(rc.dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read access trace dir %s", rc.dir)
+	}
+
+	traces := []Trace{}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), metaFileSuffix) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(rc.dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		var t Trace
+		if err := json.Unmarshal(data, &t); err != nil {
+			log.L.WithError(err).Warnf("invalid access trace %s", e.Name())
+			continue
+		}
+		if _, err := os.Stat(rc.listFile(t.Digest)); err != nil {
+			continue
+		}
+		traces = append(traces, t)
+	}
+
+	sort.Slice(traces, func(i, j int) bool {
+		return traces[i].CreatedAt.After(traces[j].CreatedAt)
+	})
+
+	return traces, nil
+}
+
+// Open the file list of the image, one absolute path per line in the order of first access.
+func (rc *Recorder) Open(d digest.Digest) (io.ReadCloser, error) {
+	if err := d.Validate(); err != nil {
+		return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "digest %q", d)
+	}
+	f, err := os.Open(rc.listFile(d))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.Wrapf(errdefs.ErrNotFound, "access trace of %s", d)
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// Files returns the file list of the image.
+func (rc *Recorder) Files(d digest.Digest) ([]string, error) {
+	rd, err := rc.Open(d)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	var files []string
+	scanner := bufio.NewScanner(rd)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, scanner.Err()
+}
+
+// Delete the trace of the image, so that it will be recorded again next time.
+func (rc *Recorder) Delete(d digest.Digest) error {
+	if err := d.Validate(); err != nil {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "digest %q", d)
+	}
+	if err := os.Remove(rc.listFile(d)); err != nil {
+		if os.IsNotExist(err) {
+			return errors.Wrapf(errdefs.ErrNotFound, "access trace of %s", d)
+		}
+		return err
+	}
+	if err := os.Remove(rc.metaFile(d)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package accesstrace
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+)
+
+func TestRecorderStorage(t *testing.T) {
+	rc, err := NewRecorder(t.TempDir(), time.Minute, SourceAuto)
+	require.NoError(t, err)
+
+	d1 := digest.FromString("image1")
+	d2 := digest.FromString("image2")
+	now := time.Now()
+
+	require.NoError(t, rc.save(Trace{Digest: d1, ImageRef: "image1", Source: SourceFanotify, Files: 2, CreatedAt: now.Add(-time.Hour)},
+		[]string{"/bin/sh", "/etc/passwd"}))
+	require.NoError(t, rc.save(Trace{Digest: d2, ImageRef: "image2", Source: SourceNydusd, CreatedAt: now}, nil))
+
+	traces, err := rc.List()
+	require.NoError(t, err)
+	require.Len(t, traces, 2)
+	require.Equal(t, d2, traces[0].Digest)
+	require.Equal(t, d1, traces[1].Digest)
+
+	files, err := rc.Files(d1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/bin/sh", "/etc/passwd"}, files)
+
+	require.NoError(t, rc.Delete(d1))
+	_, err = rc.Files(d1)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+	require.ErrorIs(t, rc.Delete(d1), errdefs.ErrNotFound)
+	require.ErrorIs(t, rc.Delete("invalid"), errdefs.ErrInvalidArgument)
+
+	traces, err = rc.List()
+	require.NoError(t, err)
+	require.Len(t, traces, 1)
+}
+
+func TestTrimPathPrefix(t *testing.T) {
+	for _, c := range []struct {
+		path, prefix, expected string
+		ok                     bool
+	}{
+		{"/mnt/rafs/bin/sh", "/mnt/rafs", "/bin/sh", true},
+		{"/mnt/rafs", "/mnt/rafs", "", false},
+		{"/mnt/rafs2/bin/sh", "/mnt/rafs", "", false},
+		{"/bin/sh", "/", "/bin/sh", true},
+	} {
+		rel, ok := trimPathPrefix(c.path, c.prefix)
+		require.Equal(t, c.ok, ok, c.path)
+		require.Equal(t, c.expected, rel, c.path)
+	}
+}
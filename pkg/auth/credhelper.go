@@ -0,0 +1,183 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/log"
+	dockerconfig "github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/docker-credential-helpers/client"
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+const (
+	// Credential helpers are executables named `docker-credential-<name>` in PATH.
+	credentialHelperPrefix  = "docker-credential-"
+	credentialHelperTimeout = 30 * time.Second
+	// Refresh the credential before it really expires.
+	credentialExpiryMargin = 5 * time.Minute
+)
+
+var (
+	// How long a credential is cached if the helper does not tell its expiry.
+	CredentialHelperCacheTTL = 10 * time.Minute
+
+	credentialHelperCache = newCredentialCache()
+)
+
+type cachedCredential struct {
+	keychain *PassKeyChain
+	expireAt time.Time
+}
+
+type credentialCache struct {
+	sync.Mutex
+	// Indexed by helper name and host.
+	entries map[string]cachedCredential
+}
+
+func newCredentialCache() *credentialCache {
+	return &credentialCache{entries: make(map[string]cachedCredential)}
+}
+
+func (c *credentialCache) get(key string) *PassKeyChain {
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(e.expireAt) {
+		delete(c.entries, key)
+		return nil
+	}
+	return e.keychain
+}
+
+func (c *credentialCache) set(key string, kc *PassKeyChain, expireAt time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	c.entries[key] = cachedCredential{keychain: kc, expireAt: expireAt}
+}
+
+// The helper configured for the host by `credHelpers`, falls back to `credsStore`.
+func credentialHelper(config *configfile.ConfigFile, host string) string {
+	if helper, ok := config.CredentialHelpers[host]; ok && helper != "" {
+		return helper
+	}
+	return config.CredentialsStore
+}
+
+// FromCredentialHelper finds auth for a given host by executing the docker credential
+// helper configured by `credHelpers` or `credsStore` in docker's config.json. The auth
+// is cached until it expires.
+func FromCredentialHelper(host string) *PassKeyChain {
+	if len(host) == 0 {
+		return nil
+	}
+
+	if host == convertedDockerHost {
+		host = dockerHost
+	}
+
+	config := dockerconfig.LoadDefaultConfigFile(os.Stderr)
+	helper := credentialHelper(config, host)
+	if helper == "" {
+		return nil
+	}
+
+	key := helper + "/" + host
+	if kc := credentialHelperCache.get(key); kc != nil {
+		return kc
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), credentialHelperTimeout)
+	defer cancel()
+	creds, err := client.Get(newHelperProgramFunc(ctx, credentialHelperPrefix+helper), host)
+	if err != nil {
+		if credentials.IsErrCredentialsNotFound(err) {
+			log.L.Debugf("no auth from credential helper %s for host %s", helper, host)
+		} else {
+			log.L.WithError(err).Warnf("failed to get auth from credential helper %s for host %s", helper, host)
+		}
+		return nil
+	}
+
+	// Do not return empty auth. It makes caller life easier.
+	if len(creds.Username) == 0 || len(creds.Secret) == 0 {
+		return nil
+	}
+
+	kc := &PassKeyChain{
+		Username: creds.Username,
+		Password: creds.Secret,
+	}
+	credentialHelperCache.set(key, kc, credentialExpiry(creds.Secret))
+
+	return kc
+}
+
+// Credentials of some registries, e.g. ACR, are JWTs telling when they expire. Others are
+// cached for `CredentialHelperCacheTTL`.
+func credentialExpiry(secret string) time.Time {
+	expireAt := time.Now().Add(CredentialHelperCacheTTL)
+	if exp, ok := tokenExpiry(secret); ok && exp.Add(-credentialExpiryMargin).Before(expireAt) {
+		return exp.Add(-credentialExpiryMargin)
+	}
+	return expireAt
+}
+
+// Get the expiry of the secret if it's a JWT.
+func tokenExpiry(secret string) (time.Time, bool) {
+	parts := strings.Split(secret, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
+
+// Execute the credential helper with a timeout, so that a stuck helper won't block image pulling.
+type helperProgram struct {
+	cmd *exec.Cmd
+}
+
+func newHelperProgramFunc(ctx context.Context, name string) client.ProgramFunc {
+	return func(args ...string) client.Program {
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Env = os.Environ()
+		return &helperProgram{cmd: cmd}
+	}
+}
+
+func (p *helperProgram) Output() ([]byte, error) {
+	return p.cmd.Output()
+}
+
+func (p *helperProgram) Input(in io.Reader) {
+	p.cmd.Stdin = in
+}
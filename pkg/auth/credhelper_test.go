@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package auth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	dockerconfig "github.com/docker/cli/cli/config"
+	"github.com/stretchr/testify/require"
+)
+
+// The fake helper counts its invocations and only knows `ecr.example.com`.
+const fakeCredentialHelper = `#!/bin/sh
+[ "$1" = "get" ] || exit 1
+read host
+echo "$host" >> "%s"
+if [ "$host" = "ecr.example.com" ]; then
+	echo '{"ServerURL":"ecr.example.com","Username":"AWS","Secret":"%s"}'
+else
+	echo "credentials not found in native keychain"
+	exit 1
+fi
+`
+
+func TestCredentialHelper(t *testing.T) {
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	jwt := "e30." + base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, time.Now().Add(time.Hour).Unix()))) + ".sig"
+
+	helper := fmt.Sprintf(fakeCredentialHelper, calls, jwt)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "docker-credential-fake"), []byte(helper), 0755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	config := `{"auths":{"ecr.example.com":{}},"credHelpers":{"ecr.example.com":"fake","other.example.com":"fake"}}`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, configFile), []byte(config), 0600))
+	t.Setenv("DOCKER_CONFIG", dir)
+	oldDir, oldCache := dockerconfig.Dir(), credentialHelperCache
+	t.Cleanup(func() {
+		dockerconfig.SetDir(oldDir)
+		credentialHelperCache = oldCache
+	})
+	dockerconfig.SetDir(dir)
+	credentialHelperCache = newCredentialCache()
+
+	// Inline auth is skipped for hosts using credential helpers.
+	require.Nil(t, FromDockerConfig("ecr.example.com"))
+
+	kc := GetRegistryKeyChain("ecr.example.com", "ecr.example.com/app:latest", nil)
+	require.NotNil(t, kc)
+	require.Equal(t, "AWS", kc.Username)
+	require.Equal(t, jwt, kc.Password)
+
+	// Served from cache.
+	kc = FromCredentialHelper("ecr.example.com")
+	require.NotNil(t, kc)
+	require.Nil(t, FromCredentialHelper("other.example.com"))
+	require.Nil(t, FromCredentialHelper("unknown.example.com"))
+
+	data, err := os.ReadFile(calls)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ecr.example.com", "other.example.com"}, strings.Fields(string(data)))
+
+	expireAt := credentialHelperCache.entries["fake/ecr.example.com"].expireAt
+	require.WithinDuration(t, time.Now().Add(CredentialHelperCacheTTL), expireAt, 5*time.Second)
+	// Expires earlier than the JWT.
+	jwt = "e30." + base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, time.Now().Add(8*time.Minute).Unix()))) + ".sig"
+	require.WithinDuration(t, time.Now().Add(3*time.Minute), credentialExpiry(jwt), 5*time.Second)
+}
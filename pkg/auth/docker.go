@@ -18,7 +18,7 @@ const (
 	convertedDockerHost = "registry-1.docker.io"
 )
 
-// FromDockerConfig finds auth for a given host in docker's config.json settings.
+// FromDockerConfig finds auth for a given host in `auths` of docker's config.json settings.
 func FromDockerConfig(host string) *PassKeyChain {
 	if len(host) == 0 {
 		return nil
@@ -32,6 +32,10 @@ func FromDockerConfig(host string) *PassKeyChain {
 	}
 
 	config := dockerconfig.LoadDefaultConfigFile(os.Stderr)
+	// Leave it to FromCredentialHelper, which caches auth from credential helpers.
+	if credentialHelper(config, host) != "" {
+		return nil
+	}
 	authConfig, err := config.GetAuthConfig(host)
 	if err != nil {
 		logrus.WithError(err).Infof("no auth from docker config for host %s", host)
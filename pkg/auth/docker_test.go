@@ -13,6 +13,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	dockerconfig "github.com/docker/cli/cli/config"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -46,6 +47,8 @@ func setupDockerConfig() (string, error) {
 		return "", err
 	}
 	os.Setenv("DOCKER_CONFIG", dir)
+	// The config directory is cached once looked up.
+	dockerconfig.SetDir(dir)
 
 	err = os.WriteFile(filepath.Join(dir, configFile),
 		[]byte(fmt.Sprintf(testConfigFmt, dockerHost, base64.StdEncoding.EncodeToString([]byte(dockerUser+":"+dockerPass)),
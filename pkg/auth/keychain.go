@@ -10,6 +10,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -60,6 +61,11 @@ func (kc PassKeyChain) TokenBase() bool {
 	return kc.Username == "" && kc.Password != ""
 }
 
+// Expiry tells when the auth expires, only if the password is a JWT with expiry.
+func (kc PassKeyChain) Expiry() (time.Time, bool) {
+	return tokenExpiry(kc.Password)
+}
+
 // FromLabels finds image pull username and secret from snapshot labels.
 // Returned `nil` means no valid username and secret is passed, it should
 // not override input nydusd configuration.
@@ -84,7 +90,9 @@ func FromLabels(labels map[string]string) *PassKeyChain {
 // 1. username and secrets labels
 // 2. cri request
 // 3. docker config
-// 4. k8s docker config secret
+// 4. docker credential helpers
+// 5. kubelet credential provider plugins
+// 6. k8s docker config secret
 func GetRegistryKeyChain(host, ref string, labels map[string]string) *PassKeyChain {
 	kc := FromLabels(labels)
 	if kc != nil {
@@ -102,6 +110,16 @@ func GetRegistryKeyChain(host, ref string, labels map[string]string) *PassKeyCha
 		return kc
 	}
 
+	kc = FromCredentialHelper(host)
+	if kc != nil {
+		return kc
+	}
+
+	kc = FromKubeletCredentialProviders(host, ref)
+	if kc != nil {
+		return kc
+	}
+
 	return FromKubeSecretDockerConfig(host)
 }
 
@@ -0,0 +1,343 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Nydusd fetches blobs lazily long after the image is pulled by CRI, so the snapshotter
+// runs kubelet credential provider plugins on its own to get registry credentials.
+// See https://kubernetes.io/docs/tasks/administer-cluster/kubelet-credential-provider/
+
+const (
+	credentialProviderRequestKind  = "CredentialProviderRequest"
+	credentialProviderResponseKind = "CredentialProviderResponse"
+	credentialProviderTimeout      = time.Minute
+
+	cacheKeyTypeImage    = "Image"
+	cacheKeyTypeRegistry = "Registry"
+	cacheKeyTypeGlobal   = "Global"
+)
+
+var (
+	kubeletCredentialProviders *KubeletCredentialProviders
+	kubeletProvidersMu         sync.Mutex
+)
+
+// Same as `CredentialProviderConfig` of kubelet.
+type credentialProviderConfig struct {
+	Providers []credentialProviderSpec `json:"providers"`
+}
+
+type credentialProviderSpec struct {
+	Name                 string           `json:"name"`
+	MatchImages          []string         `json:"matchImages"`
+	DefaultCacheDuration *metav1.Duration `json:"defaultCacheDuration,omitempty"`
+	APIVersion           string           `json:"apiVersion"`
+	Args                 []string         `json:"args,omitempty"`
+	Env                  []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"env,omitempty"`
+}
+
+type credentialProviderRequest struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Image      string `json:"image"`
+}
+
+type credentialProviderResponse struct {
+	APIVersion    string           `json:"apiVersion"`
+	Kind          string           `json:"kind"`
+	CacheKeyType  string           `json:"cacheKeyType"`
+	CacheDuration *metav1.Duration `json:"cacheDuration,omitempty"`
+	Auth          map[string]struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auth,omitempty"`
+}
+
+type cachedProviderResponse struct {
+	response *credentialProviderResponse
+	expireAt time.Time
+}
+
+type credentialProvider struct {
+	spec credentialProviderSpec
+	path string
+
+	mu sync.Mutex
+	// Indexed by image, registry or nothing according to `cacheKeyType`.
+	cache map[string]cachedProviderResponse
+}
+
+// KubeletCredentialProviders runs kubelet credential provider plugins to get
+// registry credentials, and caches them as the plugins tell.
+type KubeletCredentialProviders struct {
+	providers []*credentialProvider
+}
+
+// InitKubeletCredentialProviders loads kubelet `CredentialProviderConfig` in YAML or JSON,
+// plugins are found in `binDir`.
+func InitKubeletCredentialProviders(configPath, binDir string) error {
+	kubeletProvidersMu.Lock()
+	defer kubeletProvidersMu.Unlock()
+	if kubeletCredentialProviders != nil {
+		return nil
+	}
+
+	providers, err := NewKubeletCredentialProviders(configPath, binDir)
+	if err != nil {
+		return err
+	}
+	kubeletCredentialProviders = providers
+
+	return nil
+}
+
+func NewKubeletCredentialProviders(configPath, binDir string) (*KubeletCredentialProviders, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read credential provider config %s", configPath)
+	}
+	var config credentialProviderConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, errors.Wrapf(err, "parse credential provider config %s", configPath)
+	}
+
+	providers := &KubeletCredentialProviders{}
+	for _, spec := range config.Providers {
+		if spec.Name == "" || len(spec.MatchImages) == 0 || spec.APIVersion == "" {
+			return nil, errors.Errorf("invalid credential provider %q, name, matchImages and apiVersion are required", spec.Name)
+		}
+		if strings.ContainsAny(spec.Name, "/\\") {
+			return nil, errors.Errorf("invalid credential provider name %q", spec.Name)
+		}
+		path := filepath.Join(binDir, spec.Name)
+		if _, err := os.Stat(path); err != nil {
+			return nil, errors.Wrapf(err, "find credential provider %s", spec.Name)
+		}
+		providers.providers = append(providers.providers, &credentialProvider{
+			spec:  spec,
+			path:  path,
+			cache: make(map[string]cachedProviderResponse),
+		})
+	}
+
+	return providers, nil
+}
+
+// FromKubeletCredentialProviders finds auth for the image by kubelet credential provider plugins.
+func FromKubeletCredentialProviders(host, ref string) *PassKeyChain {
+	kubeletProvidersMu.Lock()
+	providers := kubeletCredentialProviders
+	kubeletProvidersMu.Unlock()
+	if providers == nil {
+		return nil
+	}
+
+	image := ref
+	if image == "" {
+		image = host
+	}
+	return providers.GetKeyChain(image)
+}
+
+// GetKeyChain runs providers matching the image in order, the first auth found is returned.
+func (kp *KubeletCredentialProviders) GetKeyChain(image string) *PassKeyChain {
+	for _, p := range kp.providers {
+		if !p.matches(image) {
+			continue
+		}
+
+		resp, err := p.getResponse(image)
+		if err != nil {
+			log.L.WithError(err).Warnf("failed to get auth from credential provider %s for image %s", p.spec.Name, image)
+			continue
+		}
+		if kc := resp.keyChain(image); kc != nil {
+			return kc
+		}
+	}
+
+	return nil
+}
+
+func (p *credentialProvider) matches(image string) bool {
+	for _, pattern := range p.spec.MatchImages {
+		if matchImage(pattern, image) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *credentialProvider) getResponse(image string) (*credentialProviderResponse, error) {
+	registry := imageRegistry(image)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for _, key := range []string{cacheKeyTypeImage + "/" + image, cacheKeyTypeRegistry + "/" + registry, cacheKeyTypeGlobal} {
+		if e, ok := p.cache[key]; ok {
+			if now.Before(e.expireAt) {
+				return e.response, nil
+			}
+			delete(p.cache, key)
+		}
+	}
+
+	resp, err := p.exec(image)
+	if err != nil {
+		return nil, err
+	}
+
+	duration := time.Duration(0)
+	if resp.CacheDuration != nil {
+		duration = resp.CacheDuration.Duration
+	} else if p.spec.DefaultCacheDuration != nil {
+		duration = p.spec.DefaultCacheDuration.Duration
+	}
+	if duration > 0 {
+		key := cacheKeyTypeGlobal
+		switch resp.CacheKeyType {
+		case cacheKeyTypeImage:
+			key = cacheKeyTypeImage + "/" + image
+		case cacheKeyTypeRegistry:
+			key = cacheKeyTypeRegistry + "/" + registry
+		}
+		p.cache[key] = cachedProviderResponse{response: resp, expireAt: now.Add(duration)}
+	}
+
+	return resp, nil
+}
+
+func (p *credentialProvider) exec(image string) (*credentialProviderResponse, error) {
+	req, err := json.Marshal(credentialProviderRequest{
+		APIVersion: p.spec.APIVersion,
+		Kind:       credentialProviderRequestKind,
+		Image:      image,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), credentialProviderTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, p.path, p.spec.Args...)
+	cmd.Env = os.Environ()
+	for _, e := range p.spec.Env {
+		cmd.Env = append(cmd.Env, e.Name+"="+e.Value)
+	}
+	cmd.Stdin = bytes.NewReader(req)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "execute plugin: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	var resp credentialProviderResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return nil, errors.Wrap(err, "parse response")
+	}
+	if resp.Kind != credentialProviderResponseKind || resp.APIVersion != p.spec.APIVersion {
+		return nil, errors.Errorf("unexpected response kind %q, apiVersion %q", resp.Kind, resp.APIVersion)
+	}
+	switch resp.CacheKeyType {
+	case cacheKeyTypeImage, cacheKeyTypeRegistry, cacheKeyTypeGlobal:
+	default:
+		return nil, errors.Errorf("invalid cacheKeyType %q", resp.CacheKeyType)
+	}
+
+	return &resp, nil
+}
+
+// Keys of auth are image patterns as well, the most specific one matching the image is used.
+func (resp *credentialProviderResponse) keyChain(image string) *PassKeyChain {
+	var matched string
+	for pattern := range resp.Auth {
+		if len(pattern) > len(matched) && matchImage(pattern, image) {
+			matched = pattern
+		}
+	}
+	if matched == "" {
+		return nil
+	}
+
+	auth := resp.Auth[matched]
+	if auth.Username == "" && auth.Password == "" {
+		return nil
+	}
+	return &PassKeyChain{
+		Username: auth.Username,
+		Password: auth.Password,
+	}
+}
+
+func parseImageURL(image string) (*url.URL, error) {
+	if !strings.Contains(image, "://") {
+		image = "https://" + image
+	}
+	return url.Parse(image)
+}
+
+func imageRegistry(image string) string {
+	u, err := parseImageURL(image)
+	if err != nil {
+		return image
+	}
+	return u.Host
+}
+
+// Match the image against a pattern the same way as kubelet. Globs are matched against
+// each domain segment, e.g. `*.dkr.ecr.*.amazonaws.com`. The port must be the same and
+// the path of the pattern must be a prefix of the path of the image.
+func matchImage(pattern, image string) bool {
+	p, err := parseImageURL(pattern)
+	if err != nil {
+		return false
+	}
+	i, err := parseImageURL(image)
+	if err != nil {
+		return false
+	}
+
+	if p.Port() != i.Port() || !strings.HasPrefix(i.Path, p.Path) {
+		return false
+	}
+
+	patternParts := strings.Split(p.Hostname(), ".")
+	imageParts := strings.Split(i.Hostname(), ".")
+	if len(patternParts) != len(imageParts) {
+		return false
+	}
+	for idx, part := range patternParts {
+		if ok, err := filepath.Match(part, imageParts[idx]); err != nil || !ok {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package auth
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testCredentialProviderConfig = `
+apiVersion: kubelet.config.k8s.io/v1
+kind: CredentialProviderConfig
+providers:
+  - name: fake-credential-provider
+    matchImages:
+      - "*.dkr.ecr.*.amazonaws.com"
+      - "registry.example.com:5000/team"
+    defaultCacheDuration: "12h"
+    apiVersion: credentialprovider.kubelet.k8s.io/v1
+    args:
+      - get-credentials
+    env:
+      - name: CACHE_KEY_TYPE
+        value: %s
+`
+
+	// The fake plugin records requests, and responds auth of the registry.
+	fakeCredentialProvider = `#!/bin/sh
+[ "$1" = "get-credentials" ] || exit 1
+cat >> "%s"
+echo >> "%s"
+cat <<EOT
+{
+  "apiVersion": "credentialprovider.kubelet.k8s.io/v1",
+  "kind": "CredentialProviderResponse",
+  "cacheKeyType": "$CACHE_KEY_TYPE",
+  "auth": {
+    "*.dkr.ecr.*.amazonaws.com": {"username": "AWS", "password": "ecr"},
+    "registry.example.com:5000": {"username": "user", "password": "registry"},
+    "registry.example.com:5000/team/app": {"username": "app", "password": "app"}
+  }
+}
+EOT
+`
+)
+
+func TestKubeletCredentialProviders(t *testing.T) {
+	for _, cacheKeyType := range []string{cacheKeyTypeImage, cacheKeyTypeRegistry, cacheKeyTypeGlobal} {
+		dir := t.TempDir()
+		requests := filepath.Join(dir, "requests")
+		configPath := filepath.Join(dir, "config.yaml")
+		require.NoError(t, os.WriteFile(configPath, []byte(fmt.Sprintf(testCredentialProviderConfig, cacheKeyType)), 0644))
+		plugin := fmt.Sprintf(fakeCredentialProvider, requests, requests)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "fake-credential-provider"), []byte(plugin), 0755))
+
+		providers, err := NewKubeletCredentialProviders(configPath, dir)
+		require.NoError(t, err)
+
+		images := []string{
+			"123456789012.dkr.ecr.us-west-2.amazonaws.com/app:v1",
+			"123456789012.dkr.ecr.us-west-2.amazonaws.com/app:v2",
+			"123456789012.dkr.ecr.us-west-2.amazonaws.com/app:v1",
+			"registry.example.com:5000/team/app:v1",
+			"registry.example.com:5000/team/web:v1",
+		}
+		expected := []string{"ecr", "ecr", "ecr", "app", "registry"}
+		for i, image := range images {
+			kc := providers.GetKeyChain(image)
+			require.NotNil(t, kc, image)
+			require.Equal(t, expected[i], kc.Password, image)
+		}
+
+		// Not matched by any provider.
+		require.Nil(t, providers.GetKeyChain("registry.example.com/team/app:v1"))
+		require.Nil(t, providers.GetKeyChain("docker.io/library/nginx:latest"))
+
+		data, err := os.ReadFile(requests)
+		require.NoError(t, err)
+		calls := strings.Count(string(data), "CredentialProviderRequest")
+		switch cacheKeyType {
+		case cacheKeyTypeImage:
+			require.Equal(t, 4, calls)
+		case cacheKeyTypeRegistry:
+			require.Equal(t, 2, calls)
+		case cacheKeyTypeGlobal:
+			require.Equal(t, 1, calls)
+		}
+		require.Contains(t, string(data), `"image":"123456789012.dkr.ecr.us-west-2.amazonaws.com/app:v1"`)
+	}
+}
+
+func TestMatchImage(t *testing.T) {
+	for _, c := range []struct {
+		pattern string
+		image   string
+		matched bool
+	}{
+		{"*.dkr.ecr.*.amazonaws.com", "123.dkr.ecr.us-east-1.amazonaws.com/app", true},
+		{"*.dkr.ecr.*.amazonaws.com", "dkr.ecr.us-east-1.amazonaws.com/app", false},
+		{"*.azurecr.io", "myregistry.azurecr.io/app:v1", true},
+		{"gcr.io", "gcr.io/project/app", true},
+		{"gcr.io", "us.gcr.io/project/app", false},
+		{"registry.io:5000", "registry.io/app", false},
+		{"registry.io/team", "registry.io/team/app", true},
+		{"registry.io/team", "registry.io/other/app", false},
+	} {
+		require.Equal(t, c.matched, matchImage(c.pattern, c.image), "%s %s", c.pattern, c.image)
+	}
+}
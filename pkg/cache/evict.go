@@ -0,0 +1,179 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package cache
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containerd/continuity/fs"
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/metrics/collector"
+	"github.com/containerd/nydus-snapshotter/pkg/rafs"
+)
+
+// How often to check whether the cache directory exceeds its size limit.
+const evictionInterval = time.Minute
+
+type blobEntry struct {
+	id         string
+	lastAccess time.Time
+	// Tarfs disk images can't be fetched again lazily like blob caches.
+	pinned bool
+}
+
+// RecordAccess notes that blobs of the RAFS instance are being used right now.
+// The access journal complements atime, which is unreliable on `noatime` or
+// `relatime` mounts.
+func (m *Manager) RecordAccess(r *rafs.Rafs) {
+	now := time.Now()
+
+	m.journalLock.Lock()
+	defer m.journalLock.Unlock()
+	for _, id := range instanceBlobs(r) {
+		m.journal[id] = now
+	}
+}
+
+// Evict least recently used blob caches until the cache directory usage drops
+// below the configured limit. Blobs referenced by a RAFS instance or reported by
+// `walker` are never evicted.
+func (m *Manager) Evict(ctx context.Context, walker BlobWalker) error {
+	if m.maxSize <= 0 {
+		return nil
+	}
+
+	m.gcLock.Lock()
+	defer m.gcLock.Unlock()
+
+	du, err := fs.DiskUsage(ctx, m.cacheDir)
+	if err != nil {
+		return errors.Wrapf(err, "get disk usage of %s", m.cacheDir)
+	}
+	total := du.Size
+	if total <= m.maxSize {
+		return nil
+	}
+
+	log.L.Infof("cache usage %d bytes exceeds limit %d bytes, start eviction", total, m.maxSize)
+
+	inUse, err := m.liveBlobs(ctx, walker)
+	if err != nil {
+		return errors.Wrap(err, "collect live blobs")
+	}
+
+	blobs, err := m.indexBlobs()
+	if err != nil {
+		return err
+	}
+
+	for _, b := range blobs {
+		if total <= m.maxSize {
+			break
+		}
+		if _, ok := inUse[b.id]; ok || b.pinned {
+			continue
+		}
+
+		usage, err := m.CacheUsage(ctx, b.id)
+		if err != nil {
+			log.L.WithError(err).Warnf("failed to get usage of blob cache %s", b.id)
+			continue
+		}
+
+		log.L.Infof("evict blob cache %s, size %d bytes, last access %s", b.id, usage.Size, b.lastAccess)
+		if err := m.RemoveBlobCache(b.id); err != nil {
+			log.L.WithError(err).Warnf("failed to evict blob cache %s", b.id)
+			continue
+		}
+
+		m.journalLock.Lock()
+		delete(m.journal, b.id)
+		m.journalLock.Unlock()
+
+		collector.NewCacheEvictionCollector(usage.Size).Collect()
+		total -= usage.Size
+	}
+
+	if total > m.maxSize {
+		log.L.Warnf("cache usage %d bytes still exceeds limit %d bytes, all the remaining blobs are in use",
+			total, m.maxSize)
+	}
+
+	return nil
+}
+
+// Index blob caches in the cache directory, the least recently used comes first.
+func (m *Manager) indexBlobs() ([]*blobEntry, error) {
+	entries, err := os.ReadDir(m.cacheDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read cache dir %s", m.cacheDir)
+	}
+
+	index := make(map[string]*blobEntry)
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		id, ok := parseBlobID(e.Name())
+		if !ok {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+
+		b, ok := index[id]
+		if !ok {
+			b = &blobEntry{id: id}
+			index[id] = b
+		}
+		if t := accessTime(info); t.After(b.lastAccess) {
+			b.lastAccess = t
+		}
+		if strings.HasSuffix(e.Name(), layerDiskFileSuffix) || strings.HasSuffix(e.Name(), imageDiskFileSuffix) {
+			b.pinned = true
+		}
+	}
+
+	m.journalLock.Lock()
+	for id, t := range m.journal {
+		if b, ok := index[id]; ok && t.After(b.lastAccess) {
+			b.lastAccess = t
+		}
+	}
+	m.journalLock.Unlock()
+
+	blobs := make([]*blobEntry, 0, len(index))
+	for _, b := range index {
+		blobs = append(blobs, b)
+	}
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].lastAccess.Before(blobs[j].lastAccess)
+	})
+
+	return blobs, nil
+}
+
+// The later one of atime and mtime, since blob caches are written when
+// nydusd fetches data from backend.
+func accessTime(info os.FileInfo) time.Time {
+	t := info.ModTime()
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		if atime := time.Unix(st.Atim.Unix()); atime.After(t) {
+			t = atime
+		}
+	}
+	return t
+}
@@ -0,0 +1,227 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package cache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/rafs"
+)
+
+// Suffixes of the files that nydusd and tarfs put into the cache directory for a blob.
+// Longer suffixes must be listed before their shorter tails.
+var blobFileSuffixes = []string{
+	dataFileSuffix + chunkMapFileSuffix,
+	chunkMapFileSuffix,
+	dataFileSuffix,
+	metaFileSuffix,
+	imageDiskFileSuffix,
+	layerDiskFileSuffix,
+}
+
+// BlobWalker iterates blob IDs that are still referenced by someone outside
+// of the cache manager, for example by committed snapshots.
+type BlobWalker func(ctx context.Context, fn func(blobID string)) error
+
+// Run the periodic garbage collector until ctx is cancelled. Blob caches that
+// are not referenced by any RAFS instance nor reported by `walker` are removed.
+// A round of GC can also be scheduled at any time by `SchedGC()`.
+// When a size limit is configured, least recently used blob caches are evicted
+// as well once the limit is exceeded.
+func (m *Manager) Run(ctx context.Context, walker BlobWalker) {
+	if m.disabled || (m.period <= 0 && m.maxSize <= 0) {
+		log.L.Infof("cache manager GC is disabled")
+		return
+	}
+
+	// A nil channel blocks forever, which disables the corresponding case.
+	var gcTick, evictTick <-chan time.Time
+	if m.period > 0 {
+		ticker := time.NewTicker(m.period)
+		defer ticker.Stop()
+		gcTick = ticker.C
+	}
+	if m.maxSize > 0 {
+		ticker := time.NewTicker(evictionInterval)
+		defer ticker.Stop()
+		evictTick = ticker.C
+	}
+
+	log.L.Infof("start cache manager GC with period %s, max size %d bytes", m.period, m.maxSize)
+
+	for {
+		select {
+		case <-gcTick:
+		case <-m.eventCh:
+		case <-evictTick:
+			if err := m.Evict(ctx, walker); err != nil {
+				log.L.WithError(err).Warn("failed to evict blob caches")
+			}
+			continue
+		case <-ctx.Done():
+			log.L.Infof("stop cache manager GC")
+			return
+		}
+
+		if m.period > 0 {
+			if err := m.GC(ctx, walker); err != nil {
+				log.L.WithError(err).Warn("failed to garbage collect blob caches")
+			}
+		}
+		if err := m.Evict(ctx, walker); err != nil {
+			log.L.WithError(err).Warn("failed to evict blob caches")
+		}
+	}
+}
+
+// SchedGC asks the running collector to start a round of GC as soon as possible.
+// Pending requests are merged, so it never blocks.
+func (m *Manager) SchedGC() {
+	select {
+	case m.eventCh <- struct{}{}:
+	default:
+	}
+}
+
+// GC removes blob caches which are no longer used. Blob files modified within
+// the last GC period are always kept since they may belong to a RAFS instance
+// or a snapshot which is still being set up.
+func (m *Manager) GC(ctx context.Context, walker BlobWalker) error {
+	m.gcLock.Lock()
+	defer m.gcLock.Unlock()
+
+	inUse, err := m.liveBlobs(ctx, walker)
+	if err != nil {
+		return errors.Wrap(err, "collect live blobs")
+	}
+
+	entries, err := os.ReadDir(m.cacheDir)
+	if err != nil {
+		return errors.Wrapf(err, "read cache dir %s", m.cacheDir)
+	}
+
+	deadline := time.Now().Add(-m.period)
+	// A blob may own several files, it is only collectable if all of them are stale.
+	candidates := make(map[string]bool)
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		blobID, ok := parseBlobID(e.Name())
+		if !ok {
+			continue
+		}
+		if _, ok := inUse[blobID]; ok {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		stale, seen := candidates[blobID]
+		candidates[blobID] = (stale || !seen) && info.ModTime().Before(deadline)
+	}
+
+	var removed int
+	for blobID, stale := range candidates {
+		if !stale {
+			continue
+		}
+		log.L.Infof("GC blob cache %s", blobID)
+		if err := m.RemoveBlobCache(blobID); err != nil {
+			log.L.WithError(err).Warnf("failed to remove blob cache %s", blobID)
+			continue
+		}
+		removed++
+	}
+
+	log.L.Infof("cache manager GC finished, %d blobs removed", removed)
+
+	return nil
+}
+
+// Collect blob IDs referenced by persisted RAFS instances and committed snapshots.
+func (m *Manager) liveBlobs(ctx context.Context, walker BlobWalker) (map[string]struct{}, error) {
+	inUse := make(map[string]struct{})
+
+	if m.db != nil {
+		if err := m.db.WalkRafsInstances(ctx, func(r *rafs.Rafs) error {
+			for _, id := range instanceBlobs(r) {
+				inUse[id] = struct{}{}
+			}
+			return nil
+		}); err != nil {
+			return nil, errors.Wrap(err, "walk RAFS instances")
+		}
+	}
+
+	if walker != nil {
+		if err := walker(ctx, func(blobID string) {
+			inUse[blobID] = struct{}{}
+		}); err != nil {
+			return nil, errors.Wrap(err, "walk snapshots")
+		}
+	}
+
+	return inUse, nil
+}
+
+// Blobs a RAFS instance depends on are listed in the blob table of its bootstrap.
+// If the bootstrap can't be parsed, blob meta files placed beside it when the
+// image is unpacked tell the blobs instead.
+func instanceBlobs(r *rafs.Rafs) []string {
+	if bootstrap, err := r.BootstrapFile(); err == nil {
+		blobs, err := rafs.BootstrapBlobs(bootstrap)
+		if err == nil {
+			return blobs
+		}
+		log.L.WithError(err).Warnf("failed to get blobs of instance %s from bootstrap", r.SnapshotID)
+	}
+
+	var blobs []string
+	for _, dir := range []string{
+		filepath.Join(r.SnapshotDir, "fs", "image"),
+		filepath.Join(r.SnapshotDir, "fs"),
+	} {
+		matches, err := filepath.Glob(filepath.Join(dir, "*"+metaFileSuffix))
+		if err != nil {
+			continue
+		}
+		for _, m := range matches {
+			if id, ok := parseBlobID(filepath.Base(m)); ok {
+				blobs = append(blobs, id)
+			}
+		}
+	}
+	return blobs
+}
+
+// Extract blob ID from a cache file name, returns false if the file
+// does not look like a blob cache file.
+func parseBlobID(name string) (string, bool) {
+	id := name
+	for _, suffix := range blobFileSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			id = strings.TrimSuffix(name, suffix)
+			break
+		}
+	}
+
+	if err := digest.SHA256.Validate(id); err != nil {
+		return "", false
+	}
+
+	return id, true
+}
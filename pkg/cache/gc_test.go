@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/pkg/rafs"
+	"github.com/containerd/nydus-snapshotter/pkg/store"
+)
+
+func TestParseBlobID(t *testing.T) {
+	id := strings.Repeat("a", 64)
+
+	for _, name := range []string{
+		id,
+		id + dataFileSuffix,
+		id + chunkMapFileSuffix,
+		id + dataFileSuffix + chunkMapFileSuffix,
+		id + metaFileSuffix,
+		id + imageDiskFileSuffix,
+		id + layerDiskFileSuffix,
+	} {
+		got, ok := parseBlobID(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, id, got)
+	}
+
+	for _, name := range []string{"", "cache", "abc.blob.data", id + ".tmp"} {
+		_, ok := parseBlobID(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestGC(t *testing.T) {
+	cacheDir := t.TempDir()
+	m, err := NewManager(Opt{CacheDir: cacheDir, Period: time.Hour})
+	require.NoError(t, err)
+
+	live := strings.Repeat("1", 64)
+	stale := strings.Repeat("2", 64)
+	fresh := strings.Repeat("3", 64)
+
+	old := time.Now().Add(-2 * time.Hour)
+	touch := func(name string, mtime time.Time) string {
+		p := filepath.Join(cacheDir, name)
+		require.NoError(t, os.WriteFile(p, []byte("data"), 0644))
+		require.NoError(t, os.Chtimes(p, mtime, mtime))
+		return p
+	}
+
+	liveData := touch(live+dataFileSuffix, old)
+	staleData := touch(stale+dataFileSuffix, old)
+	staleMap := touch(stale+dataFileSuffix+chunkMapFileSuffix, old)
+	freshData := touch(fresh+dataFileSuffix, old)
+	freshMeta := touch(fresh+metaFileSuffix, time.Now())
+	other := touch("unrelated", old)
+
+	walker := func(_ context.Context, fn func(string)) error {
+		fn(live)
+		return nil
+	}
+	require.NoError(t, m.GC(context.Background(), walker))
+
+	for _, p := range []string{liveData, freshData, freshMeta, other} {
+		_, err := os.Stat(p)
+		assert.NoError(t, err, p)
+	}
+	for _, p := range []string{staleData, staleMap} {
+		_, err := os.Stat(p)
+		assert.True(t, os.IsNotExist(err), p)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	cacheDir := t.TempDir()
+	db, err := store.NewDatabase(t.TempDir())
+	require.NoError(t, err)
+	defer db.Close()
+	m, err := NewManager(Opt{CacheDir: cacheDir, Database: db})
+	require.NoError(t, err)
+
+	now := time.Now()
+	blobs := []string{
+		strings.Repeat("1", 64),
+		strings.Repeat("2", 64),
+		strings.Repeat("3", 64),
+		strings.Repeat("4", 64),
+		strings.Repeat("5", 64),
+	}
+	for i, id := range blobs {
+		p := filepath.Join(cacheDir, id+dataFileSuffix)
+		require.NoError(t, os.WriteFile(p, make([]byte, 64*1024), 0644))
+		mtime := now.Add(time.Duration(i-len(blobs)) * time.Hour)
+		require.NoError(t, os.Chtimes(p, mtime, mtime))
+	}
+	// Blob 1 is the least recently used one according to atime, but the
+	// access journal says it was just used.
+	m.journal[blobs[0]] = now
+
+	// Blob 2 is used by a mounted image, which has no blob meta file.
+	r := &rafs.Rafs{SnapshotID: "1", SnapshotDir: t.TempDir()}
+	writeBootstrap(t, filepath.Join(r.SnapshotDir, "fs", "image", "image.boot"), blobs[1:2])
+	require.NoError(t, db.AddRafsInstance(context.Background(), r))
+	// Blob 3 belongs to a committed snapshot.
+	walker := func(_ context.Context, fn func(string)) error {
+		fn(blobs[2])
+		return nil
+	}
+
+	usage, err := m.CacheUsage(context.Background(), blobs[3])
+	require.NoError(t, err)
+	require.Greater(t, usage.Size, int64(0))
+
+	// Leave room for four blobs only.
+	m.maxSize = usage.Size*4 + usage.Size/2
+	require.NoError(t, m.Evict(context.Background(), walker))
+
+	_, err = os.Stat(filepath.Join(cacheDir, blobs[3]+dataFileSuffix))
+	assert.True(t, os.IsNotExist(err))
+	for _, id := range []string{blobs[0], blobs[1], blobs[2], blobs[4]} {
+		_, err := os.Stat(filepath.Join(cacheDir, id+dataFileSuffix))
+		assert.NoError(t, err)
+	}
+}
+
+// Write a RAFS v6 bootstrap with only the superblock and the device table of the blobs.
+func writeBootstrap(t *testing.T, path string, blobs []string) {
+	const superOffset, slotSize, slotOffset = 1024, 128, 16
+	data := make([]byte, (slotOffset+len(blobs))*slotSize)
+	binary.LittleEndian.PutUint32(data[superOffset:], 0xE0F5E1E2)
+	binary.LittleEndian.PutUint16(data[superOffset+86:], uint16(len(blobs)))
+	binary.LittleEndian.PutUint16(data[superOffset+88:], slotOffset)
+	for i, id := range blobs {
+		copy(data[(slotOffset+i)*slotSize:], id)
+	}
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, data, 0644))
+}
+
+func TestInstanceBlobs(t *testing.T) {
+	blobs := []string{strings.Repeat("1", 64), strings.Repeat("2", 64)}
+
+	r := &rafs.Rafs{SnapshotDir: t.TempDir()}
+	writeBootstrap(t, filepath.Join(r.SnapshotDir, "fs", "image", "image.boot"), blobs)
+	require.Equal(t, blobs, instanceBlobs(r))
+
+	// Fall back to blob meta files if the bootstrap is unknown.
+	r = &rafs.Rafs{SnapshotDir: t.TempDir()}
+	bootstrap := filepath.Join(r.SnapshotDir, "fs", "image", "image.boot")
+	require.NoError(t, os.MkdirAll(filepath.Dir(bootstrap), 0755))
+	require.NoError(t, os.WriteFile(bootstrap, make([]byte, 4096), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(bootstrap), blobs[0]+metaFileSuffix), nil, 0644))
+	require.Equal(t, blobs[:1], instanceBlobs(r))
+}
@@ -10,6 +10,7 @@ import (
 	"context"
 	"os"
 	"path"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -34,12 +35,23 @@ type Manager struct {
 	cacheDir string
 	period   time.Duration
 	eventCh  chan struct{}
+	disabled bool
+	db       *store.Database
+	// Upper limit of cache directory usage in bytes, non-positive means unlimited.
+	maxSize int64
+	// Serialize GC and eviction rounds
+	gcLock sync.Mutex
+
+	journalLock sync.Mutex
+	// When blobs are used by a RAFS instance, indexed by blob ID.
+	journal map[string]time.Time
 }
 
 type Opt struct {
 	Disabled bool
 	CacheDir string
 	Period   time.Duration
+	MaxSize  int64
 	Database *store.Database
 }
 
@@ -49,11 +61,15 @@ func NewManager(opt Opt) (*Manager, error) {
 		return nil, errors.Wrapf(err, "failed to create cache dir %s", opt.CacheDir)
 	}
 
-	eventCh := make(chan struct{})
+	eventCh := make(chan struct{}, 1)
 	m := &Manager{
 		cacheDir: opt.CacheDir,
 		period:   opt.Period,
 		eventCh:  eventCh,
+		disabled: opt.Disabled,
+		db:       opt.Database,
+		maxSize:  opt.MaxSize,
+		journal:  make(map[string]time.Time),
 	}
 
 	return m, nil
@@ -10,8 +10,10 @@ import (
 	"errors"
 
 	"github.com/containerd/cgroups/v3"
+	"github.com/containerd/nydus-snapshotter/pkg/cgroup/stats"
 	v1 "github.com/containerd/nydus-snapshotter/pkg/cgroup/v1"
 	v2 "github.com/containerd/nydus-snapshotter/pkg/cgroup/v2"
+	"github.com/opencontainers/runtime-spec/specs-go"
 )
 
 const (
@@ -22,8 +24,27 @@ var (
 	ErrCgroupNotSupported = errors.New("cgroups: cgroup not supported")
 )
 
+// IOLimit throttles IO of a block device, zero means no limit.
+type IOLimit struct {
+	Major     int64
+	Minor     int64
+	ReadBps   uint64
+	ReadIOPS  uint64
+	WriteBps  uint64
+	WriteIOPS uint64
+}
+
 type Config struct {
 	MemoryLimitInBytes int64
+	// CPU time in microseconds the cgroup can use in each CPUPeriod, -1 means no limit.
+	CPUQuota  int64
+	CPUPeriod uint64
+	IOLimits  []IOLimit
+	// Max number of processes and threads, -1 means no limit.
+	PidsMax int64
+	// Create a child cgroup for each daemon, the limits are applied to every daemon
+	// rather than all daemons.
+	PerDaemon bool
 }
 
 type DaemonCgroup interface {
@@ -31,14 +52,60 @@ type DaemonCgroup interface {
 	Delete() error
 	// Add a process to current cgroup.
 	AddProc(pid int) error
+	// Get resource usage of current cgroup.
+	Stat() (*stats.Usage, error)
+}
+
+func (c Config) resources() *specs.LinuxResources {
+	memoryLimitInBytes := c.MemoryLimitInBytes
+	resources := &specs.LinuxResources{
+		Memory: &specs.LinuxMemory{
+			Limit: &memoryLimitInBytes,
+		},
+	}
+
+	if c.CPUQuota > 0 && c.CPUPeriod > 0 {
+		quota, period := c.CPUQuota, c.CPUPeriod
+		resources.CPU = &specs.LinuxCPU{
+			Quota:  &quota,
+			Period: &period,
+		}
+	}
+
+	if len(c.IOLimits) > 0 {
+		blockIO := &specs.LinuxBlockIO{}
+		throttle := func(devices []specs.LinuxThrottleDevice, l IOLimit, rate uint64) []specs.LinuxThrottleDevice {
+			if rate == 0 {
+				return devices
+			}
+			d := specs.LinuxThrottleDevice{Rate: rate}
+			d.Major, d.Minor = l.Major, l.Minor
+			return append(devices, d)
+		}
+		for _, l := range c.IOLimits {
+			blockIO.ThrottleReadBpsDevice = throttle(blockIO.ThrottleReadBpsDevice, l, l.ReadBps)
+			blockIO.ThrottleReadIOPSDevice = throttle(blockIO.ThrottleReadIOPSDevice, l, l.ReadIOPS)
+			blockIO.ThrottleWriteBpsDevice = throttle(blockIO.ThrottleWriteBpsDevice, l, l.WriteBps)
+			blockIO.ThrottleWriteIOPSDevice = throttle(blockIO.ThrottleWriteIOPSDevice, l, l.WriteIOPS)
+		}
+		resources.BlockIO = blockIO
+	}
+
+	if c.PidsMax > 0 {
+		resources.Pids = &specs.LinuxPids{
+			Limit: c.PidsMax,
+		}
+	}
+
+	return resources
 }
 
-func createCgroup(name string, config Config) (DaemonCgroup, error) {
+func createCgroup(name string, resources *specs.LinuxResources) (DaemonCgroup, error) {
 	if cgroups.Mode() == cgroups.Unified {
-		return v2.NewCgroup(defaultSlice, name, config.MemoryLimitInBytes)
+		return v2.NewCgroup(defaultSlice, name, resources)
 	}
 
-	return v1.NewCgroup(defaultSlice, name, config.MemoryLimitInBytes)
+	return v1.NewCgroup(defaultSlice, name, resources)
 }
 
 func supported() bool {
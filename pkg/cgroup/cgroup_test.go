@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package cgroup
+
+import (
+	"testing"
+
+	"github.com/containerd/cgroups/v3/cgroup2"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigResources(t *testing.T) {
+	resources := Config{MemoryLimitInBytes: -1}.resources()
+	require.Equal(t, int64(-1), *resources.Memory.Limit)
+	require.Nil(t, resources.CPU)
+	require.Nil(t, resources.BlockIO)
+	require.Nil(t, resources.Pids)
+
+	// CPU quota takes effect only with both quota and period.
+	resources = Config{CPUQuota: 50000}.resources()
+	require.Nil(t, resources.CPU)
+	resources = Config{CPUQuota: -1, CPUPeriod: 100000}.resources()
+	require.Nil(t, resources.CPU)
+	resources = Config{CPUQuota: 50000, CPUPeriod: 100000}.resources()
+	require.Equal(t, int64(50000), *resources.CPU.Quota)
+	require.Equal(t, uint64(100000), *resources.CPU.Period)
+
+	resources = Config{PidsMax: -1}.resources()
+	require.Nil(t, resources.Pids)
+	resources = Config{PidsMax: 128}.resources()
+	require.Equal(t, int64(128), resources.Pids.Limit)
+
+	resources = Config{
+		MemoryLimitInBytes: 1 << 30,
+		IOLimits: []IOLimit{
+			{Major: 8, Minor: 0, ReadBps: 1 << 20, WriteIOPS: 100},
+			{Major: 253, Minor: 1, ReadIOPS: 200, WriteBps: 2 << 20},
+		},
+	}.resources()
+	require.Equal(t, int64(1<<30), *resources.Memory.Limit)
+	throttle := func(major, minor int64, rate uint64) specs.LinuxThrottleDevice {
+		d := specs.LinuxThrottleDevice{Rate: rate}
+		d.Major, d.Minor = major, minor
+		return d
+	}
+	// Zero rates are not throttled.
+	require.Equal(t, &specs.LinuxBlockIO{
+		ThrottleReadBpsDevice:   []specs.LinuxThrottleDevice{throttle(8, 0, 1<<20)},
+		ThrottleReadIOPSDevice:  []specs.LinuxThrottleDevice{throttle(253, 1, 200)},
+		ThrottleWriteBpsDevice:  []specs.LinuxThrottleDevice{throttle(253, 1, 2<<20)},
+		ThrottleWriteIOPSDevice: []specs.LinuxThrottleDevice{throttle(8, 0, 100)},
+	}, resources.BlockIO)
+}
+
+func TestConfigResourcesV2(t *testing.T) {
+	resources := cgroup2.ToResources(Config{
+		MemoryLimitInBytes: 1 << 30,
+		CPUQuota:           50000,
+		CPUPeriod:          100000,
+		IOLimits:           []IOLimit{{Major: 8, Minor: 0, ReadBps: 1 << 20, WriteIOPS: 100}},
+		PidsMax:            128,
+	}.resources())
+
+	require.Equal(t, int64(1<<30), *resources.Memory.Max)
+	require.Equal(t, cgroup2.CPUMax("50000 100000"), resources.CPU.Max)
+	require.Equal(t, int64(128), resources.Pids.Max)
+	require.ElementsMatch(t, []cgroup2.Entry{
+		{Type: cgroup2.ReadBPS, Major: 8, Minor: 0, Rate: 1 << 20},
+		{Type: cgroup2.WriteIOPS, Major: 8, Minor: 0, Rate: 100},
+	}, resources.IO.Max)
+}
@@ -7,13 +7,23 @@
 package cgroup
 
 import (
+	"path"
+	"sync"
+
 	"github.com/containerd/log"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/cgroup/stats"
 )
 
 type Manager struct {
 	name   string
 	config Config
 	cgroup DaemonCgroup
+
+	mu sync.Mutex
+	// Child cgroups of daemons indexed by daemon ID, only used if `PerDaemon` is enabled.
+	daemonCgroups map[string]DaemonCgroup
 }
 
 type Opt struct {
@@ -28,24 +38,101 @@ func NewManager(opt Opt) (*Manager, error) {
 	}
 
 	log.L.Infof("cgroup mode: %s", displayMode())
-	cg, err := createCgroup(opt.Name, opt.Config)
+	resources := opt.Config.resources()
+	if opt.Config.PerDaemon {
+		// Limits are applied to child cgroups of daemons.
+		resources = Config{MemoryLimitInBytes: -1}.resources()
+	}
+	cg, err := createCgroup(opt.Name, resources)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Manager{
-		name:   opt.Name,
-		config: opt.Config,
-		cgroup: cg,
+		name:          opt.Name,
+		config:        opt.Config,
+		cgroup:        cg,
+		daemonCgroups: make(map[string]DaemonCgroup),
 	}, nil
 }
 
+func (m *Manager) daemonCgroupName(daemonID string) string {
+	return path.Join(m.name, daemonID)
+}
+
+// Add the process of the daemon to the cgroup. If `PerDaemon` is enabled, a child
+// cgroup is created for the daemon.
+// Please make sure the *Manager is not null.
+func (m *Manager) AddProc(daemonID string, pid int) error {
+	if !m.config.PerDaemon {
+		return m.cgroup.AddProc(pid)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cg, ok := m.daemonCgroups[daemonID]
+	if !ok {
+		var err error
+		cg, err = createCgroup(m.daemonCgroupName(daemonID), m.config.resources())
+		if err != nil {
+			return errors.Wrapf(err, "create cgroup for daemon %s", daemonID)
+		}
+		m.daemonCgroups[daemonID] = cg
+	}
+
+	return cg.AddProc(pid)
+}
+
+// Delete the child cgroup of the daemon, it's a no-op if `PerDaemon` is disabled.
 // Please make sure the *Manager is not null.
-func (m *Manager) AddProc(pid int) error {
-	return m.cgroup.AddProc(pid)
+func (m *Manager) DeleteDaemon(daemonID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cg, ok := m.daemonCgroups[daemonID]
+	if !ok {
+		return nil
+	}
+	delete(m.daemonCgroups, daemonID)
+
+	return cg.Delete()
 }
 
 // Please make sure the *Manager is not null.
 func (m *Manager) Delete() error {
+	m.mu.Lock()
+	for id, cg := range m.daemonCgroups {
+		if err := cg.Delete(); err != nil {
+			log.L.WithError(err).Warnf("failed to delete cgroup of daemon %s", id)
+		}
+	}
+	m.daemonCgroups = make(map[string]DaemonCgroup)
+	m.mu.Unlock()
+
 	return m.cgroup.Delete()
 }
+
+// Get resource usage of the cgroup and child cgroups of daemons, indexed by cgroup name.
+// Please make sure the *Manager is not null.
+func (m *Manager) Stats() map[string]*stats.Usage {
+	m.mu.Lock()
+	cgroups := make(map[string]DaemonCgroup, len(m.daemonCgroups)+1)
+	for id, cg := range m.daemonCgroups {
+		cgroups[m.daemonCgroupName(id)] = cg
+	}
+	m.mu.Unlock()
+	cgroups[m.name] = m.cgroup
+
+	usages := make(map[string]*stats.Usage, len(cgroups))
+	for name, cg := range cgroups {
+		usage, err := cg.Stat()
+		if err != nil {
+			log.L.WithError(err).Warnf("failed to get resource usage of cgroup %s", name)
+			continue
+		}
+		usages[name] = usage
+	}
+
+	return usages
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package cgroup
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/pkg/cgroup/stats"
+)
+
+type fakeCgroup struct {
+	pids    []int
+	deleted bool
+	usage   *stats.Usage
+	err     error
+}
+
+func (cg *fakeCgroup) Delete() error {
+	cg.deleted = true
+	return cg.err
+}
+
+func (cg *fakeCgroup) AddProc(pid int) error {
+	cg.pids = append(cg.pids, pid)
+	return nil
+}
+
+func (cg *fakeCgroup) Stat() (*stats.Usage, error) {
+	return cg.usage, cg.err
+}
+
+func TestManagerAddProc(t *testing.T) {
+	root := &fakeCgroup{}
+	m := &Manager{name: "nydusd", cgroup: root, daemonCgroups: map[string]DaemonCgroup{}}
+	require.NoError(t, m.AddProc("d1", 100))
+	require.Equal(t, []int{100}, root.pids)
+	require.Empty(t, m.daemonCgroups)
+
+	// Processes are added to the child cgroups of daemons.
+	d1 := &fakeCgroup{}
+	m = &Manager{
+		name:          "nydusd",
+		config:        Config{PerDaemon: true},
+		cgroup:        root,
+		daemonCgroups: map[string]DaemonCgroup{"d1": d1},
+	}
+	require.NoError(t, m.AddProc("d1", 101))
+	require.Equal(t, []int{101}, d1.pids)
+	require.Equal(t, []int{100}, root.pids)
+	require.Equal(t, "nydusd/d1", m.daemonCgroupName("d1"))
+}
+
+func TestManagerDelete(t *testing.T) {
+	root, d1, d2 := &fakeCgroup{}, &fakeCgroup{}, &fakeCgroup{err: errors.New("busy")}
+	m := &Manager{
+		name:          "nydusd",
+		config:        Config{PerDaemon: true},
+		cgroup:        root,
+		daemonCgroups: map[string]DaemonCgroup{"d1": d1, "d2": d2},
+	}
+
+	require.NoError(t, m.DeleteDaemon("d1"))
+	require.True(t, d1.deleted)
+	require.NotContains(t, m.daemonCgroups, "d1")
+	require.NoError(t, m.DeleteDaemon("unknown"))
+
+	// Failing to delete cgroups of daemons doesn't fail the manager.
+	require.NoError(t, m.Delete())
+	require.True(t, d2.deleted)
+	require.True(t, root.deleted)
+	require.Empty(t, m.daemonCgroups)
+}
+
+func TestManagerStats(t *testing.T) {
+	m := &Manager{
+		name:   "nydusd",
+		config: Config{PerDaemon: true},
+		cgroup: &fakeCgroup{usage: &stats.Usage{MemoryBytes: 3}},
+		daemonCgroups: map[string]DaemonCgroup{
+			"d1": &fakeCgroup{usage: &stats.Usage{MemoryBytes: 1, Pids: 1}},
+			"d2": &fakeCgroup{err: errors.New("not found")},
+		},
+	}
+
+	require.Equal(t, map[string]*stats.Usage{
+		"nydusd":    {MemoryBytes: 3},
+		"nydusd/d1": {MemoryBytes: 1, Pids: 1},
+	}, m.Stats())
+}
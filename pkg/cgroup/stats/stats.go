@@ -0,0 +1,20 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package stats
+
+// Usage is the resource usage of a cgroup, which is the same for cgroup v1 and v2.
+type Usage struct {
+	MemoryBytes uint64
+	// Accumulated CPU time in microseconds.
+	CPUUsec uint64
+	Pids    uint64
+	// Accumulated IO of all devices.
+	IOReadBytes  uint64
+	IOWriteBytes uint64
+	IOReadOps    uint64
+	IOWriteOps   uint64
+}
@@ -11,23 +11,39 @@ import (
 	"github.com/containerd/log"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+
+	"github.com/containerd/nydus-snapshotter/pkg/cgroup/stats"
 )
 
 type Cgroup struct {
 	controller cgroup1.Cgroup
 }
 
+// Subsystems to limit resources of nydusd, those not mounted are skipped.
+var subsystems = []cgroup1.Name{cgroup1.Memory, cgroup1.Cpu, cgroup1.Cpuacct, cgroup1.Blkio, cgroup1.Pids}
+
 func generateHierarchy() cgroup1.Hierarchy {
-	return cgroup1.SingleSubsystem(cgroup1.Default, cgroup1.Memory)
+	return func() ([]cgroup1.Subsystem, error) {
+		all, err := cgroup1.Default()
+		if err != nil {
+			return nil, err
+		}
+		var enabled []cgroup1.Subsystem
+		for _, s := range all {
+			if slices.Contains(subsystems, s.Name()) {
+				enabled = append(enabled, s)
+			}
+		}
+		if len(enabled) == 0 {
+			return nil, errors.Errorf("none of subsystems %v is mounted", subsystems)
+		}
+		return enabled, nil
+	}
 }
 
-func NewCgroup(slice, name string, memoryLimitInBytes int64) (Cgroup, error) {
+func NewCgroup(slice, name string, resources *specs.LinuxResources) (Cgroup, error) {
 	hierarchy := generateHierarchy()
-	specResources := &specs.LinuxResources{
-		Memory: &specs.LinuxMemory{
-			Limit: &memoryLimitInBytes,
-		},
-	}
 
 	controller, err := cgroup1.Load(cgroup1.Slice(slice, name), cgroup1.WithHiearchy(hierarchy))
 	if err != nil && err != cgroup1.ErrCgroupDeleted {
@@ -41,7 +57,7 @@ func NewCgroup(slice, name string, memoryLimitInBytes int64) (Cgroup, error) {
 		}
 		if len(processes) > 0 {
 			log.L.Infof("target cgroup is existed with processes %v", processes)
-			if err := controller.Update(specResources); err != nil {
+			if err := controller.Update(resources); err != nil {
 				return Cgroup{}, err
 			}
 			return Cgroup{
@@ -53,11 +69,11 @@ func NewCgroup(slice, name string, memoryLimitInBytes int64) (Cgroup, error) {
 		}
 	}
 
-	controller, err = cgroup1.New(cgroup1.Slice(slice, name), specResources, cgroup1.WithHiearchy(hierarchy))
+	controller, err = cgroup1.New(cgroup1.Slice(slice, name), resources, cgroup1.WithHiearchy(hierarchy))
 	if err != nil {
 		return Cgroup{}, errors.Wrapf(err, "create cgroup")
 	}
-	log.L.Infof("create cgroup (v1) successful, state: %v", controller.State())
+	log.L.Infof("create cgroup (v1) %s successful, state: %v", name, controller.State())
 
 	return Cgroup{
 		controller: controller,
@@ -76,7 +92,7 @@ func (cg Cgroup) Delete() error {
 	return cg.controller.Delete()
 }
 func (cg Cgroup) AddProc(pid int) error {
-	err := cg.controller.AddProc(uint64(pid), cgroup1.Memory)
+	err := cg.controller.AddProc(uint64(pid))
 	if err != nil {
 		return err
 	}
@@ -84,3 +100,42 @@ func (cg Cgroup) AddProc(pid int) error {
 	log.L.Infof("add process %d to daemon cgroup successful", pid)
 	return nil
 }
+
+func (cg Cgroup) Stat() (*stats.Usage, error) {
+	metrics, err := cg.controller.Stat(cgroup1.IgnoreNotExist)
+	if err != nil {
+		return nil, err
+	}
+
+	var usage stats.Usage
+	if metrics.Memory != nil && metrics.Memory.Usage != nil {
+		usage.MemoryBytes = metrics.Memory.Usage.Usage
+	}
+	if metrics.CPU != nil && metrics.CPU.Usage != nil {
+		// In nanoseconds.
+		usage.CPUUsec = metrics.CPU.Usage.Total / 1000
+	}
+	if metrics.Pids != nil {
+		usage.Pids = metrics.Pids.Current
+	}
+	if metrics.Blkio != nil {
+		for _, e := range metrics.Blkio.IoServiceBytesRecursive {
+			switch e.Op {
+			case "Read":
+				usage.IOReadBytes += e.Value
+			case "Write":
+				usage.IOWriteBytes += e.Value
+			}
+		}
+		for _, e := range metrics.Blkio.IoServicedRecursive {
+			switch e.Op {
+			case "Read":
+				usage.IOReadOps += e.Value
+			case "Write":
+				usage.IOWriteOps += e.Value
+			}
+		}
+	}
+
+	return &usage, nil
+}
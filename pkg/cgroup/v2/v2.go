@@ -15,7 +15,10 @@ import (
 
 	"github.com/containerd/cgroups/v3/cgroup2"
 	"github.com/containerd/log"
+	"github.com/opencontainers/runtime-spec/specs-go"
 	"golang.org/x/exp/slices"
+
+	"github.com/containerd/nydus-snapshotter/pkg/cgroup/stats"
 )
 
 const (
@@ -38,16 +41,14 @@ func readSubtreeControllers(dir string) ([]string, error) {
 	return strings.Fields(string(b)), nil
 }
 
-func NewCgroup(slice, name string, memoryLimitInBytes int64) (Cgroup, error) {
-	resources := &cgroup2.Resources{
-		Memory: &cgroup2.Memory{},
+func NewCgroup(slice, name string, spec *specs.LinuxResources) (Cgroup, error) {
+	resources := cgroup2.ToResources(spec)
+	if resources.Memory == nil {
+		resources.Memory = &cgroup2.Memory{}
 	}
-	if memoryLimitInBytes > -1 {
-		resources = &cgroup2.Resources{
-			Memory: &cgroup2.Memory{
-				Max: &memoryLimitInBytes,
-			},
-		}
+	// A negative memory limit means no limit.
+	if resources.Memory.Max != nil && *resources.Memory.Max < 0 {
+		resources.Memory.Max = nil
 	}
 
 	rootSubtreeControllers, err := readSubtreeControllers(defaultRoot)
@@ -59,6 +60,19 @@ func NewCgroup(slice, name string, memoryLimitInBytes int64) (Cgroup, error) {
 	if !slices.Contains(rootSubtreeControllers, "memory") {
 		return Cgroup{}, ErrRootMemorySubtreeControllerDisabled
 	}
+	// Other limits are optional, skip them if their controllers are not available.
+	if resources.CPU != nil && !slices.Contains(rootSubtreeControllers, "cpu") {
+		log.L.Warn("root subtree controller for cpu is disabled, skip cpu limit")
+		resources.CPU = nil
+	}
+	if resources.IO != nil && !slices.Contains(rootSubtreeControllers, "io") {
+		log.L.Warn("root subtree controller for io is disabled, skip io limit")
+		resources.IO = nil
+	}
+	if resources.Pids != nil && !slices.Contains(rootSubtreeControllers, "pids") {
+		log.L.Warn("root subtree controller for pids is disabled, skip pids limit")
+		resources.Pids = nil
+	}
 
 	m, err := cgroup2.NewManager(defaultRoot, fmt.Sprintf("/%s/%s", slice, name), resources)
 	if err != nil {
@@ -69,7 +83,7 @@ func NewCgroup(slice, name string, memoryLimitInBytes int64) (Cgroup, error) {
 	if err != nil {
 		return Cgroup{}, err
 	}
-	log.L.Infof("create cgroup (v2) successful, controllers: %v", controllers)
+	log.L.Infof("create cgroup (v2) %s successful, controllers: %v", name, controllers)
 
 	return Cgroup{
 		manager: m,
@@ -92,3 +106,34 @@ func (cg Cgroup) AddProc(pid int) error {
 	}
 	return nil
 }
+
+func (cg Cgroup) Stat() (*stats.Usage, error) {
+	var usage stats.Usage
+	if cg.manager == nil {
+		return &usage, nil
+	}
+
+	metrics, err := cg.manager.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if metrics.Memory != nil {
+		usage.MemoryBytes = metrics.Memory.Usage
+	}
+	if metrics.CPU != nil {
+		usage.CPUUsec = metrics.CPU.UsageUsec
+	}
+	if metrics.Pids != nil {
+		usage.Pids = metrics.Pids.Current
+	}
+	if metrics.Io != nil {
+		for _, e := range metrics.Io.Usage {
+			usage.IOReadBytes += e.Rbytes
+			usage.IOWriteBytes += e.Wbytes
+			usage.IOReadOps += e.Rios
+			usage.IOWriteOps += e.Wios
+		}
+	}
+
+	return &usage, nil
+}
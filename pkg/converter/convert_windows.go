@@ -15,6 +15,7 @@ import (
 
 	"github.com/containerd/containerd/v2/core/content"
 	"github.com/containerd/containerd/v2/core/images/converter"
+	"github.com/containerd/platforms"
 	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
@@ -54,3 +55,15 @@ func ConvertHookFunc(opt MergeOption) converter.ConvertHookFunc {
 func MergeLayers(ctx context.Context, cs content.Store, descs []ocispec.Descriptor, opt MergeOption) (*ocispec.Descriptor, []ocispec.Descriptor, error) {
 	panic("not implemented")
 }
+
+func DefaultIndexConvertFunc(layerConvertFunc converter.ConvertFunc, docker2oci bool, platformMC platforms.MatchComparer) converter.ConvertFunc {
+	panic("not implemented")
+}
+
+func ReconvertHookFunc() converter.ConvertHookFunc {
+	panic("not implemented")
+}
+
+func LayerReconvertFunc(opt UnpackOption) converter.ConvertFunc {
+	panic("not implemented")
+}
@@ -20,6 +20,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/containerd/containerd/v2/pkg/tracing"
 	"github.com/pkg/errors"
 
 	"github.com/containerd/log"
@@ -37,8 +38,12 @@ const (
 	endpointMetrics = "/api/v1/metrics"
 	// Fetch metrics relevant to caches usage.
 	endpointCacheMetrics = "/api/v1/metrics/blobcache"
+	// Fetch metrics about reading from storage backends.
+	endpointBackendMetrics = "/api/v1/metrics/backend"
 	// Fetch metrics about inflighting operations.
 	endpointInflightMetrics = "/api/v1/metrics/inflight"
+	// Fetch file access patterns, only available if access pattern is enabled in nydusd configuration.
+	endpointAccessPatterns = "/api/v1/metrics/pattern"
 	// Request nydus daemon to retrieve its runtime states from the supervisor, recovering states for failover.
 	endpointTakeOver = "/api/v1/daemon/fuse/takeover"
 	// Request nydus daemon to send its runtime states to the supervisor, preparing for failover.
@@ -55,6 +60,8 @@ const (
 	defaultHTTPClientTimeout = 30 * time.Second
 
 	jsonContentType = "application/json"
+
+	spanPrefix = "nydusd"
 )
 
 // Nydusd HTTP client to query nydusd runtime status, operate file system instances.
@@ -62,7 +69,9 @@ const (
 type NydusdClient interface {
 	GetDaemonInfo() (*types.DaemonInfo, error)
 
-	Mount(mountpoint, bootstrap, daemonConfig string) error
+	Mount(mountpoint, bootstrap, daemonConfig string, prefetchFiles []string) error
+	// Update configuration, e.g. registry auth, of a mounted file system instance.
+	Remount(mountpoint, bootstrap, daemonConfig string) error
 	Umount(mountpoint string) error
 
 	BindBlob(daemonConfig string) error
@@ -71,6 +80,11 @@ type NydusdClient interface {
 	GetFsMetrics(sid string) (*types.FsMetrics, error)
 	GetInflightMetrics() (*types.InflightMetrics, error)
 	GetCacheMetrics(sid string) (*types.CacheMetrics, error)
+	// Blob cache and backend metrics are indexed by the ID of the RAFS instance,
+	// which is the fscache ID in fscache mode. It's empty for dedicated daemons.
+	GetBlobCacheMetrics(id string) (*types.CacheMetrics, error)
+	GetBackendMetrics(id string) (*types.BackendMetrics, error)
+	GetAccessPatterns(sid string) ([]types.AccessPattern, error)
 
 	TakeOver() error
 	SendFd() error
@@ -82,6 +96,8 @@ type NydusdClient interface {
 // query nydusd working status.
 type nydusdClient struct {
 	httpClient *http.Client
+	// ID of the daemon, to tell requests to different daemons apart in traces.
+	daemonID string
 }
 
 type query = url.Values
@@ -98,14 +114,25 @@ func (c *nydusdClient) url(path string, query query) (url string) {
 
 // A simple http client request wrapper with capability to take
 // request body and handle or process http response if result is expected.
+// Each request is traced by a span named after the method and the API path.
 func (c *nydusdClient) request(method string, url string,
-	body io.Reader, respHandler func(resp *http.Response) error) error {
+	body io.Reader, respHandler func(resp *http.Response) error) (err error) {
 
 	req, err := http.NewRequest(method, url, body)
 	if err != nil {
 		return errors.Wrapf(err, "construct request %s", url)
 	}
 
+	ctx, span := tracing.StartSpan(req.Context(), tracing.Name(spanPrefix, method+" "+req.URL.Path),
+		tracing.WithAttribute("daemon.id", c.daemonID),
+		tracing.WithAttribute("http.method", method),
+		tracing.WithAttribute("api.path", req.URL.Path))
+	defer func() {
+		span.SetStatus(err)
+		span.End()
+	}()
+	req = req.WithContext(ctx)
+
 	if body != nil {
 		req.Header.Add("Content-Type", jsonContentType)
 	}
@@ -115,6 +142,7 @@ func (c *nydusdClient) request(method string, url string,
 		return err
 	}
 	defer resp.Body.Close()
+	span.SetAttributes(tracing.HTTPStatusCodeAttributes(resp.StatusCode)...)
 
 	if succeeded(resp) {
 		if respHandler != nil {
@@ -199,13 +227,14 @@ func WaitUntilSocketExisted(sock string, pid int) error {
 	)
 }
 
-func NewNydusClient(sock string) (NydusdClient, error) {
+func NewNydusClient(daemonID, sock string) (NydusdClient, error) {
 	transport := buildTransport(sock)
 	return &nydusdClient{
 		httpClient: &http.Client{
 			Timeout:   defaultHTTPClientTimeout,
 			Transport: transport,
 		},
+		daemonID: daemonID,
 	}, nil
 }
 
@@ -227,8 +256,8 @@ func (c *nydusdClient) GetDaemonInfo() (*types.DaemonInfo, error) {
 	return &info, nil
 }
 
-func (c *nydusdClient) Mount(mp, bootstrap, mountConfig string) error {
-	cmd, err := json.Marshal(types.NewMountRequest(bootstrap, mountConfig))
+func (c *nydusdClient) Mount(mp, bootstrap, mountConfig string, prefetchFiles []string) error {
+	cmd, err := json.Marshal(types.NewMountRequest(bootstrap, mountConfig, prefetchFiles))
 	if err != nil {
 		return errors.Wrap(err, "construct mount request")
 	}
@@ -240,6 +269,19 @@ func (c *nydusdClient) Mount(mp, bootstrap, mountConfig string) error {
 	return c.request(http.MethodPost, url, bytes.NewBuffer(cmd), nil)
 }
 
+func (c *nydusdClient) Remount(mp, bootstrap, mountConfig string) error {
+	cmd, err := json.Marshal(types.NewMountRequest(bootstrap, mountConfig, nil))
+	if err != nil {
+		return errors.Wrap(err, "construct remount request")
+	}
+
+	query := query{}
+	query.Add("mountpoint", mp)
+	url := c.url(endpointMount, query)
+
+	return c.request(http.MethodPut, url, bytes.NewBuffer(cmd), nil)
+}
+
 func (c *nydusdClient) Umount(mp string) error {
 	query := query{}
 	query.Add("mountpoint", mp)
@@ -306,9 +348,18 @@ func (c *nydusdClient) GetInflightMetrics() (*types.InflightMetrics, error) {
 }
 
 func (c *nydusdClient) GetCacheMetrics(sid string) (*types.CacheMetrics, error) {
-	query := query{}
+	var id string
 	if sid != "" {
-		query.Add("id", "/"+sid)
+		id = "/" + sid
+	}
+
+	return c.GetBlobCacheMetrics(id)
+}
+
+func (c *nydusdClient) GetBlobCacheMetrics(id string) (*types.CacheMetrics, error) {
+	query := query{}
+	if id != "" {
+		query.Add("id", id)
 	}
 
 	url := c.url(endpointCacheMetrics, query)
@@ -322,6 +373,40 @@ func (c *nydusdClient) GetCacheMetrics(sid string) (*types.CacheMetrics, error)
 	return &m, nil
 }
 
+func (c *nydusdClient) GetBackendMetrics(id string) (*types.BackendMetrics, error) {
+	query := query{}
+	if id != "" {
+		query.Add("id", id)
+	}
+
+	url := c.url(endpointBackendMetrics, query)
+	var m types.BackendMetrics
+	if err := c.request(http.MethodGet, url, nil, func(resp *http.Response) error {
+		return decode(resp, &m)
+	}); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+func (c *nydusdClient) GetAccessPatterns(sid string) ([]types.AccessPattern, error) {
+	query := query{}
+	if sid != "" {
+		query.Add("id", "/"+sid)
+	}
+
+	url := c.url(endpointAccessPatterns, query)
+	var patterns []types.AccessPattern
+	if err := c.request(http.MethodGet, url, nil, func(resp *http.Response) error {
+		return decode(resp, &patterns)
+	}); err != nil {
+		return nil, err
+	}
+
+	return patterns, nil
+}
+
 func (c *nydusdClient) TakeOver() error {
 	url := c.url(endpointTakeOver, query{})
 	return c.request(http.MethodPut, url, nil, nil)
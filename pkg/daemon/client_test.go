@@ -17,6 +17,11 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 
 	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
 )
@@ -61,7 +66,7 @@ func prepareNydusServer(t *testing.T) (string, func()) {
 func TestNydusClient_CheckStatus(t *testing.T) {
 	sock, dispose := prepareNydusServer(t)
 	defer dispose()
-	client, err := NewNydusClient(sock)
+	client, err := NewNydusClient("testid", sock)
 	require.Nil(t, err)
 	info, err := client.GetDaemonInfo()
 	require.Nil(t, err)
@@ -69,3 +74,83 @@ func TestNydusClient_CheckStatus(t *testing.T) {
 	assert.Equal(t, "testid", info.ID)
 	assert.Equal(t, BTI, info.Version)
 }
+
+func TestNydusClient_Remount(t *testing.T) {
+	mockSocket := filepath.Join(t.TempDir(), "nydusd.sock")
+	var req types.MountRequest
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "/api/v1/mount", r.URL.Path)
+		assert.Equal(t, "/snap1", r.URL.Query().Get("mountpoint"))
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	unixListener, err := net.Listen("unix", mockSocket)
+	require.Nil(t, err)
+	ts.Listener = unixListener
+	ts.Start()
+	defer ts.Close()
+
+	client, err := NewNydusClient("testid", mockSocket)
+	require.Nil(t, err)
+	require.Nil(t, client.Remount("/snap1", "/path/to/bootstrap", `{"device":{}}`))
+	assert.Equal(t, "rafs", req.FsType)
+	assert.Equal(t, "/path/to/bootstrap", req.Source)
+	assert.Equal(t, `{"device":{}}`, req.Config)
+}
+
+func TestNydusClient_BackendMetrics(t *testing.T) {
+	mockSocket := filepath.Join(t.TempDir(), "nydusd.sock")
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/metrics/backend", r.URL.Path)
+		// Fscache IDs are passed as they are.
+		assert.Equal(t, "fscache-id", r.URL.Query().Get("id"))
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{"backend_type":"registry","read_count":4,"read_errors":1,` +
+			`"read_amount_total":4096,"read_cumulative_latency_millis_total":20}`))
+		assert.Nil(t, err)
+	}))
+	unixListener, err := net.Listen("unix", mockSocket)
+	require.Nil(t, err)
+	ts.Listener = unixListener
+	ts.Start()
+	defer ts.Close()
+
+	client, err := NewNydusClient("testid", mockSocket)
+	require.Nil(t, err)
+	m, err := client.GetBackendMetrics("fscache-id")
+	require.Nil(t, err)
+	assert.Equal(t, "registry", m.BackendType)
+	assert.Equal(t, uint64(4), m.ReadCount)
+	assert.Equal(t, uint64(1), m.ReadErrors)
+	assert.Equal(t, uint64(4096), m.ReadAmountTotal)
+	assert.Equal(t, uint64(20), m.ReadCumulativeLatencyMillisTotal)
+}
+
+func TestNydusClient_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(provider)
+
+	sock, dispose := prepareNydusServer(t)
+	defer dispose()
+	client, err := NewNydusClient("testid", sock)
+	require.Nil(t, err)
+	_, err = client.GetDaemonInfo()
+	require.Nil(t, err)
+	// The daemon is gone.
+	dispose()
+	require.NotNil(t, client.Umount("/snap1"))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "nydusd.GET /api/v1/daemon", spans[0].Name())
+	assert.Equal(t, codes.Ok, spans[0].Status().Code)
+	assert.Contains(t, spans[0].Attributes(), attribute.String("daemon.id", "testid"))
+	assert.Contains(t, spans[0].Attributes(), attribute.String("api.path", "/api/v1/daemon"))
+	assert.Contains(t, spans[0].Attributes(), attribute.Int("http.status_code", http.StatusOK))
+	assert.Equal(t, "nydusd.DELETE /api/v1/mount", spans[1].Name())
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+	assert.Contains(t, spans[1].Attributes(), attribute.String("daemon.id", "testid"))
+}
@@ -18,6 +18,7 @@ import (
 	"sync/atomic"
 	"syscall"
 	"time"
+	"unicode"
 
 	"github.com/pkg/errors"
 
@@ -25,6 +26,7 @@ import (
 
 	"github.com/containerd/nydus-snapshotter/config"
 	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
+	"github.com/containerd/nydus-snapshotter/pkg/auth"
 	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
 	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
 	"github.com/containerd/nydus-snapshotter/pkg/rafs"
@@ -265,7 +267,14 @@ func (d *Daemon) sharedFusedevMount(rafs *rafs.Rafs) error {
 		return errors.Wrap(err, "dump instance configuration")
 	}
 
-	err = client.Mount(rafs.RelaMountpoint(), bootstrap, cfg)
+	var prefetchFiles []string
+	if files := rafs.PrefetchFiles(); files != "" {
+		prefetchFiles = strings.FieldsFunc(files, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})
+	}
+
+	err = client.Mount(rafs.RelaMountpoint(), bootstrap, cfg, prefetchFiles)
 	if err != nil {
 		return errors.Wrapf(err, "mount rafs instance")
 	}
@@ -273,6 +282,65 @@ func (d *Daemon) sharedFusedevMount(rafs *rafs.Rafs) error {
 	return nil
 }
 
+// UpdateAuth pushes the registry auth of the RAFS instance into nydusd if it changes, so that
+// nydusd keeps fetching blobs after the original auth expires. Returns whether it's updated.
+func (d *Daemon) UpdateAuth(r *rafs.Rafs, kc *auth.PassKeyChain) (bool, error) {
+	if d.States.FsDriver != config.FsDriverFusedev {
+		// Nydusd can't update configuration of blobs bound to fscache.
+		return false, errors.Wrapf(errdefs.ErrNotImplemented, "update auth for fs driver %s", d.States.FsDriver)
+	}
+
+	var configFile, mountpoint string
+	if d.IsSharedDaemon() {
+		configFile = d.ConfigFile(r.SnapshotID)
+		mountpoint = r.RelaMountpoint()
+	} else {
+		configFile = d.ConfigFile("")
+		mountpoint = "/"
+	}
+
+	c, err := daemonconfig.NewDaemonConfig(d.States.FsDriver, configFile)
+	if err != nil {
+		return false, errors.Wrapf(err, "reload instance configuration %s", configFile)
+	}
+	backendType, backend := c.StorageBackend()
+	if backendType != "registry" {
+		return false, nil
+	}
+	oldAuth, oldToken := backend.Auth, backend.RegistryToken
+	c.FillAuth(kc)
+	if backend.Auth == oldAuth && backend.RegistryToken == oldToken {
+		return false, nil
+	}
+
+	bootstrap, err := r.BootstrapFile()
+	if err != nil {
+		return false, err
+	}
+	cfg, err := c.DumpString()
+	if err != nil {
+		return false, errors.Wrap(err, "dump instance configuration")
+	}
+
+	client, err := d.GetClient()
+	if err != nil {
+		return false, errors.Wrapf(err, "update auth of instance %s", r.SnapshotID)
+	}
+	if err := client.Remount(mountpoint, bootstrap, cfg); err != nil {
+		return false, errors.Wrapf(err, "remount instance %s", r.SnapshotID)
+	}
+
+	// Mount with the new auth when recovering the instance.
+	if err := c.DumpFile(configFile); err != nil {
+		return true, errors.Wrapf(err, "dump instance configuration %s", configFile)
+	}
+	if !d.IsSharedDaemon() {
+		d.Config = c
+	}
+
+	return true, nil
+}
+
 func (d *Daemon) sharedErofsMount(ra *rafs.Rafs) error {
 	client, err := d.GetClient()
 	if err != nil {
@@ -502,6 +570,47 @@ func (d *Daemon) GetCacheMetrics(sid string) (*types.CacheMetrics, error) {
 	return c.GetCacheMetrics(sid)
 }
 
+// ID of the RAFS instance in nydusd to query its blob cache and backend metrics.
+func (d *Daemon) metricsID(ra *rafs.Rafs) string {
+	switch {
+	case d.States.FsDriver == config.FsDriverFscache:
+		if id, ok := ra.Annotations[rafs.AnnoFsCacheID]; ok {
+			return id
+		}
+		return erofs.FscacheID(ra.SnapshotID)
+	case d.IsSharedDaemon():
+		return "/" + ra.SnapshotID
+	default:
+		return ""
+	}
+}
+
+// GetRafsCacheMetrics gets blob cache metrics of the RAFS instance for both fusedev and fscache daemons.
+func (d *Daemon) GetRafsCacheMetrics(ra *rafs.Rafs) (*types.CacheMetrics, error) {
+	c, err := d.GetClient()
+	if err != nil {
+		return nil, errors.Wrapf(err, "get cache metrics")
+	}
+	return c.GetBlobCacheMetrics(d.metricsID(ra))
+}
+
+// GetRafsBackendMetrics gets storage backend metrics of the RAFS instance for both fusedev and fscache daemons.
+func (d *Daemon) GetRafsBackendMetrics(ra *rafs.Rafs) (*types.BackendMetrics, error) {
+	c, err := d.GetClient()
+	if err != nil {
+		return nil, errors.Wrapf(err, "get backend metrics")
+	}
+	return c.GetBackendMetrics(d.metricsID(ra))
+}
+
+func (d *Daemon) GetAccessPatterns(sid string) ([]types.AccessPattern, error) {
+	c, err := d.GetClient()
+	if err != nil {
+		return nil, errors.Wrapf(err, "get access patterns")
+	}
+	return c.GetAccessPatterns(sid)
+}
+
 func (d *Daemon) GetClient() (NydusdClient, error) {
 	d.cmu.Lock()
 	defer d.cmu.Unlock()
@@ -513,7 +622,7 @@ func (d *Daemon) GetClient() (NydusdClient, error) {
 		if err != nil {
 			return nil, errors.Wrapf(errdefs.ErrNotFound, "daemon socket %s", sock)
 		}
-		client, err := NewNydusClient(sock)
+		client, err := NewNydusClient(d.ID(), sock)
 		if err != nil {
 			return nil, errors.Wrapf(err, "create daemon %s client", d.ID())
 		}
@@ -548,6 +657,26 @@ func (d *Daemon) Terminate() error {
 	return nil
 }
 
+// Kill nydusd by SIGKILL, so that it neither flushes nor umounts its filesystems, which
+// are taken over by the nydusd restarted or failed over on its death.
+func (d *Daemon) Kill() error {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.Pid() <= 0 {
+		return errors.Errorf("unknown pid of daemon %s", d.ID())
+	}
+	p, err := os.FindProcess(d.Pid())
+	if err != nil {
+		return errors.Wrapf(err, "find process %d", d.Pid())
+	}
+	if err = p.Signal(syscall.SIGKILL); err != nil {
+		return errors.Wrapf(err, "send SIGKILL signal to process %d", d.Pid())
+	}
+
+	return nil
+}
+
 func (d *Daemon) Wait() error {
 	// if we found pid here, we need to kill and wait process to exit, Pid=0 means somehow we lost
 	// the daemon pid, so that we can't kill the process, just roughly umount the mountpoint
@@ -49,13 +49,16 @@ type MountRequest struct {
 	FsType string `json:"fs_type"`
 	Source string `json:"source"`
 	Config string `json:"config"`
+	// Files to prefetch once the RAFS instance is mounted.
+	PrefetchFiles []string `json:"prefetch_files,omitempty"`
 }
 
-func NewMountRequest(source, config string) MountRequest {
+func NewMountRequest(source, config string, prefetchFiles []string) MountRequest {
 	return MountRequest{
-		FsType: "rafs",
-		Source: source,
-		Config: config,
+		FsType:        "rafs",
+		Source:        source,
+		Config:        config,
+		PrefetchFiles: prefetchFiles,
 	}
 }
 
@@ -73,6 +76,14 @@ type FsMetrics struct {
 	NrOpens                   uint64   `json:"nr_opens"`
 }
 
+// AccessPattern records when a file is first read and how many times it's read.
+type AccessPattern struct {
+	Ino                  uint64 `json:"ino"`
+	NrRead               uint64 `json:"nr_read"`
+	FirstAccessTimeSecs  uint64 `json:"first_access_time_secs"`
+	FirstAccessTimeNanos uint32 `json:"first_access_time_nanos"`
+}
+
 type InflightMetrics struct {
 	Values []struct {
 		Inode         uint64 `json:"inode"`
@@ -98,3 +109,14 @@ type CacheMetrics struct {
 	PrefetchEndTimeSecs          uint64   `json:"prefetch_end_time_secs"`
 	BufferedBackendSize          uint64   `json:"buffered_backend_size"`
 }
+
+// Metrics of the storage backend, e.g. registry or OSS, which data blobs are read from.
+type BackendMetrics struct {
+	BackendType                      string   `json:"backend_type"`
+	ReadCount                        uint64   `json:"read_count"`
+	ReadErrors                       uint64   `json:"read_errors"`
+	ReadAmountTotal                  uint64   `json:"read_amount_total"`
+	ReadCumulativeLatencyMillisTotal uint64   `json:"read_cumulative_latency_millis_total"`
+	ReadCumulativeLatencyMillisDist  []uint64 `json:"read_cumulative_latency_millis_dist"`
+	ReadCountBlockSizeDist           []uint64 `json:"read_count_block_size_dist"`
+}
@@ -0,0 +1,220 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package encryption
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/log"
+	"github.com/containers/ocicrypt"
+	encconfig "github.com/containers/ocicrypt/config"
+	keyproviderconfig "github.com/containers/ocicrypt/config/keyprovider-config"
+	enchelpers "github.com/containers/ocicrypt/helpers"
+	"github.com/containers/ocicrypt/keywrap/keyprovider"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/auth"
+	"github.com/containerd/nydus-snapshotter/pkg/remote"
+	"github.com/containerd/nydus-snapshotter/pkg/remote/remotes"
+)
+
+const (
+	// Where the bootstrap is in nydus meta layers.
+	bootstrapNameInLayer = "image/image.boot"
+	maxManifestSize      = 0x800000
+)
+
+// Decryptor decrypts encrypted nydus bootstraps when mounting images, with
+// node-local private keys or ocicrypt key providers.
+type Decryptor struct {
+	keysDir string
+	// Names of key providers registered to ocicrypt.
+	providers []string
+	insecure  bool
+}
+
+// NewDecryptor creates a decryptor with private keys in `keysDir` and key providers
+// configured by the ocicrypt config file `keyProviderConfig`, either can be empty.
+func NewDecryptor(keysDir, keyProviderConfig string, insecure bool) (*Decryptor, error) {
+	d := &Decryptor{
+		keysDir:  keysDir,
+		insecure: insecure,
+	}
+
+	if d.keysDir != "" {
+		if _, err := os.Stat(d.keysDir); err != nil {
+			return nil, errors.Wrapf(err, "find decryption keys dir %s", d.keysDir)
+		}
+	}
+
+	if keyProviderConfig != "" {
+		data, err := os.ReadFile(keyProviderConfig)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read keyprovider config %s", keyProviderConfig)
+		}
+		var ic keyproviderconfig.OcicryptConfig
+		if err := json.Unmarshal(data, &ic); err != nil {
+			return nil, errors.Wrapf(err, "parse keyprovider config %s", keyProviderConfig)
+		}
+		for name, attrs := range ic.KeyProviderConfig {
+			ocicrypt.RegisterKeyWrapper("provider."+name, keyprovider.NewKeyWrapper(name, attrs))
+			d.providers = append(d.providers, name)
+		}
+	}
+
+	return d, nil
+}
+
+// Keys are loaded on each decryption, so that keys can be provisioned or rotated
+// without restarting nydus-snapshotter.
+func (d *Decryptor) cryptoConfig() (*encconfig.CryptoConfig, error) {
+	var keys []string
+	if d.keysDir != "" {
+		entries, err := os.ReadDir(d.keysDir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read decryption keys dir %s", d.keysDir)
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				keys = append(keys, filepath.Join(d.keysDir, entry.Name()))
+			}
+		}
+	}
+	for _, name := range d.providers {
+		keys = append(keys, "provider:"+name)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no decryption key or key provider is available")
+	}
+
+	cc, err := enchelpers.CreateDecryptCryptoConfig(keys, []string{})
+	if err != nil {
+		return nil, errors.Wrap(err, "create decrypt config")
+	}
+	return &cc, nil
+}
+
+// UnpackBootstrap decrypts the encrypted nydus meta layer read from `reader` and unpacks
+// the file `source` in it to `target`. The whole layer is read to authenticate it before
+// the bootstrap can be used, and it fails if none of the keys can decrypt the layer.
+func (d *Decryptor) UnpackBootstrap(desc ocispec.Descriptor, reader io.Reader, source, target string) (retErr error) {
+	cc, err := d.cryptoConfig()
+	if err != nil {
+		return err
+	}
+	plain, _, err := ocicrypt.DecryptLayer(cc.DecryptConfig, reader, desc, false)
+	if err != nil {
+		return errors.Wrapf(err, "decrypt bootstrap layer %s", desc.Digest)
+	}
+
+	defer func() {
+		if retErr != nil {
+			os.Remove(target)
+		}
+	}()
+
+	if err := remote.Unpack(plain, source, target); err != nil {
+		return errors.Wrap(err, "unpack bootstrap from decrypted layer")
+	}
+	// The HMAC of the layer is only checked at the end of the stream.
+	if _, err := io.Copy(io.Discard, plain); err != nil {
+		return errors.Wrapf(err, "authenticate decrypted bootstrap layer %s", desc.Digest)
+	}
+
+	return nil
+}
+
+// PrepareMetaLayer unpacks the bootstrap of the nydus meta layer to `upperDir` if the
+// layer is encrypted. It returns false if the layer is not encrypted, which is left
+// to containerd to unpack.
+func (d *Decryptor) PrepareMetaLayer(ctx context.Context, ref string, manifestDigest, layerDigest digest.Digest,
+	labels map[string]string, upperDir string) (bool, error) {
+	keyChain, err := auth.GetKeyChainByRef(ref, labels)
+	if err != nil {
+		return false, errors.Wrap(err, "get key chain")
+	}
+	remote := remote.New(keyChain, d.insecure)
+
+	handle := func() (bool, error) {
+		fetcher, err := remote.Fetcher(ctx, ref)
+		if err != nil {
+			return false, err
+		}
+
+		layer, err := findLayer(ctx, fetcher, manifestDigest, layerDigest)
+		if err != nil {
+			return false, err
+		}
+		if !IsEncryptedMediaType(layer.MediaType) {
+			return false, nil
+		}
+
+		rc, err := fetcher.Fetch(ctx, layer)
+		if err != nil {
+			return false, errors.Wrapf(err, "fetch layer %s", layer.Digest)
+		}
+		defer rc.Close()
+
+		bootstrapDir := filepath.Join(upperDir, filepath.Dir(bootstrapNameInLayer))
+		if err := os.MkdirAll(bootstrapDir, 0750); err != nil {
+			return false, errors.Wrapf(err, "create bootstrap dir %s", bootstrapDir)
+		}
+		bootstrap := filepath.Join(upperDir, bootstrapNameInLayer)
+		if err := d.UnpackBootstrap(layer, rc, bootstrapNameInLayer, bootstrap); err != nil {
+			return true, err
+		}
+
+		log.L.Infof("decrypted bootstrap layer %s of image %s", layer.Digest, ref)
+		return true, nil
+	}
+
+	encrypted, err := handle()
+	if err != nil && remote.RetryWithPlainHTTP(ref, err) {
+		return handle()
+	}
+
+	return encrypted, err
+}
+
+// Find the descriptor of the layer in the manifest, with annotations to decrypt it.
+func findLayer(ctx context.Context, fetcher remotes.Fetcher, manifestDigest, layerDigest digest.Digest) (ocispec.Descriptor, error) {
+	fetcherByDigest, ok := fetcher.(remotes.FetcherByDigest)
+	if !ok {
+		return ocispec.Descriptor{}, errors.Errorf("fetcher %T does not implement remotes.FetcherByDigest", fetcher)
+	}
+	rc, _, err := fetcherByDigest.FetchByDigest(ctx, manifestDigest)
+	if err != nil {
+		return ocispec.Descriptor{}, errors.Wrapf(err, "fetch manifest %s", manifestDigest)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, maxManifestSize))
+	if err != nil {
+		return ocispec.Descriptor{}, errors.Wrapf(err, "read manifest %s", manifestDigest)
+	}
+	if digest.FromBytes(data) != manifestDigest {
+		return ocispec.Descriptor{}, errors.Errorf("manifest mismatches digest %s", manifestDigest)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return ocispec.Descriptor{}, errors.Wrapf(err, "unmarshal manifest %s", manifestDigest)
+	}
+
+	for _, layer := range manifest.Layers {
+		if layer.Digest == layerDigest {
+			return layer, nil
+		}
+	}
+
+	return ocispec.Descriptor{}, errors.Errorf("layer %s is not found in manifest %s", layerDigest, manifestDigest)
+}
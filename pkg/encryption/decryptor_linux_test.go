@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package encryption
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/ocicrypt"
+	enchelpers "github.com/containers/ocicrypt/helpers"
+	encocispec "github.com/containers/ocicrypt/spec"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// Generate a RSA key pair, returns paths of the private and public keys.
+func generateKey(t *testing.T, dir, name string) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	privateKey := filepath.Join(dir, name+".pem")
+	require.NoError(t, os.WriteFile(privateKey, pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}), 0600))
+
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	publicKey := filepath.Join(t.TempDir(), name+".pub")
+	require.NoError(t, os.WriteFile(publicKey, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), 0600))
+
+	return privateKey, publicKey
+}
+
+// Build a nydus meta layer containing the bootstrap and encrypt it for the recipient.
+func encryptedMetaLayer(t *testing.T, bootstrap []byte, publicKey string) (ocispec.Descriptor, []byte) {
+	var layer bytes.Buffer
+	gw := gzip.NewWriter(&layer)
+	tw := tar.NewWriter(gw)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: bootstrapNameInLayer, Mode: 0644, Size: int64(len(bootstrap))}))
+	_, err := tw.Write(bootstrap)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayerGzip,
+		Digest:    digest.FromBytes(layer.Bytes()),
+		Size:      int64(layer.Len()),
+	}
+	cc, err := enchelpers.CreateCryptoConfig([]string{"jwe:" + publicKey}, []string{})
+	require.NoError(t, err)
+	rd, finalizer, err := ocicrypt.EncryptLayer(cc.EncryptConfig, bytes.NewReader(layer.Bytes()), desc)
+	require.NoError(t, err)
+	encrypted, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	annotations, err := finalizer()
+	require.NoError(t, err)
+
+	return ocispec.Descriptor{
+		MediaType:   encocispec.MediaTypeLayerGzipEnc,
+		Digest:      digest.FromBytes(encrypted),
+		Size:        int64(len(encrypted)),
+		Annotations: annotations,
+	}, encrypted
+}
+
+func TestUnpackBootstrap(t *testing.T) {
+	keysDir := t.TempDir()
+	_, publicKey := generateKey(t, keysDir, "node")
+	bootstrap := []byte("encrypted bootstrap")
+	desc, encrypted := encryptedMetaLayer(t, bootstrap, publicKey)
+	require.True(t, IsEncryptedMediaType(desc.MediaType))
+	require.False(t, IsEncryptedMediaType(ocispec.MediaTypeImageLayerGzip))
+
+	target := filepath.Join(t.TempDir(), "image.boot")
+
+	// No key is available.
+	d, err := NewDecryptor(t.TempDir(), "", false)
+	require.NoError(t, err)
+	require.Error(t, d.UnpackBootstrap(desc, bytes.NewReader(encrypted), bootstrapNameInLayer, target))
+	require.NoFileExists(t, target)
+
+	// No key matches.
+	otherDir := t.TempDir()
+	generateKey(t, otherDir, "other")
+	d, err = NewDecryptor(otherDir, "", false)
+	require.NoError(t, err)
+	require.Error(t, d.UnpackBootstrap(desc, bytes.NewReader(encrypted), bootstrapNameInLayer, target))
+	require.NoFileExists(t, target)
+
+	// The layer is tampered.
+	d, err = NewDecryptor(keysDir, "", false)
+	require.NoError(t, err)
+	tampered := append([]byte{}, encrypted...)
+	tampered[len(tampered)-1] ^= 0xff
+	require.Error(t, d.UnpackBootstrap(desc, bytes.NewReader(tampered), bootstrapNameInLayer, target))
+	require.NoFileExists(t, target)
+
+	require.NoError(t, d.UnpackBootstrap(desc, bytes.NewReader(encrypted), bootstrapNameInLayer, target))
+	data, err := os.ReadFile(target)
+	require.NoError(t, err)
+	require.Equal(t, bootstrap, data)
+
+	_, err = NewDecryptor(filepath.Join(keysDir, "missing"), "", false)
+	require.Error(t, err)
+}
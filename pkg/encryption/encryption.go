@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"strings"
 
 	"github.com/containerd/containerd/v2/core/content"
 	"github.com/containerd/containerd/v2/core/images"
@@ -251,3 +252,8 @@ func DeryptNydusBootstrap(ctx context.Context, cs content.Store, desc ocispec.De
 	}
 	return newDesc, err
 }
+
+// IsEncryptedMediaType tells whether the layer of the media type is encrypted by ocicrypt.
+func IsEncryptedMediaType(mediaType string) bool {
+	return strings.HasSuffix(mediaType, "+encrypted")
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package events publishes what happens to nydusd daemons, such as crashes, recoveries
+// and mount failures, to the containerd events service and optionally as Kubernetes
+// Events on affected pods. Events are published asynchronously and dropped if the
+// publisher falls behind, so callers are never blocked.
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	eventsapi "github.com/containerd/containerd/api/services/events/v1"
+	"github.com/containerd/containerd/v2/defaults"
+	"github.com/containerd/containerd/v2/pkg/dialer"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+	"github.com/containerd/log"
+	"github.com/containerd/typeurl/v2"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/containerd/nydus-snapshotter/config"
+)
+
+const (
+	TopicDaemonDied          = "/nydus/daemon/died"
+	TopicDaemonRestarted     = "/nydus/daemon/restarted"
+	TopicDaemonFailedOver    = "/nydus/daemon/failover"
+	TopicDaemonRecoverFailed = "/nydus/daemon/recover-failed"
+	TopicMountFailed         = "/nydus/daemon/mount-failed"
+	TopicReadFailed          = "/nydus/daemon/read-failed"
+	TopicHungIO              = "/nydus/daemon/hung-io"
+)
+
+const (
+	defaultContainerdAddress = "/run/containerd/containerd.sock"
+	defaultNamespace         = "k8s.io"
+	publishTimeout           = 5 * time.Second
+	queueSize                = 64
+)
+
+// DaemonEvent describes what happens to a nydusd daemon and the images it serves.
+type DaemonEvent struct {
+	DaemonID string `json:"daemon_id,omitempty"`
+	FsDriver string `json:"fs_driver,omitempty"`
+	// Snapshots and images served by the daemon, or the snapshot failed to mount.
+	Snapshots []string  `json:"snapshots,omitempty"`
+	Images    []string  `json:"images,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func init() {
+	typeurl.Register(&DaemonEvent{}, "nydus-snapshotter", "events", "DaemonEvent")
+}
+
+type message struct {
+	topic string
+	event *DaemonEvent
+}
+
+type publisher struct {
+	namespace string
+	client    eventsapi.EventsClient
+	// Nil if events are not recorded on pods.
+	pods  *podRecorder
+	queue chan message
+}
+
+var (
+	mu sync.Mutex
+	// Nil if publishing events is disabled.
+	globalPublisher *publisher
+)
+
+// Init starts publishing events until `ctx` is done.
+func Init(ctx context.Context, cfg config.EventsConfig) error {
+	if !cfg.Enable {
+		return nil
+	}
+
+	address := cfg.ContainerdAddress
+	if address == "" {
+		address = defaultContainerdAddress
+	}
+	namespace := cfg.Namespace
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+
+	// The connection is established lazily since containerd may start after the snapshotter.
+	conn, err := grpc.NewClient(dialer.DialAddress(address),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(dialer.ContextDialer),
+		grpc.WithDefaultCallOptions(grpc.MaxCallSendMsgSize(defaults.DefaultMaxSendMsgSize)),
+	)
+	if err != nil {
+		return errors.Wrapf(err, "connect to containerd %s", address)
+	}
+
+	p := &publisher{
+		namespace: namespace,
+		client:    eventsapi.NewEventsClient(conn),
+		queue:     make(chan message, queueSize),
+	}
+	if cfg.KubeconfigPath != "" {
+		p.pods, err = newPodRecorder(cfg.KubeconfigPath)
+		if err != nil {
+			conn.Close()
+			return errors.Wrap(err, "create kubernetes event recorder")
+		}
+	}
+
+	mu.Lock()
+	globalPublisher = p
+	mu.Unlock()
+
+	go func() {
+		defer conn.Close()
+		p.run(ctx)
+	}()
+
+	log.L.Infof("publish nydusd events to containerd %s in namespace %s", address, namespace)
+
+	return nil
+}
+
+// Publish the event in background, it's a no-op if publishing events is disabled.
+func Publish(topic string, event *DaemonEvent) {
+	mu.Lock()
+	p := globalPublisher
+	mu.Unlock()
+	if p == nil {
+		return
+	}
+
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	select {
+	case p.queue <- message{topic: topic, event: event}:
+	default:
+		log.L.Warnf("too many pending events, drop event %s of daemon %s", topic, event.DaemonID)
+	}
+}
+
+func (p *publisher) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			mu.Lock()
+			if globalPublisher == p {
+				globalPublisher = nil
+			}
+			mu.Unlock()
+			return
+		case m := <-p.queue:
+			if err := p.publish(ctx, m); err != nil {
+				log.L.WithError(err).Warnf("failed to publish event %s to containerd", m.topic)
+			}
+			if p.pods != nil {
+				p.pods.record(ctx, m.topic, m.event)
+			}
+		}
+	}
+}
+
+func (p *publisher) publish(ctx context.Context, m message) error {
+	payload, err := typeurl.MarshalAnyToProto(m.event)
+	if err != nil {
+		return errors.Wrap(err, "marshal event")
+	}
+
+	ctx, cancel := context.WithTimeout(namespaces.WithNamespace(ctx, p.namespace), publishTimeout)
+	defer cancel()
+	_, err = p.client.Publish(ctx, &eventsapi.PublishRequest{Topic: m.topic, Event: payload})
+	return err
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package events
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	eventsapi "github.com/containerd/containerd/api/services/events/v1"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+	"github.com/containerd/typeurl/v2"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/containerd/nydus-snapshotter/config"
+)
+
+type published struct {
+	namespace string
+	topic     string
+	event     *DaemonEvent
+}
+
+type fakeEventsServer struct {
+	eventsapi.UnimplementedEventsServer
+	ch chan published
+}
+
+func (s *fakeEventsServer) Publish(ctx context.Context, req *eventsapi.PublishRequest) (*emptypb.Empty, error) {
+	ns, _ := namespaces.Namespace(ctx)
+	v, err := typeurl.UnmarshalAny(req.Event)
+	if err != nil {
+		return nil, err
+	}
+	s.ch <- published{namespace: ns, topic: req.Topic, event: v.(*DaemonEvent)}
+	return &emptypb.Empty{}, nil
+}
+
+func TestPublish(t *testing.T) {
+	// No-op if publishing is disabled.
+	Publish(TopicDaemonDied, &DaemonEvent{DaemonID: "d0"})
+
+	sock := filepath.Join(t.TempDir(), "containerd.sock")
+	listener, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	server := &fakeEventsServer{ch: make(chan published, 1)}
+	rpc := grpc.NewServer()
+	eventsapi.RegisterEventsServer(rpc, server)
+	go func() { _ = rpc.Serve(listener) }()
+	defer rpc.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, Init(ctx, config.EventsConfig{Enable: true, ContainerdAddress: sock}))
+
+	Publish(TopicDaemonDied, &DaemonEvent{DaemonID: "d1", Images: []string{"docker.io/library/nginx:latest"}})
+	select {
+	case p := <-server.ch:
+		require.Equal(t, defaultNamespace, p.namespace)
+		require.Equal(t, TopicDaemonDied, p.topic)
+		require.Equal(t, "d1", p.event.DaemonID)
+		require.Equal(t, []string{"docker.io/library/nginx:latest"}, p.event.Images)
+		require.False(t, p.event.Timestamp.IsZero())
+	case <-time.After(10 * time.Second):
+		t.Fatal("event is not published")
+	}
+}
+
+func TestRecordPodEvents(t *testing.T) {
+	pod := func(name, node, image string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: corev1.PodSpec{
+				NodeName:   node,
+				Containers: []corev1.Container{{Name: "app", Image: image}},
+			},
+		}
+	}
+	client := fake.NewSimpleClientset(
+		pod("nginx", "node1", "nginx"),
+		pod("redis", "node1", "redis:7"),
+	)
+	r := &podRecorder{client: client, nodeName: "node1"}
+
+	ctx := context.Background()
+	r.record(ctx, TopicDaemonDied, &DaemonEvent{DaemonID: "d1", Images: []string{"docker.io/library/nginx:latest"}})
+	r.record(ctx, "/nydus/daemon/unknown", &DaemonEvent{DaemonID: "d1", Images: []string{"docker.io/library/redis:7"}})
+
+	events, err := client.CoreV1().Events("default").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, events.Items, 1)
+	ev := events.Items[0]
+	require.Equal(t, "nginx", ev.InvolvedObject.Name)
+	require.Equal(t, "NydusDaemonDied", ev.Reason)
+	require.Equal(t, corev1.EventTypeWarning, ev.Type)
+	require.Equal(t, "nydusd d1 serving image docker.io/library/nginx:latest died", ev.Message)
+}
@@ -0,0 +1,174 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package events
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/distribution/reference"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	eventSource = "nydus-snapshotter"
+	// The node is identified by the environment variable, usually set by the downward API.
+	nodeNameEnv = "NODE_NAME"
+)
+
+// How events of topics are recorded as Kubernetes Events.
+var topicReasons = map[string]struct {
+	reason    string
+	eventType string
+	action    string
+}{
+	TopicDaemonDied:          {"NydusDaemonDied", corev1.EventTypeWarning, "died"},
+	TopicDaemonRestarted:     {"NydusDaemonRestarted", corev1.EventTypeNormal, "was restarted"},
+	TopicDaemonFailedOver:    {"NydusDaemonFailedOver", corev1.EventTypeNormal, "was failed over"},
+	TopicDaemonRecoverFailed: {"NydusDaemonRecoverFailed", corev1.EventTypeWarning, "failed to recover"},
+	TopicMountFailed:         {"NydusMountFailed", corev1.EventTypeWarning, "failed to mount"},
+	TopicReadFailed:          {"NydusReadFailed", corev1.EventTypeWarning, "failed to read data"},
+	TopicHungIO:              {"NydusHungIO", corev1.EventTypeWarning, "has hung IO requests"},
+}
+
+// Record events on pods of the node which run images affected by the events.
+type podRecorder struct {
+	client   kubernetes.Interface
+	nodeName string
+}
+
+func newPodRecorder(kubeconfigPath string) (*podRecorder, error) {
+	loadingRule := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRule.ExplicitPath = kubeconfigPath
+	clientConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		loadingRule,
+		&clientcmd.ConfigOverrides{},
+	).ClientConfig()
+	if err != nil {
+		return nil, errors.Wrapf(err, "load kubeconfig %s", kubeconfigPath)
+	}
+	client, err := kubernetes.NewForConfig(clientConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "create kubernetes client")
+	}
+
+	nodeName := os.Getenv(nodeNameEnv)
+	if nodeName == "" {
+		if nodeName, err = os.Hostname(); err != nil {
+			return nil, errors.Wrap(err, "get node name")
+		}
+	}
+
+	return &podRecorder{client: client, nodeName: nodeName}, nil
+}
+
+// Images are compared by their normalized references.
+func normalizeImage(image string) string {
+	named, err := reference.ParseDockerRef(image)
+	if err != nil {
+		return image
+	}
+	return named.String()
+}
+
+// Pods of the node running any of the images.
+func (r *podRecorder) affectedPods(ctx context.Context, images []string) ([]corev1.Pod, error) {
+	wanted := make(map[string]struct{}, len(images))
+	for _, i := range images {
+		wanted[normalizeImage(i)] = struct{}{}
+	}
+
+	pods, err := r.client.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: "spec.nodeName=" + r.nodeName,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "list pods of node %s", r.nodeName)
+	}
+
+	var result []corev1.Pod
+	for _, pod := range pods.Items {
+		if runsAnyImage(&pod, wanted) {
+			result = append(result, pod)
+		}
+	}
+
+	return result, nil
+}
+
+func runsAnyImage(pod *corev1.Pod, images map[string]struct{}) bool {
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, c := range containers {
+			if _, ok := images[normalizeImage(c.Image)]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *podRecorder) record(ctx context.Context, topic string, event *DaemonEvent) {
+	if len(event.Images) == 0 {
+		return
+	}
+	reason, ok := topicReasons[topic]
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+
+	pods, err := r.affectedPods(ctx, event.Images)
+	if err != nil {
+		log.L.WithError(err).Warnf("failed to find pods affected by event %s", topic)
+		return
+	}
+
+	msg := fmt.Sprintf("nydus image %s %s", strings.Join(event.Images, ", "), reason.action)
+	if event.DaemonID != "" {
+		msg = fmt.Sprintf("nydusd %s serving image %s %s", event.DaemonID, strings.Join(event.Images, ", "), reason.action)
+	}
+	if event.Message != "" {
+		msg += ": " + event.Message
+	}
+
+	now := metav1.NewTime(time.Now())
+	for _, pod := range pods {
+		ev := &corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: pod.Name + ".",
+				Namespace:    pod.Namespace,
+			},
+			InvolvedObject: corev1.ObjectReference{
+				APIVersion:      "v1",
+				Kind:            "Pod",
+				Namespace:       pod.Namespace,
+				Name:            pod.Name,
+				UID:             pod.UID,
+				ResourceVersion: pod.ResourceVersion,
+			},
+			Reason:         reason.reason,
+			Message:        msg,
+			Type:           reason.eventType,
+			Source:         corev1.EventSource{Component: eventSource, Host: r.nodeName},
+			FirstTimestamp: now,
+			LastTimestamp:  now,
+			Count:          1,
+		}
+		if _, err := r.client.CoreV1().Events(pod.Namespace).Create(ctx, ev, metav1.CreateOptions{}); err != nil {
+			log.L.WithError(err).Warnf("failed to record event %s on pod %s/%s", topic, pod.Namespace, pod.Name)
+		}
+	}
+}
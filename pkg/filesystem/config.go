@@ -8,8 +8,11 @@
 package filesystem
 
 import (
+	"github.com/containerd/nydus-snapshotter/pkg/accesstrace"
 	"github.com/containerd/nydus-snapshotter/pkg/cache"
+	"github.com/containerd/nydus-snapshotter/pkg/encryption"
 	"github.com/containerd/nydus-snapshotter/pkg/manager"
+	"github.com/containerd/nydus-snapshotter/pkg/p2p"
 	"github.com/containerd/nydus-snapshotter/pkg/referrer"
 	"github.com/containerd/nydus-snapshotter/pkg/signature"
 	"github.com/containerd/nydus-snapshotter/pkg/stargz"
@@ -60,6 +63,16 @@ func WithReferrerManager(rm *referrer.Manager) NewFSOpt {
 	}
 }
 
+func WithDecryptor(decryptor *encryption.Decryptor) NewFSOpt {
+	return func(fs *Filesystem) error {
+		if decryptor == nil {
+			return errors.New("decryptor cannot be nil")
+		}
+		fs.decryptor = decryptor
+		return nil
+	}
+}
+
 func WithTarfsManager(tm *tarfs.Manager) NewFSOpt {
 	return func(fs *Filesystem) error {
 		if tm == nil {
@@ -70,6 +83,23 @@ func WithTarfsManager(tm *tarfs.Manager) NewFSOpt {
 	}
 }
 
+func WithP2PManager(pm *p2p.Manager) NewFSOpt {
+	return func(fs *Filesystem) error {
+		if pm == nil {
+			return errors.New("p2p manager cannot be nil")
+		}
+		fs.p2pMgr = pm
+		return nil
+	}
+}
+
+func WithAccessTraceRecorder(rc *accesstrace.Recorder) NewFSOpt {
+	return func(fs *Filesystem) error {
+		fs.accessTraceRecorder = rc
+		return nil
+	}
+}
+
 func WithVerifier(verifier *signature.Verifier) NewFSOpt {
 	return func(fs *Filesystem) error {
 		fs.verifier = verifier
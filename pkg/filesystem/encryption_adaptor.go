@@ -0,0 +1,39 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"context"
+
+	snpkg "github.com/containerd/containerd/v2/pkg/snapshotters"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+func (fs *Filesystem) DecryptionEnabled() bool {
+	return fs.decryptor != nil
+}
+
+// PrepareEncryptedMetaLayer decrypts and unpacks the bootstrap of the nydus meta layer
+// into `upperDirPath` if the layer is encrypted, so that containerd doesn't have to.
+// It returns false if the layer is not encrypted.
+func (fs *Filesystem) PrepareEncryptedMetaLayer(ctx context.Context, labels map[string]string, upperDirPath string) (bool, error) {
+	ref, ok := labels[snpkg.TargetRefLabel]
+	if !ok {
+		return false, errors.Errorf("not found image reference label")
+	}
+	layerDigest := digest.Digest(labels[snpkg.TargetLayerDigestLabel])
+	if layerDigest.Validate() != nil {
+		return false, errors.Errorf("not found layer digest label")
+	}
+	manifestDigest := digest.Digest(labels[snpkg.TargetManifestDigestLabel])
+	if manifestDigest.Validate() != nil {
+		return false, errors.Errorf("not found manifest digest label")
+	}
+
+	return fs.decryptor.PrepareMetaLayer(ctx, ref, manifestDigest, layerDigest, labels, upperDirPath)
+}
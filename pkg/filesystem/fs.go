@@ -26,32 +26,44 @@ import (
 
 	"github.com/containerd/containerd/v2/core/snapshots"
 	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/containerd/containerd/v2/pkg/tracing"
 	"github.com/containerd/log"
 
 	"github.com/containerd/nydus-snapshotter/config"
 	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
+	"github.com/containerd/nydus-snapshotter/pkg/accesstrace"
 	"github.com/containerd/nydus-snapshotter/pkg/cache"
 	"github.com/containerd/nydus-snapshotter/pkg/daemon"
 	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+	"github.com/containerd/nydus-snapshotter/pkg/encryption"
 	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/events"
 	"github.com/containerd/nydus-snapshotter/pkg/label"
 	"github.com/containerd/nydus-snapshotter/pkg/manager"
+	"github.com/containerd/nydus-snapshotter/pkg/p2p"
 	racache "github.com/containerd/nydus-snapshotter/pkg/rafs"
 	"github.com/containerd/nydus-snapshotter/pkg/referrer"
 	"github.com/containerd/nydus-snapshotter/pkg/signature"
 	"github.com/containerd/nydus-snapshotter/pkg/stargz"
 	"github.com/containerd/nydus-snapshotter/pkg/tarfs"
+	"github.com/containerd/nydus-snapshotter/pkg/utils/mount"
 )
 
+// Prefix of span names of filesystem operations.
+const spanPrefix = "nydus.filesystem"
+
 type Filesystem struct {
 	fusedevSharedDaemon *daemon.Daemon
 	fscacheSharedDaemon *daemon.Daemon
 	enabledManagers     map[string]*manager.Manager
 	cacheMgr            *cache.Manager
 	referrerMgr         *referrer.Manager
+	decryptor           *encryption.Decryptor
 	stargzResolver      *stargz.Resolver
 	tarfsMgr            *tarfs.Manager
+	p2pMgr              *p2p.Manager
 	verifier            *signature.Verifier
+	accessTraceRecorder *accesstrace.Recorder
 	nydusdBinaryPath    string
 	rootMountpoint      string
 	snapshotMutexMap    sync.Map
@@ -131,12 +143,27 @@ func NewFileSystem(ctx context.Context, opt ...NewFSOpt) (*Filesystem, error) {
 	for _, d := range recoveringDaemons {
 		d := d
 		egRecover.Go(func() error {
-			d.ClearVestige()
 			fsManager, err := fs.getManager(d.States.FsDriver)
 			if err != nil {
 				log.L.Warnf("Failed to get filesystem manager for daemon %s, skipping recovery: %v", d.States.ID, err)
 				return nil
 			}
+			// Take over the service of the dead daemon without unmounting if its
+			// supervisor still keeps the states persisted before snapshotter restart.
+			if fsManager.RecoverPolicy == config.RecoverPolicyFailover && d.Supervisor != nil && d.Supervisor.HasStates() {
+				log.L.Infof("Do failover for daemon %s during recovery", d.ID())
+				if err := fsManager.FailoverDaemon(d); err != nil {
+					log.L.Warnf("Failed to failover daemon %s during recovery, skipping: %v", d.ID(), err)
+					return nil
+				}
+				if err := d.WaitUntilState(types.DaemonStateRunning); err != nil {
+					log.L.Warnf("Failed to wait for daemon %s to become running, skipping: %v", d.ID(), err)
+					return nil
+				}
+				fs.TryRetainSharedDaemon(d)
+				return nil
+			}
+			d.ClearVestige()
 			if err := fsManager.StartDaemon(d); err != nil {
 				log.L.Warnf("Failed to start daemon %s during recovery, skipping: %v", d.ID(), err)
 				return nil
@@ -242,7 +269,14 @@ func (fs *Filesystem) TryStopSharedDaemon() {
 
 // WaitUntilReady wait until daemon ready by snapshotID, it will wait until nydus domain socket established
 // and the status of nydusd daemon must be ready
-func (fs *Filesystem) WaitUntilReady(snapshotID string) error {
+func (fs *Filesystem) WaitUntilReady(ctx context.Context, snapshotID string) (err error) {
+	_, span := tracing.StartSpan(ctx, tracing.Name(spanPrefix, "WaitUntilReady"),
+		tracing.WithAttribute("snapshot.id", snapshotID))
+	defer func() {
+		span.SetStatus(err)
+		span.End()
+	}()
+
 	rafs := racache.RafsGlobalCache.Get(snapshotID)
 	if rafs == nil {
 		// If NoneDaemon mode, there's no need to wait for daemon ready
@@ -272,6 +306,13 @@ func (fs *Filesystem) WaitUntilReady(snapshotID string) error {
 // this method will fork nydus daemon and manage it in the internal store, and indexed by snapshotID
 // It must set up all necessary resources during Mount procedure and revoke any step if necessary.
 func (fs *Filesystem) Mount(ctx context.Context, snapshotID string, labels map[string]string, s *storage.Snapshot) (err error) {
+	ctx, span := tracing.StartSpan(ctx, tracing.Name(spanPrefix, "Mount"),
+		tracing.WithAttribute("snapshot.id", snapshotID))
+	defer func() {
+		span.SetStatus(err)
+		span.End()
+	}()
+
 	mu := fs.getSnapshotMutex(snapshotID)
 	mu.Lock()
 	defer mu.Unlock()
@@ -305,9 +346,18 @@ func (fs *Filesystem) Mount(ctx context.Context, snapshotID string, labels map[s
 	if err != nil {
 		return errors.Wrapf(err, "create rafs instance %s", snapshotID)
 	}
+	if referrer, ok := labels[label.NydusReferrer]; ok {
+		rafs.AddAnnotation(label.NydusReferrer, referrer)
+	}
 
 	defer func() {
 		if err != nil {
+			events.Publish(events.TopicMountFailed, &events.DaemonEvent{
+				FsDriver:  fsDriver,
+				Snapshots: []string{snapshotID},
+				Images:    []string{imageID},
+				Message:   err.Error(),
+			})
 			if umountErr := fs.Umount(ctx, snapshotID); umountErr != nil {
 				log.L.WithError(umountErr).Warnf("failed to umount snapshot %s during cleanup", snapshotID)
 			}
@@ -334,6 +384,7 @@ func (fs *Filesystem) Mount(ctx context.Context, snapshotID string, labels map[s
 		if err := fs.copyBlobMetaFiles(bootstrap, cacheDir); err != nil {
 			log.L.Warnf("Failed to copy blob.meta files to cache: %v", err)
 		}
+		fs.cacheMgr.RecordAccess(rafs)
 
 		if useSharedDaemon {
 			d, err = fs.getSharedDaemon(fsDriver)
@@ -364,6 +415,12 @@ func (fs *Filesystem) Mount(ctx context.Context, snapshotID string, labels map[s
 		if err != nil {
 			return errors.Wrap(err, "supplement configuration")
 		}
+		if files := params[daemonconfig.PrefetchFiles]; files != "" {
+			rafs.AddAnnotation(racache.AnnoPrefetchFiles, files)
+		}
+		if fs.p2pMgr != nil {
+			fs.p2pMgr.AddMirrors(cfg, bootstrap)
+		}
 
 		// TODO: How to manage rafs configurations on-disk? separated json config file or DB record?
 		// In order to recover erofs mount, the configuration file has to be persisted.
@@ -390,7 +447,7 @@ func (fs *Filesystem) Mount(ctx context.Context, snapshotID string, labels map[s
 		d.AddRafsInstance(rafs)
 
 		// if publicKey is not empty we should verify bootstrap file of image
-		err = fs.verifier.Verify(labels, bootstrap)
+		err = fs.verifier.Verify(ctx, imageID, labels, bootstrap)
 		if err != nil {
 			return errors.Wrapf(err, "verify signature of daemon %s", d.ID())
 		}
@@ -398,12 +455,12 @@ func (fs *Filesystem) Mount(ctx context.Context, snapshotID string, labels map[s
 
 	switch fsDriver {
 	case config.FsDriverFscache:
-		err = fs.mountRemote(fsManager, useSharedDaemon, d, rafs)
+		err = fs.mountRemote(ctx, fsManager, useSharedDaemon, d, rafs)
 		if err != nil {
 			err = errors.Wrapf(err, "mount file system by daemon %s, snapshot %s", d.ID(), snapshotID)
 		}
 	case config.FsDriverFusedev:
-		err = fs.mountRemote(fsManager, useSharedDaemon, d, rafs)
+		err = fs.mountRemote(ctx, fsManager, useSharedDaemon, d, rafs)
 		if err != nil {
 			err = errors.Wrapf(err, "mount file system by daemon %s, snapshot %s", d.ID(), snapshotID)
 		}
@@ -438,9 +495,13 @@ func (fs *Filesystem) Mount(ctx context.Context, snapshotID string, labels map[s
 			}
 			return errors.Wrapf(err, "create instance %s", snapshotID)
 		}
+
+		if fs.accessTraceRecorder != nil && d != nil {
+			fs.accessTraceRecorder.Start(rafs, d, digest.Digest(labels[snpkg.TargetManifestDigestLabel]))
+		}
 	}
 
-	return nil
+	return err
 }
 
 func (fs *Filesystem) getSnapshotMutex(snapshotID string) *sync.Mutex {
@@ -502,6 +563,9 @@ func (fs *Filesystem) Umount(_ context.Context, snapshotID string) error {
 	if fsDriver == config.FsDriverNodev {
 		return nil
 	}
+	if fs.accessTraceRecorder != nil {
+		fs.accessTraceRecorder.Stop(snapshotID)
+	}
 	fsManager, err := fs.getManager(fsDriver)
 	if err != nil {
 		return errors.Wrapf(err, "get manager for filesystem instance %s", rafs.DaemonID)
@@ -544,6 +608,67 @@ func (fs *Filesystem) Umount(_ context.Context, snapshotID string) error {
 	return nil
 }
 
+// ForceUmount umounts the RAFS instance of the snapshot on behalf of operators, e.g. the
+// instance is stuck. Even if nydusd fails to umount it, the instance is still detached from
+// its daemon and removed from both the instance cache and database, so that they are kept
+// consistent and the snapshot can be mounted again.
+func (fs *Filesystem) ForceUmount(ctx context.Context, snapshotID string) error {
+	rafs := racache.RafsGlobalCache.Get(snapshotID)
+	if rafs == nil {
+		return errors.Wrapf(errdefs.ErrNotFound, "RAFS instance of snapshot %s", snapshotID)
+	}
+
+	if err := fs.Umount(ctx, snapshotID); err != nil {
+		log.L.WithError(err).Warnf("failed to umount snapshot %s, force to remove it", snapshotID)
+		if err := fs.forceDetach(rafs); err != nil {
+			return err
+		}
+	}
+
+	racache.RafsGlobalCache.Remove(snapshotID)
+	log.L.Infof("RAFS instance of snapshot %s is forcibly umounted", snapshotID)
+
+	return nil
+}
+
+// Clean up the RAFS instance which fails to be umounted. Its mountpoint is lazily detached
+// and its dedicated nydusd, which is possibly hung, is killed and destroyed.
+func (fs *Filesystem) forceDetach(rafs *racache.Rafs) error {
+	snapshotID := rafs.SnapshotID
+	fsManager, err := fs.getManager(rafs.GetFsDriver())
+	if err != nil {
+		return errors.Wrapf(err, "get manager for filesystem instance %s", snapshotID)
+	}
+
+	// Umount may fail before or after the instance is detached from its daemon.
+	d, _ := fs.getDaemonByRafs(rafs)
+	if d != nil && d.RafsCache.Get(snapshotID) != nil {
+		d.RemoveRafsInstance(snapshotID)
+	}
+	if err := fsManager.RemoveRafsInstance(snapshotID); err != nil {
+		return errors.Wrapf(err, "remove snapshot %s", snapshotID)
+	}
+
+	// RAFS instances in shared FUSE daemons are not mounted by kernel, so nothing is detached.
+	if mp := rafs.GetMountpoint(); mp != "" {
+		if err := mount.LazyUmount(mp); err != nil {
+			log.L.WithError(err).Warnf("failed to lazily umount %s of snapshot %s", mp, snapshotID)
+		}
+	}
+
+	if d != nil && !d.IsSharedDaemon() && d.GetRef() == 0 {
+		// Nydusd may not respond to SIGTERM when it's hung.
+		if err := d.Kill(); err != nil {
+			log.L.WithError(err).Warnf("failed to kill daemon %s", d.ID())
+		}
+		if err := fsManager.DestroyDaemon(d); err != nil {
+			return errors.Wrapf(err, "destroy daemon %s", d.ID())
+		}
+	}
+
+	return nil
+}
+
 // How much space the layer/blob cache filesystem is occupying
 // The blob digest mush have `sha256:` prefixed, otherwise, throw errors.
 func (fs *Filesystem) CacheUsage(ctx context.Context, blobDigest string) (snapshots.Usage, error) {
@@ -584,6 +709,34 @@ func (fs *Filesystem) RemoveCache(blobDigest string) error {
 	return fs.cacheMgr.RemoveBlobCache(blobID)
 }
 
+// CacheMetrics returns blob cache metrics of the RAFS instance of the snapshot.
+func (fs *Filesystem) CacheMetrics(snapshotID string) (*types.CacheMetrics, error) {
+	rafs := racache.RafsGlobalCache.Get(snapshotID)
+	if rafs == nil {
+		return nil, errors.Wrapf(errdefs.ErrNotFound, "RAFS instance of snapshot %s", snapshotID)
+	}
+	d, err := fs.getDaemonByRafs(rafs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get daemon of snapshot %s", snapshotID)
+	}
+
+	var sid string
+	if d.IsSharedDaemon() {
+		sid = snapshotID
+	}
+	return d.GetCacheMetrics(sid)
+}
+
+// AccessTraceRecorder returns nil if access trace recording is disabled.
+func (fs *Filesystem) AccessTraceRecorder() *accesstrace.Recorder {
+	return fs.accessTraceRecorder
+}
+
+// Schedule a round of blob cache GC.
+func (fs *Filesystem) SchedCacheGC() {
+	fs.cacheMgr.SchedGC()
+}
+
 // Try to stop all the running daemons if they are not referenced by any snapshots
 // Clean up resources along with the daemons.
 func (fs *Filesystem) Teardown(ctx context.Context) error {
@@ -624,8 +777,21 @@ func (fs *Filesystem) BootstrapFile(id string) (string, error) {
 
 // daemon mountpoint to rafs mountpoint
 // calculate rafs mountpoint for snapshots mount slice.
-func (fs *Filesystem) mountRemote(fsManager *manager.Manager, useSharedDaemon bool,
-	d *daemon.Daemon, r *racache.Rafs) error {
+func (fs *Filesystem) mountRemote(ctx context.Context, fsManager *manager.Manager, useSharedDaemon bool,
+	d *daemon.Daemon, r *racache.Rafs) (err error) {
+	// Spans cover requests to nydusd, i.e. mounting RAFS instances by the API of shared
+	// daemons, or starting dedicated daemons.
+	op := "StartDaemon"
+	if useSharedDaemon {
+		op = "SharedMount"
+	}
+	_, span := tracing.StartSpan(ctx, tracing.Name(spanPrefix, op),
+		tracing.WithAttribute("daemon.id", d.ID()),
+		tracing.WithAttribute("fs.driver", fsManager.FsDriver))
+	defer func() {
+		span.SetStatus(err)
+		span.End()
+	}()
 
 	if useSharedDaemon {
 		if fsManager.FsDriver == config.FsDriverFusedev {
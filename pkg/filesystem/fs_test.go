@@ -0,0 +1,113 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package filesystem
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon"
+	"github.com/containerd/nydus-snapshotter/pkg/manager"
+	racache "github.com/containerd/nydus-snapshotter/pkg/rafs"
+	"github.com/containerd/nydus-snapshotter/pkg/store"
+)
+
+func newTestDaemon(t *testing.T, m *manager.Manager, mode config.DaemonMode) *daemon.Daemon {
+	dir := t.TempDir()
+	d, err := daemon.NewDaemon(
+		daemon.WithDaemonMode(mode),
+		daemon.WithFsDriver(config.FsDriverFusedev),
+		daemon.WithConfigDir(filepath.Join(dir, "config")),
+		daemon.WithLogDir(filepath.Join(dir, "logs")),
+		daemon.WithMountpoint(filepath.Join(dir, "mnt")),
+	)
+	require.NoError(t, err)
+	d.States.APISocket = filepath.Join(dir, "socket", "api.sock")
+	require.NoError(t, os.MkdirAll(d.HostMountpoint(), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Dir(d.GetAPISock()), 0755))
+	require.NoError(t, m.AddDaemon(d))
+	return d
+}
+
+func newTestRafs(t *testing.T, m *manager.Manager, d *daemon.Daemon, snapshotID string) *racache.Rafs {
+	r := &racache.Rafs{
+		SnapshotID:  snapshotID,
+		FsDriver:    config.FsDriverFusedev,
+		SnapshotDir: t.TempDir(),
+		Mountpoint:  d.HostMountpoint(),
+		Annotations: map[string]string{},
+	}
+	racache.RafsGlobalCache.Add(r)
+	d.AddRafsInstance(r)
+	require.NoError(t, m.AddRafsInstance(r))
+	return r
+}
+
+func TestForceUmount(t *testing.T) {
+	ctx := context.Background()
+	require.NoError(t, config.ProcessConfigurations(&config.SnapshotterConfig{
+		Root:         t.TempDir(),
+		DaemonMode:   string(config.DaemonModeDedicated),
+		DaemonConfig: config.DaemonConfig{FsDriver: config.FsDriverFusedev},
+	}))
+	db, err := store.NewDatabase(t.TempDir())
+	require.NoError(t, err)
+	m, err := manager.NewManager(manager.Opt{Database: db, FsDriver: config.FsDriverFusedev, RootDir: t.TempDir()})
+	require.NoError(t, err)
+	fs := &Filesystem{enabledManagers: map[string]*manager.Manager{config.FsDriverFusedev: m}}
+
+	require.Error(t, fs.ForceUmount(ctx, "missing"))
+
+	// The shared nydusd fails to umount the instance.
+	shared := newTestDaemon(t, m, config.DaemonModeShared)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"code": "EIO", "message": "instance is busy"}`))
+	}))
+	listener, err := net.Listen("unix", shared.GetAPISock())
+	require.NoError(t, err)
+	ts.Listener = listener
+	ts.Start()
+	defer ts.Close()
+
+	r := newTestRafs(t, m, shared, "1")
+	r.Mountpoint = filepath.Join(shared.HostMountpoint(), r.SnapshotID)
+	require.NoError(t, fs.ForceUmount(ctx, "1"))
+	require.Nil(t, racache.RafsGlobalCache.Get("1"))
+	require.Equal(t, int32(0), shared.GetRef())
+	// The shared nydusd is kept for other instances.
+	require.NotNil(t, m.GetByDaemonID(shared.ID()))
+	_, instances, err := m.ListRecords(ctx)
+	require.NoError(t, err)
+	require.Empty(t, instances)
+
+	// The dedicated nydusd of an instance failing to umount is killed and destroyed.
+	dedicated := newTestDaemon(t, m, config.DaemonModeDedicated)
+	cmd := exec.Command("sleep", "60")
+	require.NoError(t, cmd.Start())
+	dedicated.States.ProcessID = cmd.Process.Pid
+	r = newTestRafs(t, m, dedicated, "2")
+	require.NoError(t, fs.forceDetach(r))
+	require.Equal(t, int32(0), dedicated.GetRef())
+	require.Nil(t, m.GetByDaemonID(dedicated.ID()))
+	require.ErrorIs(t, syscall.Kill(cmd.Process.Pid, 0), syscall.ESRCH)
+	daemons, instances, err := m.ListRecords(ctx)
+	require.NoError(t, err)
+	require.Empty(t, instances)
+	require.Len(t, daemons, 1)
+}
@@ -13,6 +13,8 @@ import (
 	snpkg "github.com/containerd/containerd/v2/pkg/snapshotters"
 	"github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/label"
 )
 
 func (fs *Filesystem) ReferrerDetectEnabled() bool {
@@ -52,9 +54,12 @@ func (fs *Filesystem) TryFetchMetadata(ctx context.Context, labels map[string]st
 		return fmt.Errorf("invalid label %s=%s", snpkg.TargetManifestDigestLabel, manifestDigest)
 	}
 
-	if err := fs.referrerMgr.TryFetchMetadata(ctx, ref, manifestDigest, metadataPath); err != nil {
+	referrer, err := fs.referrerMgr.TryFetchMetadata(ctx, ref, manifestDigest, metadataPath)
+	if err != nil {
 		return errors.Wrap(err, "try fetch metadata")
 	}
+	// Report the selected nydus image in the RAFS instance once it's mounted.
+	labels[label.NydusReferrer] = referrer.Manifest.Digest.String()
 
 	return nil
 }
@@ -18,6 +18,7 @@ import (
 
 	"github.com/KarpelesLab/reflink"
 	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	"github.com/containerd/containerd/v2/pkg/tracing"
 	"github.com/containerd/log"
 	"github.com/containerd/nydus-snapshotter/config"
 	"github.com/containerd/nydus-snapshotter/pkg/auth"
@@ -162,10 +163,18 @@ func (fs *Filesystem) MergeStargzMetaLayer(ctx context.Context, s storage.Snapsh
 // Generate nydus bootstrap from stargz layers
 // Download estargz TOC part from each layer as `nydus-image` conversion source.
 // After conversion, a nydus metadata or bootstrap is used to pointing to each estargz blob
-func (fs *Filesystem) PrepareStargzMetaLayer(blob *stargz.Blob, storagePath string, _ map[string]string) error {
+func (fs *Filesystem) PrepareStargzMetaLayer(ctx context.Context, blob *stargz.Blob, storagePath string, _ map[string]string) (err error) {
 	ref := blob.GetImageReference()
 	layerDigest := blob.GetDigest()
 
+	_, span := tracing.StartSpan(ctx, tracing.Name(spanPrefix, "PrepareStargzMetaLayer"),
+		tracing.WithAttribute("image.ref", ref),
+		tracing.WithAttribute("layer.digest", layerDigest))
+	defer func() {
+		span.SetStatus(err)
+		span.End()
+	}()
+
 	if !fs.StargzEnabled() {
 		return fmt.Errorf("stargz compatibility is not enabled")
 	}
@@ -198,6 +207,7 @@ func (fs *Filesystem) PrepareStargzMetaLayer(blob *stargz.Blob, storagePath stri
 	if err != nil {
 		return errors.Wrap(err, "save stargz index")
 	}
+	span.AddEvent("stargz TOC downloaded")
 	err = os.Chmod(stargzFile, 0440)
 	if err != nil {
 		return err
@@ -50,7 +50,7 @@ func (fs *Filesystem) PrepareTarfsLayer(ctx context.Context, labels map[string]s
 		}
 	}
 
-	if err := fs.tarfsMgr.PrepareLayer(snapshotID, ref, manifestDigest, layerDigest, upperDirPath); err != nil {
+	if err := fs.tarfsMgr.PrepareLayer(ctx, snapshotID, ref, manifestDigest, layerDigest, upperDirPath); err != nil {
 		log.L.WithError(err).Errorf("async prepare tarfs layer of snapshot ID %s", snapshotID)
 	}
 	if limiter != nil {
@@ -48,6 +48,10 @@ const (
 	NydusProxyMode = "containerd.io/snapshot/nydus-proxy-mode"
 	// A bool flag to enable integrity verification of meta data blob
 	NydusSignature = "containerd.io/snapshot/nydus-signature"
+	// A bool flag to make nydusd download all data of the image once it's mounted, e.g. to warm up the image.
+	NydusPrefetchAll = "containerd.io/snapshot/nydus-prefetch-all"
+	// Digest of the referrer manifest selected as the nydus image of an OCI image, set by the snapshotter.
+	NydusReferrer = "containerd.io/snapshot/nydus-referrer"
 
 	// A bool flag to mark the blob as a estargz data blob, set by the snapshotter.
 	StargzLayer = "containerd.io/snapshot/stargz"
@@ -82,6 +86,11 @@ func IsNydusProxyMode(labels map[string]string) bool {
 	return ok
 }
 
+func IsPrefetchAll(labels map[string]string) bool {
+	_, ok := labels[NydusPrefetchAll]
+	return ok
+}
+
 func HasTarfsHint(labels map[string]string) bool {
 	_, ok := labels[TarfsHint]
 	return ok
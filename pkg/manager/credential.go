@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package manager
+
+import (
+	"context"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
+	"github.com/containerd/nydus-snapshotter/pkg/auth"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+	"github.com/containerd/nydus-snapshotter/pkg/utils/registry"
+)
+
+// Refresh the auth a while before it expires, so that nydusd never uses an expired one.
+const credentialRefreshMargin = 2 * time.Minute
+
+// RefreshCredentials periodically re-resolves registry auth of RAFS instances served by
+// running nydusd, and pushes the auth into nydusd if it changes. Auth with a known expiry,
+// e.g. a JWT token, is refreshed before it expires even if that's earlier than `interval`.
+func (m *Manager) RefreshCredentials(ctx context.Context, interval time.Duration) {
+	if m.FsDriver != config.FsDriverFusedev {
+		return
+	}
+
+	// Refresh right away, auth of recovered instances may expire earlier than `interval`.
+	next := m.refreshCredentials(time.Now().Add(interval))
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		next = m.refreshCredentials(time.Now().Add(interval))
+		timer.Reset(time.Until(next))
+	}
+}
+
+// Push the latest auth into all running nydusd, return when to refresh next time.
+func (m *Manager) refreshCredentials(next time.Time) time.Time {
+	keychains := map[string]*auth.PassKeyChain{}
+
+	for _, d := range m.ListDaemons() {
+		if d.State() != types.DaemonStateRunning {
+			continue
+		}
+
+		for _, r := range d.RafsCache.List() {
+			kc, ok := keychains[r.ImageID]
+			if !ok {
+				kc = resolveKeyChain(r.ImageID)
+				keychains[r.ImageID] = kc
+			}
+			if kc == nil {
+				continue
+			}
+
+			if exp, ok := kc.Expiry(); ok {
+				if t := exp.Add(-credentialRefreshMargin); t.Before(next) {
+					next = t
+				}
+			}
+
+			updated, err := d.UpdateAuth(r, kc)
+			if err != nil {
+				log.L.WithError(err).Warnf("failed to refresh auth of instance %s in daemon %s", r.SnapshotID, d.ID())
+				continue
+			}
+			if updated {
+				log.L.Infof("refreshed auth of instance %s in daemon %s", r.SnapshotID, d.ID())
+			}
+		}
+	}
+
+	// Don't retry too eagerly if an expired token is returned again.
+	if earliest := time.Now().Add(credentialRefreshMargin); next.Before(earliest) {
+		next = earliest
+	}
+
+	return next
+}
+
+func resolveKeyChain(imageID string) *auth.PassKeyChain {
+	image, err := registry.ParseImage(imageID)
+	if err != nil {
+		log.L.WithError(err).Warnf("failed to parse image %s", imageID)
+		return nil
+	}
+	// The same registry host as nydusd is configured with by Filesystem.Mount().
+	host := daemonconfig.RegistryHost(image.Host, false)
+	return auth.GetRegistryKeyChain(host, imageID, nil)
+}
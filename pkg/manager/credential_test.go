@@ -0,0 +1,144 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package manager
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	dockerconfig "github.com/docker/cli/cli/config"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+	"github.com/containerd/nydus-snapshotter/pkg/rafs"
+)
+
+type remountRequest struct {
+	mountpoint string
+	config     *daemonconfig.FuseDaemonConfig
+}
+
+// Serve the API of a running nydusd recording remount requests.
+func serveRemount(t *testing.T, sock string) func() []remountRequest {
+	var mu sync.Mutex
+	var remounts []remountRequest
+	newFakeNydusd(t, sock, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/mount" && r.Method == http.MethodPut:
+			var req types.MountRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			var c daemonconfig.FuseDaemonConfig
+			require.NoError(t, json.Unmarshal([]byte(req.Config), &c))
+			mu.Lock()
+			remounts = append(remounts, remountRequest{mountpoint: r.URL.Query().Get("mountpoint"), config: &c})
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	return func() []remountRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]remountRequest{}, remounts...)
+	}
+}
+
+func TestRefreshCredentials(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, config.ProcessConfigurations(&config.SnapshotterConfig{
+		Root:         root,
+		DaemonMode:   string(config.DaemonModeShared),
+		DaemonConfig: config.DaemonConfig{FsDriver: config.FsDriverFusedev},
+	}))
+
+	// Auth of the registry is a JWT.
+	dockerConfigDir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dockerConfigDir)
+	dockerconfig.SetDir(dockerConfigDir)
+	setAuth := func(exp time.Time) string {
+		jwt := "e30." + base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, exp.Unix()))) + ".sig"
+		auth := base64.StdEncoding.EncodeToString([]byte("user:" + jwt))
+		c := fmt.Sprintf(`{"auths":{"registry.example.com":{"auth":%q}}}`, auth)
+		require.NoError(t, os.WriteFile(filepath.Join(dockerConfigDir, "config.json"), []byte(c), 0600))
+		return auth
+	}
+
+	d, err := daemon.NewDaemon(
+		daemon.WithDaemonMode(config.DaemonModeShared),
+		daemon.WithFsDriver(config.FsDriverFusedev),
+		daemon.WithConfigDir(filepath.Join(root, "config")),
+	)
+	require.NoError(t, err)
+	d.States.APISocket = filepath.Join(root, "api.sock")
+	remounts := serveRemount(t, d.States.APISocket)
+	_, err = d.GetState()
+	require.NoError(t, err)
+
+	r := &rafs.Rafs{
+		SnapshotID:  "1",
+		ImageID:     "registry.example.com/library/nginx:latest",
+		SnapshotDir: filepath.Join(root, "snapshots", "1"),
+		Annotations: map[string]string{},
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(r.SnapshotDir, "fs", "image"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(r.SnapshotDir, "fs", "image", "image.boot"), nil, 0644))
+	d.AddRafsInstance(r)
+	c := &daemonconfig.FuseDaemonConfig{Device: &daemonconfig.DeviceConfig{}}
+	c.Device.Backend.BackendType = "registry"
+	c.Device.Backend.Config.Host = "registry.example.com"
+	c.Device.Backend.Config.Auth = "expired"
+	require.NoError(t, c.DumpFile(d.ConfigFile(r.SnapshotID)))
+
+	m := &Manager{FsDriver: config.FsDriverFusedev, daemonCache: newDaemonCache()}
+	m.daemonCache.Add(d)
+
+	// The new auth is pushed into nydusd, and refreshed again before it expires.
+	now := time.Now()
+	auth := setAuth(now.Add(10 * time.Minute))
+	next := m.refreshCredentials(now.Add(time.Hour))
+	require.WithinDuration(t, now.Add(10*time.Minute-credentialRefreshMargin), next, 5*time.Second)
+	require.Len(t, remounts(), 1)
+	require.Equal(t, "/1", remounts()[0].mountpoint)
+	require.Equal(t, auth, remounts()[0].config.Device.Backend.Config.Auth)
+	saved, err := daemonconfig.LoadFuseConfig(d.ConfigFile(r.SnapshotID))
+	require.NoError(t, err)
+	require.Equal(t, auth, saved.Device.Backend.Config.Auth)
+
+	// Nydusd is left alone if the auth doesn't change.
+	next = m.refreshCredentials(now.Add(time.Hour))
+	require.WithinDuration(t, now.Add(10*time.Minute-credentialRefreshMargin), next, 5*time.Second)
+	require.Len(t, remounts(), 1)
+
+	// Don't refresh too eagerly if the auth is about to expire.
+	setAuth(now.Add(time.Minute))
+	next = m.refreshCredentials(now.Add(time.Hour))
+	require.WithinDuration(t, time.Now().Add(credentialRefreshMargin), next, 5*time.Second)
+	require.Len(t, remounts(), 2)
+
+	// Refresh right away once started.
+	auth = setAuth(now.Add(time.Hour))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.RefreshCredentials(ctx, time.Hour)
+	require.Eventually(t, func() bool {
+		return len(remounts()) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, auth, remounts()[2].config.Device.Backend.Config.Auth)
+}
@@ -23,7 +23,6 @@ import (
 	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
 	"github.com/containerd/nydus-snapshotter/pkg/metrics/collector"
 	metrics "github.com/containerd/nydus-snapshotter/pkg/metrics/tool"
-	"github.com/containerd/nydus-snapshotter/pkg/prefetch"
 )
 
 const endpointGetBackend string = "/api/v1/daemons/%s/backend"
@@ -103,7 +102,7 @@ func (m *Manager) StartDaemon(d *daemon.Daemon) error {
 		collector.NewDaemonEventCollector(types.DaemonStateRunning).Collect()
 
 		if m.CgroupMgr != nil {
-			if err := m.CgroupMgr.AddProc(d.States.ProcessID); err != nil {
+			if err := m.CgroupMgr.AddProc(d.ID(), d.States.ProcessID); err != nil {
 				log.L.WithError(err).Errorf("add daemon %s to cgroup failed", d.ID())
 				return
 			}
@@ -122,7 +121,7 @@ func (m *Manager) StartDaemon(d *daemon.Daemon) error {
 // Build commandline according to nydusd daemon configuration.
 func (m *Manager) BuildDaemonCommand(d *daemon.Daemon, bin string, upgrade bool) (*exec.Cmd, error) {
 	var cmdOpts []command.Opt
-	var imageReference string
+	var prefetchFiles string
 
 	nydusdThreadNum := d.NydusdThreadNum()
 
@@ -148,7 +147,8 @@ func (m *Manager) BuildDaemonCommand(d *daemon.Daemon, bin string, upgrade bool)
 				return nil, errors.Wrapf(errdefs.ErrNotFound, "daemon %s no rafs instance associated", d.ID())
 			}
 
-			imageReference = rafs.ImageID
+			// Prefetch list of the image is recorded on the instance when it is mounted.
+			prefetchFiles = rafs.PrefetchFiles()
 
 			bootstrap, err := rafs.BootstrapFile()
 			if err != nil {
@@ -176,12 +176,8 @@ func (m *Manager) BuildDaemonCommand(d *daemon.Daemon, bin string, upgrade bool)
 			command.WithID(d.ID()))
 	}
 
-	if imageReference != "" {
-		prefetchfiles := prefetch.Pm.GetPrefetchInfo(imageReference)
-		if prefetchfiles != "" {
-			cmdOpts = append(cmdOpts, command.WithPrefetchFiles(prefetchfiles))
-			prefetch.Pm.DeleteFromPrefetchMap(imageReference)
-		}
+	if prefetchFiles != "" {
+		cmdOpts = append(cmdOpts, command.WithPrefetchFiles(prefetchFiles))
 	}
 
 	cmdOpts = append(cmdOpts,
@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"path"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -19,6 +20,8 @@ import (
 	"github.com/containerd/nydus-snapshotter/config"
 	"github.com/containerd/nydus-snapshotter/pkg/daemon"
 	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/events"
 	"github.com/containerd/nydus-snapshotter/pkg/metrics/collector"
 	"github.com/pkg/errors"
 )
@@ -55,6 +58,9 @@ func (m *Manager) handleDaemonDeathEvent() {
 		collector.NewDaemonInfoCollector(&d.Version, -1).Collect()
 		d.Unlock()
 
+		events.Publish(events.TopicDaemonDied, newDaemonEvent(d,
+			fmt.Sprintf("recover policy %s", m.RecoverPolicy)))
+
 		d.ResetState()
 
 		if m.RecoverPolicy == config.RecoverPolicyRestart {
@@ -77,33 +83,49 @@ func (m *Manager) doDaemonFailover(d *daemon.Daemon) {
 		log.L.Warnf("fail to unsubscribe daemon %s, %v", d.ID(), err)
 	}
 
+	if err := m.FailoverDaemon(d); err != nil {
+		log.L.WithError(err).Errorf("fail to failover daemon %s", d.ID())
+		m.failInstances(d, err)
+		events.Publish(events.TopicDaemonRecoverFailed, newDaemonEvent(d, err.Error()))
+		return
+	}
+
+	// Make sure every RAFS instance is served by the new nydusd.
+	m.recoverFailoverInstances(d)
+
+	events.Publish(events.TopicDaemonFailedOver, newDaemonEvent(d, ""))
+}
+
+// FailoverDaemon starts a new nydusd to take over the service of a dead daemon without
+// unmounting, by the states and file descriptor kept by its supervisor.
+func (m *Manager) FailoverDaemon(d *daemon.Daemon) error {
 	su := m.SupervisorSet.GetSupervisor(d.ID())
+	if su == nil {
+		return errors.Wrapf(errdefs.ErrNotFound, "supervisor of daemon %s", d.ID())
+	}
 	if err := su.SendStatesTimeout(time.Second * 10); err != nil {
-		log.L.Errorf("Send states error, %s", err)
-		return
+		return errors.Wrap(err, "send states")
 	}
 
 	// Failover nydusd still depends on the old supervisor
 
 	if err := m.StartDaemon(d); err != nil {
-		log.L.Errorf("fail to start daemon %s when recovering", d.ID())
-		return
+		return errors.Wrap(err, "start daemon")
 	}
 
 	if err := d.WaitUntilState(types.DaemonStateInit); err != nil {
-		log.L.WithError(err).Errorf("daemon didn't reach state %s,", types.DaemonStateInit)
-		return
+		return errors.Wrapf(err, "wait for state %s", types.DaemonStateInit)
 	}
 
 	if err := d.TakeOver(); err != nil {
-		log.L.Errorf("fail to takeover, %s", err)
-		return
+		return errors.Wrap(err, "takeover")
 	}
 
 	if err := d.Start(); err != nil {
-		log.L.Errorf("fail to start service, %s", err)
-		return
+		return errors.Wrap(err, "start service")
 	}
+
+	return nil
 }
 
 func (m *Manager) doDaemonRestart(d *daemon.Daemon) {
@@ -119,21 +141,36 @@ func (m *Manager) doDaemonRestart(d *daemon.Daemon) {
 	d.ClearVestige()
 	if err := m.StartDaemon(d); err != nil {
 		log.L.Errorf("fails to start daemon %s when recovering", d.ID())
+		events.Publish(events.TopicDaemonRecoverFailed, newDaemonEvent(d, err.Error()))
 		return
 	}
 
 	// Mount rafs instance by http API
-	instances := d.RafsCache.List()
-	for _, r := range instances {
-		// For dedicated nydusd daemon, Rafs has already been mounted during starting nydusd
-		if d.HostMountpoint() == r.GetMountpoint() {
-			break
-		}
+	m.recoverSharedInstances(d)
 
-		if err := d.SharedMount(r); err != nil {
-			log.L.Warnf("Failed to mount rafs instance, %v", err)
+	events.Publish(events.TopicDaemonRestarted, newDaemonEvent(d, ""))
+}
+
+// Describe the daemon and the RAFS instances it serves in an event.
+func newDaemonEvent(d *daemon.Daemon, message string) *events.DaemonEvent {
+	ev := &events.DaemonEvent{
+		DaemonID: d.ID(),
+		FsDriver: d.States.FsDriver,
+		Message:  message,
+	}
+
+	images := map[string]struct{}{}
+	for _, r := range d.RafsCache.List() {
+		ev.Snapshots = append(ev.Snapshots, r.SnapshotID)
+		if _, ok := images[r.ImageID]; !ok {
+			images[r.ImageID] = struct{}{}
+			ev.Images = append(ev.Images, r.ImageID)
 		}
 	}
+	sort.Strings(ev.Snapshots)
+	sort.Strings(ev.Images)
+
+	return ev
 }
 
 // Provide minimal parameters since most of it can be recovered by nydusd states.
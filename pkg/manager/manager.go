@@ -150,6 +150,32 @@ func (m *Manager) RemoveRafsInstance(snapshotID string) error {
 	return m.store.DeleteRafsInstance(snapshotID)
 }
 
+// ListRecords returns the daemons and RAFS instances of the manager's filesystem driver
+// persisted in database, which may be different from the cached ones if something goes wrong.
+func (m *Manager) ListRecords(ctx context.Context) ([]*daemon.ConfigState, []*rafs.Rafs, error) {
+	daemons := []*daemon.ConfigState{}
+	if err := m.store.WalkDaemons(ctx, func(s *daemon.ConfigState) error {
+		if s.FsDriver == m.FsDriver {
+			daemons = append(daemons, s)
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, errors.Wrapf(err, "walk daemons")
+	}
+
+	instances := []*rafs.Rafs{}
+	if err := m.store.WalkRafsInstances(ctx, func(r *rafs.Rafs) error {
+		if r.GetFsDriver() == m.FsDriver {
+			instances = append(instances, r)
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, errors.Wrapf(err, "walk RAFS instances")
+	}
+
+	return daemons, instances, nil
+}
+
 func (m *Manager) recoverRafsInstances(ctx context.Context,
 	recoveringDaemons *map[string]*daemon.Daemon, liveDaemons *map[string]*daemon.Daemon) error {
 	if err := m.store.WalkRafsInstances(ctx, func(r *rafs.Rafs) error {
@@ -274,6 +300,12 @@ func (m *Manager) DestroyDaemon(d *daemon.Daemon) error {
 		log.L.Warnf("Failed to wait for daemon, %v", err)
 	}
 
+	if m.CgroupMgr != nil {
+		if err := m.CgroupMgr.DeleteDaemon(d.ID()); err != nil {
+			log.L.WithError(err).Warnf("Failed to delete cgroup of daemon %s", d.ID())
+		}
+	}
+
 	collector.NewDaemonEventCollector(types.DaemonStateDestroyed).Collect()
 	d.Lock()
 	collector.NewDaemonInfoCollector(&d.Version, -1).Collect()
@@ -349,7 +381,7 @@ func (m *Manager) recoverDaemons(ctx context.Context,
 		(*liveDaemons)[d.ID()] = d
 
 		if m.CgroupMgr != nil {
-			if err := m.CgroupMgr.AddProc(d.States.ProcessID); err != nil {
+			if err := m.CgroupMgr.AddProc(d.ID(), d.States.ProcessID); err != nil {
 				return errors.Wrapf(err, "add daemon %s to cgroup failed", d.ID())
 			}
 		}
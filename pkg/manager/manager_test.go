@@ -0,0 +1,37 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package manager
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+)
+
+// Serve the API of a running nydusd on the socket, requests other than getting the
+// daemon information are passed to the handler.
+func newFakeNydusd(t *testing.T, sock string, handler http.HandlerFunc) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/daemon" && r.Method == http.MethodGet {
+			w.Header().Set("Content-Type", "application/json")
+			require.NoError(t, json.NewEncoder(w).Encode(types.DaemonInfo{ID: "nydusd", State: types.DaemonStateRunning}))
+			return
+		}
+		handler(w, r)
+	}))
+	listener, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	ts.Listener = listener
+	ts.Start()
+	t.Cleanup(ts.Close)
+}
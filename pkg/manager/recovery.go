@@ -0,0 +1,199 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package manager
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/containerd/nydus-snapshotter/config"
+
+	"github.com/containerd/nydus-snapshotter/pkg/daemon"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/metrics/collector"
+	"github.com/containerd/nydus-snapshotter/pkg/rafs"
+	"github.com/containerd/nydus-snapshotter/pkg/utils/retry"
+)
+
+var (
+	// Retry to recover a RAFS instance with exponential backoff, it takes about 1 minute
+	// before giving up.
+	RecoveryAttempts = uint(8)
+	RecoveryDelay    = 500 * time.Millisecond
+	RecoveryMaxDelay = 16 * time.Second
+	// Instances of a daemon are recovered concurrently, and those not recovered in time
+	// are given up, so that the recovery of a daemon takes bounded time.
+	RecoveryConcurrency = 8
+	RecoveryTimeout     = 2 * time.Minute
+)
+
+// Re-mount RAFS instances to a restarted shared daemon. For fscache, each instance has to
+// re-bind its blobs to the daemon. Every instance is recovered on its own, so failing
+// to recover an instance does not prevent others from being recovered. The recovery
+// state of each instance is persisted and exposed by the system controller.
+func (m *Manager) recoverSharedInstances(d *daemon.Daemon) {
+	if err := d.WaitUntilState(types.DaemonStateRunning); err != nil {
+		// Let retries below tell if the daemon is really broken.
+		log.L.WithError(err).Warnf("daemon %s is not running before recovering RAFS instances", d.ID())
+	}
+
+	m.recoverInstances(d, sharedInstances(d), d.SharedMount)
+}
+
+// Check RAFS instances of a failed over FUSE daemon. The new nydusd restores instances
+// from the states kept by its supervisor, and shared instances missing from it, e.g. those
+// mounted after the states are sent, are re-mounted.
+func (m *Manager) recoverFailoverInstances(d *daemon.Daemon) {
+	if d.States.FsDriver != config.FsDriverFusedev {
+		return
+	}
+
+	m.recoverInstances(d, cachedInstances(d), func(r *rafs.Rafs) error {
+		// For dedicated nydusd daemon, Rafs is mounted at the FUSE mountpoint.
+		dedicated := d.HostMountpoint() == r.GetMountpoint()
+		var id string
+		if !dedicated {
+			id = r.SnapshotID
+		}
+		_, err := d.GetFsMetrics(id)
+		if err == nil || dedicated {
+			return err
+		}
+		log.L.WithError(err).Warnf("RAFS instance %s is not taken over by daemon %s, re-mount it", r.SnapshotID, d.ID())
+		return d.SharedMount(r)
+	})
+}
+
+// Mark RAFS instances of a daemon which fails to be recovered.
+func (m *Manager) failInstances(d *daemon.Daemon, err error) {
+	for _, r := range cachedInstances(d) {
+		m.updateRecoveryState(d, r, rafs.RecoveryStateFailed, 0, err)
+	}
+}
+
+// Get the cached instances of the daemon rather than copies of them in the order they
+// are mounted, so that recovery states are updated to the instances in cache.
+func cachedInstances(d *daemon.Daemon) []*rafs.Rafs {
+	d.RafsCache.Lock()
+	instances := make([]*rafs.Rafs, 0, len(d.RafsCache.ListLocked()))
+	for _, r := range d.RafsCache.ListLocked() {
+		instances = append(instances, r)
+	}
+	d.RafsCache.Unlock()
+
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].Seq < instances[j].Seq
+	})
+	return instances
+}
+
+func sharedInstances(d *daemon.Daemon) []*rafs.Rafs {
+	var instances []*rafs.Rafs
+	for _, r := range cachedInstances(d) {
+		// For dedicated nydusd daemon, Rafs has already been mounted during starting nydusd
+		if d.HostMountpoint() != r.GetMountpoint() {
+			instances = append(instances, r)
+		}
+	}
+	return instances
+}
+
+func (m *Manager) recoverInstances(d *daemon.Daemon, instances []*rafs.Rafs, mount func(*rafs.Rafs) error) {
+	deadline := time.Now().Add(RecoveryTimeout)
+	var failed atomic.Int32
+	var g errgroup.Group
+	g.SetLimit(RecoveryConcurrency)
+	for _, r := range instances {
+		g.Go(func() error {
+			if err := m.recoverRafsInstance(d, r, deadline, mount); err != nil {
+				log.L.WithError(err).Errorf("failed to recover RAFS instance %s of daemon %s", r.SnapshotID, d.ID())
+				failed.Add(1)
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	log.L.Infof("Recovered %d RAFS instances of daemon %s, %d failed",
+		len(instances)-int(failed.Load()), d.ID(), failed.Load())
+}
+
+func (m *Manager) recoverRafsInstance(d *daemon.Daemon, r *rafs.Rafs, deadline time.Time, mount func(*rafs.Rafs) error) error {
+	var attempts uint
+	m.updateRecoveryState(d, r, rafs.RecoveryStateRecovering, attempts, nil)
+
+	var lastErr error
+	err := retry.Do(func() error {
+		if !isCachedInstance(d, r) {
+			return retry.Unrecoverable(errors.Wrapf(errdefs.ErrNotFound, "instance %s was removed", r.SnapshotID))
+		}
+		if attempts > 0 && time.Now().After(deadline) {
+			return retry.Unrecoverable(errors.Wrap(lastErr, "recovery timed out"))
+		}
+		attempts++
+		lastErr = mount(r)
+		return lastErr
+	},
+		retry.Attempts(RecoveryAttempts),
+		retry.Delay(RecoveryDelay),
+		retry.MaxDelay(RecoveryMaxDelay),
+		retry.DelayType(retry.BackOffDelay),
+		retry.LastErrorOnly(true),
+		retry.OnRetry(func(n uint, err error) {
+			log.L.WithError(err).Warnf("failed to recover RAFS instance %s, attempt %d", r.SnapshotID, n+1)
+			m.updateRecoveryState(d, r, rafs.RecoveryStateRecovering, attempts, err)
+		}),
+	)
+	if err != nil {
+		m.updateRecoveryState(d, r, rafs.RecoveryStateFailed, attempts, err)
+		return errors.Wrapf(err, "recover instance %s after %d attempts", r.SnapshotID, attempts)
+	}
+
+	m.updateRecoveryState(d, r, rafs.RecoveryStateRecovered, attempts, nil)
+	return nil
+}
+
+func (m *Manager) updateRecoveryState(d *daemon.Daemon, r *rafs.Rafs, state string, attempts uint, lastErr error) {
+	s := &rafs.RecoveryState{
+		State:     state,
+		Attempts:  attempts,
+		UpdatedAt: time.Now(),
+	}
+	if lastErr != nil {
+		s.LastError = lastErr.Error()
+	}
+
+	d.RafsCache.Lock()
+	// Never bring back the record of an instance umounted during recovery.
+	if d.RafsCache.ListLocked()[r.SnapshotID] != r {
+		d.RafsCache.Unlock()
+		return
+	}
+	r.SetRecovery(s)
+	err := m.store.UpdateRafsInstance(r)
+	d.RafsCache.Unlock()
+	if err != nil {
+		log.L.WithError(err).Warnf("failed to persist recovery state of RAFS instance %s", r.SnapshotID)
+	}
+
+	// Only count outcomes rather than every attempt.
+	if state != rafs.RecoveryStateRecovering || attempts == 0 {
+		collector.NewRafsRecoveryEventCollector(d.ID(), state).Collect()
+	}
+}
+
+func isCachedInstance(d *daemon.Daemon, r *rafs.Rafs) bool {
+	d.RafsCache.Lock()
+	defer d.RafsCache.Unlock()
+	return d.RafsCache.ListLocked()[r.SnapshotID] == r
+}
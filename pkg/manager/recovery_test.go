@@ -0,0 +1,243 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mohae/deepcopy"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon"
+	"github.com/containerd/nydus-snapshotter/pkg/rafs"
+	"github.com/containerd/nydus-snapshotter/pkg/store"
+)
+
+func newRecoveryManager(t *testing.T) *Manager {
+	db, err := store.NewDatabase(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	s, err := store.NewDaemonRafsStore(db)
+	require.NoError(t, err)
+	return &Manager{store: s}
+}
+
+func recoveryStates(t *testing.T, m *Manager) map[string]*rafs.RecoveryState {
+	states := map[string]*rafs.RecoveryState{}
+	err := m.store.WalkRafsInstances(context.Background(), func(r *rafs.Rafs) error {
+		states[r.SnapshotID] = r.Recovery
+		return nil
+	})
+	require.NoError(t, err)
+	return states
+}
+
+func TestRecoverRafsInstance(t *testing.T) {
+	RecoveryAttempts = 3
+	RecoveryDelay = time.Millisecond
+
+	m := newRecoveryManager(t)
+	d, err := daemon.NewDaemon()
+	require.NoError(t, err)
+	r1 := &rafs.Rafs{SnapshotID: "1", Annotations: map[string]string{}}
+	r2 := &rafs.Rafs{SnapshotID: "2", Annotations: map[string]string{}}
+	for _, r := range []*rafs.Rafs{r1, r2} {
+		d.AddRafsInstance(r)
+		require.NoError(t, m.AddRafsInstance(r))
+	}
+
+	// Recovered after a failure.
+	deadline := time.Now().Add(time.Minute)
+	var mounts int
+	err = m.recoverRafsInstance(d, r1, deadline, func(*rafs.Rafs) error {
+		mounts++
+		if mounts < 2 {
+			return errors.New("bind blob")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Never recovered, which does not affect other instances.
+	err = m.recoverRafsInstance(d, r2, deadline, func(*rafs.Rafs) error {
+		return errors.New("bind blob")
+	})
+	require.Error(t, err)
+
+	states := recoveryStates(t, m)
+	require.Equal(t, rafs.RecoveryStateRecovered, states["1"].State)
+	require.Equal(t, uint(2), states["1"].Attempts)
+	require.Empty(t, states["1"].LastError)
+	require.Equal(t, rafs.RecoveryStateFailed, states["2"].State)
+	require.Equal(t, uint(3), states["2"].Attempts)
+	require.Equal(t, "bind blob", states["2"].LastError)
+	require.Equal(t, rafs.RecoveryStateFailed, d.RafsCache.Get("2").Recovery.State)
+
+	// An instance umounted during recovery is not brought back.
+	d.RemoveRafsInstance("1")
+	require.NoError(t, m.RemoveRafsInstance("1"))
+	err = m.recoverRafsInstance(d, r1, deadline, func(*rafs.Rafs) error { return nil })
+	require.Error(t, err)
+	err = m.store.WalkRafsInstances(context.Background(), func(r *rafs.Rafs) error {
+		require.NotEqual(t, "1", r.SnapshotID)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestRecoverInstances(t *testing.T) {
+	RecoveryAttempts = 3
+	RecoveryDelay = time.Millisecond
+	RecoveryConcurrency = 2
+	defer func() { RecoveryConcurrency = 8 }()
+
+	m := newRecoveryManager(t)
+	d, err := daemon.NewDaemon()
+	require.NoError(t, err)
+	var instances []*rafs.Rafs
+	for _, id := range []string{"1", "2", "3", "4"} {
+		r := &rafs.Rafs{SnapshotID: id, Annotations: map[string]string{}}
+		d.AddRafsInstance(r)
+		require.NoError(t, m.AddRafsInstance(r))
+		instances = append(instances, r)
+	}
+
+	// Instances are mounted concurrently, each mount waits for another one.
+	var mu sync.Mutex
+	var running, maxRunning int
+	barrier := make(chan struct{})
+	m.recoverInstances(d, instances, func(*rafs.Rafs) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+
+		select {
+		case barrier <- struct{}{}:
+		case <-barrier:
+		case <-time.After(5 * time.Second):
+			return errors.New("mounted sequentially")
+		}
+		return nil
+	})
+	require.Equal(t, 2, maxRunning)
+	for _, s := range recoveryStates(t, m) {
+		require.Equal(t, rafs.RecoveryStateRecovered, s.State)
+	}
+
+	// Give up once it's timed out.
+	var mounts int
+	err = m.recoverRafsInstance(d, instances[0], time.Now(), func(*rafs.Rafs) error {
+		mounts++
+		return errors.New("bind blob")
+	})
+	require.ErrorContains(t, err, "timed out")
+	require.Equal(t, 1, mounts)
+	require.Equal(t, rafs.RecoveryStateFailed, recoveryStates(t, m)["1"].State)
+}
+
+func TestRecoverFailoverInstances(t *testing.T) {
+	RecoveryAttempts = 3
+	RecoveryDelay = time.Millisecond
+
+	m := newRecoveryManager(t)
+	d, err := daemon.NewDaemon(
+		daemon.WithFsDriver(config.FsDriverFusedev),
+		daemon.WithDaemonMode(config.DaemonModeShared),
+		daemon.WithConfigDir(t.TempDir()),
+		daemon.WithMountpoint("/mnt"),
+	)
+	require.NoError(t, err)
+	d.States.APISocket = filepath.Join(t.TempDir(), "api.sock")
+
+	// Instance 1 is taken over by the new nydusd, but instance 2 is not.
+	var mu sync.Mutex
+	served := map[string]bool{"/1": true}
+	newFakeNydusd(t, d.States.APISocket, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/api/v1/metrics" && served[r.URL.Query().Get("id")]:
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte("{}"))
+		case r.URL.Path == "/api/v1/mount" && r.Method == http.MethodPost:
+			served[r.URL.Query().Get("mountpoint")] = true
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"code": "NotFound", "message": "no such instance"}`))
+		}
+	})
+
+	for _, id := range []string{"1", "2"} {
+		r := &rafs.Rafs{SnapshotID: id, SnapshotDir: t.TempDir(), Mountpoint: filepath.Join("/mnt", id),
+			Annotations: map[string]string{}}
+		bootstrap := filepath.Join(r.SnapshotDir, "fs", "image", "image.boot")
+		require.NoError(t, os.MkdirAll(filepath.Dir(bootstrap), 0755))
+		require.NoError(t, os.WriteFile(bootstrap, nil, 0644))
+		require.NoError(t, os.MkdirAll(filepath.Dir(d.ConfigFile(id)), 0755))
+		require.NoError(t, os.WriteFile(d.ConfigFile(id), []byte(`{"device": {}}`), 0644))
+		d.AddRafsInstance(r)
+		require.NoError(t, m.AddRafsInstance(r))
+	}
+
+	m.recoverFailoverInstances(d)
+	states := recoveryStates(t, m)
+	require.Equal(t, rafs.RecoveryStateRecovered, states["1"].State)
+	require.Equal(t, rafs.RecoveryStateRecovered, states["2"].State)
+	require.True(t, served["/2"])
+
+	// All instances fail if the daemon fails to be failed over.
+	m.failInstances(d, errors.New("takeover"))
+	for _, s := range recoveryStates(t, m) {
+		require.Equal(t, rafs.RecoveryStateFailed, s.State)
+		require.Equal(t, "takeover", s.LastError)
+	}
+}
+
+func TestRecoveryStateRace(t *testing.T) {
+	m := newRecoveryManager(t)
+	d, err := daemon.NewDaemon()
+	require.NoError(t, err)
+	r := &rafs.Rafs{SnapshotID: "1", Annotations: map[string]string{}}
+	d.AddRafsInstance(r)
+	require.NoError(t, m.AddRafsInstance(r))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			m.updateRecoveryState(d, r, rafs.RecoveryStateRecovering, uint(i), nil)
+		}
+	}()
+	// Instances are read while being recovered, e.g. by the system controller.
+	for i := 0; i < 100; i++ {
+		_ = r.GetRecovery()
+		_, err := json.Marshal(r)
+		require.NoError(t, err)
+		c := deepcopy.Copy(map[string]*rafs.Rafs{"1": r}).(map[string]*rafs.Rafs)
+		require.NotSame(t, r, c["1"])
+	}
+	<-done
+}
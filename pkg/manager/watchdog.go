@@ -0,0 +1,296 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package manager
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+	"github.com/containerd/nydus-snapshotter/pkg/events"
+	"github.com/containerd/nydus-snapshotter/pkg/metrics/collector"
+)
+
+const (
+	// Keep this many latest diagnostics bundles for each daemon.
+	maxDiagnosticsBundles = 10
+	// Only the tail of the nydusd log is saved in diagnostics bundles.
+	maxDiagnosticsLogSize = 16 << 20
+)
+
+type HungIOWatchdogOpt struct {
+	// How often inflight requests are checked.
+	Interval time.Duration
+	// How long a request has been pending before it's regarded as hung.
+	Threshold time.Duration
+	// One of config.HungIOAction*, each action includes the previous ones.
+	Action string
+	// Where diagnostics bundles are saved.
+	DumpDir string
+}
+
+// WatchHungIO periodically checks inflight FUSE requests of running nydusd, and takes
+// the action on nydusd having requests pending longer than the threshold. The action
+// is taken once for the same hung requests, and again only if more requests are hung.
+func (m *Manager) WatchHungIO(ctx context.Context, opt HungIOWatchdogOpt) {
+	// Only nydusd serving FUSE exports inflight requests.
+	if m.FsDriver != config.FsDriverFusedev {
+		return
+	}
+
+	// Unique IDs of hung requests which have been acted on, per daemon.
+	acted := map[string]map[uint64]struct{}{}
+
+	ticker := time.NewTicker(opt.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		m.checkHungIO(opt, acted, time.Now())
+	}
+}
+
+// Daemons are checked concurrently, so that a daemon whose API hangs as well doesn't
+// hold up acting on the others until its requests time out.
+func (m *Manager) checkHungIO(opt HungIOWatchdogOpt, acted map[string]map[uint64]struct{}, now time.Time) {
+	daemons := map[string]struct{}{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, d := range m.ListDaemons() {
+		daemons[d.ID()] = struct{}{}
+		// Requests resent to the restarted or failed over nydusd are not acted on again.
+		if d.State() != types.DaemonStateRunning {
+			continue
+		}
+
+		wg.Add(1)
+		go func(d *daemon.Daemon) {
+			defer wg.Done()
+
+			inflight, err := d.GetInflightMetrics()
+			if err != nil {
+				log.L.WithError(err).Warnf("failed to get inflight metrics of daemon %s", d.ID())
+				return
+			}
+
+			hung, longest := hungRequests(inflight, opt.Threshold, now)
+			fresh := false
+			mu.Lock()
+			for unique := range hung {
+				if _, ok := acted[d.ID()][unique]; !ok {
+					fresh = true
+					break
+				}
+			}
+			// Forget requests which are no longer hung.
+			acted[d.ID()] = hung
+			mu.Unlock()
+			if fresh {
+				m.handleHungIO(d, opt, inflight, len(hung), longest, now)
+			}
+		}(d)
+	}
+	wg.Wait()
+
+	for id := range acted {
+		if _, ok := daemons[id]; !ok {
+			delete(acted, id)
+		}
+	}
+}
+
+// Return unique IDs of requests pending for `threshold` or longer, and how long the
+// longest one has been pending.
+func hungRequests(inflight *types.InflightMetrics, threshold time.Duration, now time.Time) (map[uint64]struct{}, time.Duration) {
+	hung := map[uint64]struct{}{}
+	var longest time.Duration
+
+	for _, v := range inflight.Values {
+		elapsed := now.Sub(time.Unix(int64(v.TimestampSecs), 0))
+		if elapsed < threshold {
+			continue
+		}
+		hung[v.Unique] = struct{}{}
+		if elapsed > longest {
+			longest = elapsed
+		}
+		log.L.Debugf("hung request, inode %d, opcode %d, unique %d, elapsed %s", v.Inode, v.Opcode, v.Unique, elapsed)
+	}
+
+	return hung, longest
+}
+
+func (m *Manager) handleHungIO(d *daemon.Daemon, opt HungIOWatchdogOpt, inflight *types.InflightMetrics,
+	hung int, longest time.Duration, now time.Time) {
+	message := fmt.Sprintf("%d requests are pending for more than %s, the longest for %s",
+		hung, opt.Threshold, longest.Truncate(time.Second))
+	log.L.Warnf("daemon %s has hung IO: %s", d.ID(), message)
+
+	events.Publish(events.TopicHungIO, newDaemonEvent(d, message))
+	collector.NewHungIOActionCollector(d.ID(), config.HungIOActionEvent).Collect()
+	if opt.Action == config.HungIOActionEvent {
+		return
+	}
+
+	bundle, err := dumpDiagnostics(d, opt.DumpDir, inflight, now)
+	if err != nil {
+		log.L.WithError(err).Warnf("failed to dump diagnostics of daemon %s", d.ID())
+	} else {
+		log.L.Infof("dumped diagnostics of daemon %s to %s", d.ID(), bundle)
+		collector.NewHungIOActionCollector(d.ID(), config.HungIOActionDump).Collect()
+	}
+	if opt.Action == config.HungIOActionDump {
+		return
+	}
+
+	if m.RecoverPolicy != config.RecoverPolicyRestart && m.RecoverPolicy != config.RecoverPolicyFailover {
+		log.L.Warnf("daemon %s with hung IO is not recovered by recover policy %s", d.ID(), m.RecoverPolicy)
+		return
+	}
+	// The death of nydusd is caught by the liveness monitor, which restarts or fails
+	// over nydusd by the recover policy.
+	if err := d.Kill(); err != nil {
+		log.L.WithError(err).Errorf("failed to kill daemon %s with hung IO", d.ID())
+		return
+	}
+	log.L.Warnf("killed daemon %s with hung IO to %s it", d.ID(), m.RecoverPolicy)
+	collector.NewHungIOActionCollector(d.ID(), config.HungIOActionRecover).Collect()
+}
+
+// Save states, inflight requests, RAFS instances and logs of nydusd in a tar.gz bundle
+// in `dir`, and return the path of the bundle.
+func dumpDiagnostics(d *daemon.Daemon, dir string, inflight *types.InflightMetrics, now time.Time) (_ string, retErr error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", errors.Wrapf(err, "create diagnostics dir %s", dir)
+	}
+
+	bundle := filepath.Join(dir, fmt.Sprintf("%s-%s.tar.gz", d.ID(), now.UTC().Format("20060102T150405Z")))
+	f, err := os.OpenFile(bundle, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", errors.Wrapf(err, "create diagnostics bundle %s", bundle)
+	}
+	defer func() {
+		if retErr != nil {
+			os.Remove(bundle)
+		}
+	}()
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	add := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: now}); err != nil {
+			return errors.Wrapf(err, "add %s to diagnostics bundle", name)
+		}
+		if _, err := tw.Write(data); err != nil {
+			return errors.Wrapf(err, "add %s to diagnostics bundle", name)
+		}
+		return nil
+	}
+
+	// The daemon information is only saved if nydusd still answers.
+	info, err := d.GetDaemonInfo()
+	if err != nil {
+		log.L.WithError(err).Warnf("failed to get information of daemon %s", d.ID())
+	}
+	for name, v := range map[string]interface{}{
+		"states.json":    d.States,
+		"info.json":      info,
+		"inflight.json":  inflight.Values,
+		"instances.json": d.RafsCache.List(),
+	} {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return "", errors.Wrapf(err, "marshal %s", name)
+		}
+		if err := add(name, data); err != nil {
+			return "", err
+		}
+	}
+
+	if !d.States.LogToStdout {
+		data, err := readLogTail(d.LogFile())
+		if err != nil {
+			log.L.WithError(err).Warnf("failed to save log of daemon %s", d.ID())
+		} else if err := add(filepath.Base(d.LogFile()), data); err != nil {
+			return "", err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return "", errors.Wrap(err, "close diagnostics bundle")
+	}
+	if err := gw.Close(); err != nil {
+		return "", errors.Wrap(err, "close diagnostics bundle")
+	}
+	if err := f.Close(); err != nil {
+		return "", errors.Wrap(err, "close diagnostics bundle")
+	}
+
+	pruneDiagnostics(dir, d.ID())
+
+	return bundle, nil
+}
+
+// Read the tail of the log which nydusd may be still writing.
+func readLogTail(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open log %s", path)
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, errors.Wrapf(err, "stat log %s", path)
+	}
+	if offset := st.Size() - maxDiagnosticsLogSize; offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return nil, errors.Wrapf(err, "seek log %s", path)
+		}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(f, maxDiagnosticsLogSize))
+	if err != nil {
+		return nil, errors.Wrapf(err, "read log %s", path)
+	}
+	return data, nil
+}
+
+// Remove old diagnostics bundles of the daemon, whose names are ordered by time.
+func pruneDiagnostics(dir, daemonID string) {
+	bundles, err := filepath.Glob(filepath.Join(dir, daemonID+"-*.tar.gz"))
+	if err != nil || len(bundles) <= maxDiagnosticsBundles {
+		return
+	}
+	sort.Strings(bundles)
+	for _, b := range bundles[:len(bundles)-maxDiagnosticsBundles] {
+		if err := os.Remove(b); err != nil {
+			log.L.WithError(err).Warnf("failed to remove diagnostics bundle %s", b)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package manager
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+	"github.com/containerd/nydus-snapshotter/pkg/metrics/data"
+	"github.com/containerd/nydus-snapshotter/pkg/rafs"
+)
+
+type inflightRequest struct {
+	Inode         uint64 `json:"inode"`
+	Opcode        uint32 `json:"opcode"`
+	Unique        uint64 `json:"unique"`
+	TimestampSecs uint64 `json:"timestamp_secs"`
+}
+
+// Serve the API of a running nydusd with the inflight requests.
+func serveNydusd(t *testing.T, sock string, inflight func() []inflightRequest) {
+	newFakeNydusd(t, sock, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/metrics/inflight" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(inflight()))
+	})
+}
+
+func hungIOActions(t *testing.T, daemonID, action string) float64 {
+	var m dto.Metric
+	require.NoError(t, data.HungIOActionCount.WithLabelValues(daemonID, action).Write(&m))
+	return m.GetCounter().GetValue()
+}
+
+func TestCheckHungIO(t *testing.T) {
+	now := time.Now()
+	var mu sync.Mutex
+	requests := []inflightRequest{
+		{Inode: 1, Unique: 1, TimestampSecs: uint64(now.Add(-2 * time.Minute).Unix())},
+		{Inode: 2, Unique: 2, TimestampSecs: uint64(now.Unix())},
+	}
+	inflight := func() []inflightRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+
+	d, err := daemon.NewDaemon(daemon.WithLogDir(t.TempDir()))
+	require.NoError(t, err)
+	d.States.APISocket = filepath.Join(t.TempDir(), "api.sock")
+	serveNydusd(t, d.States.APISocket, inflight)
+	_, err = d.GetState()
+	require.NoError(t, err)
+	d.AddRafsInstance(&rafs.Rafs{SnapshotID: "1", ImageID: "image", Annotations: map[string]string{}})
+	require.NoError(t, os.MkdirAll(d.States.LogDir, 0755))
+	require.NoError(t, os.WriteFile(d.LogFile(), []byte("nydusd log"), 0600))
+
+	m := &Manager{FsDriver: config.FsDriverFusedev, daemonCache: newDaemonCache()}
+	m.daemonCache.Add(d)
+
+	opt := HungIOWatchdogOpt{
+		Threshold: time.Minute,
+		Action:    config.HungIOActionDump,
+		DumpDir:   t.TempDir(),
+	}
+	acted := map[string]map[uint64]struct{}{}
+
+	// Act on the hung request once.
+	m.checkHungIO(opt, acted, now)
+	m.checkHungIO(opt, acted, now.Add(time.Second))
+	require.Equal(t, float64(1), hungIOActions(t, d.ID(), config.HungIOActionEvent))
+	require.Equal(t, float64(1), hungIOActions(t, d.ID(), config.HungIOActionDump))
+	require.Equal(t, float64(0), hungIOActions(t, d.ID(), config.HungIOActionRecover))
+
+	bundles, err := filepath.Glob(filepath.Join(opt.DumpDir, d.ID()+"-*.tar.gz"))
+	require.NoError(t, err)
+	require.Len(t, bundles, 1)
+	f, err := os.Open(bundles[0])
+	require.NoError(t, err)
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	files := map[string]string{}
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[hdr.Name] = string(content)
+	}
+	require.Len(t, files, 5)
+	require.Equal(t, "nydusd log", files["nydusd.log"])
+	require.Contains(t, files["instances.json"], `"SnapshotID": "1"`)
+	require.Contains(t, files["info.json"], `"state": "RUNNING"`)
+
+	// Act again when another request is hung.
+	m.checkHungIO(opt, acted, now.Add(2*time.Minute))
+	require.Equal(t, float64(2), hungIOActions(t, d.ID(), config.HungIOActionEvent))
+
+	// Act again when requests are hung again after they are all completed.
+	mu.Lock()
+	requests = nil
+	mu.Unlock()
+	m.checkHungIO(opt, acted, now.Add(3*time.Minute))
+	mu.Lock()
+	requests = []inflightRequest{{Inode: 1, Unique: 1, TimestampSecs: uint64(now.Unix())}}
+	mu.Unlock()
+	m.checkHungIO(opt, acted, now.Add(4*time.Minute))
+	require.Equal(t, float64(3), hungIOActions(t, d.ID(), config.HungIOActionEvent))
+
+	// Old bundles are removed.
+	for i := 0; i < maxDiagnosticsBundles+2; i++ {
+		_, err := dumpDiagnostics(d, opt.DumpDir, &types.InflightMetrics{}, now.Add(time.Duration(i)*time.Hour))
+		require.NoError(t, err)
+	}
+	bundles, err = filepath.Glob(filepath.Join(opt.DumpDir, d.ID()+"-*.tar.gz"))
+	require.NoError(t, err)
+	require.Len(t, bundles, maxDiagnosticsBundles)
+}
+
+// Start a fake nydusd process holding the listener of the socket, so that connections of
+// the liveness monitor are hung up when it's killed.
+func startFakeNydusd(t *testing.T, sock string) *exec.Cmd {
+	listener, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	f, err := listener.(*net.UnixListener).File()
+	require.NoError(t, err)
+	require.NoError(t, listener.Close())
+	defer f.Close()
+
+	cmd := exec.Command("sleep", "60")
+	cmd.ExtraFiles = []*os.File{f}
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	return cmd
+}
+
+func TestHungIORecover(t *testing.T) {
+	now := time.Now()
+	inflight := func() []inflightRequest {
+		return []inflightRequest{{Inode: 1, Unique: 1, TimestampSecs: uint64(now.Add(-2 * time.Minute).Unix())}}
+	}
+
+	d, err := daemon.NewDaemon(daemon.WithLogDir(t.TempDir()))
+	require.NoError(t, err)
+	d.States.APISocket = filepath.Join(t.TempDir(), "api.sock")
+	serveNydusd(t, d.States.APISocket, inflight)
+	_, err = d.GetState()
+	require.NoError(t, err)
+	livenessSock := filepath.Join(t.TempDir(), "liveness.sock")
+	cmd := startFakeNydusd(t, livenessSock)
+	d.States.ProcessID = cmd.Process.Pid
+
+	monitor, err := newMonitor()
+	require.NoError(t, err)
+	monitor.Run()
+	t.Cleanup(monitor.Destroy)
+	m := &Manager{
+		FsDriver:         config.FsDriverFusedev,
+		daemonCache:      newDaemonCache(),
+		monitor:          monitor,
+		LivenessNotifier: make(chan deathEvent, 1),
+	}
+	m.daemonCache.Add(d)
+	require.NoError(t, m.monitor.Subscribe(d.ID(), livenessSock, m.LivenessNotifier))
+
+	opt := HungIOWatchdogOpt{
+		Threshold: time.Minute,
+		Action:    config.HungIOActionRecover,
+		DumpDir:   t.TempDir(),
+	}
+
+	// Nydusd is left alive if it isn't recovered by the recover policy.
+	m.RecoverPolicy = config.RecoverPolicyNone
+	m.checkHungIO(opt, map[string]map[uint64]struct{}{}, now)
+	require.Equal(t, float64(1), hungIOActions(t, d.ID(), config.HungIOActionDump))
+	require.Equal(t, float64(0), hungIOActions(t, d.ID(), config.HungIOActionRecover))
+	require.NoError(t, syscall.Kill(cmd.Process.Pid, 0))
+
+	// Nydusd is killed, and its death is caught by the liveness monitor to restart or
+	// fail over it.
+	m.RecoverPolicy = config.RecoverPolicyRestart
+	m.checkHungIO(opt, map[string]map[uint64]struct{}{}, now)
+	require.Equal(t, float64(1), hungIOActions(t, d.ID(), config.HungIOActionRecover))
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	require.Equal(t, syscall.SIGKILL, exitErr.Sys().(syscall.WaitStatus).Signal())
+	select {
+	case ev := <-m.LivenessNotifier:
+		require.Equal(t, d.ID(), ev.daemonID)
+		require.Equal(t, livenessSock, ev.path)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "death of the killed daemon is not notified")
+	}
+}
+
+func TestCheckHungIOConcurrently(t *testing.T) {
+	now := time.Now()
+	hung := []inflightRequest{{Inode: 1, Unique: 1, TimestampSecs: uint64(now.Add(-2 * time.Minute).Unix())}}
+	m := &Manager{FsDriver: config.FsDriverFusedev, daemonCache: newDaemonCache()}
+
+	// The API of a daemon hangs.
+	release := make(chan struct{})
+	stuck, err := daemon.NewDaemon(daemon.WithLogDir(t.TempDir()))
+	require.NoError(t, err)
+	stuck.States.APISocket = filepath.Join(t.TempDir(), "api.sock")
+	serveNydusd(t, stuck.States.APISocket, func() []inflightRequest {
+		<-release
+		return nil
+	})
+	_, err = stuck.GetState()
+	require.NoError(t, err)
+	m.daemonCache.Add(stuck)
+
+	d, err := daemon.NewDaemon(daemon.WithLogDir(t.TempDir()))
+	require.NoError(t, err)
+	d.States.APISocket = filepath.Join(t.TempDir(), "api.sock")
+	serveNydusd(t, d.States.APISocket, func() []inflightRequest { return hung })
+	_, err = d.GetState()
+	require.NoError(t, err)
+	m.daemonCache.Add(d)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.checkHungIO(HungIOWatchdogOpt{Threshold: time.Minute, Action: config.HungIOActionEvent},
+			map[string]map[uint64]struct{}{}, now)
+	}()
+
+	// The other daemon is acted on without waiting for the hung API.
+	require.Eventually(t, func() bool {
+		return hungIOActions(t, d.ID(), config.HungIOActionEvent) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	select {
+	case <-done:
+		require.Fail(t, "the check returns before the hung API")
+	default:
+	}
+
+	close(release)
+	<-done
+	require.Equal(t, float64(0), hungIOActions(t, stuck.ID(), config.HungIOActionEvent))
+}
@@ -0,0 +1,48 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package collector
+
+import (
+	"github.com/containerd/log"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+	"github.com/containerd/nydus-snapshotter/pkg/metrics/data"
+)
+
+type BackendMetricsCollector struct {
+	Metrics  *types.BackendMetrics
+	ImageRef string
+}
+
+type BackendMetricsVecCollector struct {
+	MetricsVec []BackendMetricsCollector
+}
+
+func (c *BackendMetricsCollector) Collect() {
+	if c.Metrics == nil {
+		log.L.Warnf("can not collect backend metrics: Metrics is nil")
+		return
+	}
+
+	m := c.Metrics
+	data.BackendReadBytes.WithLabelValues(c.ImageRef).Set(float64(m.ReadAmountTotal))
+	data.BackendReadCount.WithLabelValues(c.ImageRef).Set(float64(m.ReadCount))
+	data.BackendReadErrors.WithLabelValues(c.ImageRef).Set(float64(m.ReadErrors))
+	data.BackendReadLatency.WithLabelValues(c.ImageRef).Set(averageLatency(m))
+}
+
+func (c *BackendMetricsVecCollector) Collect() {
+	for _, m := range c.MetricsVec {
+		m.Collect()
+	}
+}
+
+func averageLatency(m *types.BackendMetrics) float64 {
+	if m.ReadCount == 0 {
+		return 0
+	}
+	return float64(m.ReadCumulativeLatencyMillisTotal) / float64(m.ReadCount)
+}
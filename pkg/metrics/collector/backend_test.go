@@ -0,0 +1,20 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+)
+
+func TestAverageLatency(t *testing.T) {
+	assert.Equal(t, float64(0), averageLatency(&types.BackendMetrics{}))
+	assert.Equal(t, 2.5, averageLatency(&types.BackendMetrics{ReadCount: 4, ReadCumulativeLatencyMillisTotal: 10}))
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package collector
+
+import (
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+	"github.com/containerd/nydus-snapshotter/pkg/metrics/data"
+)
+
+type CacheMetricsCollector struct {
+	Metrics  *types.CacheMetrics
+	ImageRef string
+}
+
+type CacheMetricsVecCollector struct {
+	MetricsVec []CacheMetricsCollector
+}
+
+func (c *CacheMetricsCollector) Collect() {
+	if c.Metrics == nil {
+		log.L.Warnf("can not collect cache metrics: Metrics is nil")
+		return
+	}
+
+	m := c.Metrics
+	data.CachePartialHits.WithLabelValues(c.ImageRef).Set(float64(m.PartialHits))
+	data.CacheWholeHits.WithLabelValues(c.ImageRef).Set(float64(m.WholeHits))
+	data.CacheTotalReads.WithLabelValues(c.ImageRef).Set(float64(m.Total))
+	data.CacheReadHitRatio.WithLabelValues(c.ImageRef).Set(hitRatio(m))
+	data.CacheBufferedBackendSize.WithLabelValues(c.ImageRef).Set(float64(m.BufferedBackendSize))
+
+	data.PrefetchDataAmount.WithLabelValues(c.ImageRef).Set(float64(m.PrefetchDataAmount))
+	data.PrefetchRequests.WithLabelValues(c.ImageRef).Set(float64(m.PrefetchRequestsCount))
+	data.PrefetchCumulativeTime.WithLabelValues(c.ImageRef).Set(float64(m.PrefetchCumulativeTimeMillis))
+
+	duration := prefetchDuration(m, time.Now())
+	data.PrefetchDuration.WithLabelValues(c.ImageRef).Set(duration.Seconds())
+	var throughput float64
+	if duration > 0 {
+		throughput = float64(m.PrefetchDataAmount) / duration.Seconds()
+	}
+	data.PrefetchThroughput.WithLabelValues(c.ImageRef).Set(throughput)
+}
+
+func (c *CacheMetricsVecCollector) Collect() {
+	for _, m := range c.MetricsVec {
+		m.Collect()
+	}
+}
+
+func hitRatio(m *types.CacheMetrics) float64 {
+	if m.Total == 0 {
+		return 0
+	}
+	return float64(m.PartialHits+m.WholeHits) / float64(m.Total)
+}
+
+// Nydusd reports zero as the end time until all prefetch requests are done.
+func prefetchDuration(m *types.CacheMetrics, now time.Time) time.Duration {
+	if m.PrefetchBeginTimeSecs == 0 {
+		return 0
+	}
+	begin := time.Unix(int64(m.PrefetchBeginTimeSecs), 0)
+	end := now
+	if m.PrefetchEndTimeSecs >= m.PrefetchBeginTimeSecs {
+		end = time.Unix(int64(m.PrefetchEndTimeSecs), 0)
+	}
+	if end.Before(begin) {
+		return 0
+	}
+	return end.Sub(begin)
+}
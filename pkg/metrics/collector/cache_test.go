@@ -0,0 +1,34 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+)
+
+func TestHitRatio(t *testing.T) {
+	assert.Equal(t, float64(0), hitRatio(&types.CacheMetrics{}))
+	assert.Equal(t, 0.75, hitRatio(&types.CacheMetrics{PartialHits: 1, WholeHits: 2, Total: 4}))
+}
+
+func TestPrefetchDuration(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	assert.Equal(t, time.Duration(0), prefetchDuration(&types.CacheMetrics{}, now))
+	// Prefetch is in progress.
+	assert.Equal(t, 100*time.Second, prefetchDuration(&types.CacheMetrics{PrefetchBeginTimeSecs: 900}, now))
+	// Prefetch is done.
+	assert.Equal(t, 10*time.Second, prefetchDuration(&types.CacheMetrics{
+		PrefetchBeginTimeSecs: 900,
+		PrefetchEndTimeSecs:   910,
+	}, now))
+}
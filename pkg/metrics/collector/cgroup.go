@@ -0,0 +1,31 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package collector
+
+import (
+	"github.com/containerd/nydus-snapshotter/pkg/cgroup/stats"
+	"github.com/containerd/nydus-snapshotter/pkg/metrics/data"
+)
+
+type CgroupResourceCollector struct {
+	Cgroup string
+	Usage  *stats.Usage
+}
+
+func (c *CgroupResourceCollector) Collect() {
+	if c.Usage == nil {
+		return
+	}
+
+	data.CgroupMemoryUsage.WithLabelValues(c.Cgroup).Set(float64(c.Usage.MemoryBytes))
+	data.CgroupCPUUsage.WithLabelValues(c.Cgroup).Set(float64(c.Usage.CPUUsec) / 1e6)
+	data.CgroupPids.WithLabelValues(c.Cgroup).Set(float64(c.Usage.Pids))
+	data.CgroupIOBytes.WithLabelValues(c.Cgroup, "read").Set(float64(c.Usage.IOReadBytes))
+	data.CgroupIOBytes.WithLabelValues(c.Cgroup, "write").Set(float64(c.Usage.IOWriteBytes))
+	data.CgroupIOOperations.WithLabelValues(c.Cgroup, "read").Set(float64(c.Usage.IOReadOps))
+	data.CgroupIOOperations.WithLabelValues(c.Cgroup, "write").Set(float64(c.Usage.IOWriteOps))
+}
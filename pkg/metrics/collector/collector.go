@@ -12,6 +12,7 @@ import (
 
 	"github.com/containerd/nydus-snapshotter/pkg/metrics/data"
 
+	"github.com/containerd/nydus-snapshotter/pkg/cgroup/stats"
 	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
 	"github.com/containerd/nydus-snapshotter/pkg/metrics/tool"
 	"github.com/pkg/errors"
@@ -35,12 +36,32 @@ func NewFsMetricsVecCollector() *FsMetricsVecCollector {
 	return &FsMetricsVecCollector{}
 }
 
+func NewCacheMetricsVecCollector() *CacheMetricsVecCollector {
+	return &CacheMetricsVecCollector{}
+}
+
+func NewBackendMetricsVecCollector() *BackendMetricsVecCollector {
+	return &BackendMetricsVecCollector{}
+}
+
 func NewInflightMetricsVecCollector(hungIOInterval time.Duration) *InflightMetricsVecCollector {
 	return &InflightMetricsVecCollector{
 		HungIOInterval: hungIOInterval,
 	}
 }
 
+func NewRafsRecoveryEventCollector(daemonID, event string) *RafsRecoveryEventCollector {
+	return &RafsRecoveryEventCollector{daemonID, event}
+}
+
+func NewHungIOActionCollector(daemonID, action string) *HungIOActionCollector {
+	return &HungIOActionCollector{daemonID, action}
+}
+
+func NewCgroupResourceCollector(cgroup string, usage *stats.Usage) *CgroupResourceCollector {
+	return &CgroupResourceCollector{cgroup, usage}
+}
+
 func NewDaemonInfoCollector(version *types.BuildTimeInfo, value float64) *DaemonInfoCollector {
 	return &DaemonInfoCollector{version, value}
 }
@@ -53,6 +74,10 @@ func NewSnapshotterMetricsCollector(ctx context.Context, cacheDir string, pid in
 	return &SnapshotterMetricsCollector{ctx, cacheDir, pid, currentStat}, nil
 }
 
+func NewCacheEvictionCollector(size int64) *CacheEvictionCollector {
+	return &CacheEvictionCollector{Size: size}
+}
+
 func NewSnapshotMetricsTimer(method SnapshotMethod) *prometheus.Timer {
 	return CollectSnapshotMetricsTimer(data.SnapshotEventElapsedHists, method)
 }
@@ -21,6 +21,16 @@ type DaemonInfoCollector struct {
 	value   float64
 }
 
+type RafsRecoveryEventCollector struct {
+	DaemonID string
+	Event    string
+}
+
+type HungIOActionCollector struct {
+	DaemonID string
+	Action   string
+}
+
 type DaemonResourceCollector struct {
 	DaemonID string
 	Value    float64
@@ -41,3 +51,11 @@ func (d *DaemonInfoCollector) Collect() {
 func (d *DaemonResourceCollector) Collect() {
 	data.NydusdRSS.WithLabelValues(d.DaemonID).Set(d.Value)
 }
+
+func (r *RafsRecoveryEventCollector) Collect() {
+	data.RafsRecoveryEventCount.WithLabelValues(r.DaemonID, r.Event).Inc()
+}
+
+func (h *HungIOActionCollector) Collect() {
+	data.HungIOActionCount.WithLabelValues(h.DaemonID, h.Action).Inc()
+}
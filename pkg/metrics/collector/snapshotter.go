@@ -23,6 +23,11 @@ type SnapshotterMetricsCollector struct {
 	lastStat *tool.Stat
 }
 
+type CacheEvictionCollector struct {
+	// Disk usage of the evicted blob cache in bytes
+	Size int64
+}
+
 type SnapshotMethod string
 
 const (
@@ -82,6 +87,11 @@ func (s *SnapshotterMetricsCollector) Collect() {
 	s.CollectResourceUsage()
 }
 
+func (c *CacheEvictionCollector) Collect() {
+	data.CacheEvictionCount.Inc()
+	data.CacheEvictionSize.Add(float64(c.Size) / 1024)
+}
+
 func CollectSnapshotMetricsTimer(h *prometheus.HistogramVec, event SnapshotMethod) *prometheus.Timer {
 	return prometheus.NewTimer(
 		prometheus.ObserverFunc(
@@ -0,0 +1,47 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package data
+
+import (
+	"github.com/containerd/nydus-snapshotter/pkg/metrics/types/ttl"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	BackendReadBytes = ttl.NewGaugeVecWithTTL(
+		prometheus.GaugeOpts{
+			Name: "nydusd_backend_read_bytes",
+			Help: "Total bytes read from storage backend.",
+		},
+		[]string{imageRefLabel},
+		ttl.DefaultTTL,
+	)
+	BackendReadCount = ttl.NewGaugeVecWithTTL(
+		prometheus.GaugeOpts{
+			Name: "nydusd_backend_read_counts",
+			Help: "Total number of read requests sent to storage backend.",
+		},
+		[]string{imageRefLabel},
+		ttl.DefaultTTL,
+	)
+	BackendReadErrors = ttl.NewGaugeVecWithTTL(
+		prometheus.GaugeOpts{
+			Name: "nydusd_backend_read_errors",
+			Help: "Total number of failed read requests sent to storage backend.",
+		},
+		[]string{imageRefLabel},
+		ttl.DefaultTTL,
+	)
+	BackendReadLatency = ttl.NewGaugeVecWithTTL(
+		prometheus.GaugeOpts{
+			Name: "nydusd_backend_read_latency_milliseconds",
+			Help: "Average latency of read requests sent to storage backend, in milliseconds.",
+		},
+		[]string{imageRefLabel},
+		ttl.DefaultTTL,
+	)
+)
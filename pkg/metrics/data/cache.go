@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package data
+
+import (
+	"github.com/containerd/nydus-snapshotter/pkg/metrics/types/ttl"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	CacheReadHitRatio = ttl.NewGaugeVecWithTTL(
+		prometheus.GaugeOpts{
+			Name: "nydusd_cache_hit_ratio",
+			Help: "Ratio of read requests served by blob cache, including partial hits.",
+		},
+		[]string{imageRefLabel},
+		ttl.DefaultTTL,
+	)
+	CachePartialHits = ttl.NewGaugeVecWithTTL(
+		prometheus.GaugeOpts{
+			Name: "nydusd_cache_partial_hits",
+			Help: "Total number of read requests partially served by blob cache.",
+		},
+		[]string{imageRefLabel},
+		ttl.DefaultTTL,
+	)
+	CacheWholeHits = ttl.NewGaugeVecWithTTL(
+		prometheus.GaugeOpts{
+			Name: "nydusd_cache_whole_hits",
+			Help: "Total number of read requests wholly served by blob cache.",
+		},
+		[]string{imageRefLabel},
+		ttl.DefaultTTL,
+	)
+	CacheTotalReads = ttl.NewGaugeVecWithTTL(
+		prometheus.GaugeOpts{
+			Name: "nydusd_cache_read_counts",
+			Help: "Total number of read requests against blob cache.",
+		},
+		[]string{imageRefLabel},
+		ttl.DefaultTTL,
+	)
+	CacheBufferedBackendSize = ttl.NewGaugeVecWithTTL(
+		prometheus.GaugeOpts{
+			Name: "nydusd_cache_buffered_backend_bytes",
+			Help: "Size of backend data buffered in memory by blob cache.",
+		},
+		[]string{imageRefLabel},
+		ttl.DefaultTTL,
+	)
+	PrefetchDataAmount = ttl.NewGaugeVecWithTTL(
+		prometheus.GaugeOpts{
+			Name: "nydusd_prefetch_data_bytes",
+			Help: "Total bytes prefetched from backend.",
+		},
+		[]string{imageRefLabel},
+		ttl.DefaultTTL,
+	)
+	PrefetchRequests = ttl.NewGaugeVecWithTTL(
+		prometheus.GaugeOpts{
+			Name: "nydusd_prefetch_request_counts",
+			Help: "Total number of prefetch requests sent to backend.",
+		},
+		[]string{imageRefLabel},
+		ttl.DefaultTTL,
+	)
+	PrefetchThroughput = ttl.NewGaugeVecWithTTL(
+		prometheus.GaugeOpts{
+			Name: "nydusd_prefetch_throughput_bytes_per_second",
+			Help: "Average prefetch throughput from the beginning of prefetch.",
+		},
+		[]string{imageRefLabel},
+		ttl.DefaultTTL,
+	)
+	PrefetchDuration = ttl.NewGaugeVecWithTTL(
+		prometheus.GaugeOpts{
+			Name: "nydusd_prefetch_duration_seconds",
+			Help: "Wall time spent on prefetch, up to now if prefetch is still in progress.",
+		},
+		[]string{imageRefLabel},
+		ttl.DefaultTTL,
+	)
+	PrefetchCumulativeTime = ttl.NewGaugeVecWithTTL(
+		prometheus.GaugeOpts{
+			Name: "nydusd_prefetch_cumulative_time_milliseconds",
+			Help: "Time spent by all prefetch workers, in milliseconds.",
+		},
+		[]string{imageRefLabel},
+		ttl.DefaultTTL,
+	)
+)
@@ -0,0 +1,60 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package data
+
+import (
+	"github.com/containerd/nydus-snapshotter/pkg/metrics/types/ttl"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	cgroupLabel = "cgroup"
+	ioOpLabel   = "io_op"
+)
+
+var (
+	CgroupMemoryUsage = ttl.NewGaugeVecWithTTL(
+		prometheus.GaugeOpts{
+			Name: "nydusd_cgroup_memory_usage_bytes",
+			Help: "Memory usage of nydusd cgroup.",
+		},
+		[]string{cgroupLabel},
+		ttl.DefaultTTL,
+	)
+	CgroupCPUUsage = ttl.NewGaugeVecWithTTL(
+		prometheus.GaugeOpts{
+			Name: "nydusd_cgroup_cpu_usage_seconds",
+			Help: "Accumulated CPU time consumed by nydusd cgroup.",
+		},
+		[]string{cgroupLabel},
+		ttl.DefaultTTL,
+	)
+	CgroupPids = ttl.NewGaugeVecWithTTL(
+		prometheus.GaugeOpts{
+			Name: "nydusd_cgroup_pids",
+			Help: "Number of processes and threads in nydusd cgroup.",
+		},
+		[]string{cgroupLabel},
+		ttl.DefaultTTL,
+	)
+	CgroupIOBytes = ttl.NewGaugeVecWithTTL(
+		prometheus.GaugeOpts{
+			Name: "nydusd_cgroup_io_bytes",
+			Help: "Accumulated bytes read from or written to block devices by nydusd cgroup.",
+		},
+		[]string{cgroupLabel, ioOpLabel},
+		ttl.DefaultTTL,
+	)
+	CgroupIOOperations = ttl.NewGaugeVecWithTTL(
+		prometheus.GaugeOpts{
+			Name: "nydusd_cgroup_io_operations",
+			Help: "Accumulated IO operations on block devices issued by nydusd cgroup.",
+		},
+		[]string{cgroupLabel, ioOpLabel},
+		ttl.DefaultTTL,
+	)
+)
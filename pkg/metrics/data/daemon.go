@@ -15,6 +15,8 @@ var (
 	nydusdEventLabel   = "nydusd_event"
 	nydusdVersionLabel = "version"
 	daemonIDLabel      = "daemon_id"
+	recoveryEventLabel = "recovery_event"
+	hungIOActionLabel  = "action"
 )
 
 var (
@@ -40,4 +42,18 @@ var (
 		[]string{daemonIDLabel},
 		ttl.DefaultTTL,
 	)
+	RafsRecoveryEventCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nydusd_rafs_recovery_event_counts",
+			Help: "The recovery events of RAFS instances after nydus daemon died.",
+		},
+		[]string{daemonIDLabel, recoveryEventLabel},
+	)
+	HungIOActionCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nydusd_hung_io_actions",
+			Help: "The actions taken by the watchdog on nydus daemon having hung IO.",
+		},
+		[]string{daemonIDLabel, hungIOActionLabel},
+	)
 )
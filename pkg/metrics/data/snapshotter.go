@@ -32,6 +32,20 @@ var (
 		},
 	)
 
+	CacheEvictionCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "snapshotter_cache_eviction_counts",
+			Help: "Number of blob caches evicted because the cache size limit is exceeded.",
+		},
+	)
+
+	CacheEvictionSize = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "snapshotter_cache_eviction_kilobytes",
+			Help: "Disk space reclaimed by evicting blob caches.",
+		},
+	)
+
 	CPUUsage = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "snapshotter_cpu_usage_percentage",
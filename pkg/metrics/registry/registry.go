@@ -21,11 +21,34 @@ func init() {
 		data.FsReadHit,
 		data.FsReadError,
 		data.TotalHungIO,
+		data.CacheReadHitRatio,
+		data.CachePartialHits,
+		data.CacheWholeHits,
+		data.CacheTotalReads,
+		data.CacheBufferedBackendSize,
+		data.PrefetchDataAmount,
+		data.PrefetchRequests,
+		data.PrefetchThroughput,
+		data.PrefetchDuration,
+		data.PrefetchCumulativeTime,
+		data.BackendReadBytes,
+		data.BackendReadCount,
+		data.BackendReadErrors,
+		data.BackendReadLatency,
 		data.NydusdEventCount,
 		data.NydusdCount,
 		data.NydusdRSS,
+		data.RafsRecoveryEventCount,
+		data.HungIOActionCount,
+		data.CgroupMemoryUsage,
+		data.CgroupCPUUsage,
+		data.CgroupPids,
+		data.CgroupIOBytes,
+		data.CgroupIOOperations,
 		data.SnapshotEventElapsedHists,
 		data.CacheUsage,
+		data.CacheEvictionCount,
+		data.CacheEvictionSize,
 		data.CPUUsage,
 		data.MemoryUsage,
 		data.CPUSystem,
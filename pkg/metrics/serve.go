@@ -9,6 +9,7 @@ package metrics
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"time"
 
@@ -16,7 +17,9 @@ import (
 
 	"github.com/containerd/log"
 	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/cgroup"
 	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+	"github.com/containerd/nydus-snapshotter/pkg/events"
 	"github.com/containerd/nydus-snapshotter/pkg/manager"
 	"github.com/containerd/nydus-snapshotter/pkg/metrics/collector"
 	"github.com/containerd/nydus-snapshotter/pkg/metrics/tool"
@@ -31,7 +34,13 @@ type Server struct {
 	managers          []*manager.Manager
 	snCollectors      []*collector.SnapshotterMetricsCollector
 	fsCollector       *collector.FsMetricsVecCollector
+	cacheCollector    *collector.CacheMetricsVecCollector
+	backendCollector  *collector.BackendMetricsVecCollector
 	inflightCollector *collector.InflightMetricsVecCollector
+	// Failed file operations of each RAFS instance in the last round, indexed by snapshot ID.
+	fopErrors map[string]uint64
+	// Failed backend reads of each RAFS instance served by fscache daemons in the last round.
+	backendErrors map[string]uint64
 }
 
 func WithProcessManagers(managers []*manager.Manager) ServerOpt {
@@ -50,6 +59,8 @@ func NewServer(ctx context.Context, opts ...ServerOpt) (*Server, error) {
 	}
 
 	s.fsCollector = collector.NewFsMetricsVecCollector()
+	s.cacheCollector = collector.NewCacheMetricsVecCollector()
+	s.backendCollector = collector.NewBackendMetricsVecCollector()
 	// TODO(tangbin): make hung IO interval configurable
 	s.inflightCollector = collector.NewInflightMetricsVecCollector(defaultHungIOInterval)
 	for _, pm := range s.managers {
@@ -81,11 +92,32 @@ func (s *Server) CollectDaemonResourceMetrics(_ context.Context) {
 	}
 }
 
+func (s *Server) CollectCgroupMetrics(_ context.Context) {
+	// Managers of different fs drivers may share the same cgroup.
+	visited := make(map[*cgroup.Manager]struct{})
+	for _, pm := range s.managers {
+		cm := pm.CgroupMgr
+		if cm == nil {
+			continue
+		}
+		if _, ok := visited[cm]; ok {
+			continue
+		}
+		visited[cm] = struct{}{}
+
+		for name, usage := range cm.Stats() {
+			collector.NewCgroupResourceCollector(name, usage).Collect()
+		}
+	}
+}
+
 func (s *Server) CollectFsMetrics(ctx context.Context) {
 	var fsMetricsVec []collector.FsMetricsCollector
+	fopErrors := make(map[string]uint64)
 
 	for _, pm := range s.managers {
-		// Collect FS metrics from fusedev daemons.
+		// Collect FS metrics from fusedev daemons, fscache daemons don't serve
+		// file operations which are handled by EROFS in kernel.
 		if pm.FsDriver != config.FsDriverFusedev {
 			continue
 		}
@@ -116,9 +148,26 @@ func (s *Server) CollectFsMetrics(ctx context.Context) {
 					Metrics:  fsMetrics,
 					ImageRef: i.ImageID,
 				})
+
+				// Failed file operations are mostly caused by failures to read from the storage backend.
+				var failed uint64
+				for _, n := range fsMetrics.FopErrors {
+					failed += n
+				}
+				if last, ok := s.fopErrors[i.SnapshotID]; ok && failed > last {
+					events.Publish(events.TopicReadFailed, &events.DaemonEvent{
+						DaemonID:  d.ID(),
+						FsDriver:  pm.FsDriver,
+						Snapshots: []string{i.SnapshotID},
+						Images:    []string{i.ImageID},
+						Message:   fmt.Sprintf("%d file operations failed", failed-last),
+					})
+				}
+				fopErrors[i.SnapshotID] = failed
 			}
 		}
 	}
+	s.fopErrors = fopErrors
 
 	if fsMetricsVec != nil {
 		s.fsCollector.MetricsVec = fsMetricsVec
@@ -126,10 +175,105 @@ func (s *Server) CollectFsMetrics(ctx context.Context) {
 	}
 }
 
+// Blob caches and storage backends are per RAFS instance for both fusedev and fscache daemons.
+func hasBlobMetrics(fsDriver string) bool {
+	return fsDriver == config.FsDriverFusedev || fsDriver == config.FsDriverFscache
+}
+
+func (s *Server) CollectCacheMetrics(ctx context.Context) {
+	var cacheMetricsVec []collector.CacheMetricsCollector
+
+	for _, pm := range s.managers {
+		if !hasBlobMetrics(pm.FsDriver) {
+			continue
+		}
+
+		daemons := pm.ListDaemons()
+		for _, d := range daemons {
+			// Skip daemons that are not serving
+			if d.State() != types.DaemonStateRunning {
+				continue
+			}
+
+			for _, i := range d.RafsCache.List() {
+				cacheMetrics, err := d.GetRafsCacheMetrics(i)
+				if err != nil {
+					log.G(ctx).Errorf("failed to get cache metric: %v", err)
+					continue
+				}
+
+				cacheMetricsVec = append(cacheMetricsVec, collector.CacheMetricsCollector{
+					Metrics:  cacheMetrics,
+					ImageRef: i.ImageID,
+				})
+			}
+		}
+	}
+
+	if cacheMetricsVec != nil {
+		s.cacheCollector.MetricsVec = cacheMetricsVec
+		s.cacheCollector.Collect()
+	}
+}
+
+func (s *Server) CollectBackendMetrics(ctx context.Context) {
+	var backendMetricsVec []collector.BackendMetricsCollector
+	backendErrors := make(map[string]uint64)
+
+	for _, pm := range s.managers {
+		if !hasBlobMetrics(pm.FsDriver) {
+			continue
+		}
+
+		daemons := pm.ListDaemons()
+		for _, d := range daemons {
+			// Skip daemons that are not serving
+			if d.State() != types.DaemonStateRunning {
+				continue
+			}
+
+			for _, i := range d.RafsCache.List() {
+				backendMetrics, err := d.GetRafsBackendMetrics(i)
+				if err != nil {
+					log.G(ctx).Errorf("failed to get backend metric: %v", err)
+					continue
+				}
+
+				backendMetricsVec = append(backendMetricsVec, collector.BackendMetricsCollector{
+					Metrics:  backendMetrics,
+					ImageRef: i.ImageID,
+				})
+
+				// Read failures of fusedev daemons are published along with FS metrics.
+				if pm.FsDriver != config.FsDriverFscache {
+					continue
+				}
+				failed := backendMetrics.ReadErrors
+				if last, ok := s.backendErrors[i.SnapshotID]; ok && failed > last {
+					events.Publish(events.TopicReadFailed, &events.DaemonEvent{
+						DaemonID:  d.ID(),
+						FsDriver:  pm.FsDriver,
+						Snapshots: []string{i.SnapshotID},
+						Images:    []string{i.ImageID},
+						Message:   fmt.Sprintf("%d backend reads failed", failed-last),
+					})
+				}
+				backendErrors[i.SnapshotID] = failed
+			}
+		}
+	}
+	s.backendErrors = backendErrors
+
+	if backendMetricsVec != nil {
+		s.backendCollector.MetricsVec = backendMetricsVec
+		s.backendCollector.Collect()
+	}
+}
+
 func (s *Server) CollectInflightMetrics(ctx context.Context) {
 	inflightMetricsVec := make([]*types.InflightMetrics, 0, 16)
 	for _, pm := range s.managers {
-		// Collect inflight metrics from fusedev daemons.
+		// Collect inflight metrics from fusedev daemons, nydusd only tracks inflight FUSE requests.
 		if pm.FsDriver != config.FsDriverFusedev {
 			continue
 		}
@@ -172,7 +316,10 @@ outer:
 		select {
 		case <-timer.C:
 			s.CollectFsMetrics(ctx)
+			s.CollectCacheMetrics(ctx)
+			s.CollectBackendMetrics(ctx)
 			s.CollectDaemonResourceMetrics(ctx)
+			s.CollectCgroupMetrics(ctx)
 			// Collect snapshotter metrics.
 			for _, snCollector := range s.snCollectors {
 				snCollector.Collect()
@@ -0,0 +1,241 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package p2p
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Blob cache is suffixed after nydus v2.1
+	dataFileSuffix = ".blob.data"
+	// Nydusd tracks chunks downloaded into the blob cache by the chunk map.
+	chunkMapFileSuffix = ".chunk_map"
+
+	// Layout of the chunk map header, see `PersistMap` of nydus storage.
+	chunkMapHeaderSize    = 4096
+	chunkMapMagic         = 0x424D4150
+	chunkMapMagic2        = 0x434D4150
+	chunkMapMagicAllReady = 0x4D4D4150
+)
+
+// Blob caches in the cache directory are shared with peers only if they are fully downloaded,
+// and nydusd caches compressed chunks, so that the cache files are the same as the blobs in
+// the registry. Nydus blob IDs are sha256 digests of blobs.
+//
+// Blobs are only served to peers pulling an image repository they belong to, which is known
+// when images are mounted on this node.
+type blobStore struct {
+	cacheDir string
+	// Whether nydusd caches compressed chunks.
+	compressed bool
+	// Where to persist repositories of blobs.
+	indexFile string
+
+	mu sync.Mutex
+	// Repositories blobs belong to, indexed by blob digests.
+	repos map[digest.Digest]map[string]struct{}
+}
+
+func newBlobStore(cacheDir, indexFile string, compressed bool) (*blobStore, error) {
+	s := &blobStore{
+		cacheDir:   cacheDir,
+		compressed: compressed,
+		indexFile:  indexFile,
+		repos:      make(map[digest.Digest]map[string]struct{}),
+	}
+
+	data, err := os.ReadFile(indexFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, errors.Wrapf(err, "read blob index %s", indexFile)
+	}
+	var index map[digest.Digest][]string
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal blob index %s", indexFile)
+	}
+	for dgst, repos := range index {
+		s.repos[dgst] = make(map[string]struct{}, len(repos))
+		for _, repo := range repos {
+			s.repos[dgst][repo] = struct{}{}
+		}
+	}
+
+	return s, nil
+}
+
+// Record the repository the blobs belong to.
+func (s *blobStore) addRepo(repo string, blobs []digest.Digest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	for _, dgst := range blobs {
+		repos, ok := s.repos[dgst]
+		if !ok {
+			repos = make(map[string]struct{})
+			s.repos[dgst] = repos
+		}
+		if _, ok := repos[repo]; !ok {
+			repos[repo] = struct{}{}
+			changed = true
+		}
+	}
+	if changed {
+		s.persist()
+	}
+}
+
+// Persist the index, the caller must hold the lock.
+func (s *blobStore) persist() {
+	index := make(map[digest.Digest][]string, len(s.repos))
+	for dgst, repos := range s.repos {
+		for repo := range repos {
+			index[dgst] = append(index[dgst], repo)
+		}
+	}
+	data, err := json.Marshal(index)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(s.indexFile), 0700)
+	}
+	if err == nil {
+		tmp := s.indexFile + ".tmp"
+		if err = os.WriteFile(tmp, data, 0600); err == nil {
+			err = os.Rename(tmp, s.indexFile)
+		}
+	}
+	if err != nil {
+		log.L.WithError(err).Warnf("failed to persist blob index %s", s.indexFile)
+	}
+}
+
+func (s *blobStore) belongsTo(dgst digest.Digest, repo string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.repos[dgst][repo]
+	return ok
+}
+
+// Open the fully downloaded cache file of the blob in the repository.
+func (s *blobStore) open(dgst digest.Digest, repo string) (*os.File, bool) {
+	if dgst.Validate() != nil || dgst.Algorithm() != digest.SHA256 || !s.belongsTo(dgst, repo) {
+		return nil, false
+	}
+
+	for _, path := range s.dataFiles(dgst.Encoded()) {
+		if !s.complete(path) {
+			continue
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		return f, true
+	}
+
+	return nil, false
+}
+
+func (s *blobStore) dataFiles(id string) []string {
+	return []string{
+		filepath.Join(s.cacheDir, id+dataFileSuffix),
+		// For backward compatibility
+		filepath.Join(s.cacheDir, id),
+	}
+}
+
+// List digests of all fully downloaded blobs with known repositories.
+func (s *blobStore) list() []digest.Digest {
+	s.mu.Lock()
+	candidates := make([]digest.Digest, 0, len(s.repos))
+	for dgst := range s.repos {
+		candidates = append(candidates, dgst)
+	}
+	s.mu.Unlock()
+
+	var blobs, removed []digest.Digest
+	for _, dgst := range candidates {
+		exists := false
+		for _, path := range s.dataFiles(dgst.Encoded()) {
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+			exists = true
+			if s.complete(path) {
+				blobs = append(blobs, dgst)
+				break
+			}
+		}
+		if !exists {
+			removed = append(removed, dgst)
+		}
+	}
+
+	// Forget blob caches removed by GC.
+	if len(removed) > 0 {
+		s.mu.Lock()
+		for _, dgst := range removed {
+			delete(s.repos, dgst)
+		}
+		s.persist()
+		s.mu.Unlock()
+	}
+
+	return blobs
+}
+
+// Tell whether nydusd has downloaded all chunks of the blob cache file by its chunk map.
+func (s *blobStore) complete(dataFile string) bool {
+	if !s.compressed {
+		return false
+	}
+
+	f, err := os.Open(dataFile + chunkMapFileSuffix)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	var header struct {
+		Magic    uint32
+		Version  uint32
+		Magic2   uint32
+		AllReady uint32
+	}
+	if err := binary.Read(f, binary.LittleEndian, &header); err != nil {
+		return false
+	}
+	if header.Magic != chunkMapMagic || header.Magic2 != chunkMapMagic2 {
+		return false
+	}
+	if header.AllReady == chunkMapMagicAllReady {
+		return true
+	}
+
+	// Chunks are all ready if every bit of the bitmap is set, when the chunk count is a
+	// multiple of 8 and nydusd has not marked the map as all ready yet.
+	if _, err := f.Seek(chunkMapHeaderSize, io.SeekStart); err != nil {
+		return false
+	}
+	bitmap, err := io.ReadAll(f)
+	if err != nil || len(bitmap) == 0 {
+		return false
+	}
+	return len(bytes.TrimLeft(bitmap, "\xff")) == 0
+}
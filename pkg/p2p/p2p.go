@@ -0,0 +1,325 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package p2p shares fully downloaded blob caches between nodes. Each node serves its blob
+// caches by the registry API, and nydusd fetches blobs from peers having them as registry
+// mirrors before the registry.
+package p2p
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
+	"github.com/containerd/nydus-snapshotter/pkg/rafs"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultRefreshInterval = 30 * time.Second
+	peerRequestTimeout     = 10 * time.Second
+	// Maximum of peers queried at the same time.
+	maxConcurrentPeerRequests = 16
+)
+
+type Opt struct {
+	Config   config.P2PConfig
+	CacheDir string
+	// Whether nydusd caches compressed chunks, blob caches are only served to peers if so.
+	CompressedCache bool
+	RootDir         string // Nydus-snapshotter work directory
+}
+
+type Manager struct {
+	cfg             config.P2PConfig
+	refreshInterval time.Duration
+	// Tells the node itself from peers since it may be discovered as a peer too.
+	nodeID string
+	// Shared by all peers to authenticate each other.
+	secret []byte
+	store  *blobStore
+	client *http.Client
+
+	mu         sync.Mutex
+	localBlobs []digest.Digest
+	// Blobs served by each peer, indexed by peer address.
+	peers map[string]map[digest.Digest]struct{}
+}
+
+func NewManager(opt Opt) (*Manager, error) {
+	interval := defaultRefreshInterval
+	if opt.Config.RefreshInterval != "" {
+		d, err := time.ParseDuration(opt.Config.RefreshInterval)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse refresh interval %s", opt.Config.RefreshInterval)
+		}
+		interval = d
+	}
+
+	secret, err := os.ReadFile(opt.Config.TokenFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read token file %s", opt.Config.TokenFile)
+	}
+	secret = bytes.TrimSpace(secret)
+	if len(secret) == 0 {
+		return nil, errors.Errorf("token file %s is empty", opt.Config.TokenFile)
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, errors.Wrap(err, "generate node ID")
+	}
+
+	store, err := newBlobStore(opt.CacheDir, filepath.Join(opt.RootDir, "p2p", "blobs.json"), opt.CompressedCache)
+	if err != nil {
+		return nil, errors.Wrap(err, "create blob store")
+	}
+	if !opt.CompressedCache {
+		log.L.Warn("blob caches are not served to peers since nydusd caches uncompressed chunks")
+	}
+
+	return &Manager{
+		cfg:             opt.Config,
+		refreshInterval: interval,
+		nodeID:          hex.EncodeToString(id),
+		secret:          secret,
+		store:           store,
+		client:          &http.Client{Timeout: peerRequestTimeout},
+		peers:           make(map[string]map[digest.Digest]struct{}),
+	}, nil
+}
+
+// Start serving blob caches to peers, and discovering peers periodically until `ctx` is done.
+func (m *Manager) Start(ctx context.Context) error {
+	listener, err := net.Listen("tcp", m.cfg.Address)
+	if err != nil {
+		return errors.Wrapf(err, "listen on %s", m.cfg.Address)
+	}
+	server := &http.Server{Handler: m.handler(), ReadHeaderTimeout: peerRequestTimeout}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.L.WithError(err).Errorf("p2p blob server on %s stopped", m.cfg.Address)
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(m.refreshInterval)
+		defer ticker.Stop()
+		for {
+			m.refresh(ctx)
+			select {
+			case <-ctx.Done():
+				server.Close()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	log.L.Infof("serving blob caches to peers on %s", m.cfg.Address)
+
+	return nil
+}
+
+func (m *Manager) refresh(ctx context.Context) {
+	localBlobs := m.store.list()
+
+	var (
+		wg    sync.WaitGroup
+		lock  sync.Mutex
+		sem   = make(chan struct{}, maxConcurrentPeerRequests)
+		peers = make(map[string]map[digest.Digest]struct{})
+	)
+	for _, peer := range m.discover(ctx) {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(peer string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			blobs, err := m.peerBlobs(ctx, peer)
+			if err != nil {
+				log.L.WithError(err).Debugf("failed to get blobs of peer %s", peer)
+				return
+			}
+			if blobs == nil {
+				return
+			}
+			lock.Lock()
+			peers[peer] = blobs
+			lock.Unlock()
+		}(peer)
+	}
+	wg.Wait()
+
+	m.mu.Lock()
+	m.localBlobs = localBlobs
+	m.peers = peers
+	m.mu.Unlock()
+
+	log.L.Debugf("p2p: %d local blobs, %d peers", len(localBlobs), len(peers))
+}
+
+// Discover peers from the static list, the peers file and the DNS SRV record.
+func (m *Manager) discover(ctx context.Context) []string {
+	seen := map[string]struct{}{}
+	var peers []string
+	add := func(peer string) {
+		peer = strings.TrimSpace(peer)
+		if peer == "" || strings.HasPrefix(peer, "#") {
+			return
+		}
+		if _, ok := seen[peer]; !ok {
+			seen[peer] = struct{}{}
+			peers = append(peers, peer)
+		}
+	}
+
+	for _, p := range m.cfg.Peers {
+		add(p)
+	}
+
+	if m.cfg.PeersFile != "" {
+		data, err := os.ReadFile(m.cfg.PeersFile)
+		if err != nil {
+			log.L.WithError(err).Warnf("failed to read peers file %s", m.cfg.PeersFile)
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			add(scanner.Text())
+		}
+	}
+
+	if m.cfg.DNSSRV != "" {
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", m.cfg.DNSSRV)
+		if err != nil {
+			log.L.WithError(err).Warnf("failed to look up peers by SRV record %s", m.cfg.DNSSRV)
+		}
+		for _, r := range records {
+			add(net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port))))
+		}
+	}
+
+	return peers
+}
+
+// Get blobs served by the peer, returns nil if the peer is the node itself.
+func (m *Manager) peerBlobs(ctx context.Context, peer string) (map[digest.Digest]struct{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+peer+endpointBlobs, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(tokenHeader, m.token(peerScope))
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var body blobsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, errors.Wrap(err, "decode blob list")
+	}
+	if body.Node == m.nodeID {
+		return nil, nil
+	}
+
+	blobs := make(map[digest.Digest]struct{}, len(body.Blobs))
+	for _, b := range body.Blobs {
+		blobs[b] = struct{}{}
+	}
+	return blobs, nil
+}
+
+// Mirrors returns peers serving any of the blobs of the image repository as nydusd mirrors,
+// peers serving more of the blobs come first.
+func (m *Manager) Mirrors(repo string, blobs []digest.Digest) []daemonconfig.MirrorConfig {
+	m.mu.Lock()
+	counts := map[string]int{}
+	for peer, served := range m.peers {
+		for _, b := range blobs {
+			if _, ok := served[b]; ok {
+				counts[peer]++
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	peers := make([]string, 0, len(counts))
+	for peer := range counts {
+		peers = append(peers, peer)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		if counts[peers[i]] != counts[peers[j]] {
+			return counts[peers[i]] > counts[peers[j]]
+		}
+		return peers[i] < peers[j]
+	})
+
+	mirrors := make([]daemonconfig.MirrorConfig, 0, len(peers))
+	for _, peer := range peers {
+		mirrors = append(mirrors, daemonconfig.MirrorConfig{
+			Host:    "http://" + peer,
+			Headers: map[string]string{tokenHeader: m.token(repoScope(repo))},
+			PingURL: "http://" + peer + endpointPing,
+		})
+	}
+	return mirrors
+}
+
+// AddMirrors puts peers serving blobs of the RAFS bootstrap before other mirrors of the
+// nydusd configuration. The blobs are served to peers pulling the same image repository
+// once they are downloaded.
+func (m *Manager) AddMirrors(c daemonconfig.DaemonConfig, bootstrap string) {
+	backendType, backend := c.StorageBackend()
+	if backendType != "registry" {
+		return
+	}
+
+	blobs, err := bootstrapBlobs(bootstrap)
+	if err != nil {
+		log.L.WithError(err).Warnf("failed to get blobs of bootstrap %s", bootstrap)
+		return
+	}
+	m.store.addRepo(backend.Repo, blobs)
+	if mirrors := m.Mirrors(backend.Repo, blobs); len(mirrors) > 0 {
+		backend.Mirrors = append(mirrors, backend.Mirrors...)
+	}
+}
+
+// Blob IDs in the bootstrap are sha256 digests of the blobs.
+func bootstrapBlobs(bootstrap string) ([]digest.Digest, error) {
+	ids, err := rafs.BootstrapBlobs(bootstrap)
+	if err != nil {
+		return nil, err
+	}
+	blobs := make([]digest.Digest, 0, len(ids))
+	for _, id := range ids {
+		blobs = append(blobs, digest.NewDigestFromEncoded(digest.SHA256, id))
+	}
+	return blobs, nil
+}
@@ -0,0 +1,343 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+const testChunkSize = 512
+
+func freeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// Write a nydusd chunk map of the blob cache file.
+func writeChunkMap(t *testing.T, dataFile string, allReady bool, bitmap []byte) {
+	var buf bytes.Buffer
+	header := [4]uint32{chunkMapMagic, 1, chunkMapMagic2, 0}
+	if allReady {
+		header[3] = chunkMapMagicAllReady
+	}
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, header))
+	buf.Write(make([]byte, chunkMapHeaderSize-buf.Len()))
+	buf.Write(bitmap)
+	require.NoError(t, os.WriteFile(dataFile+chunkMapFileSuffix, buf.Bytes(), 0644))
+}
+
+func writeTokenFile(t *testing.T, secret string) string {
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(path, []byte(secret+"\n"), 0600))
+	return path
+}
+
+// Fetch the blob chunk by chunk like nydusd with compressed blob cache, trying mirrors
+// before the registry. The chunk map is marked all ready once all chunks are downloaded.
+func fetchBlob(t *testing.T, node string, c *daemonconfig.FuseDaemonConfig, cacheDir string, dgst digest.Digest, size int) {
+	_, backend := c.StorageBackend()
+	dataFile := filepath.Join(cacheDir, dgst.Encoded()+dataFileSuffix)
+	f, err := os.Create(dataFile)
+	require.NoError(t, err)
+	defer f.Close()
+
+	hosts := append(backend.Mirrors, daemonconfig.MirrorConfig{Host: backend.Scheme + "://" + backend.Host})
+	for offset := 0; offset < size; offset += testChunkSize {
+		end := offset + testChunkSize
+		if end > size {
+			end = size
+		}
+		var chunk []byte
+		for _, host := range hosts {
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/v2/%s/blobs/%s", host.Host, backend.Repo, dgst), nil)
+			require.NoError(t, err)
+			req.Header.Set("User-Agent", node)
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, end-1))
+			for k, v := range host.Headers {
+				req.Header.Set(k, v)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			data, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			require.NoError(t, err)
+			if resp.StatusCode == http.StatusPartialContent {
+				chunk = data
+				break
+			}
+		}
+		require.Len(t, chunk, end-offset)
+		_, err := f.WriteAt(chunk, int64(offset))
+		require.NoError(t, err)
+	}
+
+	writeChunkMap(t, dataFile, true, nil)
+}
+
+func TestP2PBlobSharing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	blob := bytes.Repeat([]byte("nydus blob shared between nodes."), 64)
+	dgst := digest.FromBytes(blob)
+
+	// The registry counts blob pulls of each node.
+	var mu sync.Mutex
+	pulls := map[string]int{}
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/library/app/blobs/"+dgst.String() {
+			mu.Lock()
+			pulls[r.UserAgent()]++
+			mu.Unlock()
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(blob))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer registry.Close()
+	nodePulls := func(node string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return pulls[node]
+	}
+
+	// The bootstrap of the image only has the blob.
+	bootstrap := filepath.Join(t.TempDir(), "image.boot")
+	writeBootstrap(t, bootstrap, []string{dgst.Encoded()})
+
+	addrA, addrB := freeAddress(t), freeAddress(t)
+	peersFile := filepath.Join(t.TempDir(), "peers")
+	require.NoError(t, os.WriteFile(peersFile, []byte("# peers\n"+addrA+"\n"+addrB+"\n"), 0644))
+	tokenFile := writeTokenFile(t, "secret")
+
+	type node struct {
+		name     string
+		mgr      *Manager
+		cacheDir string
+	}
+	newNode := func(name, addr string) *node {
+		n := &node{name: name, cacheDir: t.TempDir()}
+		var err error
+		n.mgr, err = NewManager(Opt{
+			Config:          config.P2PConfig{Address: addr, PeersFile: peersFile, TokenFile: tokenFile},
+			CacheDir:        n.cacheDir,
+			CompressedCache: true,
+			RootDir:         t.TempDir(),
+		})
+		require.NoError(t, err)
+		require.NoError(t, n.mgr.Start(ctx))
+		return n
+	}
+	// Mount the image, and nydusd of the node reads the whole blob.
+	mount := func(n *node) *daemonconfig.FuseDaemonConfig {
+		c := &daemonconfig.FuseDaemonConfig{Device: &daemonconfig.DeviceConfig{}}
+		c.Device.Backend.BackendType = "registry"
+		c.Device.Backend.Config.Host = registry.Listener.Addr().String()
+		c.Device.Backend.Config.Repo = "library/app"
+		c.Device.Backend.Config.Scheme = "http"
+		n.mgr.AddMirrors(c, bootstrap)
+		fetchBlob(t, n.name, c, n.cacheDir, dgst, len(blob))
+		return c
+	}
+
+	// Node A pulls the blob from the registry since no peer has it.
+	nodeA, nodeB := newNode("a", addrA), newNode("b", addrB)
+	c := mount(nodeA)
+	require.Empty(t, c.Device.Backend.Config.Mirrors)
+	require.Equal(t, len(blob)/testChunkSize, nodePulls("a"))
+
+	require.Eventually(t, func() bool {
+		nodeA.mgr.refresh(ctx)
+		nodeB.mgr.refresh(ctx)
+		return len(nodeB.mgr.Mirrors("library/app", []digest.Digest{dgst})) == 1
+	}, 5*time.Second, 100*time.Millisecond)
+	// Node B itself is not a peer.
+	require.Len(t, nodeB.mgr.peers, 1)
+
+	// Node B gets the whole blob from node A without pulling from the registry.
+	c = mount(nodeB)
+	require.Len(t, c.Device.Backend.Config.Mirrors, 1)
+	require.Equal(t, "http://"+addrA, c.Device.Backend.Config.Mirrors[0].Host)
+	require.Equal(t, 0, nodePulls("b"))
+	data, err := os.ReadFile(filepath.Join(nodeB.cacheDir, dgst.Encoded()+dataFileSuffix))
+	require.NoError(t, err)
+	require.Equal(t, blob, data)
+
+	// Node B serves the blob too once it's downloaded.
+	require.Eventually(t, func() bool {
+		nodeA.mgr.refresh(ctx)
+		nodeB.mgr.refresh(ctx)
+		return len(nodeA.mgr.Mirrors("library/app", []digest.Digest{dgst})) == 1
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestServeBlob(t *testing.T) {
+	blob := []byte("nydus blob")
+	dgst := digest.FromBytes(blob)
+	cacheDir := t.TempDir()
+	dataFile := filepath.Join(cacheDir, dgst.Encoded()+dataFileSuffix)
+	require.NoError(t, os.WriteFile(dataFile, blob, 0644))
+	writeChunkMap(t, dataFile, true, nil)
+
+	m, err := NewManager(Opt{
+		Config:          config.P2PConfig{TokenFile: writeTokenFile(t, "secret")},
+		CacheDir:        cacheDir,
+		CompressedCache: true,
+		RootDir:         t.TempDir(),
+	})
+	require.NoError(t, err)
+	m.store.addRepo("library/app", []digest.Digest{dgst})
+	m.localBlobs = m.store.list()
+	ts := httptest.NewServer(m.handler())
+	defer ts.Close()
+
+	get := func(path, token string) int {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set(tokenHeader, token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Nydusd pings mirrors without tokens.
+	require.Equal(t, http.StatusOK, get(endpointPing, ""))
+
+	require.Equal(t, http.StatusUnauthorized, get(endpointBlobs, ""))
+	require.Equal(t, http.StatusUnauthorized, get(endpointBlobs, m.token(repoScope("library/app"))))
+	require.Equal(t, http.StatusOK, get(endpointBlobs, m.token(peerScope)))
+
+	// Peers with another secret are not authenticated.
+	other, err := NewManager(Opt{
+		Config:   config.P2PConfig{TokenFile: writeTokenFile(t, "other")},
+		CacheDir: t.TempDir(),
+		RootDir:  t.TempDir(),
+	})
+	require.NoError(t, err)
+	_, err = other.peerBlobs(context.Background(), ts.Listener.Addr().String())
+	require.ErrorContains(t, err, "401")
+	blobs, err := m.peerBlobs(context.Background(), ts.Listener.Addr().String())
+	require.NoError(t, err)
+	require.Nil(t, blobs, "the node itself")
+
+	// Blobs are only served with tokens of repositories they belong to.
+	path := "/v2/library/app/blobs/" + dgst.String()
+	require.Equal(t, http.StatusUnauthorized, get(path, ""))
+	require.Equal(t, http.StatusUnauthorized, get(path, m.token(peerScope)))
+	require.Equal(t, http.StatusUnauthorized, get(path, m.token(repoScope("library/other"))))
+	require.Equal(t, http.StatusNotFound, get("/v2/library/other/blobs/"+dgst.String(), m.token(repoScope("library/other"))))
+	require.Equal(t, http.StatusOK, get(path, m.token(repoScope("library/app"))))
+
+	// Nydusd gets blobs from peers with tokens of the repository.
+	m.peers = map[string]map[digest.Digest]struct{}{"peer:9753": {dgst: {}}}
+	mirrors := m.Mirrors("library/app", []digest.Digest{dgst})
+	require.Len(t, mirrors, 1)
+	require.Equal(t, map[string]string{tokenHeader: m.token(repoScope("library/app"))}, mirrors[0].Headers)
+}
+
+func TestBlobStore(t *testing.T) {
+	cacheDir := t.TempDir()
+	indexFile := filepath.Join(t.TempDir(), "p2p", "blobs.json")
+	s, err := newBlobStore(cacheDir, indexFile, true)
+	require.NoError(t, err)
+
+	blob := func(name string) (digest.Digest, string) {
+		dgst := digest.FromString(name)
+		dataFile := filepath.Join(cacheDir, dgst.Encoded()+dataFileSuffix)
+		require.NoError(t, os.WriteFile(dataFile, []byte(name), 0644))
+		return dgst, dataFile
+	}
+	complete, completeFile := blob("complete")
+	writeChunkMap(t, completeFile, true, []byte{0xff, 0x07})
+	// Nydusd hasn't marked it as all ready yet.
+	allSet, allSetFile := blob("all set")
+	writeChunkMap(t, allSetFile, false, []byte{0xff, 0xff})
+	partial, partialFile := blob("partial")
+	writeChunkMap(t, partialFile, false, []byte{0xff, 0x07})
+	noChunkMap, _ := blob("no chunk map")
+	// Downloaded by nydusd before v2.1
+	legacy := digest.FromString("legacy")
+	legacyFile := filepath.Join(cacheDir, legacy.Encoded())
+	require.NoError(t, os.WriteFile(legacyFile, []byte("legacy"), 0644))
+	writeChunkMap(t, legacyFile, true, nil)
+	// Not mounted on this node.
+	unknown, unknownFile := blob("unknown")
+	writeChunkMap(t, unknownFile, true, nil)
+	// Removed by GC.
+	removed := digest.FromString("removed")
+
+	all := []digest.Digest{complete, allSet, partial, noChunkMap, legacy, removed}
+	s.addRepo("library/app", all)
+	require.ElementsMatch(t, []digest.Digest{complete, allSet, legacy}, s.list())
+	require.NotContains(t, s.repos, removed)
+	require.NotContains(t, s.repos, unknown)
+
+	f, ok := s.open(complete, "library/app")
+	require.True(t, ok)
+	f.Close()
+	_, ok = s.open(complete, "library/other")
+	require.False(t, ok)
+	_, ok = s.open(partial, "library/app")
+	require.False(t, ok)
+
+	// Repositories of blobs are persisted.
+	s, err = newBlobStore(cacheDir, indexFile, true)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []digest.Digest{complete, allSet, legacy}, s.list())
+
+	// Blob caches of uncompressed chunks are not the blobs.
+	s, err = newBlobStore(cacheDir, indexFile, false)
+	require.NoError(t, err)
+	require.Empty(t, s.list())
+}
+
+// Write a RAFS v6 bootstrap with only the superblock and the device table of the blobs.
+func writeBootstrap(t *testing.T, path string, blobs []string) {
+	const superOffset, slotSize, slotOffset = 1024, 128, 16
+	data := make([]byte, (slotOffset+len(blobs))*slotSize)
+	binary.LittleEndian.PutUint32(data[superOffset:], 0xE0F5E1E2)
+	binary.LittleEndian.PutUint16(data[superOffset+86:], uint16(len(blobs)))
+	binary.LittleEndian.PutUint16(data[superOffset+88:], slotOffset)
+	for i, id := range blobs {
+		copy(data[(slotOffset+i)*slotSize:], id)
+	}
+	require.NoError(t, os.WriteFile(path, data, 0644))
+}
+
+func TestBootstrapBlobs(t *testing.T) {
+	dgst := digest.FromString("blob")
+	bootstrap := filepath.Join(t.TempDir(), "image.boot")
+	writeBootstrap(t, bootstrap, []string{dgst.Encoded()})
+	blobs, err := bootstrapBlobs(bootstrap)
+	require.NoError(t, err)
+	require.Equal(t, []digest.Digest{dgst}, blobs)
+
+	_, err = NewManager(Opt{Config: config.P2PConfig{TokenFile: writeTokenFile(t, " ")}})
+	require.ErrorContains(t, err, "is empty")
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package p2p
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// Peers exchange blobs they serve by this endpoint.
+	endpointBlobs = "/api/v1/blobs"
+	// Nydusd pings mirrors by this endpoint.
+	endpointPing = "/v2/"
+
+	// Peers and nydusd authenticate by tokens derived from the shared secret in this header.
+	tokenHeader = "X-Nydus-P2P-Token"
+	// Scope of tokens to get blobs served by peers.
+	peerScope = "peer"
+)
+
+// Peers serve blobs by the registry API so that nydusd can use them as registry mirrors.
+var blobPathPattern = regexp.MustCompile(`^/v2/(.+)/blobs/(sha256:[0-9a-f]{64})$`)
+
+type blobsResponse struct {
+	Node  string          `json:"node"`
+	Blobs []digest.Digest `json:"blobs"`
+}
+
+// Blobs are only served with a token of the image repository they belong to, so that a token
+// leaked from nydusd configuration can't be used to get blobs of other images.
+func repoScope(repo string) string {
+	return "repo:" + repo
+}
+
+func (m *Manager) token(scope string) string {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(scope))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (m *Manager) authorized(r *http.Request, scope string) bool {
+	return hmac.Equal([]byte(r.Header.Get(tokenHeader)), []byte(m.token(scope)))
+}
+
+func (m *Manager) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(endpointBlobs, m.serveBlobList)
+	mux.HandleFunc(endpointPing, m.serveBlob)
+	return mux
+}
+
+func (m *Manager) serveBlobList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !m.authorized(r, peerScope) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	m.mu.Lock()
+	resp := blobsResponse{Node: m.nodeID, Blobs: m.localBlobs}
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.L.WithError(err).Warnf("failed to send blob list to %s", r.RemoteAddr)
+	}
+}
+
+func (m *Manager) serveBlob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	if r.URL.Path == endpointPing {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	matches := blobPathPattern.FindStringSubmatch(r.URL.Path)
+	if matches == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	repo, dgst := matches[1], digest.Digest(matches[2])
+	if !m.authorized(r, repoScope(repo)) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	f, ok := m.store.open(dgst, repo)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	log.L.Debugf("serve blob %s of %s to peer %s, range %q", dgst, repo, r.RemoteAddr, r.Header.Get("Range"))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+	// Handles range requests and HEAD.
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
@@ -7,54 +7,150 @@
 package prefetch
 
 import (
+	"context"
 	"encoding/json"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/prefetch/types"
 )
 
+// Store persists prefetch lists so that they survive snapshotter restart.
+type Store interface {
+	SavePrefetchEntry(ctx context.Context, e *types.Entry) error
+	DeletePrefetchEntry(ctx context.Context, key string) error
+	WalkPrefetchEntries(ctx context.Context, cb func(e *types.Entry) error) error
+}
+
 type prefetchInfo struct {
-	prefetchMap   map[string]string
+	prefetchMap   map[string]*types.Entry
 	prefetchMutex sync.Mutex
+	store         Store
 }
 
 var Pm prefetchInfo
 
+// SetStore loads all persisted prefetch lists from the store, and
+// saves prefetch lists to the store from now on.
+func (p *prefetchInfo) SetStore(s Store) error {
+	p.prefetchMutex.Lock()
+	defer p.prefetchMutex.Unlock()
+
+	if p.prefetchMap == nil {
+		p.prefetchMap = make(map[string]*types.Entry)
+	}
+	if err := s.WalkPrefetchEntries(context.Background(), func(e *types.Entry) error {
+		p.prefetchMap[e.Key()] = e
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "load prefetch lists")
+	}
+	p.store = s
+
+	log.L.Infof("loaded %d prefetch lists", len(p.prefetchMap))
+	return nil
+}
+
 func (p *prefetchInfo) SetPrefetchFiles(body []byte) error {
 	p.prefetchMutex.Lock()
 	defer p.prefetchMutex.Unlock()
 
-	var prefetchMsg []map[string]string
+	var prefetchMsg []types.Entry
 	if err := json.Unmarshal(body, &prefetchMsg); err != nil {
-		return err
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "unmarshal prefetch list, %s", err)
 	}
 
 	if p.prefetchMap == nil {
-		p.prefetchMap = make(map[string]string)
+		p.prefetchMap = make(map[string]*types.Entry)
 	}
-	for _, item := range prefetchMsg {
-		image := item["image"]
-		prefetchfiles := item["prefetch"]
-		p.prefetchMap[image] = prefetchfiles
+	now := time.Now()
+	for i := range prefetchMsg {
+		e := &prefetchMsg[i]
+		if e.ImageRef == "" {
+			return errors.Wrap(errdefs.ErrInvalidArgument, "image of prefetch list is empty")
+		}
+		if e.ManifestDigest != "" {
+			if err := e.ManifestDigest.Validate(); err != nil {
+				return errors.Wrapf(errdefs.ErrInvalidArgument, "digest of image %s, %s", e.ImageRef, err)
+			}
+		}
+		e.UpdatedAt = now
+		if p.store != nil {
+			if err := p.store.SavePrefetchEntry(context.Background(), e); err != nil {
+				return errors.Wrapf(err, "save prefetch list of image %s", e.Key())
+			}
+		}
+		p.prefetchMap[e.Key()] = e
 	}
 
-	log.L.Infof("received prefetch list from nri plugin: %v ", p.prefetchMap)
+	log.L.Infof("received prefetch list from nri plugin: %v ", prefetchMsg)
 	return nil
 }
 
-func (p *prefetchInfo) GetPrefetchInfo(image string) string {
+// GetPrefetchInfo returns the prefetch list of the image, the one recorded for
+// the manifest digest is preferred to the one for the image reference only.
+func (p *prefetchInfo) GetPrefetchInfo(image string, manifestDigest digest.Digest) string {
 	p.prefetchMutex.Lock()
 	defer p.prefetchMutex.Unlock()
 
-	if prefetchfiles, ok := p.prefetchMap[image]; ok {
-		return prefetchfiles
+	if manifestDigest != "" {
+		if e, ok := p.prefetchMap[types.Key(image, manifestDigest)]; ok {
+			return e.PrefetchFiles
+		}
+	}
+	if e, ok := p.prefetchMap[types.Key(image, "")]; ok {
+		return e.PrefetchFiles
 	}
 	return ""
 }
 
-func (p *prefetchInfo) DeleteFromPrefetchMap(image string) {
+// List all prefetch lists ordered by key.
+func (p *prefetchInfo) List() []types.Entry {
+	p.prefetchMutex.Lock()
+	defer p.prefetchMutex.Unlock()
+
+	entries := make([]types.Entry, 0, len(p.prefetchMap))
+	for _, e := range p.prefetchMap {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key() < entries[j].Key()
+	})
+	return entries
+}
+
+func (p *prefetchInfo) Get(image string, manifestDigest digest.Digest) (*types.Entry, error) {
 	p.prefetchMutex.Lock()
 	defer p.prefetchMutex.Unlock()
 
-	delete(p.prefetchMap, image)
+	e, ok := p.prefetchMap[types.Key(image, manifestDigest)]
+	if !ok {
+		return nil, errors.Wrapf(errdefs.ErrNotFound, "prefetch list of image %s", types.Key(image, manifestDigest))
+	}
+	entry := *e
+	return &entry, nil
+}
+
+func (p *prefetchInfo) Delete(image string, manifestDigest digest.Digest) error {
+	p.prefetchMutex.Lock()
+	defer p.prefetchMutex.Unlock()
+
+	key := types.Key(image, manifestDigest)
+	if _, ok := p.prefetchMap[key]; !ok {
+		return errors.Wrapf(errdefs.ErrNotFound, "prefetch list of image %s", key)
+	}
+	if p.store != nil {
+		if err := p.store.DeletePrefetchEntry(context.Background(), key); err != nil {
+			return errors.Wrapf(err, "delete prefetch list of image %s", key)
+		}
+	}
+	delete(p.prefetchMap, key)
+
+	return nil
 }
@@ -0,0 +1,55 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package prefetch_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/pkg/prefetch"
+	"github.com/containerd/nydus-snapshotter/pkg/prefetch/types"
+	"github.com/containerd/nydus-snapshotter/pkg/store"
+)
+
+func TestPersistPrefetchLists(t *testing.T) {
+	rootDir := t.TempDir()
+	db, err := store.NewDatabase(rootDir)
+	require.NoError(t, err)
+
+	ctx := context.TODO()
+	manifestDigest := digest.FromString("manifest")
+	e1 := types.Entry{ImageRef: "docker.io/library/nginx:latest", PrefetchFiles: "/usr/sbin/nginx,/etc/nginx"}
+	e2 := types.Entry{ImageRef: "docker.io/library/nginx:latest", ManifestDigest: manifestDigest, PrefetchFiles: "/etc/nginx"}
+	require.NoError(t, db.SavePrefetchEntry(ctx, &e1))
+	require.NoError(t, db.SavePrefetchEntry(ctx, &e2))
+
+	require.NoError(t, prefetch.Pm.SetStore(db))
+	require.Len(t, prefetch.Pm.List(), 2)
+	require.Equal(t, "/etc/nginx", prefetch.Pm.GetPrefetchInfo(e1.ImageRef, manifestDigest))
+	require.Equal(t, "/usr/sbin/nginx,/etc/nginx", prefetch.Pm.GetPrefetchInfo(e1.ImageRef, digest.FromString("other")))
+
+	require.NoError(t, prefetch.Pm.Delete(e2.ImageRef, manifestDigest))
+	keys := []string{}
+	require.NoError(t, db.WalkPrefetchEntries(ctx, func(e *types.Entry) error {
+		keys = append(keys, e.Key())
+		return nil
+	}))
+	require.Equal(t, []string{e1.Key()}, keys)
+	require.Equal(t, "/usr/sbin/nginx,/etc/nginx", prefetch.Pm.GetPrefetchInfo(e1.ImageRef, manifestDigest))
+
+	// New prefetch lists are saved to the store.
+	require.NoError(t, prefetch.Pm.SetPrefetchFiles([]byte(`[{"image":"docker.io/library/redis:latest","prefetch":"/usr/bin/redis-server"}]`)))
+	keys = keys[:0]
+	require.NoError(t, db.WalkPrefetchEntries(ctx, func(e *types.Entry) error {
+		keys = append(keys, e.Key())
+		return nil
+	}))
+	require.ElementsMatch(t, []string{e1.Key(), "docker.io/library/redis:latest"}, keys)
+}
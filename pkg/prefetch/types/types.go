@@ -0,0 +1,34 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package types
+
+import (
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// Entry is a prefetch list of an image. An entry without manifest digest
+// applies to all images of the reference.
+type Entry struct {
+	ImageRef       string        `json:"image"`
+	ManifestDigest digest.Digest `json:"digest,omitempty"`
+	PrefetchFiles  string        `json:"prefetch"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+}
+
+func (e *Entry) Key() string {
+	return Key(e.ImageRef, e.ManifestDigest)
+}
+
+// Key identifies the prefetch list of an image by its reference and manifest digest.
+func Key(imageRef string, manifestDigest digest.Digest) string {
+	if manifestDigest == "" {
+		return imageRef
+	}
+	return imageRef + "@" + manifestDigest.String()
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package rafs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+const (
+	rafsV5Magic         = 0x52414653
+	rafsV5BlobTableSize = 64 << 20
+
+	// RAFS v6 bootstraps are EROFS images, whose blobs are the extra devices.
+	erofsSuperOffset   = 1024
+	erofsMagic         = 0xE0F5E1E2
+	erofsDevtSlotSize  = 128
+	erofsDevtTagLength = 64
+)
+
+// BootstrapBlobs reads IDs of the data blobs in the blob table of a RAFS v5 or v6 bootstrap.
+func BootstrapBlobs(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open bootstrap %s", path)
+	}
+	defer f.Close()
+
+	var header [erofsSuperOffset + 128]byte
+	if _, err := io.ReadFull(f, header[:]); err != nil {
+		return nil, errors.Wrapf(err, "read superblock of bootstrap %s", path)
+	}
+
+	switch {
+	case binary.LittleEndian.Uint32(header[erofsSuperOffset:]) == erofsMagic:
+		return rafsV6Blobs(f, header[erofsSuperOffset:])
+	case binary.LittleEndian.Uint32(header[:]) == rafsV5Magic:
+		return rafsV5Blobs(f, header[:])
+	default:
+		return nil, errors.Errorf("bootstrap %s is neither RAFS v5 nor v6", path)
+	}
+}
+
+// The blob ID is the tag of each device slot.
+func rafsV6Blobs(r io.ReaderAt, sb []byte) ([]string, error) {
+	devices := int(binary.LittleEndian.Uint16(sb[86:]))
+	slotOffset := int64(binary.LittleEndian.Uint16(sb[88:])) * erofsDevtSlotSize
+
+	table := make([]byte, devices*erofsDevtSlotSize)
+	if _, err := r.ReadAt(table, slotOffset); err != nil {
+		return nil, errors.Wrap(err, "read device table")
+	}
+
+	blobs := make([]string, 0, devices)
+	for i := 0; i < devices; i++ {
+		tag := table[i*erofsDevtSlotSize : i*erofsDevtSlotSize+erofsDevtTagLength]
+		if id := string(bytes.TrimRight(tag, "\x00")); isBlobID(id) {
+			blobs = append(blobs, id)
+		}
+	}
+	return blobs, nil
+}
+
+// Each entry of the blob table is the readahead range followed by the blob ID,
+// entries are separated by NUL and the table is padded to 8 bytes.
+func rafsV5Blobs(r io.ReaderAt, sb []byte) ([]string, error) {
+	offset := int64(binary.LittleEndian.Uint64(sb[48:]))
+	size := binary.LittleEndian.Uint32(sb[64:])
+	if size > rafsV5BlobTableSize {
+		return nil, errors.Errorf("blob table of %d bytes is too large", size)
+	}
+
+	table := make([]byte, size)
+	if _, err := r.ReadAt(table, offset); err != nil {
+		return nil, errors.Wrap(err, "read blob table")
+	}
+
+	var blobs []string
+	for pos := 0; pos+8 < len(table); {
+		entry := table[pos+8:]
+		if end := bytes.IndexByte(entry, 0); end >= 0 {
+			entry = entry[:end]
+		}
+		if id := string(entry); isBlobID(id) {
+			blobs = append(blobs, id)
+		}
+		pos += 8 + len(entry) + 1
+	}
+	return blobs, nil
+}
+
+// Blob IDs are sha256 digests of the blobs.
+func isBlobID(id string) bool {
+	return digest.SHA256.Validate(id) == nil
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package rafs
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Write a RAFS v6 bootstrap with only the superblock and the device table.
+func writeV6Bootstrap(t *testing.T, path string, blobs []string) {
+	const slotOffset = 16
+	data := make([]byte, (slotOffset+len(blobs))*erofsDevtSlotSize)
+	sb := data[erofsSuperOffset:]
+	binary.LittleEndian.PutUint32(sb, erofsMagic)
+	binary.LittleEndian.PutUint16(sb[86:], uint16(len(blobs)))
+	binary.LittleEndian.PutUint16(sb[88:], slotOffset)
+	for i, id := range blobs {
+		copy(data[(slotOffset+i)*erofsDevtSlotSize:], id)
+	}
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, data, 0644))
+}
+
+// Write a RAFS v5 bootstrap with only the superblock and the blob table.
+func writeV5Bootstrap(t *testing.T, path string, blobs []string) {
+	const tableOffset = 8192
+	var table []byte
+	for i, id := range blobs {
+		table = append(table, make([]byte, 8)...)
+		table = append(table, id...)
+		if i < len(blobs)-1 {
+			table = append(table, 0)
+		}
+	}
+	for len(table)%8 != 0 {
+		table = append(table, 0)
+	}
+	data := make([]byte, tableOffset+len(table))
+	binary.LittleEndian.PutUint32(data, rafsV5Magic)
+	binary.LittleEndian.PutUint64(data[48:], tableOffset)
+	binary.LittleEndian.PutUint32(data[64:], uint32(len(table)))
+	copy(data[tableOffset:], table)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, data, 0644))
+}
+
+func TestBootstrapBlobs(t *testing.T) {
+	// Tags which are not blob IDs are skipped.
+	blobs := []string{strings.Repeat("1", 64), "not-a-blob", strings.Repeat("2", 64)}
+	dir := t.TempDir()
+
+	v6 := filepath.Join(dir, "v6.boot")
+	writeV6Bootstrap(t, v6, blobs)
+	got, err := BootstrapBlobs(v6)
+	require.NoError(t, err)
+	require.Equal(t, []string{blobs[0], blobs[2]}, got)
+
+	v5 := filepath.Join(dir, "v5.boot")
+	writeV5Bootstrap(t, v5, blobs)
+	got, err = BootstrapBlobs(v5)
+	require.NoError(t, err)
+	require.Equal(t, []string{blobs[0], blobs[2]}, got)
+
+	unknown := filepath.Join(dir, "unknown.boot")
+	require.NoError(t, os.WriteFile(unknown, make([]byte, 4096), 0644))
+	_, err = BootstrapBlobs(unknown)
+	require.ErrorContains(t, err, "neither RAFS v5 nor v6")
+	_, err = BootstrapBlobs(filepath.Join(dir, "missing.boot"))
+	require.Error(t, err)
+}
@@ -7,10 +7,12 @@
 package rafs
 
 import (
+	"encoding/json"
 	"os"
 	"path"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/mohae/deepcopy"
 	"github.com/pkg/errors"
@@ -23,6 +25,9 @@ import (
 const (
 	AnnoFsCacheDomainID string = "fscache.domainid"
 	AnnoFsCacheID       string = "fscache.id"
+	// Files to prefetch when the instance is mounted, which are passed to nydusd
+	// through command line or mount API rather than the daemon configuration.
+	AnnoPrefetchFiles string = "prefetch.files"
 )
 
 type NewRafsOpt func(r *Rafs) error
@@ -120,6 +125,65 @@ type Rafs struct {
 	// 2. Absolute path to each rafs instance root directory.
 	Mountpoint  string
 	Annotations map[string]string
+	// State of the latest recovery after the serving nydusd daemon died.
+	Recovery *RecoveryState `json:",omitempty"`
+}
+
+const (
+	RecoveryStateRecovering = "RECOVERING"
+	RecoveryStateRecovered  = "RECOVERED"
+	RecoveryStateFailed     = "FAILED"
+)
+
+// RecoveryState records how a RAFS instance is recovered after its nydusd daemon dies.
+type RecoveryState struct {
+	State     string    `json:"state"`
+	Attempts  uint      `json:"attempts"`
+	LastError string    `json:"last_error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Recovery states are updated while instances are listed, copied or persisted by others.
+var recoveryLock sync.RWMutex
+
+// Get the state of the latest recovery, which is never modified once it's set.
+func (r *Rafs) GetRecovery() *RecoveryState {
+	recoveryLock.RLock()
+	defer recoveryLock.RUnlock()
+	return r.Recovery
+}
+
+func (r *Rafs) SetRecovery(s *RecoveryState) {
+	recoveryLock.Lock()
+	defer recoveryLock.Unlock()
+	r.Recovery = s
+}
+
+// DeepCopy is used by deepcopy.Copy() to copy instances in caches.
+func (r *Rafs) DeepCopy() interface{} {
+	if r == nil {
+		return r
+	}
+	recoveryLock.RLock()
+	c := *r
+	recoveryLock.RUnlock()
+
+	if r.Annotations != nil {
+		c.Annotations = make(map[string]string, len(r.Annotations))
+		for k, v := range r.Annotations {
+			c.Annotations[k] = v
+		}
+	}
+	return &c
+}
+
+func (r *Rafs) MarshalJSON() ([]byte, error) {
+	// Without methods of Rafs to avoid recursion.
+	type rafs Rafs
+	recoveryLock.RLock()
+	c := rafs(*r)
+	recoveryLock.RUnlock()
+	return json.Marshal(&c)
 }
 
 func NewRafs(snapshotID, imageID, fsDriver string) (*Rafs, error) {
@@ -145,6 +209,11 @@ func (r *Rafs) AddAnnotation(k, v string) {
 	r.Annotations[k] = v
 }
 
+// Files to prefetch in nydusd when the instance is mounted, separated by commas.
+func (r *Rafs) PrefetchFiles() string {
+	return r.Annotations[AnnoPrefetchFiles]
+}
+
 func (r *Rafs) GetSnapshotDir() string {
 	return r.SnapshotDir
 }
@@ -8,9 +8,15 @@ package referrer
 
 import (
 	"context"
+	"sync"
+	"time"
 
+	"github.com/containerd/containerd/v2/pkg/tracing"
 	"github.com/containerd/log"
 	"github.com/containerd/nydus-snapshotter/pkg/auth"
+	"github.com/containerd/nydus-snapshotter/pkg/encryption"
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/platforms"
 	"github.com/golang/groupcache/lru"
 	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
@@ -18,30 +24,133 @@ import (
 	"golang.org/x/sync/singleflight"
 )
 
+const defaultCacheTTL = 10 * time.Minute
+
+// Prefix of span names of referrer operations.
+const spanPrefix = "nydus.referrer"
+
+// Policy selects the nydus image among referrers of an image manifest, which may
+// also be SBOMs, signatures or nydus images of other versions and platforms.
+type Policy struct {
+	// Only select referrers of the artifact type if it's not empty.
+	ArtifactType string
+	// Only select referrers having all the annotations, which are looked up in the
+	// referrer descriptor, the referrer manifest and its nydus meta layer in order.
+	Annotations map[string]string
+	// Only select referrers for the platform if they declare platforms. It's the
+	// platform of the node if it's nil.
+	Platform platforms.MatchComparer
+}
+
+// Referrer is the nydus image selected among referrers of an image manifest.
+type Referrer struct {
+	// Descriptor of the referrer manifest.
+	Manifest ocispec.Descriptor
+	// Nydus meta layer of the referrer manifest.
+	MetaLayer ocispec.Descriptor
+}
+
+// Cached result of checking referrers of an image manifest.
+type cacheEntry struct {
+	// Nil if no nydus image is found among referrers.
+	referrer *Referrer
+	expireAt time.Time
+}
+
 type Manager struct {
 	insecure bool
+	policy   Policy
+	ttl      time.Duration
+	mu       sync.Mutex
 	cache    *lru.Cache
 	sg       singleflight.Group
+	// Decrypt encrypted nydus metadata layers if it's not nil.
+	decryptor *encryption.Decryptor
+}
+
+type Opt func(manager *Manager)
+
+func WithDecryptor(decryptor *encryption.Decryptor) Opt {
+	return func(manager *Manager) {
+		manager.decryptor = decryptor
+	}
+}
+
+func WithPolicy(policy Policy) Opt {
+	return func(manager *Manager) {
+		manager.policy = policy
+	}
+}
+
+// WithCacheTTL sets how long the nydus image selected among referrers, or the lack
+// of one, is cached for an image manifest.
+func WithCacheTTL(ttl time.Duration) Opt {
+	return func(manager *Manager) {
+		manager.ttl = ttl
+	}
 }
 
-func NewManager(insecure bool) *Manager {
+func NewManager(insecure bool, opts ...Opt) *Manager {
 	manager := Manager{
 		insecure: insecure,
+		ttl:      defaultCacheTTL,
 		cache:    lru.New(500),
 		sg:       singleflight.Group{},
 	}
+	for _, o := range opts {
+		o(&manager)
+	}
+	if manager.policy.Platform == nil {
+		manager.policy.Platform = platforms.Default()
+	}
 
 	return &manager
 }
 
-// CheckReferrer attempts to fetch the referrers and parse out
+func (manager *Manager) getCache(manifestDigest digest.Digest) (*cacheEntry, bool) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	v, ok := manager.cache.Get(manifestDigest)
+	if !ok {
+		return nil, false
+	}
+	entry := v.(*cacheEntry)
+	if time.Now().After(entry.expireAt) {
+		manager.cache.Remove(manifestDigest)
+		return nil, false
+	}
+	return entry, true
+}
+
+func (manager *Manager) addCache(manifestDigest digest.Digest, referrer *Referrer) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	manager.cache.Add(manifestDigest, &cacheEntry{
+		referrer: referrer,
+		expireAt: time.Now().Add(manager.ttl),
+	})
+}
+
+// CheckReferrer attempts to fetch the referrers and select
 // the nydus image by specified manifest digest.
-func (manager *Manager) CheckReferrer(ctx context.Context, ref string, manifestDigest digest.Digest) (*ocispec.Descriptor, error) {
-	metaLayer, err, _ := manager.sg.Do(manifestDigest.String(), func() (interface{}, error) {
-		// Try to get nydus metadata layer descriptor from LRU cache.
-		if metaLayer, ok := manager.cache.Get(manifestDigest); ok {
-			desc := metaLayer.(ocispec.Descriptor)
-			return &desc, nil
+func (manager *Manager) CheckReferrer(ctx context.Context, ref string, manifestDigest digest.Digest) (_ *Referrer, retErr error) {
+	ctx, span := tracing.StartSpan(ctx, tracing.Name(spanPrefix, "CheckReferrer"),
+		tracing.WithAttribute("image.ref", ref),
+		tracing.WithAttribute("manifest.digest", manifestDigest.String()))
+	defer func() {
+		span.SetStatus(retErr)
+		span.End()
+	}()
+
+	referrer, err, _ := manager.sg.Do(manifestDigest.String(), func() (interface{}, error) {
+		// Try to get the selected nydus image from cache.
+		if entry, ok := manager.getCache(manifestDigest); ok {
+			if entry.referrer == nil {
+				return nil, errors.Wrapf(errdefs.ErrNotFound, "no nydus image among referrers of %s", manifestDigest)
+			}
+			return entry.referrer, nil
 		}
 
 		keyChain, err := auth.GetKeyChainByRef(ref, nil)
@@ -49,40 +158,61 @@ func (manager *Manager) CheckReferrer(ctx context.Context, ref string, manifestD
 			return nil, errors.Wrap(err, "get key chain")
 		}
 
-		// No LRU cache found, try to fetch referrers and parse out
-		// the nydus metadata layer descriptor.
-		referrer := newReferrer(keyChain, manager.insecure)
-		metaLayer, err := referrer.checkReferrer(ctx, ref, manifestDigest)
+		// No cache found, try to fetch referrers and select the nydus image.
+		referrer, err := newReferrer(keyChain, manager.insecure).checkReferrer(ctx, ref, manifestDigest, &manager.policy)
 		if err != nil {
+			// Images without nydus referrers are remembered as well, so that
+			// referrers are not fetched for each layer of them.
+			if errdefs.IsNotFound(err) {
+				manager.addCache(manifestDigest, nil)
+			}
 			return nil, errors.Wrap(err, "check referrer")
 		}
 
-		// FIXME: how to invalidate the LRU cache if referrers update?
-		manager.cache.Add(manifestDigest, *metaLayer)
+		manager.addCache(manifestDigest, referrer)
 
-		return metaLayer, nil
+		return referrer, nil
 	})
 
 	if err != nil {
-		log.L.WithField("ref", ref).WithError(err).Warn("check referrer")
+		logger := log.L.WithField("ref", ref).WithError(err)
+		if errdefs.IsNotFound(err) {
+			logger.Debug("check referrer")
+		} else {
+			logger.Warn("check referrer")
+		}
 		return nil, err
 	}
 
-	return metaLayer.(*ocispec.Descriptor), nil
+	return referrer.(*Referrer), nil
 }
 
-// TryFetchMetadata try to fetch and unpack nydus metadata file to specified path.
-func (manager *Manager) TryFetchMetadata(ctx context.Context, ref string, manifestDigest digest.Digest, metadataPath string) error {
-	metaLayer, err := manager.CheckReferrer(ctx, ref, manifestDigest)
+// TryFetchMetadata try to fetch and unpack nydus metadata file to specified path,
+// returns the selected nydus image.
+func (manager *Manager) TryFetchMetadata(ctx context.Context, ref string, manifestDigest digest.Digest, metadataPath string) (_ *Referrer, retErr error) {
+	ctx, span := tracing.StartSpan(ctx, tracing.Name(spanPrefix, "TryFetchMetadata"),
+		tracing.WithAttribute("image.ref", ref),
+		tracing.WithAttribute("manifest.digest", manifestDigest.String()))
+	defer func() {
+		span.SetStatus(retErr)
+		span.End()
+	}()
+
+	referrer, err := manager.CheckReferrer(ctx, ref, manifestDigest)
 	if err != nil {
-		return errors.Wrap(err, "check referrer")
+		return nil, errors.Wrap(err, "check referrer")
 	}
 
 	keyChain, err := auth.GetKeyChainByRef(ref, nil)
 	if err != nil {
-		return errors.Wrap(err, "get key chain")
+		return nil, errors.Wrap(err, "get key chain")
+	}
+
+	r := newReferrer(keyChain, manager.insecure)
+	r.decryptor = manager.decryptor
+	if err := r.fetchMetadata(ctx, ref, referrer.MetaLayer, metadataPath); err != nil {
+		return nil, err
 	}
 
-	referrer := newReferrer(keyChain, manager.insecure)
-	return referrer.fetchMetadata(ctx, ref, *metaLayer, metadataPath)
+	return referrer, nil
 }
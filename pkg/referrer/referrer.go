@@ -8,15 +8,16 @@ package referrer
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
 	"os"
 
 	"github.com/containerd/nydus-snapshotter/pkg/auth"
+	"github.com/containerd/nydus-snapshotter/pkg/encryption"
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
 	"github.com/containerd/nydus-snapshotter/pkg/label"
 	"github.com/containerd/nydus-snapshotter/pkg/remote"
 
+	"github.com/containerd/log"
 	"github.com/containerd/nydus-snapshotter/pkg/remote/remotes"
 	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
@@ -27,8 +28,12 @@ import (
 const maxManifestIndexSize = 0x800000
 const metadataNameInLayer = "image/image.boot"
 
+// At most how many referrer manifests are fetched to select the nydus image.
+const maxReferrerManifests = 16
+
 type referrer struct {
-	remote *remote.Remote
+	remote    *remote.Remote
+	decryptor *encryption.Decryptor
 }
 
 func newReferrer(keyChain *auth.PassKeyChain, insecure bool) *referrer {
@@ -37,19 +42,24 @@ func newReferrer(keyChain *auth.PassKeyChain, insecure bool) *referrer {
 	}
 }
 
-// checkReferrer fetches the referrers and parses out the nydus
-// image by specified manifest digest.
+// checkReferrer fetches the referrers and selects the nydus
+// image by specified manifest digest and the policy.
 // it's using distribution list referrers API.
-func (r *referrer) checkReferrer(ctx context.Context, ref string, manifestDigest digest.Digest) (*ocispec.Descriptor, error) {
-	handle := func() (*ocispec.Descriptor, error) {
+func (r *referrer) checkReferrer(ctx context.Context, ref string, manifestDigest digest.Digest, policy *Policy) (*Referrer, error) {
+	handle := func() (*Referrer, error) {
 		// Create an new resolver to request.
 		fetcher, err := r.remote.Fetcher(ctx, ref)
 		if err != nil {
 			return nil, errors.Wrap(err, "get fetcher")
 		}
 
-		// Fetch image referrers from remote registry.
-		rc, _, err := fetcher.(remotes.ReferrersFetcher).FetchReferrers(ctx, manifestDigest)
+		// Fetch image referrers from remote registry, registries falling back
+		// to the tag schema may ignore the artifact type filter.
+		var artifactTypes []string
+		if policy.ArtifactType != "" {
+			artifactTypes = append(artifactTypes, policy.ArtifactType)
+		}
+		rc, _, err := fetcher.(remotes.ReferrersFetcher).FetchReferrers(ctx, manifestDigest, artifactTypes...)
 		if err != nil {
 			return nil, errors.Wrap(err, "fetch referrers")
 		}
@@ -57,50 +67,102 @@ func (r *referrer) checkReferrer(ctx context.Context, ref string, manifestDigest
 
 		// Parse image manifest list from referrers.
 		var index ocispec.Index
-		bytes, err := io.ReadAll(io.LimitReader(rc, maxManifestIndexSize))
-		if err != nil {
-			return nil, errors.Wrap(err, "read referrers")
-		}
-		if err := json.Unmarshal(bytes, &index); err != nil {
-			return nil, errors.Wrap(err, "unmarshal referrers index")
-		}
-		if len(index.Manifests) == 0 {
-			return nil, fmt.Errorf("empty referrer list")
+		if err := readJSON(rc, maxManifestIndexSize, &index); err != nil {
+			return nil, errors.Wrap(err, "read referrers index")
 		}
 
-		// Prefer to fetch the last manifest and check if it is a nydus image.
-		// TODO: should we search by matching ArtifactType?
-		rc, err = fetcher.Fetch(ctx, index.Manifests[0])
-		if err != nil {
-			return nil, errors.Wrap(err, "fetch referrers")
+		var checked int
+		for _, desc := range index.Manifests {
+			if !policy.matchDescriptor(desc) {
+				continue
+			}
+			if checked >= maxReferrerManifests {
+				log.L.Warnf("too many referrers of %s, ignore the rest", manifestDigest)
+				break
+			}
+			checked++
+
+			metaLayer, err := checkManifest(ctx, fetcher, desc, policy)
+			if err != nil {
+				log.L.WithError(err).Debugf("skip referrer %s of %s", desc.Digest, manifestDigest)
+				continue
+			}
+
+			return &Referrer{Manifest: desc, MetaLayer: *metaLayer}, nil
 		}
-		defer rc.Close()
 
-		var manifest ocispec.Manifest
-		bytes, err = io.ReadAll(rc)
-		if err != nil {
-			return nil, errors.Wrap(err, "read manifest")
-		}
-		if err := json.Unmarshal(bytes, &manifest); err != nil {
-			return nil, errors.Wrap(err, "unmarshal manifest")
-		}
-		if len(manifest.Layers) < 1 {
-			return nil, fmt.Errorf("invalid manifest")
+		return nil, errors.Wrapf(errdefs.ErrNotFound, "no nydus image among %d referrers", len(index.Manifests))
+	}
+
+	referrer, err := handle()
+	if err != nil && r.remote.RetryWithPlainHTTP(ref, err) {
+		return handle()
+	}
+
+	return referrer, err
+}
+
+// Filter referrers by their descriptors before fetching the manifests.
+func (p *Policy) matchDescriptor(desc ocispec.Descriptor) bool {
+	if desc.ArtifactType == SignatureArtifactType {
+		return false
+	}
+	// Referrers without artifact types are checked by their manifests.
+	if p.ArtifactType != "" && desc.ArtifactType != "" && desc.ArtifactType != p.ArtifactType {
+		return false
+	}
+	if desc.Platform != nil && !p.Platform.Match(*desc.Platform) {
+		return false
+	}
+	return true
+}
+
+// Check whether the referrer manifest is a nydus image accepted by the policy,
+// returns its nydus meta layer.
+func checkManifest(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor, policy *Policy) (*ocispec.Descriptor, error) {
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch manifest")
+	}
+	defer rc.Close()
+
+	var manifest ocispec.Manifest
+	if err := readJSON(rc, maxManifestIndexSize, &manifest); err != nil {
+		return nil, errors.Wrap(err, "read manifest")
+	}
+	if len(manifest.Layers) < 1 {
+		return nil, fmt.Errorf("invalid manifest")
+	}
+
+	if policy.ArtifactType != "" {
+		artifactType := manifest.ArtifactType
+		if artifactType == "" {
+			artifactType = manifest.Config.MediaType
 		}
-		metaLayer := manifest.Layers[len(manifest.Layers)-1]
-		if !label.IsNydusMetaLayer(metaLayer.Annotations) {
-			return nil, fmt.Errorf("invalid nydus manifest")
+		if artifactType != policy.ArtifactType {
+			return nil, errors.Errorf("artifact type %s mismatches", artifactType)
 		}
+	}
 
-		return &metaLayer, nil
+	metaLayer := manifest.Layers[len(manifest.Layers)-1]
+	if !label.IsNydusMetaLayer(metaLayer.Annotations) {
+		return nil, fmt.Errorf("invalid nydus manifest")
 	}
 
-	desc, err := handle()
-	if err != nil && r.remote.RetryWithPlainHTTP(ref, err) {
-		return handle()
+	for key, expected := range policy.Annotations {
+		value, ok := desc.Annotations[key]
+		if !ok {
+			value, ok = manifest.Annotations[key]
+		}
+		if !ok {
+			value = metaLayer.Annotations[key]
+		}
+		if value != expected {
+			return nil, errors.Errorf("annotation %s=%q mismatches %q", key, value, expected)
+		}
 	}
 
-	return desc, err
+	return &metaLayer, nil
 }
 
 // fetchMetadata fetches and unpacks nydus metadata file to specified path.
@@ -120,6 +182,13 @@ func (r *referrer) fetchMetadata(ctx context.Context, ref string, desc ocispec.D
 		}
 		defer rc.Close()
 
+		if encryption.IsEncryptedMediaType(desc.MediaType) {
+			if r.decryptor == nil {
+				return errors.Errorf("nydus metadata layer %s is encrypted but decryption is not configured", desc.Digest)
+			}
+			return r.decryptor.UnpackBootstrap(desc, rc, metadataNameInLayer, metadataPath)
+		}
+
 		if err := remote.Unpack(rc, metadataNameInLayer, metadataPath); err != nil {
 			os.Remove(metadataPath)
 			return errors.Wrap(err, "unpack metadata from layer")
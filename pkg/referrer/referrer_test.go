@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package referrer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/label"
+)
+
+const (
+	nydusArtifactType = "application/vnd.nydus.image.v1"
+	fsVersion         = "containerd.io/snapshot/nydus-fs-version"
+)
+
+// Serve referrers of the image manifest `subject` in repository `library/app`, returns
+// the registry and the counter of referrers requests.
+func newRegistry(t *testing.T, subject digest.Digest, referrers []ocispec.Descriptor, contents map[digest.Digest][]byte) (*httptest.Server, *int32) {
+	index, err := json.Marshal(ocispec.Index{MediaType: ocispec.MediaTypeImageIndex, Manifests: referrers})
+	require.NoError(t, err)
+
+	var requests int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/":
+		case r.URL.Path == "/v2/library/app/referrers/"+subject.String():
+			// Ignore the artifact type filter like registries falling back to the tag schema.
+			atomic.AddInt32(&requests, 1)
+			w.Header().Set("Content-Type", ocispec.MediaTypeImageIndex)
+			_, _ = w.Write(index)
+		case strings.HasPrefix(r.URL.Path, "/v2/library/app/manifests/"):
+			data, ok := contents[digest.Digest(strings.TrimPrefix(r.URL.Path, "/v2/library/app/manifests/"))]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+			w.Header().Set("Docker-Content-Digest", digest.FromBytes(data).String())
+			_, _ = w.Write(data)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})), &requests
+}
+
+func TestCheckReferrer(t *testing.T) {
+	contents := map[digest.Digest][]byte{}
+	referrer := func(artifactType string, platform *ocispec.Platform, layerAnnotations map[string]string) ocispec.Descriptor {
+		layer := ocispec.Descriptor{
+			MediaType:   ocispec.MediaTypeImageLayerGzip,
+			Digest:      digest.FromString(artifactType + platforms.FormatAll(*platform) + layerAnnotations[fsVersion]),
+			Size:        1,
+			Annotations: layerAnnotations,
+		}
+		data, err := json.Marshal(ocispec.Manifest{
+			MediaType:    ocispec.MediaTypeImageManifest,
+			ArtifactType: artifactType,
+			Config:       ocispec.DescriptorEmptyJSON,
+			Layers:       []ocispec.Descriptor{layer},
+		})
+		require.NoError(t, err)
+		dgst := digest.FromBytes(data)
+		contents[dgst] = data
+		// Artifact types are left to be checked in manifests.
+		return ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: dgst, Size: int64(len(data)), Platform: platform}
+	}
+
+	node := platforms.DefaultSpec()
+	other := ocispec.Platform{OS: "windows", Architecture: "arm"}
+	nydus := func(version string) map[string]string {
+		return map[string]string{label.NydusMetaLayer: "true", fsVersion: version}
+	}
+	signature := referrer(SignatureArtifactType, &node, nil)
+	signature.ArtifactType = SignatureArtifactType
+	referrers := []ocispec.Descriptor{
+		signature,
+		referrer("application/spdx+json", &node, nil),
+		referrer(nydusArtifactType, &node, nydus("5")),
+		referrer(nydusArtifactType, &other, nydus("6")),
+		referrer("application/vnd.example.image", &node, nydus("6")),
+		referrer(nydusArtifactType, &node, nydus("6")),
+	}
+
+	subject := digest.FromString("subject")
+	registry, requests := newRegistry(t, subject, referrers, contents)
+	defer registry.Close()
+	ref := strings.TrimPrefix(registry.URL, "http://") + "/library/app:latest"
+	ctx := context.Background()
+
+	// Select by the artifact type, the platform and the annotation.
+	manager := NewManager(false, WithPolicy(Policy{
+		ArtifactType: nydusArtifactType,
+		Annotations:  map[string]string{fsVersion: "6"},
+	}))
+	selected, err := manager.CheckReferrer(ctx, ref, subject)
+	require.NoError(t, err)
+	require.Equal(t, referrers[5].Digest, selected.Manifest.Digest)
+	require.Equal(t, "6", selected.MetaLayer.Annotations[fsVersion])
+	selected, err = manager.CheckReferrer(ctx, ref, subject)
+	require.NoError(t, err)
+	require.Equal(t, referrers[5].Digest, selected.Manifest.Digest)
+	require.Equal(t, int32(1), atomic.LoadInt32(requests))
+
+	// The first nydus image is selected without a policy, the signature is skipped.
+	manager = NewManager(false)
+	selected, err = manager.CheckReferrer(ctx, ref, subject)
+	require.NoError(t, err)
+	require.Equal(t, referrers[2].Digest, selected.Manifest.Digest)
+
+	// The lack of nydus images is cached as well, until it expires.
+	manager = NewManager(false, WithCacheTTL(100*time.Millisecond), WithPolicy(Policy{
+		Annotations: map[string]string{fsVersion: "7"},
+	}))
+	atomic.StoreInt32(requests, 0)
+	_, err = manager.CheckReferrer(ctx, ref, subject)
+	require.True(t, errdefs.IsNotFound(err))
+	_, err = manager.CheckReferrer(ctx, ref, subject)
+	require.True(t, errdefs.IsNotFound(err))
+	require.Equal(t, int32(1), atomic.LoadInt32(requests))
+	time.Sleep(200 * time.Millisecond)
+	_, err = manager.CheckReferrer(ctx, ref, subject)
+	require.True(t, errdefs.IsNotFound(err))
+	require.Equal(t, int32(2), atomic.LoadInt32(requests))
+}
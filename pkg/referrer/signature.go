@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package referrer
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/auth"
+	"github.com/containerd/nydus-snapshotter/pkg/remote/remotes"
+)
+
+const (
+	// Artifact type of the referrer manifest carrying bootstrap signatures, each
+	// layer of which is a raw signature of the nydus bootstrap file.
+	SignatureArtifactType = "application/vnd.nydus.signature.v1+json"
+	// Optional annotation of signature layers telling which key signed the bootstrap,
+	// the hex encoded SHA256 digest of the DER encoded PKIX public key.
+	AnnotationSignatureKeyID = "containerd.io/snapshot/nydus-signature-key-id"
+
+	maxSignatureSize      = 0x10000
+	maxSignatureManifests = 16
+)
+
+// Signature is a bootstrap signature fetched from referrers.
+type Signature struct {
+	Data  []byte
+	KeyID string
+	// Digest of the referrer manifest carrying the signature.
+	Manifest digest.Digest
+}
+
+// FetchSignatures fetches bootstrap signatures attached to the image manifest as referrers.
+// The signatures are not cached since they may be rotated at any time.
+func (manager *Manager) FetchSignatures(ctx context.Context, ref string, manifestDigest digest.Digest) ([]Signature, error) {
+	keyChain, err := auth.GetKeyChainByRef(ref, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "get key chain")
+	}
+
+	referrer := newReferrer(keyChain, manager.insecure)
+	return referrer.fetchSignatures(ctx, ref, manifestDigest)
+}
+
+func (r *referrer) fetchSignatures(ctx context.Context, ref string, manifestDigest digest.Digest) ([]Signature, error) {
+	handle := func() ([]Signature, error) {
+		fetcher, err := r.remote.Fetcher(ctx, ref)
+		if err != nil {
+			return nil, errors.Wrap(err, "get fetcher")
+		}
+
+		rc, _, err := fetcher.(remotes.ReferrersFetcher).FetchReferrers(ctx, manifestDigest, SignatureArtifactType)
+		if err != nil {
+			return nil, errors.Wrap(err, "fetch referrers")
+		}
+		defer rc.Close()
+
+		var index ocispec.Index
+		if err := readJSON(rc, maxManifestIndexSize, &index); err != nil {
+			return nil, errors.Wrap(err, "read referrers index")
+		}
+
+		var signatures []Signature
+		var fetched int
+		for _, desc := range index.Manifests {
+			// Registries falling back to the tag schema may not fill in artifact types.
+			if desc.ArtifactType != SignatureArtifactType && desc.ArtifactType != "" {
+				continue
+			}
+			if fetched >= maxSignatureManifests {
+				log.L.Warnf("too many signature referrers of %s, ignore the rest", manifestDigest)
+				break
+			}
+			fetched++
+
+			sigs, err := fetchSignatureManifest(ctx, fetcher, desc)
+			if err != nil {
+				log.L.WithError(err).Warnf("failed to fetch signature referrer %s", desc.Digest)
+				continue
+			}
+			signatures = append(signatures, sigs...)
+		}
+
+		return signatures, nil
+	}
+
+	signatures, err := handle()
+	if err != nil && r.remote.RetryWithPlainHTTP(ref, err) {
+		return handle()
+	}
+
+	return signatures, err
+}
+
+func fetchSignatureManifest(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor) ([]Signature, error) {
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch manifest")
+	}
+	defer rc.Close()
+
+	var manifest ocispec.Manifest
+	if err := readJSON(rc, maxManifestIndexSize, &manifest); err != nil {
+		return nil, errors.Wrap(err, "read manifest")
+	}
+	if manifest.ArtifactType != SignatureArtifactType && manifest.Config.MediaType != SignatureArtifactType {
+		return nil, nil
+	}
+
+	signatures := make([]Signature, 0, len(manifest.Layers))
+	for _, layer := range manifest.Layers {
+		if layer.Size > maxSignatureSize {
+			return nil, errors.Errorf("signature %s is too large", layer.Digest)
+		}
+		data, err := fetchBlob(ctx, fetcher, layer)
+		if err != nil {
+			return nil, errors.Wrapf(err, "fetch signature %s", layer.Digest)
+		}
+		signatures = append(signatures, Signature{
+			Data:     data,
+			KeyID:    layer.Annotations[AnnotationSignatureKeyID],
+			Manifest: desc.Digest,
+		})
+	}
+
+	return signatures, nil
+}
+
+func fetchBlob(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor) ([]byte, error) {
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, desc.Size))
+	if err != nil {
+		return nil, err
+	}
+	if digest.FromBytes(data) != desc.Digest {
+		return nil, errors.Errorf("digest mismatches, expected %s", desc.Digest)
+	}
+	return data, nil
+}
+
+func readJSON(rd io.Reader, limit int64, v interface{}) error {
+	data, err := io.ReadAll(io.LimitReader(rd, limit))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,289 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package remote
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
+	"github.com/containerd/nydus-snapshotter/pkg/utils/transport"
+	"github.com/pkg/errors"
+)
+
+// Same defaults as nydusd.
+const (
+	defaultMirrorHealthCheckInterval = 5 * time.Second
+	defaultMirrorFailureLimit        = 5
+	mirrorPingTimeout                = 5 * time.Second
+)
+
+var (
+	// Where to find hosts.toml of registry mirrors.
+	mirrorsConfigDir = config.GetMirrorsConfigDir
+
+	mirrorsLock sync.Mutex
+	// Health of mirrors is shared by all fetches, indexed by mirror URL.
+	mirrors = map[string]*Mirror{}
+	// Mirrors configured for registry hosts, indexed by registry host.
+	mirrorsConfigs = map[string]*mirrorsConfig{}
+)
+
+// Mirrors of a registry host loaded from hosts.toml, which is loaded again only if it changes.
+type mirrorsConfig struct {
+	hostsFile string
+	modTime   time.Time
+	size      int64
+	configs   []daemonconfig.MirrorConfig
+}
+
+// Mirror is a registry mirror configured in `hosts.toml` of `mirrors_config.dir`, the same
+// mirrors used by nydusd. A mirror is skipped after `failure_limit` continuous failures,
+// and is pinged every `health_check_interval` seconds until it recovers.
+type Mirror struct {
+	// URL of the mirror, e.g. `https://mirror.example.com`.
+	URL    string
+	Scheme string
+	Host   string
+
+	mu                  sync.Mutex
+	header              http.Header
+	pingURL             string
+	healthCheckInterval time.Duration
+	failureLimit        uint8
+	failures            uint8
+	unhealthy           bool
+}
+
+// Mirrors returns healthy mirrors of the registry host in the order of `hosts.toml`.
+// Fetches should fall back to the registry host if all of them fail.
+func Mirrors(host string) []*Mirror {
+	configs, err := loadMirrorsConfig(daemonconfig.RegistryHost(host, false))
+	if err != nil {
+		log.L.WithError(err).Warnf("failed to load mirrors of %s", host)
+		return nil
+	}
+
+	var result []*Mirror
+	for _, c := range configs {
+		m, err := getMirror(c)
+		if err != nil {
+			log.L.WithError(err).Warnf("invalid mirror %s of %s", c.Host, host)
+			continue
+		}
+		if m.Healthy() {
+			result = append(result, m)
+		}
+	}
+
+	return result
+}
+
+// PoolMirrors returns healthy mirrors of the registry host to resolve blobs by transport.Pool.
+func PoolMirrors(host string) []transport.Mirror {
+	var result []transport.Mirror
+	for _, m := range Mirrors(host) {
+		result = append(result, transport.Mirror{URL: m.URL, Scheme: m.Scheme, Host: m.Host, Transport: m.Transport})
+	}
+	return result
+}
+
+// Mirrors are looked up on every fetch, so hosts.toml is only parsed again once it's modified.
+func loadMirrorsConfig(registryHost string) ([]daemonconfig.MirrorConfig, error) {
+	dir := mirrorsConfigDir()
+	hostsFile, err := daemonconfig.HostsFile(dir, registryHost)
+	if err != nil {
+		return nil, err
+	}
+	current := mirrorsConfig{hostsFile: hostsFile}
+	if hostsFile != "" {
+		st, err := os.Stat(hostsFile)
+		if err == nil {
+			current.modTime, current.size = st.ModTime(), st.Size()
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	mirrorsLock.Lock()
+	cached, ok := mirrorsConfigs[registryHost]
+	mirrorsLock.Unlock()
+	if ok && cached.hostsFile == current.hostsFile && cached.modTime.Equal(current.modTime) && cached.size == current.size {
+		return cached.configs, nil
+	}
+
+	current.configs, err = daemonconfig.LoadMirrorsConfig(dir, registryHost)
+	if err != nil {
+		return nil, err
+	}
+	mirrorsLock.Lock()
+	mirrorsConfigs[registryHost] = &current
+	mirrorsLock.Unlock()
+
+	return current.configs, nil
+}
+
+func getMirror(c daemonconfig.MirrorConfig) (*Mirror, error) {
+	u, err := url.Parse(c.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	mirrorsLock.Lock()
+	m, ok := mirrors[c.Host]
+	if !ok {
+		m = &Mirror{URL: c.Host, Scheme: u.Scheme, Host: u.Host}
+		mirrors[c.Host] = m
+	}
+	mirrorsLock.Unlock()
+
+	// Pick up changes of hosts.toml.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.header = http.Header{}
+	for k, v := range c.Headers {
+		m.header.Set(k, v)
+	}
+	m.pingURL = c.PingURL
+	if m.pingURL == "" {
+		m.pingURL = strings.TrimSuffix(c.Host, "/") + "/v2/"
+	}
+	m.healthCheckInterval = defaultMirrorHealthCheckInterval
+	if c.HealthCheckInterval > 0 {
+		m.healthCheckInterval = time.Duration(c.HealthCheckInterval) * time.Second
+	}
+	m.failureLimit = defaultMirrorFailureLimit
+	if c.FailureLimit > 0 {
+		m.failureLimit = c.FailureLimit
+	}
+
+	return m, nil
+}
+
+// Healthy tells whether fetches should try the mirror.
+func (m *Mirror) Healthy() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return !m.unhealthy
+}
+
+// ReportSuccess resets continuous failures of the mirror.
+func (m *Mirror) ReportSuccess() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures = 0
+}
+
+// ReportFailure marks the mirror unhealthy once it continuously fails `failure_limit` times.
+func (m *Mirror) ReportFailure() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.unhealthy {
+		return
+	}
+	m.failures++
+	if m.failures < m.failureLimit {
+		return
+	}
+
+	log.L.Warnf("mirror %s failed %d times, fall back to the registry until it recovers", m.URL, m.failures)
+	m.unhealthy = true
+	go m.checkHealth()
+}
+
+func (m *Mirror) checkHealth() {
+	for {
+		m.mu.Lock()
+		interval := m.healthCheckInterval
+		m.mu.Unlock()
+		time.Sleep(interval)
+
+		if err := m.ping(); err != nil {
+			log.L.WithError(err).Debugf("mirror %s is still unhealthy", m.URL)
+			continue
+		}
+
+		log.L.Infof("mirror %s recovered", m.URL)
+		m.mu.Lock()
+		m.unhealthy = false
+		m.failures = 0
+		m.mu.Unlock()
+		return
+	}
+}
+
+// A mirror is considered healthy as long as it responds, even with 401.
+func (m *Mirror) ping() error {
+	m.mu.Lock()
+	pingURL, header := m.pingURL, m.header.Clone()
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorPingTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pingURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return errors.Errorf("ping %s: unexpected status %s", pingURL, resp.Status)
+	}
+
+	return nil
+}
+
+// Transport sends requests to the mirror with headers of the mirror, and tracks health of
+// the mirror by their results.
+func (m *Mirror) Transport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &mirrorTransport{mirror: m, rt: rt}
+}
+
+type mirrorTransport struct {
+	mirror *Mirror
+	rt     http.RoundTripper
+}
+
+func (t *mirrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mirror.mu.Lock()
+	header := t.mirror.header
+	t.mirror.mu.Unlock()
+	if len(header) > 0 {
+		req = req.Clone(req.Context())
+		for k, v := range header {
+			if req.Header.Get(k) == "" {
+				req.Header[k] = v
+			}
+		}
+	}
+
+	resp, err := t.rt.RoundTrip(req)
+	if req.Context().Err() != nil {
+		// Canceled by the caller, nothing to do with the mirror.
+		return resp, err
+	}
+	if err != nil || resp.StatusCode >= http.StatusInternalServerError {
+		t.mirror.ReportFailure()
+	} else {
+		t.mirror.ReportSuccess()
+	}
+	return resp, err
+}
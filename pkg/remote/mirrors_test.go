@@ -0,0 +1,133 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/containerd/nydus-snapshotter/pkg/remote/remotes"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirrorFailover(t *testing.T) {
+	blob := []byte("hello nydus")
+	dgst := digest.FromBytes(blob)
+	serveBlob := func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/blobs/"+dgst.String()) {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(blob)))
+		_, _ = w.Write(blob)
+	}
+
+	origin := httptest.NewServer(http.HandlerFunc(serveBlob))
+	defer origin.Close()
+	var mirrorDown atomic.Bool
+	var mirrorHits atomic.Int32
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrorHits.Add(1)
+		if mirrorDown.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		serveBlob(w, r)
+	}))
+	defer mirror.Close()
+
+	// hosts.toml of the origin registry.
+	host := strings.TrimPrefix(origin.URL, "http://")
+	dir := t.TempDir()
+	hostDir := filepath.Join(dir, strings.Replace(host, ":", "_", 1)+"_")
+	require.NoError(t, os.MkdirAll(hostDir, 0755))
+	hostsToml := fmt.Sprintf("[host.%q]\nfailure_limit = 2\nhealth_check_interval = 1\n", mirror.URL)
+	require.NoError(t, os.WriteFile(filepath.Join(hostDir, "hosts.toml"), []byte(hostsToml), 0644))
+
+	defer func(f func() string) { mirrorsConfigDir = f }(mirrorsConfigDir)
+	mirrorsConfigDir = func() string { return dir }
+
+	fetch := func() {
+		remote := New(nil, false)
+		remote.withPlainHTTP = true
+		fetcher, err := remote.Fetcher(context.Background(), host+"/library/test:latest")
+		require.NoError(t, err)
+		rc, _, err := fetcher.(remotes.FetcherByDigest).FetchByDigest(context.Background(), dgst)
+		require.NoError(t, err)
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.Equal(t, blob, data)
+	}
+
+	fetch()
+	require.NotZero(t, mirrorHits.Load())
+	require.Len(t, Mirrors(host), 1)
+
+	// Fall back to the origin registry, and skip the mirror once it fails too many times.
+	mirrorDown.Store(true)
+	fetch()
+	fetch()
+	require.Empty(t, Mirrors(host))
+	hits := mirrorHits.Load()
+	fetch()
+	require.Equal(t, hits, mirrorHits.Load())
+
+	// The mirror is used again after it recovers.
+	mirrorDown.Store(false)
+	require.Eventually(t, func() bool {
+		return len(Mirrors(host)) == 1
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestLoadMirrorsConfig(t *testing.T) {
+	dir := t.TempDir()
+	defer func(f func() string) { mirrorsConfigDir = f }(mirrorsConfigDir)
+	mirrorsConfigDir = func() string { return dir }
+
+	host := "registry.example.com"
+	require.Empty(t, Mirrors(host))
+
+	hostsFile := filepath.Join(dir, host, "hosts.toml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(hostsFile), 0755))
+	write := func(content string, mtime time.Time) {
+		require.NoError(t, os.WriteFile(hostsFile, []byte(content), 0644))
+		require.NoError(t, os.Chtimes(hostsFile, mtime, mtime))
+	}
+	mtime := time.Now().Add(-time.Hour)
+	write(`[host."https://mirror-a.example.com"]`, mtime)
+	configs, err := loadMirrorsConfig(host)
+	require.NoError(t, err)
+	require.Len(t, configs, 1)
+	require.Equal(t, "https://mirror-a.example.com", configs[0].Host)
+
+	// The unmodified hosts.toml is not parsed again.
+	write(`[host."https://mirror-b.example.com"]`, mtime)
+	configs, err = loadMirrorsConfig(host)
+	require.NoError(t, err)
+	require.Equal(t, "https://mirror-a.example.com", configs[0].Host)
+
+	// Changes of hosts.toml are picked up.
+	write(`[host."https://mirror-b.example.com"]`, mtime.Add(time.Second))
+	configs, err = loadMirrorsConfig(host)
+	require.NoError(t, err)
+	require.Equal(t, "https://mirror-b.example.com", configs[0].Host)
+	require.NoError(t, os.Remove(hostsFile))
+	configs, err = loadMirrorsConfig(host)
+	require.NoError(t, err)
+	require.Empty(t, configs)
+}
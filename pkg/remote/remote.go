@@ -69,19 +69,41 @@ func New(keyChain *auth.PassKeyChain, insecure bool) *Remote {
 	}
 
 	resolverFunc := func(plainHTTP bool) remotes.Resolver {
-		registryHosts := docker.ConfigureDefaultRegistries(
-			docker.WithAuthorizer(
-				docker.NewDockerAuthorizer(
-					docker.WithAuthClient(newClient(insecure)),
-					docker.WithAuthCreds(credFunc),
-				),
-			),
-			docker.WithClient(newClient(insecure)),
+		authorizer := docker.NewDockerAuthorizer(
+			docker.WithAuthClient(newClient(insecure)),
+			docker.WithAuthCreds(credFunc),
+		)
+		client := newClient(insecure)
+		defaultHosts := docker.ConfigureDefaultRegistries(
+			docker.WithAuthorizer(authorizer),
+			docker.WithClient(client),
 			docker.WithPlainHTTP(func(_ string) (bool, error) {
 				return plainHTTP, nil
 			}),
 		)
 
+		// Try mirrors in hosts.toml before the registry, the same as nydusd.
+		registryHosts := func(host string) ([]docker.RegistryHost, error) {
+			origin, err := defaultHosts(host)
+			if err != nil {
+				return nil, err
+			}
+
+			var hosts []docker.RegistryHost
+			for _, m := range Mirrors(host) {
+				hosts = append(hosts, docker.RegistryHost{
+					Client:       &http.Client{Transport: m.Transport(client.Transport)},
+					Authorizer:   authorizer,
+					Host:         m.Host,
+					Scheme:       m.Scheme,
+					Path:         "/v2",
+					Capabilities: docker.HostCapabilityPull | docker.HostCapabilityResolve,
+				})
+			}
+
+			return append(hosts, origin...), nil
+		}
+
 		return docker.NewResolver(docker.ResolverOptions{
 			Hosts: registryHosts,
 		})
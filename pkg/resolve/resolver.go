@@ -13,6 +13,7 @@ import (
 	"net/http"
 
 	"github.com/containerd/nydus-snapshotter/pkg/auth"
+	"github.com/containerd/nydus-snapshotter/pkg/remote"
 	"github.com/containerd/nydus-snapshotter/pkg/utils/transport"
 	distribution "github.com/distribution/reference"
 	"github.com/google/go-containerregistry/pkg/name"
@@ -44,8 +45,11 @@ func (r *Resolver) Resolve(ref, digest string, labels map[string]string) (io.Rea
 	}
 	keychain := auth.GetRegistryKeyChain(host, ref, labels)
 
+	// Healthy mirrors of the registry are tried before the registry itself.
+	mirrors := remote.PoolMirrors(nref.Context().RegistryStr())
+
 	var tr http.RoundTripper
-	url, tr, err := r.res.Resolve(nref, digest, keychain)
+	url, tr, err := r.res.Resolve(nref, digest, keychain, mirrors)
 
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to create authn transport %v", keychain)
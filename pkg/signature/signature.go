@@ -7,67 +7,157 @@
 package signature
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
+	"io"
 	"os"
 
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
 
+	"github.com/containerd/nydus-snapshotter/config"
 	"github.com/containerd/nydus-snapshotter/pkg/label"
+	"github.com/containerd/nydus-snapshotter/pkg/referrer"
+	"github.com/containerd/nydus-snapshotter/pkg/utils/registry"
 	"github.com/containerd/nydus-snapshotter/pkg/utils/signer"
 )
 
 type Verifier struct {
-	signer *signer.Signer
-	force  bool
+	signers []*signer.Signer
+	config  config.ImageConfig
+	// Fetch signatures from referrers if it's not nil.
+	referrerMgr *referrer.Manager
 }
 
-func NewVerifier(publicKeyFile string, validateSignature bool) (*Verifier, error) {
+// A bootstrap signature and where it comes from.
+type signature struct {
+	data   []byte
+	keyID  string
+	source string
+}
+
+func NewVerifier(cfg config.ImageConfig, insecure bool) (*Verifier, error) {
 	res := &Verifier{
-		force: validateSignature,
+		config: cfg,
 	}
-	if !validateSignature {
-		return res, nil
+
+	for _, publicKeyFile := range cfg.PublicKeys() {
+		if _, err := os.Stat(publicKeyFile); err != nil {
+			return nil, fmt.Errorf("failed to find publicKeyFile %q", publicKeyFile)
+		}
+		publicKeyByte, err := os.ReadFile(publicKeyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read from publicKeyFile %q", publicKeyFile)
+		}
+		sign, err := signer.New(publicKeyByte)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to initialize signer from %q", publicKeyFile)
+		}
+		res.signers = append(res.signers, sign)
 	}
-	if publicKeyFile == "" {
-		return nil, errors.New("publicKeyFile is required")
+	if len(res.signers) == 0 && cfg.SignatureVerificationEnabled() {
+		return nil, errors.New("public key file is required to verify bootstrap signatures")
 	}
-	if _, err := os.Stat(publicKeyFile); err != nil {
-		return nil, fmt.Errorf("failed to find publicKeyFile %q", publicKeyFile)
+
+	if cfg.EnableReferrerSignature {
+		res.referrerMgr = referrer.NewManager(insecure)
 	}
-	publicKeyByte, err := os.ReadFile(publicKeyFile)
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to read from publicKeyFile %q", publicKeyFile)
+
+	return res, nil
+}
+
+// Verify the bootstrap of the image according to the signature policy of its registry.
+func (v *Verifier) Verify(ctx context.Context, imageRef string, labels map[string]string, bootstrapFile string) error {
+	policy := v.policy(imageRef)
+	if policy == config.SignaturePolicySkip {
+		return nil
 	}
-	sign, err := signer.New(publicKeyByte)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to initialize signer")
+
+	err := v.verify(ctx, imageRef, labels, bootstrapFile)
+	if err != nil && policy == config.SignaturePolicyWarn {
+		log.L.WithError(err).Warnf("failed to verify bootstrap signature of image %s", imageRef)
+		return nil
 	}
-	res.signer = sign
-	return res, nil
+
+	return err
+}
+
+func (v *Verifier) policy(imageRef string) string {
+	var host string
+	if image, err := registry.ParseImage(imageRef); err == nil {
+		host = image.Host
+	}
+	return v.config.GetSignaturePolicy(host)
 }
 
-func (v *Verifier) Verify(label map[string]string, bootstrapFile string) error {
-	signature, err := getFromLabel(label)
+func (v *Verifier) verify(ctx context.Context, imageRef string, labels map[string]string, bootstrapFile string) error {
+	if len(v.signers) == 0 {
+		return errors.New("no public key to verify bootstrap signature")
+	}
+
+	signatures, err := v.signatures(ctx, imageRef, labels)
 	if err != nil {
 		return err
 	}
-	if signature == nil {
-		if v.force {
-			return errors.New("bootstrap signature is required when force validation")
-		}
-		return nil
+	if len(signatures) == 0 {
+		return errors.New("bootstrap signature is required when force validation")
 	}
 
-	if v.signer == nil {
-		return nil
-	}
 	f, err := os.Open(bootstrapFile)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	return v.signer.Verify(f, signature)
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return errors.Wrapf(err, "read bootstrap %s", bootstrapFile)
+	}
+	hashed := h.Sum(nil)
+
+	// Any signature verified by any trusted key is good enough.
+	for _, sig := range signatures {
+		for _, s := range v.signers {
+			if sig.keyID != "" && sig.keyID != s.KeyID() {
+				continue
+			}
+			if err := s.VerifyDigest(hashed, sig.data); err == nil {
+				log.L.Debugf("bootstrap of image %s is verified by key %s with signature from %s",
+					imageRef, s.KeyID(), sig.source)
+				return nil
+			}
+		}
+	}
+
+	return errors.Errorf("none of %d signatures is verified by trusted keys", len(signatures))
+}
+
+func (v *Verifier) signatures(ctx context.Context, imageRef string, labels map[string]string) ([]signature, error) {
+	var signatures []signature
+
+	data, err := getFromLabel(labels)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode signature from label")
+	}
+	if data != nil {
+		signatures = append(signatures, signature{data: data, source: "label " + label.NydusSignature})
+	}
+
+	manifestDigest := digest.Digest(labels[label.CRIManifestDigest])
+	if v.referrerMgr != nil && manifestDigest != "" {
+		sigs, err := v.referrerMgr.FetchSignatures(ctx, imageRef, manifestDigest)
+		if err != nil {
+			// The signature from label may still work.
+			log.L.WithError(err).Warnf("failed to fetch signatures of image %s from referrers", imageRef)
+		}
+		for _, s := range sigs {
+			signatures = append(signatures, signature{data: s.Data, keyID: s.KeyID, source: "referrer " + s.Manifest.String()})
+		}
+	}
+
+	return signatures, nil
 }
 
 func getFromLabel(labels map[string]string) ([]byte, error) {
@@ -80,32 +170,3 @@ func getFromLabel(labels map[string]string) ([]byte, error) {
 	}
 	return nil, nil
 }
-
-// func Verify(label map[string]string, bootstrapFile, publicKey string, force bool) error {
-// 	signature, err := getFromLabel(label)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	// if we found signature on image manifest, we should verify it
-// 	if signature == nil {
-// 		if force {
-// 			return errors.New("bootstrap signature is required when force validation")
-// 		}
-// 		return nil
-// 	}
-//
-// 	publicKeyByte, err := ioutil.ReadFile(publicKey)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	sign, err := signer.New(publicKeyByte)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	f, err := os.Open(bootstrapFile)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	defer f.Close()
-// 	return sign.Verify(f, signature)
-// }
@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package signature
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/label"
+)
+
+func writePublicKey(t *testing.T, dir, name string, key crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	require.NoError(t, err)
+	path := filepath.Join(dir, name)
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600)
+	require.NoError(t, err)
+	return path
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	bootstrap := filepath.Join(dir, "image.boot")
+	content := []byte("nydus bootstrap")
+	require.NoError(t, os.WriteFile(bootstrap, content, 0600))
+	hashed := sha256.Sum256(content)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	rsaSig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, hashed[:])
+	require.NoError(t, err)
+	ecdsaSig, err := ecdsa.SignASN1(rand.Reader, ecdsaKey, hashed[:])
+	require.NoError(t, err)
+	otherSig, err := ecdsa.SignASN1(rand.Reader, otherKey, hashed[:])
+	require.NoError(t, err)
+
+	labelsOf := func(sig []byte) map[string]string {
+		return map[string]string{label.NydusSignature: base64.StdEncoding.EncodeToString(sig)}
+	}
+
+	// Both the old and the new keys are trusted during key rotation.
+	cfg := config.ImageConfig{
+		PublicKeyFile:  writePublicKey(t, dir, "rsa.pub", &rsaKey.PublicKey),
+		PublicKeyFiles: []string{writePublicKey(t, dir, "ecdsa.pub", &ecdsaKey.PublicKey)},
+		RegistrySignaturePolicies: map[string]string{
+			"warn.example.com": config.SignaturePolicyWarn,
+			"skip.example.com": config.SignaturePolicySkip,
+		},
+		SignaturePolicy: config.SignaturePolicyEnforce,
+	}
+	v, err := NewVerifier(cfg, false)
+	require.NoError(t, err)
+	require.Len(t, v.signers, 2)
+
+	image := "docker.io/library/busybox:latest"
+	require.NoError(t, v.Verify(ctx, image, labelsOf(rsaSig), bootstrap))
+	require.NoError(t, v.Verify(ctx, image, labelsOf(ecdsaSig), bootstrap))
+	require.Error(t, v.Verify(ctx, image, labelsOf(otherSig), bootstrap))
+	require.Error(t, v.Verify(ctx, image, map[string]string{}, bootstrap))
+
+	// Failures are tolerated or not checked at all by per-registry policies.
+	require.NoError(t, v.Verify(ctx, "warn.example.com/app:v1", labelsOf(otherSig), bootstrap))
+	require.NoError(t, v.Verify(ctx, "warn.example.com/app:v1", map[string]string{}, bootstrap))
+	require.NoError(t, v.Verify(ctx, "skip.example.com/app:v1", labelsOf(otherSig), bootstrap))
+
+	cfg.SignaturePolicy = ""
+	v, err = NewVerifier(cfg, false)
+	require.NoError(t, err)
+	require.NoError(t, v.Verify(ctx, image, labelsOf(otherSig), bootstrap))
+
+	cfg.ValidateSignature = true
+	v, err = NewVerifier(cfg, false)
+	require.NoError(t, err)
+	require.Error(t, v.Verify(ctx, image, labelsOf(otherSig), bootstrap))
+
+	// Signatures can't be verified without any public key.
+	cfg.PublicKeyFile = ""
+	cfg.PublicKeyFiles = nil
+	_, err = NewVerifier(cfg, false)
+	require.Error(t, err)
+	_, err = NewVerifier(config.ImageConfig{SignaturePolicy: config.SignaturePolicyEnforce}, false)
+	require.Error(t, err)
+	_, err = NewVerifier(config.ImageConfig{RegistrySignaturePolicies: map[string]string{
+		"warn.example.com": config.SignaturePolicyWarn,
+	}}, false)
+	require.Error(t, err)
+	_, err = NewVerifier(config.ImageConfig{RegistrySignaturePolicies: map[string]string{
+		"skip.example.com": config.SignaturePolicySkip,
+	}}, false)
+	require.NoError(t, err)
+}
@@ -19,6 +19,7 @@ import (
 	"time"
 
 	"github.com/containerd/log"
+	"github.com/containerd/nydus-snapshotter/pkg/remote"
 	"github.com/containerd/nydus-snapshotter/pkg/utils/transport"
 	"github.com/containerd/stargz-snapshotter/estargz"
 	distribution "github.com/distribution/reference"
@@ -161,7 +162,7 @@ func (r *Resolver) resolve(ref, digest string, keychain authn.Keychain) (*io.Sec
 		return nil, errors.Wrapf(err, "failed to parse ref %q (%q)", sref, digest)
 	}
 
-	url, tr, err := r.res.Resolve(nref, digest, keychain)
+	url, tr, err := r.res.Resolve(nref, digest, keychain, remote.PoolMirrors(nref.Context().RegistryStr()))
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to resolve reference of %q, %q", nref, digest)
 	}
@@ -22,6 +22,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/containerd/nydus-snapshotter/pkg/auth"
+	"github.com/containerd/nydus-snapshotter/pkg/utils/transport"
 )
 
 func TestResolver_resolve(t *testing.T) {
@@ -56,7 +57,7 @@ func TestResolver_resolve(t *testing.T) {
 type MockResolver struct {
 }
 
-func (res *MockResolver) Resolve(_ name.Reference, _ string, _ authn.Keychain) (string, http.RoundTripper, error) {
+func (res *MockResolver) Resolve(_ name.Reference, _ string, _ authn.Keychain, _ []transport.Mirror) (string, http.RoundTripper, error) {
 	return "http://oss.com/v2/test/myserver/blobs/sha256:mock", &mockRoundTripper{}, nil
 }
 
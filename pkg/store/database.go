@@ -17,7 +17,9 @@ import (
 	"github.com/containerd/log"
 	"github.com/containerd/nydus-snapshotter/pkg/daemon"
 	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/prefetch/types"
 	"github.com/containerd/nydus-snapshotter/pkg/rafs"
+	"github.com/containerd/nydus-snapshotter/pkg/tarfs"
 
 	"github.com/pkg/errors"
 	bolt "go.etcd.io/bbolt"
@@ -32,6 +34,8 @@ const (
 //	- v1:
 //		- daemons
 //		- instances
+//		- prefetch
+//		- tarfs
 
 var (
 	v1RootBucket = []byte("v1")
@@ -42,6 +46,10 @@ var (
 	// RAFS filesystem instances.
 	// A RAFS filesystem may have associated daemon or not.
 	instancesBucket = []byte("instances")
+	// Prefetch lists of images, keyed by image reference and manifest digest.
+	prefetchBucket = []byte("prefetch")
+	// States of tarfs snapshots, such as attached loop devices and erofs mountpoints.
+	tarfsBucket = []byte("tarfs")
 )
 
 // Database keeps infos that need to survive among snapshotter restart
@@ -87,6 +95,16 @@ func getInstancesBucket(tx *bolt.Tx) *bolt.Bucket {
 	return bucket.Bucket(instancesBucket)
 }
 
+func getPrefetchBucket(tx *bolt.Tx) *bolt.Bucket {
+	bucket := tx.Bucket(v1RootBucket)
+	return bucket.Bucket(prefetchBucket)
+}
+
+func getTarfsBucket(tx *bolt.Tx) *bolt.Bucket {
+	bucket := tx.Bucket(v1RootBucket)
+	return bucket.Bucket(tarfsBucket)
+}
+
 func updateObject(bucket *bolt.Bucket, key string, obj interface{}) error {
 	keyBytes := []byte(key)
 
@@ -163,6 +181,14 @@ func (db *Database) initDatabase() error {
 			return errors.Wrapf(err, "bucket %s", instancesBucket)
 		}
 
+		if _, err := bk.CreateBucketIfNotExists(prefetchBucket); err != nil {
+			return errors.Wrapf(err, "bucket %s", prefetchBucket)
+		}
+
+		if _, err := bk.CreateBucketIfNotExists(tarfsBucket); err != nil {
+			return errors.Wrapf(err, "bucket %s", tarfsBucket)
+		}
+
 		if val := bk.Get(versionKey); val == nil {
 			version = "v1.0"
 		} else {
@@ -287,6 +313,15 @@ func (db *Database) AddRafsInstance(_ context.Context, instance *rafs.Rafs) erro
 	})
 }
 
+// UpdateRafsInstance adds or replaces a RAFS instance record.
+func (db *Database) UpdateRafsInstance(_ context.Context, instance *rafs.Rafs) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		bucket := getInstancesBucket(tx)
+
+		return updateObject(bucket, instance.SnapshotID, instance)
+	})
+}
+
 func (db *Database) DeleteRafsInstance(_ context.Context, snapshotID string) error {
 	return db.db.Update(func(tx *bolt.Tx) error {
 		bucket := getInstancesBucket(tx)
@@ -299,6 +334,80 @@ func (db *Database) DeleteRafsInstance(_ context.Context, snapshotID string) err
 	})
 }
 
+// SavePrefetchEntry adds or replaces the prefetch list of an image.
+func (db *Database) SavePrefetchEntry(_ context.Context, e *types.Entry) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		bucket := getPrefetchBucket(tx)
+
+		return updateObject(bucket, e.Key(), e)
+	})
+}
+
+func (db *Database) DeletePrefetchEntry(_ context.Context, key string) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		bucket := getPrefetchBucket(tx)
+
+		if err := bucket.Delete([]byte(key)); err != nil {
+			return errors.Wrapf(err, "delete prefetch list %s", key)
+		}
+
+		return nil
+	})
+}
+
+func (db *Database) WalkPrefetchEntries(_ context.Context, cb func(e *types.Entry) error) error {
+	return db.db.View(func(tx *bolt.Tx) error {
+		bucket := getPrefetchBucket(tx)
+
+		return bucket.ForEach(func(key, value []byte) error {
+			e := &types.Entry{}
+
+			if err := json.Unmarshal(value, e); err != nil {
+				return errors.Wrapf(err, "unmarshal %s", key)
+			}
+
+			return cb(e)
+		})
+	})
+}
+
+// SaveTarfsSnapshot adds or replaces the state of a tarfs snapshot.
+func (db *Database) SaveTarfsSnapshot(_ context.Context, r *tarfs.SnapshotRecord) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		bucket := getTarfsBucket(tx)
+
+		return updateObject(bucket, r.SnapshotID, r)
+	})
+}
+
+func (db *Database) DeleteTarfsSnapshot(_ context.Context, snapshotID string) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		bucket := getTarfsBucket(tx)
+
+		if err := bucket.Delete([]byte(snapshotID)); err != nil {
+			return errors.Wrapf(err, "delete tarfs snapshot %s", snapshotID)
+		}
+
+		return nil
+	})
+}
+
+func (db *Database) WalkTarfsSnapshots(_ context.Context, cb func(r *tarfs.SnapshotRecord) error) error {
+	return db.db.View(func(tx *bolt.Tx) error {
+		bucket := getTarfsBucket(tx)
+
+		return bucket.ForEach(func(key, value []byte) error {
+			r := &tarfs.SnapshotRecord{}
+
+			if err := json.Unmarshal(value, r); err != nil {
+				return errors.Wrapf(err, "unmarshal %s", key)
+			}
+
+			return cb(r)
+		})
+	})
+}
+
 func (db *Database) NextInstanceSeq() (uint64, error) {
 	tx, err := db.db.Begin(true)
 	if err != nil {
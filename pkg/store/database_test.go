@@ -7,6 +7,8 @@ import (
 	"testing"
 
 	"github.com/containerd/nydus-snapshotter/pkg/daemon"
+	"github.com/containerd/nydus-snapshotter/pkg/prefetch/types"
+	"github.com/opencontainers/go-digest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -103,3 +105,34 @@ func TestLegacyRecordsSharedDaemonModes(t *testing.T) {
 	_, err = NewDatabase("testdata")
 	assert.Nil(t, err)
 }
+
+func Test_prefetch(t *testing.T) {
+	rootDir := t.TempDir()
+
+	db, err := NewDatabase(rootDir)
+	require.Nil(t, err)
+
+	ctx := context.TODO()
+	manifestDigest := digest.FromString("manifest")
+	e1 := types.Entry{ImageRef: "docker.io/library/nginx:latest", PrefetchFiles: "/usr/sbin/nginx"}
+	e2 := types.Entry{ImageRef: "docker.io/library/nginx:latest", ManifestDigest: manifestDigest, PrefetchFiles: "/etc/nginx"}
+	require.Nil(t, db.SavePrefetchEntry(ctx, &e1))
+	require.Nil(t, db.SavePrefetchEntry(ctx, &e2))
+	// Saving an existing entry replaces it
+	e1.PrefetchFiles = "/usr/sbin/nginx,/etc/nginx"
+	require.Nil(t, db.SavePrefetchEntry(ctx, &e1))
+	require.Nil(t, db.Close())
+
+	db, err = NewDatabase(rootDir)
+	require.Nil(t, err)
+	require.Nil(t, db.DeletePrefetchEntry(ctx, e2.Key()))
+	keys := []string{}
+	var files string
+	_ = db.WalkPrefetchEntries(ctx, func(e *types.Entry) error {
+		keys = append(keys, e.Key())
+		files = e.PrefetchFiles
+		return nil
+	})
+	require.Equal(t, []string{e1.Key()}, keys)
+	require.Equal(t, "/usr/sbin/nginx,/etc/nginx", files)
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package supervisor
+
+import (
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/containerd/log"
+	"github.com/coreos/go-systemd/v22/activation"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// File descriptors held by supervisors, e.g. the `/dev/fuse` fd, are kept in the
+// systemd file descriptor store so that they survive snapshotter restart. Systemd
+// passes them back to the restarted snapshotter by the socket activation protocol.
+//
+// It requires the snapshotter service to be configured with `NotifyAccess=main`
+// and `FileDescriptorStoreMax=`.
+
+const fdNamePrefix = "nydusd-"
+
+var (
+	storedFdsOnce sync.Once
+	storedFdsLock sync.Mutex
+	// FDs passed back by systemd, indexed by daemon ID.
+	storedFds map[string]int
+)
+
+func fdStoreEnabled() bool {
+	return os.Getenv("NOTIFY_SOCKET") != ""
+}
+
+func sdNotify(state string, fds ...int) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return errors.New("NOTIFY_SOCKET is not set")
+	}
+
+	// A connected datagram socket of package net can't send control messages.
+	sock, err := unix.Socket(unix.AF_UNIX, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return errors.Wrap(err, "create socket")
+	}
+	defer unix.Close(sock)
+
+	var oob []byte
+	if len(fds) > 0 {
+		oob = unix.UnixRights(fds...)
+	}
+	if err := unix.Sendmsg(sock, []byte(state), oob, &unix.SockaddrUnix{Name: name}, 0); err != nil {
+		return errors.Wrapf(err, "notify %q", strings.ReplaceAll(state, "\n", " "))
+	}
+
+	return nil
+}
+
+// Keep the fd of the daemon in systemd, the previously stored one is replaced.
+func storeFd(id string, fd int) error {
+	if err := removeStoredFd(id); err != nil {
+		return err
+	}
+	return sdNotify("FDSTORE=1\nFDNAME="+fdNamePrefix+id, fd)
+}
+
+func removeStoredFd(id string) error {
+	return sdNotify("FDSTOREREMOVE=1\nFDNAME=" + fdNamePrefix + id)
+}
+
+func loadStoredFds() {
+	storedFds = map[string]int{}
+	for _, f := range activation.Files(true) {
+		id := strings.TrimPrefix(f.Name(), fdNamePrefix)
+		if id == f.Name() {
+			f.Close()
+			continue
+		}
+		// The os.File closes the fd once it's garbage collected, so duplicate it.
+		fd, err := unix.FcntlInt(f.Fd(), unix.F_DUPFD_CLOEXEC, 0)
+		f.Close()
+		if err != nil {
+			log.L.WithError(err).Warnf("failed to duplicate stored fd of daemon %s", id)
+			continue
+		}
+		log.L.Infof("retrieved stored fd %d of daemon %s", fd, id)
+		storedFds[id] = fd
+	}
+}
+
+// Take the fd of the daemon passed back by systemd, return -1 if not found.
+func takeStoredFd(id string) int {
+	storedFdsOnce.Do(loadStoredFds)
+
+	storedFdsLock.Lock()
+	defer storedFdsLock.Unlock()
+
+	fd, ok := storedFds[id]
+	if !ok {
+		return -1
+	}
+	delete(storedFds, id)
+	return fd
+}
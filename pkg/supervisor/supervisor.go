@@ -36,30 +36,44 @@ type StatesStorage interface {
 	Clean()
 }
 
-// Store daemon states in memory
-type MemStatesStorage struct {
-	data []byte
+// Store daemon states in a file so that they survive snapshotter restart.
+type FileStatesStorage struct {
+	path string
 }
 
-func newMemStatesStorage() *MemStatesStorage {
-	return &MemStatesStorage{
-		data: []byte{},
+func newFileStatesStorage(path string) *FileStatesStorage {
+	return &FileStatesStorage{
+		path: path,
 	}
 }
 
-func (mss *MemStatesStorage) Save(data []byte) {
-	mss.data = make([]byte, len(data))
-	copy(mss.data, data)
+func (fss *FileStatesStorage) Save(data []byte) {
+	// Never leave a partially written states file.
+	tmp := fss.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		log.L.WithError(err).Errorf("Fail to save states to %s", tmp)
+		return
+	}
+	if err := os.Rename(tmp, fss.path); err != nil {
+		log.L.WithError(err).Errorf("Fail to save states to %s", fss.path)
+	}
 }
 
-func (mss *MemStatesStorage) Load() ([]byte, error) {
-	data := make([]byte, len(mss.data))
-	copy(data, mss.data)
+func (fss *FileStatesStorage) Load() ([]byte, error) {
+	data, err := os.ReadFile(fss.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []byte{}, nil
+		}
+		return nil, errors.Wrapf(err, "load states from %s", fss.path)
+	}
 	return data, nil
 }
 
-func (mss *MemStatesStorage) Clean() {
-	mss.data = []byte{}
+func (fss *FileStatesStorage) Clean() {
+	if err := os.Remove(fss.path); err != nil && !os.IsNotExist(err) {
+		log.L.WithError(err).Warnf("Fail to clean states %s", fss.path)
+	}
 }
 
 // Use daemon ID as the supervisor ID
@@ -70,23 +84,40 @@ type Supervisor struct {
 	// Hold the sended file descriptors.
 	fd          int
 	dataStorage StatesStorage
-	mu          sync.Mutex
-	sem         *semaphore.Weighted
+	// Keep the held file descriptor in systemd.
+	fdStore bool
+	mu      sync.Mutex
+	sem     *semaphore.Weighted
 }
 
 func (su *Supervisor) save(data []byte, fd int) {
 	su.mu.Lock()
 	defer su.mu.Unlock()
 
-	// Always overwrite states and FDs
-	// We should clean up the stored states since each received states set is atomic
-	su.dataStorage.Clean()
-	if fd > 0 {
+	// Always overwrite states and FDs since each received states set is atomic
+	if fd > 0 && fd != su.fd {
+		if su.fd > 0 {
+			if err := syscall.Close(su.fd); err != nil {
+				log.L.Warnf("Fail to close fd %d, %s", su.fd, err)
+			}
+		}
 		su.fd = fd
+		if su.fdStore {
+			if err := storeFd(su.id, fd); err != nil {
+				log.L.WithError(err).Warnf("Fail to keep fd of supervisor %s in systemd", su.id)
+			}
+		}
 	}
 	su.dataStorage.Save(data)
 }
 
+// HasStates tells if the supervisor holds both states and file descriptor of the daemon,
+// which are required by nydusd to take over the service of a dead daemon.
+func (su *Supervisor) HasStates() bool {
+	data, fd, err := su.load()
+	return err == nil && len(data) > 0 && fd > 0
+}
+
 // Load resources kept by this supervisor
 //  1. daemon runtime states
 //  2. file descriptor
@@ -350,8 +381,9 @@ func (su *Supervisor) Sock() string {
 type SupervisorsSet struct {
 	mu  sync.Mutex
 	set map[string]*Supervisor
-	// A directory where all the supervisor sockets resides.
-	root string
+	// A directory where all the supervisor sockets and states resides.
+	root    string
+	fdStore bool
 }
 
 func NewSupervisorSet(root string) (*SupervisorsSet, error) {
@@ -359,9 +391,15 @@ func NewSupervisorSet(root string) (*SupervisorsSet, error) {
 		return nil, err
 	}
 
+	fdStore := fdStoreEnabled()
+	if !fdStore {
+		log.L.Warnf("systemd file descriptor store is unavailable, daemons can't failover after snapshotter restarts")
+	}
+
 	return &SupervisorsSet{
-		set:  make(map[string]*Supervisor),
-		root: root}, nil
+		set:     make(map[string]*Supervisor),
+		root:    root,
+		fdStore: fdStore}, nil
 }
 
 func (ss *SupervisorsSet) NewSupervisor(id string) *Supervisor {
@@ -372,9 +410,14 @@ func (ss *SupervisorsSet) NewSupervisor(id string) *Supervisor {
 		path: sockPath,
 		// Negative value means no FD was ever held.
 		fd:          -1,
-		dataStorage: newMemStatesStorage(),
+		dataStorage: newFileStatesStorage(filepath.Join(ss.root, fmt.Sprintf("%s.states", id))),
+		fdStore:     ss.fdStore,
 		sem:         semaphore.NewWeighted(1),
 	}
+	// States of the daemon are kept if the snapshotter restarts.
+	if ss.fdStore {
+		supervisor.fd = takeStoredFd(id)
+	}
 
 	ss.mu.Lock()
 	defer ss.mu.Unlock()
@@ -412,7 +455,14 @@ func (ss *SupervisorsSet) DestroySupervisor(id string) error {
 		if err := syscall.Close(supervisor.fd); err != nil {
 			log.L.Errorf("Fail to close fd %d, %s", supervisor.fd, err)
 		}
+		supervisor.fd = -1
+		if supervisor.fdStore {
+			if err := removeStoredFd(id); err != nil {
+				log.L.WithError(err).Warnf("Fail to remove fd of supervisor %s from systemd", id)
+			}
+		}
 	}
+	supervisor.dataStorage.Clean()
 
 	return nil
 }
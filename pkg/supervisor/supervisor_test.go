@@ -10,11 +10,14 @@ import (
 	"crypto/rand"
 	"net"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
 )
 
 func TestSupervisor(t *testing.T) {
@@ -107,3 +110,65 @@ func TestSupervisorTimeout(t *testing.T) {
 	_, err = net.DialUnix("unix", nil, addr)
 	assert.NotNil(t, err, "%v", err)
 }
+
+func TestSupervisorStatesPersistence(t *testing.T) {
+	rootDir := t.TempDir()
+
+	// Fake systemd notify socket to receive stored FDs.
+	notifySock := filepath.Join(rootDir, "notify.sock")
+	notifyConn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: notifySock, Net: "unixgram"})
+	require.NoError(t, err)
+	defer notifyConn.Close()
+	t.Setenv("NOTIFY_SOCKET", notifySock)
+
+	supervisorSet, err := NewSupervisorSet(rootDir)
+	require.NoError(t, err)
+	su1 := supervisorSet.NewSupervisor("su1")
+	require.False(t, su1.HasStates())
+
+	tmpFile, err := os.CreateTemp(rootDir, "fuse")
+	require.NoError(t, err)
+	defer tmpFile.Close()
+
+	addr, err := net.ResolveUnixAddr("unix", su1.Sock())
+	require.NoError(t, err)
+	err = su1.FetchDaemonStates(func() error {
+		conn, err := net.DialUnix("unix", nil, addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		return send(conn, []byte("states"), int(tmpFile.Fd()))
+	})
+	require.NoError(t, err)
+	require.True(t, su1.HasStates())
+
+	// The old one is removed before storing the received fd.
+	buf := make([]byte, 256)
+	oob := make([]byte, unix.CmsgSpace(4))
+	n, oobn, _, _, err := notifyConn.ReadMsgUnix(buf, oob)
+	require.NoError(t, err)
+	require.Equal(t, "FDSTOREREMOVE=1\nFDNAME=nydusd-su1", string(buf[:n]))
+	require.Zero(t, oobn)
+	n, oobn, _, _, err = notifyConn.ReadMsgUnix(buf, oob)
+	require.NoError(t, err)
+	require.Equal(t, "FDSTORE=1\nFDNAME=nydusd-su1", string(buf[:n]))
+	scms, err := unix.ParseSocketControlMessage(oob[:oobn])
+	require.NoError(t, err)
+	fds, err := unix.ParseUnixRights(&scms[0])
+	require.NoError(t, err)
+	require.Len(t, fds, 1)
+	unix.Close(fds[0])
+
+	// States are loaded by the supervisor created after snapshotter restarts.
+	supervisorSet, err = NewSupervisorSet(rootDir)
+	require.NoError(t, err)
+	su1 = supervisorSet.NewSupervisor("su1")
+	data, _, err := su1.load()
+	require.NoError(t, err)
+	require.Equal(t, []byte("states"), data)
+
+	require.NoError(t, supervisorSet.DestroySupervisor("su1"))
+	_, err = os.Stat(filepath.Join(rootDir, "su1.states"))
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package system
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/containerd/log"
+	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/accesstrace"
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+)
+
+func errorStatusCode(err error) int {
+	switch {
+	case errdefs.IsNotFound(err):
+		return http.StatusNotFound
+	case errors.Is(err, errdefs.ErrInvalidArgument):
+		return http.StatusBadRequest
+	case errors.Is(err, errdefs.ErrNotImplemented):
+		return http.StatusNotImplemented
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (sc *Controller) accessTraceRecorder() (*accesstrace.Recorder, error) {
+	if sc.fs == nil || sc.fs.AccessTraceRecorder() == nil {
+		return nil, errors.Wrap(errdefs.ErrNotFound, "access trace recording is not enabled")
+	}
+	return sc.fs.AccessTraceRecorder(), nil
+}
+
+// GET /api/v1/access-traces
+func (sc *Controller) listAccessTraces() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		recorder, err := sc.accessTraceRecorder()
+		if err != nil {
+			m := newErrorMessage(err.Error())
+			http.Error(w, m.encode(), errorStatusCode(err))
+			return
+		}
+
+		traces, err := recorder.List()
+		if err != nil {
+			m := newErrorMessage(err.Error())
+			http.Error(w, m.encode(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse(w, traces)
+	}
+}
+
+// GET /api/v1/access-traces/{digest}
+// Respond with the accessed files, one absolute path per line in the order of first
+// access, which can be used as prefetch patterns of image conversion directly.
+func (sc *Controller) getAccessTrace() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		defer func() {
+			if err != nil {
+				m := newErrorMessage(err.Error())
+				http.Error(w, m.encode(), errorStatusCode(err))
+			}
+		}()
+
+		recorder, err := sc.accessTraceRecorder()
+		if err != nil {
+			return
+		}
+
+		rd, err := recorder.Open(digest.Digest(mux.Vars(r)["digest"]))
+		if err != nil {
+			return
+		}
+		defer rd.Close()
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		if _, err := io.Copy(w, rd); err != nil {
+			log.L.Errorf("write body %s", err)
+		}
+	}
+}
+
+// DELETE /api/v1/access-traces/{digest}
+// The image will be recorded again the next time it's mounted.
+func (sc *Controller) deleteAccessTrace() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		defer func() {
+			if err != nil {
+				m := newErrorMessage(err.Error())
+				http.Error(w, m.encode(), errorStatusCode(err))
+			}
+		}()
+
+		recorder, err := sc.accessTraceRecorder()
+		if err != nil {
+			return
+		}
+
+		if err = recorder.Delete(digest.Digest(mux.Vars(r)["digest"])); err != nil {
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
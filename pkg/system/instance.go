@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package system
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/rafs"
+)
+
+// GET /api/v1/instances
+func (sc *Controller) listInstances() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		instances := rafs.RafsGlobalCache.List()
+
+		info := make([]RafsInstanceInfo, 0, len(instances))
+		for _, i := range instances {
+			info = append(info, newRafsInstanceInfo(i))
+		}
+		sort.Slice(info, func(i, j int) bool {
+			return info[i].SnapshotID < info[j].SnapshotID
+		})
+
+		jsonResponse(w, &info)
+	}
+}
+
+// GET /api/v1/instances/{snapshot_id}
+func (sc *Controller) getInstance() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snapshotID := mux.Vars(r)["snapshot_id"]
+
+		i := rafs.RafsGlobalCache.Get(snapshotID)
+		if i == nil {
+			err := errors.Wrapf(errdefs.ErrNotFound, "RAFS instance of snapshot %s", snapshotID)
+			m := newErrorMessage(err.Error())
+			http.Error(w, m.encode(), http.StatusNotFound)
+			return
+		}
+
+		jsonResponse(w, newRafsInstanceInfo(i))
+	}
+}
+
+// DELETE /api/v1/instances/{snapshot_id}
+// Force to umount the RAFS instance. It's up to the operator to make sure
+// that no container is using the instance any more.
+func (sc *Controller) umountInstance() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snapshotID := mux.Vars(r)["snapshot_id"]
+
+		if err := sc.fs.ForceUmount(r.Context(), snapshotID); err != nil {
+			m := newErrorMessage(err.Error())
+			http.Error(w, m.encode(), errorStatusCode(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package system
+
+import (
+	"net/http"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/prefetch"
+)
+
+// Prefetch lists are identified by query parameters `image` and optional `digest`,
+// the image manifest digest.
+func prefetchListKey(r *http.Request) (string, digest.Digest, error) {
+	image := r.URL.Query().Get("image")
+	if image == "" {
+		return "", "", errors.Wrap(errdefs.ErrInvalidArgument, "query parameter image is required")
+	}
+	manifestDigest := digest.Digest(r.URL.Query().Get("digest"))
+	if manifestDigest != "" {
+		if err := manifestDigest.Validate(); err != nil {
+			return "", "", errors.Wrapf(errdefs.ErrInvalidArgument, "digest %q", manifestDigest)
+		}
+	}
+	return image, manifestDigest, nil
+}
+
+// GET /api/v1/prefetch
+// List all prefetch lists, or get the prefetch list of an image if `image` is specified.
+func (sc *Controller) getPrefetchConfiguration() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		defer func() {
+			if err != nil {
+				m := newErrorMessage(err.Error())
+				http.Error(w, m.encode(), errorStatusCode(err))
+			}
+		}()
+
+		if !r.URL.Query().Has("image") {
+			jsonResponse(w, prefetch.Pm.List())
+			return
+		}
+
+		image, manifestDigest, err := prefetchListKey(r)
+		if err != nil {
+			return
+		}
+		entry, err := prefetch.Pm.Get(image, manifestDigest)
+		if err != nil {
+			return
+		}
+
+		jsonResponse(w, entry)
+	}
+}
+
+// DELETE /api/v1/prefetch
+func (sc *Controller) deletePrefetchConfiguration() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		defer func() {
+			if err != nil {
+				m := newErrorMessage(err.Error())
+				http.Error(w, m.encode(), errorStatusCode(err))
+			}
+		}()
+
+		image, manifestDigest, err := prefetchListKey(r)
+		if err != nil {
+			return
+		}
+		if err = prefetch.Pm.Delete(image, manifestDigest); err != nil {
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
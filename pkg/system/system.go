@@ -7,6 +7,7 @@
 package system
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -23,7 +24,10 @@ import (
 	"github.com/containerd/log"
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
 
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
 	"github.com/containerd/nydus-snapshotter/pkg/daemon"
 	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
 	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
@@ -31,7 +35,9 @@ import (
 	"github.com/containerd/nydus-snapshotter/pkg/manager"
 	metrics "github.com/containerd/nydus-snapshotter/pkg/metrics/tool"
 	"github.com/containerd/nydus-snapshotter/pkg/prefetch"
+	"github.com/containerd/nydus-snapshotter/pkg/rafs"
 	"github.com/containerd/nydus-snapshotter/pkg/utils/signals"
+	"github.com/containerd/nydus-snapshotter/pkg/warmup"
 )
 
 const (
@@ -45,6 +51,15 @@ const (
 	endpointPrefetch       string = "/api/v1/prefetch"
 	// Provide backend information
 	endpointGetBackend string = "/api/v1/daemons/{id}/backend"
+	// List recorded file access traces, or download/delete the trace of an image by manifest digest.
+	endpointAccessTraces string = "/api/v1/access-traces"
+	endpointAccessTrace  string = "/api/v1/access-traces/{digest}"
+	// List RAFS instances, get or force to umount a RAFS instance by snapshot ID.
+	endpointInstances string = "/api/v1/instances"
+	endpointInstance  string = "/api/v1/instances/{snapshot_id}"
+	// Start or list image warm-up jobs, get or cancel a warm-up job by ID.
+	endpointWarmups string = "/api/v1/warmups"
+	endpointWarmup  string = "/api/v1/warmups/{id}"
 )
 
 const defaultErrorCode string = "Unknown"
@@ -60,6 +75,7 @@ const defaultErrorCode string = "Unknown"
 type Controller struct {
 	fs       *filesystem.Filesystem
 	managers []*manager.Manager
+	warmups  *warmup.Manager
 	// httpSever *http.Server
 	addr   *net.UnixAddr
 	uid    int
@@ -67,22 +83,24 @@ type Controller struct {
 	router *mux.Router
 }
 
-type upgradeRequest struct {
+// UpgradeRequest is the body of the request to upgrade nydusd daemons.
+type UpgradeRequest struct {
 	NydusdPath string `json:"nydusd_path"`
 	Version    string `json:"version"`
 	Policy     string `json:"policy"`
 }
 
-type errorMessage struct {
+// ErrorMessage is the body of an error response.
+type ErrorMessage struct {
 	Code    string `json:"code"`
 	Message string `json:"message"`
 }
 
-func newErrorMessage(message string) errorMessage {
-	return errorMessage{Code: defaultErrorCode, Message: message}
+func newErrorMessage(message string) ErrorMessage {
+	return ErrorMessage{Code: defaultErrorCode, Message: message}
 }
 
-func (m *errorMessage) encode() string {
+func (m *ErrorMessage) encode() string {
 	msg, err := json.Marshal(&m)
 	if err != nil {
 		log.L.Errorf("Failed to encode error message, %s", err)
@@ -107,7 +125,8 @@ func jsonResponse(w http.ResponseWriter, payload interface{}) {
 	}
 }
 
-type daemonInfo struct {
+// DaemonInfo describes a nydusd daemon and the RAFS instances it serves.
+type DaemonInfo struct {
 	ID                    string  `json:"id"`
 	Pid                   int     `json:"pid"`
 	APISock               string  `json:"api_socket"`
@@ -118,14 +137,43 @@ type daemonInfo struct {
 	MemoryRSS             float64 `json:"memory_rss_kb"`
 	ReadData              float32 `json:"read_data_kb"`
 
-	Instances map[string]rafsInstanceInfo `json:"instances"`
+	Instances map[string]RafsInstanceInfo `json:"instances"`
 }
 
-type rafsInstanceInfo struct {
+// RafsInstanceInfo describes a RAFS instance.
+type RafsInstanceInfo struct {
 	SnapshotID  string `json:"snapshot_id"`
 	SnapshotDir string `json:"snapshot_dir"`
 	Mountpoint  string `json:"mountpoint"`
 	ImageID     string `json:"image_id"`
+	DaemonID    string `json:"daemon_id,omitempty"`
+	FsDriver    string `json:"fs_driver,omitempty"`
+
+	Recovery *rafs.RecoveryState `json:"recovery,omitempty"`
+}
+
+// BackendInfo describes the storage backend of a nydusd daemon.
+type BackendInfo struct {
+	BackendType string      `json:"type"`
+	Config      interface{} `json:"config"`
+}
+
+// DaemonRecords are nydusd daemons and RAFS instances persisted in database.
+type DaemonRecords struct {
+	Daemons   []*daemon.ConfigState `json:"daemons"`
+	Instances []*rafs.Rafs          `json:"instances"`
+}
+
+func newRafsInstanceInfo(r *rafs.Rafs) RafsInstanceInfo {
+	return RafsInstanceInfo{
+		SnapshotID:  r.SnapshotID,
+		SnapshotDir: r.SnapshotDir,
+		Mountpoint:  r.GetMountpoint(),
+		ImageID:     r.ImageID,
+		DaemonID:    r.DaemonID,
+		FsDriver:    r.GetFsDriver(),
+		Recovery:    r.GetRecovery(),
+	}
 }
 
 func NewSystemController(fs *filesystem.Filesystem, managers []*manager.Manager, sock string, uid, gid int) (*Controller, error) {
@@ -147,6 +195,7 @@ func NewSystemController(fs *filesystem.Filesystem, managers []*manager.Manager,
 	sc := Controller{
 		fs:       fs,
 		managers: managers,
+		warmups:  warmup.NewManager(fs),
 		addr:     addr,
 		uid:      uid,
 		gid:      gid,
@@ -177,7 +226,8 @@ func (sc *Controller) Run() error {
 		}
 	}()
 
-	err = http.Serve(listener, sc.router)
+	server := &http.Server{Handler: sc.router, ConnContext: connContext, ReadHeaderTimeout: 30 * time.Second}
+	err = server.Serve(listener)
 	if err != nil {
 		return errors.Wrapf(err, "system management serving")
 	}
@@ -190,7 +240,65 @@ func (sc *Controller) registerRouter() {
 	sc.router.HandleFunc(endpointDaemonsUpgrade, sc.upgradeDaemons()).Methods(http.MethodPut)
 	sc.router.HandleFunc(endpointDaemonRecords, sc.getDaemonRecords()).Methods(http.MethodGet)
 	sc.router.HandleFunc(endpointPrefetch, sc.setPrefetchConfiguration()).Methods(http.MethodPut)
+	sc.router.HandleFunc(endpointPrefetch, sc.getPrefetchConfiguration()).Methods(http.MethodGet)
+	sc.router.HandleFunc(endpointPrefetch, sc.deletePrefetchConfiguration()).Methods(http.MethodDelete)
 	sc.router.HandleFunc(endpointGetBackend, sc.getBackend()).Methods(http.MethodGet)
+	sc.router.HandleFunc(endpointAccessTraces, sc.listAccessTraces()).Methods(http.MethodGet)
+	sc.router.HandleFunc(endpointAccessTrace, sc.getAccessTrace()).Methods(http.MethodGet)
+	sc.router.HandleFunc(endpointAccessTrace, sc.deleteAccessTrace()).Methods(http.MethodDelete)
+	sc.router.HandleFunc(endpointInstances, sc.listInstances()).Methods(http.MethodGet)
+	sc.router.HandleFunc(endpointInstance, sc.getInstance()).Methods(http.MethodGet)
+	sc.router.HandleFunc(endpointInstance, sc.umountInstance()).Methods(http.MethodDelete)
+	sc.router.HandleFunc(endpointWarmups, sc.startWarmup()).Methods(http.MethodPost)
+	sc.router.HandleFunc(endpointWarmups, sc.listWarmups()).Methods(http.MethodGet)
+	sc.router.HandleFunc(endpointWarmup, sc.getWarmup()).Methods(http.MethodGet)
+	sc.router.HandleFunc(endpointWarmup, sc.cancelWarmup()).Methods(http.MethodDelete)
+}
+
+type connContextKey struct{}
+
+// Remember the connection of each request to tell the process sending it.
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// Get pid of the process sending the request through the unix socket.
+func peerPid(r *http.Request) (int, error) {
+	c, ok := r.Context().Value(connContextKey{}).(*net.UnixConn)
+	if !ok {
+		return 0, errors.New("request is not from a unix socket")
+	}
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var cred *unix.Ucred
+	var credErr error
+	if err := rc.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return 0, err
+	}
+	if credErr != nil {
+		return 0, errors.Wrap(credErr, "get peer credentials")
+	}
+
+	return int(cred.Pid), nil
+}
+
+// Nydusd fetches credentials of its storage backend from the system controller when backend
+// source is enabled, which are never exposed to any other process.
+func isBackendSource(r *http.Request, d *daemon.Daemon) bool {
+	if !config.IsBackendSourceEnabled() || d.Pid() <= 0 {
+		return false
+	}
+	pid, err := peerPid(r)
+	if err != nil {
+		log.L.WithError(err).Debugf("unknown peer requesting backend of daemon %s", d.ID())
+		return false
+	}
+	return pid == d.Pid()
 }
 
 func (sc *Controller) getBackend() func(w http.ResponseWriter, r *http.Request) {
@@ -214,12 +322,12 @@ func (sc *Controller) getBackend() func(w http.ResponseWriter, r *http.Request)
 
 			if d != nil {
 				backendType, backendConfig := d.Config.StorageBackend()
-				backend := struct {
-					BackendType string      `json:"type"`
-					Config      interface{} `json:"config"`
-				}{
-					backendType,
-					backendConfig,
+				backend := BackendInfo{
+					BackendType: backendType,
+					Config:      backendConfig,
+				}
+				if !isBackendSource(r, d) {
+					backend.Config = daemonconfig.SerializeWithSecretFilter(backendConfig)
 				}
 				jsonResponse(w, backend)
 				ma.Unlock()
@@ -249,20 +357,15 @@ func (sc *Controller) setPrefetchConfiguration() func(w http.ResponseWriter, r *
 
 func (sc *Controller) describeDaemons() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, _ *http.Request) {
-		info := make([]daemonInfo, 0, 10)
+		info := make([]DaemonInfo, 0, 10)
 
 		for _, manager := range sc.managers {
 			daemons := manager.ListDaemons()
 
 			for _, d := range daemons {
-				instances := make(map[string]rafsInstanceInfo)
+				instances := make(map[string]RafsInstanceInfo)
 				for _, i := range d.RafsCache.List() {
-					instances[i.SnapshotID] = rafsInstanceInfo{
-						SnapshotID:  i.SnapshotID,
-						SnapshotDir: i.SnapshotDir,
-						Mountpoint:  i.GetMountpoint(),
-						ImageID:     i.ImageID,
-					}
+					instances[i.SnapshotID] = newRafsInstanceInfo(i)
 				}
 
 				memRSS, err := metrics.GetProcessMemoryRSSKiloBytes(d.Pid())
@@ -278,7 +381,7 @@ func (sc *Controller) describeDaemons() func(w http.ResponseWriter, r *http.Requ
 					readData = float32(fsMetrics.DataRead) / 1024
 				}
 
-				i := daemonInfo{
+				i := DaemonInfo{
 					ID:                    d.ID(),
 					Pid:                   d.Pid(),
 					HostMountpoint:        d.HostMountpoint(),
@@ -297,11 +400,25 @@ func (sc *Controller) describeDaemons() func(w http.ResponseWriter, r *http.Requ
 	}
 }
 
-// TODO: Implement me!
 func (sc *Controller) getDaemonRecords() func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, _ *http.Request) {
-		m := newErrorMessage("not implemented")
-		http.Error(w, m.encode(), http.StatusNotImplemented)
+	return func(w http.ResponseWriter, r *http.Request) {
+		records := DaemonRecords{
+			Daemons:   []*daemon.ConfigState{},
+			Instances: []*rafs.Rafs{},
+		}
+
+		for _, manager := range sc.managers {
+			daemons, instances, err := manager.ListRecords(r.Context())
+			if err != nil {
+				m := newErrorMessage(err.Error())
+				http.Error(w, m.encode(), http.StatusInternalServerError)
+				return
+			}
+			records.Daemons = append(records.Daemons, daemons...)
+			records.Instances = append(records.Instances, instances...)
+		}
+
+		jsonResponse(w, &records)
 	}
 }
 
@@ -325,7 +442,7 @@ func (sc *Controller) getDaemonRecords() func(w http.ResponseWriter, r *http.Req
 // 6. Delete the old nydusd executive
 func (sc *Controller) upgradeDaemons() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var c upgradeRequest
+		var c UpgradeRequest
 		var err error
 		var statusCode int
 
@@ -377,7 +494,7 @@ func (sc *Controller) upgradeDaemons() func(w http.ResponseWriter, r *http.Reque
 
 // Provide minimal parameters since most of it can be recovered by nydusd states.
 // Create a new daemon in Manger to take over the service.
-func (sc *Controller) upgradeNydusDaemon(d *daemon.Daemon, c upgradeRequest, manager *manager.Manager) error {
+func (sc *Controller) upgradeNydusDaemon(d *daemon.Daemon, c UpgradeRequest, manager *manager.Manager) error {
 	supervisor := d.Supervisor
 	if supervisor == nil {
 		return errors.New("should set recover policy to failover to enable hot upgrade")
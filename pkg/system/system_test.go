@@ -7,9 +7,25 @@
 package system
 
 import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon"
+	"github.com/containerd/nydus-snapshotter/pkg/manager"
+	"github.com/containerd/nydus-snapshotter/pkg/rafs"
+	"github.com/containerd/nydus-snapshotter/pkg/store"
 )
 
 func TestBuildUpgradeSocket(t *testing.T) {
@@ -37,3 +53,102 @@ func TestBuildUpgradeSocket(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, "api223.sock", next)
 }
+
+func TestInstances(t *testing.T) {
+	sc := &Controller{router: mux.NewRouter()}
+	sc.registerRouter()
+
+	rafs.RafsGlobalCache.Add(&rafs.Rafs{SnapshotID: "2", ImageID: "image", DaemonID: "d1",
+		FsDriver: config.FsDriverFusedev, Mountpoint: "/mnt/2"})
+	rafs.RafsGlobalCache.Add(&rafs.Rafs{SnapshotID: "1", ImageID: "image", DaemonID: "d1",
+		FsDriver: config.FsDriverFusedev, Mountpoint: "/mnt/1"})
+	defer rafs.RafsGlobalCache.Remove("1")
+	defer rafs.RafsGlobalCache.Remove("2")
+
+	rec := httptest.NewRecorder()
+	sc.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, endpointInstances, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var instances []RafsInstanceInfo
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &instances))
+	assert.Len(t, instances, 2)
+	assert.Equal(t, "1", instances[0].SnapshotID)
+	assert.Equal(t, "/mnt/1", instances[0].Mountpoint)
+	assert.Equal(t, "d1", instances[0].DaemonID)
+
+	rec = httptest.NewRecorder()
+	sc.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, endpointInstances+"/2", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var instance RafsInstanceInfo
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &instance))
+	assert.Equal(t, "2", instance.SnapshotID)
+	assert.Equal(t, config.FsDriverFusedev, instance.FsDriver)
+
+	rec = httptest.NewRecorder()
+	sc.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, endpointInstances+"/3", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestGetBackend(t *testing.T) {
+	require.NoError(t, config.ProcessConfigurations(&config.SnapshotterConfig{
+		Root:                   t.TempDir(),
+		DaemonMode:             string(config.DaemonModeDedicated),
+		DaemonConfig:           config.DaemonConfig{FsDriver: config.FsDriverFusedev},
+		SystemControllerConfig: config.SystemControllerConfig{Enable: true},
+		Experimental:           config.Experimental{EnableBackendSource: true},
+	}))
+
+	db, err := store.NewDatabase(t.TempDir())
+	require.NoError(t, err)
+	m, err := manager.NewManager(manager.Opt{Database: db, FsDriver: config.FsDriverFusedev, RootDir: t.TempDir()})
+	require.NoError(t, err)
+	d, err := daemon.NewDaemon(daemon.WithFsDriver(config.FsDriverFusedev))
+	require.NoError(t, err)
+	cfg := &daemonconfig.FuseDaemonConfig{Device: &daemonconfig.DeviceConfig{}}
+	cfg.Device.Backend.BackendType = "registry"
+	cfg.Device.Backend.Config.Host = "registry.example.com"
+	cfg.Device.Backend.Config.Auth = "secret"
+	d.Config = cfg
+	require.NoError(t, m.AddDaemon(d))
+
+	sc := &Controller{managers: []*manager.Manager{m}, router: mux.NewRouter()}
+	sc.registerRouter()
+	sock := filepath.Join(t.TempDir(), "system.sock")
+	ts := httptest.NewUnstartedServer(sc.router)
+	ts.Config.ConnContext = connContext
+	ts.Listener, err = net.Listen("unix", sock)
+	require.NoError(t, err)
+	ts.Start()
+	defer ts.Close()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sock)
+		},
+	}}
+	getBackend := func(id string) (int, map[string]interface{}) {
+		resp, err := client.Get("http://unix/api/v1/daemons/" + id + "/backend")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var backend struct {
+			Config map[string]interface{} `json:"config"`
+		}
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&backend))
+		}
+		return resp.StatusCode, backend.Config
+	}
+
+	code, _ := getBackend("missing")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// Credentials are redacted for processes other than nydusd.
+	code, backend := getBackend(d.ID())
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "registry.example.com", backend["host"])
+	assert.NotContains(t, backend, "auth")
+
+	// Nydusd gets its credentials when backend source is enabled.
+	d.States.ProcessID = os.Getpid()
+	_, backend = getBackend(d.ID())
+	assert.Equal(t, "secret", backend["auth"])
+}
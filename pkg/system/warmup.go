@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package system
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/warmup"
+)
+
+// WarmupRequest is the body of the request to warm up an image.
+type WarmupRequest struct {
+	Image string `json:"image"`
+}
+
+func (sc *Controller) warmupManager() (*warmup.Manager, error) {
+	if sc.warmups == nil {
+		return nil, errors.Wrap(errdefs.ErrNotFound, "image warm-up is not available")
+	}
+	return sc.warmups, nil
+}
+
+// POST /api/v1/warmups
+// Start downloading the whole image into the local blob cache.
+func (sc *Controller) startWarmup() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, err := func() (warmup.Status, error) {
+			m, err := sc.warmupManager()
+			if err != nil {
+				return warmup.Status{}, err
+			}
+			var req WarmupRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				return warmup.Status{}, errors.Wrapf(errdefs.ErrInvalidArgument, "decode request, %s", err)
+			}
+			return m.Start(req.Image)
+		}()
+		if err != nil {
+			m := newErrorMessage(err.Error())
+			http.Error(w, m.encode(), errorStatusCode(err))
+			return
+		}
+
+		jsonResponse(w, &status)
+	}
+}
+
+// GET /api/v1/warmups
+func (sc *Controller) listWarmups() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		m, err := sc.warmupManager()
+		if err != nil {
+			m := newErrorMessage(err.Error())
+			http.Error(w, m.encode(), errorStatusCode(err))
+			return
+		}
+
+		jsonResponse(w, m.List())
+	}
+}
+
+// GET /api/v1/warmups/{id}
+func (sc *Controller) getWarmup() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, err := func() (warmup.Status, error) {
+			m, err := sc.warmupManager()
+			if err != nil {
+				return warmup.Status{}, err
+			}
+			return m.Get(mux.Vars(r)["id"])
+		}()
+		if err != nil {
+			m := newErrorMessage(err.Error())
+			http.Error(w, m.encode(), errorStatusCode(err))
+			return
+		}
+
+		jsonResponse(w, &status)
+	}
+}
+
+// DELETE /api/v1/warmups/{id}
+// Cancel the warm-up job, blob caches already downloaded are kept.
+func (sc *Controller) cancelWarmup() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, err := func() (warmup.Status, error) {
+			m, err := sc.warmupManager()
+			if err != nil {
+				return warmup.Status{}, err
+			}
+			return m.Cancel(mux.Vars(r)["id"])
+		}()
+		if err != nil {
+			m := newErrorMessage(err.Error())
+			http.Error(w, m.encode(), errorStatusCode(err))
+			return
+		}
+
+		jsonResponse(w, &status)
+	}
+}
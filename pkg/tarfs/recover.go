@@ -0,0 +1,303 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package tarfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/containerd/log"
+	losetup "github.com/freddierice/go-losetup"
+	"github.com/moby/sys/mountinfo"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/config"
+)
+
+// Store persists states of tarfs snapshots so that they can be recovered after
+// the snapshotter restarts.
+type Store interface {
+	SaveTarfsSnapshot(ctx context.Context, r *SnapshotRecord) error
+	DeleteTarfsSnapshot(ctx context.Context, snapshotID string) error
+	WalkTarfsSnapshots(ctx context.Context, cb func(r *SnapshotRecord) error) error
+}
+
+// SnapshotRecord is the persisted state of a tarfs snapshot.
+type SnapshotRecord struct {
+	SnapshotID      string `json:"snapshot_id"`
+	Status          int    `json:"status"`
+	BlobID          string `json:"blob_id,omitempty"`
+	UpperDirPath    string `json:"upper_dir_path"`
+	ErofsMountPoint string `json:"erofs_mountpoint,omitempty"`
+	DataLoopdev     string `json:"data_loopdev,omitempty"`
+	MetaLoopdev     string `json:"meta_loopdev,omitempty"`
+	DiskFilePath    string `json:"disk_file_path,omitempty"`
+}
+
+var (
+	// Where to find backing files of loop devices.
+	sysBlockPath = "/sys/block"
+	// Get erofs mounts from /proc/self/mountinfo.
+	getErofsMounts = func() ([]*mountinfo.Info, error) {
+		return mountinfo.GetMounts(mountinfo.FSTypeFilter("erofs"))
+	}
+
+	blobIDPattern = regexp.MustCompile(`"blob_id":"([0-9a-f]+)"`)
+)
+
+func (st *snapshotStatus) record() *SnapshotRecord {
+	r := &SnapshotRecord{
+		SnapshotID:      st.snapshotID,
+		Status:          st.status,
+		BlobID:          st.blobID,
+		UpperDirPath:    st.upperDirPath,
+		ErofsMountPoint: st.erofsMountPoint,
+		DiskFilePath:    st.diskFilePath,
+	}
+	if st.dataLoopdev != nil {
+		r.DataLoopdev = st.dataLoopdev.Path()
+	}
+	if st.metaLoopdev != nil {
+		r.MetaLoopdev = st.metaLoopdev.Path()
+	}
+	return r
+}
+
+// Persist state of the tarfs snapshot, the caller must hold the lock of the status.
+func (t *Manager) saveSnapshotStatus(st *snapshotStatus) {
+	if t.store == nil {
+		return
+	}
+	if err := t.store.SaveTarfsSnapshot(context.Background(), st.record()); err != nil {
+		log.L.WithError(err).Warnf("failed to persist state of tarfs snapshot %s", st.snapshotID)
+	}
+}
+
+func (t *Manager) deleteSnapshotStatus(snapshotID string) {
+	if t.store == nil {
+		return
+	}
+	if err := t.store.DeleteTarfsSnapshot(context.Background(), snapshotID); err != nil {
+		log.L.WithError(err).Warnf("failed to delete state of tarfs snapshot %s", snapshotID)
+	}
+}
+
+// Recover rebuilds states of tarfs snapshots after the snapshotter restarts, and
+// persists states of tarfs snapshots into `s` from now on.
+//
+// Besides the persisted records, layers converted by a snapshotter without
+// persistence are found by rescanning bootstraps in `snapshotsRoot`. Attached loop
+// devices and erofs mounts are rebuilt from sysfs and /proc/self/mountinfo, since
+// they may have changed when the snapshotter was not running. Block images exported
+// from layers are rescanned from the cache directory.
+func (t *Manager) Recover(ctx context.Context, s Store, snapshotsRoot string) error {
+	records := map[string]*SnapshotRecord{}
+	if err := s.WalkTarfsSnapshots(ctx, func(r *SnapshotRecord) error {
+		records[r.SnapshotID] = r
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "walk tarfs snapshots")
+	}
+
+	entries, err := os.ReadDir(snapshotsRoot)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "read snapshots directory %s", snapshotsRoot)
+	}
+	for _, e := range entries {
+		if _, ok := records[e.Name()]; ok || !e.IsDir() {
+			continue
+		}
+		upperDirPath := filepath.Join(snapshotsRoot, e.Name(), "fs")
+		if _, err := os.Stat(t.layerMetaFilePath(upperDirPath)); err == nil {
+			records[e.Name()] = &SnapshotRecord{
+				SnapshotID:   e.Name(),
+				Status:       TarfsStatusReady,
+				UpperDirPath: upperDirPath,
+			}
+		}
+	}
+
+	loopdevs, err := scanLoopdevs()
+	if err != nil {
+		return errors.Wrap(err, "scan loop devices")
+	}
+	mounts, err := getErofsMounts()
+	if err != nil {
+		return errors.Wrap(err, "get erofs mounts")
+	}
+	mountpoints := make(map[string]*mountinfo.Info, len(mounts))
+	for _, m := range mounts {
+		mountpoints[m.Mountpoint] = m
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.store = s
+
+	for _, r := range records {
+		st := t.recoverSnapshot(r, loopdevs, mountpoints)
+		if st == nil {
+			log.L.Infof("tarfs snapshot %s was removed, drop its record", r.SnapshotID)
+			t.deleteSnapshotStatus(r.SnapshotID)
+			continue
+		}
+
+		t.snapshotMap[r.SnapshotID] = st
+		t.saveSnapshotStatus(st)
+		log.L.Infof("recovered tarfs snapshot %s, status %d, mountpoint %q",
+			st.snapshotID, st.status, st.erofsMountPoint)
+	}
+
+	return nil
+}
+
+// Rebuild the status of a tarfs snapshot from its record, return nil if the snapshot is gone.
+func (t *Manager) recoverSnapshot(r *SnapshotRecord, loopdevs map[string][]string, mountpoints map[string]*mountinfo.Info) *snapshotStatus {
+	if _, err := os.Stat(r.UpperDirPath); err != nil {
+		return nil
+	}
+
+	_, cancel := context.WithCancel(context.Background())
+	st := &snapshotStatus{
+		snapshotID:   r.SnapshotID,
+		upperDirPath: r.UpperDirPath,
+		status:       TarfsStatusReady,
+		blobID:       r.BlobID,
+		wg:           &sync.WaitGroup{},
+		cancel:       cancel,
+	}
+
+	layerMetaFile := t.layerMetaFilePath(r.UpperDirPath)
+	if _, err := os.Stat(layerMetaFile); err != nil {
+		// The conversion was interrupted by the restart.
+		st.status = TarfsStatusFailed
+		return st
+	}
+
+	if st.blobID == "" {
+		blobInfo, err := t.getImageBlobInfo(layerMetaFile)
+		if err != nil {
+			log.L.WithError(err).Warnf("failed to get blob of tarfs snapshot %s", r.SnapshotID)
+			st.status = TarfsStatusFailed
+			return st
+		}
+		if m := blobIDPattern.FindStringSubmatch(blobInfo); m != nil {
+			st.blobID = m[1]
+		}
+	}
+	if st.blobID != "" {
+		st.blobTarFilePath = t.layerTarFilePath(st.blobID)
+		st.dataLoopdev = claimLoopdev(loopdevs, st.blobTarFilePath, r.DataLoopdev)
+		st.diskFilePath = t.recoverDiskFile(st.blobID, r.DiskFilePath)
+	}
+
+	mergedBootstrap := t.imageMetaFilePath(r.UpperDirPath)
+	st.metaLoopdev = claimLoopdev(loopdevs, mergedBootstrap, r.MetaLoopdev)
+
+	mountPoint := filepath.Join(filepath.Dir(r.UpperDirPath), "mnt")
+	if m, ok := mountpoints[mountPoint]; ok {
+		st.erofsMountPoint = mountPoint
+		// The erofs filesystem is mounted from the loop device of the merged bootstrap.
+		if st.metaLoopdev == nil {
+			st.metaLoopdev = claimLoopdev(loopdevs, mergedBootstrap, m.Source)
+		}
+	}
+
+	return st
+}
+
+// Find the block image exported from the layer, return an empty path if there is none.
+//
+// Exports interrupted by the restart leave temporary files behind, remove them so the
+// block images are exported again. The recorded block image is kept if the export mode
+// has changed since it was exported, otherwise the one of current export mode is taken.
+func (t *Manager) recoverDiskFile(blobID, recorded string) string {
+	for _, path := range []string{t.LayerDiskFilePath(blobID), t.ImageDiskFilePath(blobID)} {
+		if err := os.Remove(path + ".tarfs.tmp"); err == nil {
+			log.L.Infof("removed interrupted block export %s", path)
+		}
+	}
+
+	if recorded != "" {
+		if _, err := os.Stat(recorded); err == nil {
+			return recorded
+		}
+		log.L.Warnf("exported block image %s is gone", recorded)
+	}
+
+	wholeImage, exportDisk, withVerity := config.GetTarfsExportFlags()
+	if !exportDisk && !withVerity {
+		return ""
+	}
+	path := t.LayerDiskFilePath(blobID)
+	if wholeImage {
+		path = t.ImageDiskFilePath(blobID)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// Find loop devices and their backing files, indexed by backing files.
+func scanLoopdevs() (map[string][]string, error) {
+	entries, err := os.ReadDir(sysBlockPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string][]string{}, nil
+		}
+		return nil, err
+	}
+
+	loopdevs := map[string][]string{}
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), "loop") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(sysBlockPath, e.Name(), "loop", "backing_file"))
+		if err != nil {
+			// Not attached to any file.
+			continue
+		}
+		backingFile := strings.TrimSpace(string(data))
+		loopdevs[backingFile] = append(loopdevs[backingFile], "/dev/"+e.Name())
+	}
+
+	return loopdevs, nil
+}
+
+// Take a loop device attached to the backing file, the preferred one is taken if it's
+// still attached to the file. Every loop device can only be taken once.
+func claimLoopdev(loopdevs map[string][]string, backingFile, preferred string) *losetup.Device {
+	devs := loopdevs[backingFile]
+	if len(devs) == 0 {
+		return nil
+	}
+
+	idx := 0
+	for i, d := range devs {
+		if d == preferred {
+			idx = i
+			break
+		}
+	}
+	path := devs[idx]
+	loopdevs[backingFile] = append(devs[:idx:idx], devs[idx+1:]...)
+
+	var number uint64
+	if _, err := fmt.Sscanf(path, losetup.DeviceFormatString, &number); err != nil {
+		log.L.WithError(err).Warnf("invalid loop device %s", path)
+		return nil
+	}
+	dev := losetup.New(number, os.O_RDWR)
+	return &dev
+}
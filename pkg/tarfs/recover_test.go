@@ -0,0 +1,217 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package tarfs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/moby/sys/mountinfo"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/config"
+)
+
+type memStore map[string]SnapshotRecord
+
+func (s memStore) SaveTarfsSnapshot(_ context.Context, r *SnapshotRecord) error {
+	s[r.SnapshotID] = *r
+	return nil
+}
+
+func (s memStore) DeleteTarfsSnapshot(_ context.Context, snapshotID string) error {
+	delete(s, snapshotID)
+	return nil
+}
+
+func (s memStore) WalkTarfsSnapshots(_ context.Context, cb func(r *SnapshotRecord) error) error {
+	for _, r := range s {
+		r := r
+		if err := cb(&r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestRecover(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, config.ProcessConfigurations(&config.SnapshotterConfig{Root: root, DaemonMode: string(config.DaemonModeShared)}))
+	snapshotsRoot := filepath.Join(root, "snapshots")
+	cacheDir := filepath.Join(root, "cache")
+	require.NoError(t, os.MkdirAll(cacheDir, 0755))
+
+	// Fake nydus-image to inspect blobs of a layer bootstrap.
+	nydusImage := filepath.Join(root, "nydus-image")
+	err := os.WriteFile(nydusImage, []byte("#!/bin/sh\necho '[{\"blob_id\":\"cc\"}]'\n"), 0755)
+	require.NoError(t, err)
+
+	upperDir := func(id string) string {
+		return filepath.Join(snapshotsRoot, id, "fs")
+	}
+	writeFile := func(path string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, nil, 0644))
+	}
+
+	defer func(path string, getMounts func() ([]*mountinfo.Info, error)) {
+		sysBlockPath, getErofsMounts = path, getMounts
+	}(sysBlockPath, getErofsMounts)
+
+	sysBlockPath = filepath.Join(root, "sys")
+	attachLoopdev := func(name, backingFile string) {
+		path := filepath.Join(sysBlockPath, name, "loop", "backing_file")
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(backingFile+"\n"), 0644))
+	}
+	getErofsMounts = func() ([]*mountinfo.Info, error) {
+		return []*mountinfo.Info{{
+			Mountpoint: filepath.Join(snapshotsRoot, "2", "mnt"),
+			Source:     "/dev/loop7",
+			FSType:     "erofs",
+		}}, nil
+	}
+
+	s := memStore{}
+	// Lower layer of an image with its tar file attached.
+	writeFile(filepath.Join(upperDir("1"), "image", TarfsLayerBootstrapName))
+	writeFile(filepath.Join(cacheDir, "aa"))
+	attachLoopdev("loop3", filepath.Join(cacheDir, "aa"))
+	s["1"] = SnapshotRecord{SnapshotID: "1", Status: TarfsStatusReady, BlobID: "aa", UpperDirPath: upperDir("1"), DataLoopdev: "/dev/loop3"}
+	// Upper layer of an image with merged bootstrap mounted.
+	writeFile(filepath.Join(upperDir("2"), "image", TarfsLayerBootstrapName))
+	writeFile(filepath.Join(upperDir("2"), "image", TarfsImageBootstrapName))
+	attachLoopdev("loop5", filepath.Join(cacheDir, "bb"))
+	attachLoopdev("loop7", filepath.Join(upperDir("2"), "image", TarfsImageBootstrapName))
+	s["2"] = SnapshotRecord{SnapshotID: "2", Status: TarfsStatusReady, BlobID: "bb", UpperDirPath: upperDir("2")}
+	// Layer converted without persistence.
+	writeFile(filepath.Join(upperDir("3"), "image", TarfsLayerBootstrapName))
+	// Conversion interrupted by restart.
+	require.NoError(t, os.MkdirAll(upperDir("4"), 0755))
+	s["4"] = SnapshotRecord{SnapshotID: "4", Status: TarfsStatusPrepare, BlobID: "dd", UpperDirPath: upperDir("4")}
+	// Snapshot removed when snapshotter is not running.
+	s["5"] = SnapshotRecord{SnapshotID: "5", Status: TarfsStatusReady, BlobID: "ee", UpperDirPath: upperDir("5")}
+
+	m := NewManager(false, false, cacheDir, nydusImage, 0)
+	require.NoError(t, m.Recover(context.Background(), s, snapshotsRoot))
+
+	require.Len(t, m.snapshotMap, 4)
+	require.NoError(t, m.waitLayerReady("1"))
+	st := m.snapshotMap["1"]
+	require.Equal(t, filepath.Join(cacheDir, "aa"), st.blobTarFilePath)
+	require.Equal(t, "/dev/loop3", st.dataLoopdev.Path())
+	require.Nil(t, st.metaLoopdev)
+	require.Empty(t, st.erofsMountPoint)
+
+	st = m.snapshotMap["2"]
+	require.Equal(t, "/dev/loop5", st.dataLoopdev.Path())
+	require.Equal(t, "/dev/loop7", st.metaLoopdev.Path())
+	require.Equal(t, filepath.Join(snapshotsRoot, "2", "mnt"), st.erofsMountPoint)
+
+	st = m.snapshotMap["3"]
+	require.Equal(t, TarfsStatusReady, st.status)
+	require.Equal(t, "cc", st.blobID)
+
+	require.Error(t, m.waitLayerReady("4"))
+
+	// States are persisted again.
+	require.Len(t, s, 4)
+	require.Equal(t, "/dev/loop7", s["2"].MetaLoopdev)
+	require.Equal(t, "cc", s["3"].BlobID)
+	require.Equal(t, TarfsStatusFailed, s["4"].Status)
+}
+
+func TestRecoverBlockExports(t *testing.T) {
+	root := t.TempDir()
+	snapshotsRoot := filepath.Join(root, "snapshots")
+	cacheDir := filepath.Join(root, "cache")
+	require.NoError(t, os.MkdirAll(cacheDir, 0755))
+
+	defer func(path string, getMounts func() ([]*mountinfo.Info, error)) {
+		sysBlockPath, getErofsMounts = path, getMounts
+	}(sysBlockPath, getErofsMounts)
+	sysBlockPath = filepath.Join(root, "sys")
+	getErofsMounts = func() ([]*mountinfo.Info, error) {
+		return nil, nil
+	}
+
+	writeFile := func(path string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, nil, 0644))
+	}
+	layer := func(s memStore, id, blobID string) {
+		upperDir := filepath.Join(snapshotsRoot, id, "fs")
+		writeFile(filepath.Join(upperDir, "image", TarfsLayerBootstrapName))
+		s[id] = SnapshotRecord{SnapshotID: id, Status: TarfsStatusReady, BlobID: blobID, UpperDirPath: upperDir}
+	}
+
+	for _, tc := range []struct {
+		mode string
+		// Whether the block image is exported from the whole image.
+		wholeImage bool
+	}{
+		{mode: "layer_block"},
+		{mode: "layer_block_with_verity"},
+		{mode: "image_block", wholeImage: true},
+		{mode: "image_block_with_verity", wholeImage: true},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			require.NoError(t, config.ProcessConfigurations(&config.SnapshotterConfig{
+				Root:         root,
+				DaemonMode:   string(config.DaemonModeShared),
+				Experimental: config.Experimental{TarfsConfig: config.TarfsConfig{ExportMode: tc.mode}},
+			}))
+			m := NewManager(false, false, cacheDir, "", 0)
+			diskFilePath := m.LayerDiskFilePath
+			if tc.wholeImage {
+				diskFilePath = m.ImageDiskFilePath
+			}
+
+			s := memStore{}
+			// Block image exported before the restart.
+			layer(s, "1", "aa")
+			writeFile(diskFilePath("aa"))
+			r := s["1"]
+			r.DiskFilePath = diskFilePath("aa")
+			s["1"] = r
+			// Block image exported by a snapshotter without persistence.
+			layer(s, "2", "bb")
+			writeFile(diskFilePath("bb"))
+			// Export interrupted by the restart.
+			layer(s, "3", "cc")
+			writeFile(diskFilePath("cc") + ".tarfs.tmp")
+			// Block image removed when snapshotter is not running.
+			layer(s, "4", "dd")
+			r = s["4"]
+			r.DiskFilePath = diskFilePath("dd")
+			s["4"] = r
+			// Block image exported in another export mode.
+			layer(s, "5", "ee")
+			writeFile(filepath.Join(cacheDir, "ee.other.disk"))
+			r = s["5"]
+			r.DiskFilePath = filepath.Join(cacheDir, "ee.other.disk")
+			s["5"] = r
+
+			require.NoError(t, m.Recover(context.Background(), s, snapshotsRoot))
+			require.Len(t, m.snapshotMap, 5)
+			require.Equal(t, diskFilePath("aa"), m.snapshotMap["1"].diskFilePath)
+			require.Equal(t, diskFilePath("bb"), m.snapshotMap["2"].diskFilePath)
+			require.Equal(t, diskFilePath("bb"), s["2"].DiskFilePath)
+			require.Empty(t, m.snapshotMap["3"].diskFilePath)
+			require.NoFileExists(t, diskFilePath("cc")+".tarfs.tmp")
+			require.Empty(t, m.snapshotMap["4"].diskFilePath)
+			require.Empty(t, s["4"].DiskFilePath)
+			require.Equal(t, filepath.Join(cacheDir, "ee.other.disk"), m.snapshotMap["5"].diskFilePath)
+
+			require.NoError(t, os.RemoveAll(snapshotsRoot))
+			require.NoError(t, os.RemoveAll(cacheDir))
+			require.NoError(t, os.MkdirAll(cacheDir, 0755))
+		})
+	}
+}
@@ -23,6 +23,7 @@ import (
 
 	"github.com/containerd/containerd/v2/core/snapshots/storage"
 	"github.com/containerd/containerd/v2/pkg/archive/compression"
+	"github.com/containerd/containerd/v2/pkg/tracing"
 	"github.com/containerd/log"
 	"github.com/containerd/nydus-snapshotter/config"
 	"github.com/containerd/nydus-snapshotter/pkg/auth"
@@ -35,12 +36,16 @@ import (
 	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/semaphore"
 	"golang.org/x/sync/singleflight"
 	"golang.org/x/sys/unix"
 	"k8s.io/utils/lru"
 )
 
+// Prefix of span names of tarfs operations.
+const spanPrefix = "nydus.tarfs"
+
 const (
 	TarfsStatusInit    = 0
 	TarfsStatusPrepare = 1
@@ -70,14 +75,18 @@ type Manager struct {
 	tarfsHintCache       *lru.Cache // cache oci image ref and tarfs hint annotation
 	diffIDCache          *lru.Cache // cache oci blob digest and diffID
 	sg                   singleflight.Group
+	store                Store // persist tarfs snapshots status if not nil
 }
 
 type snapshotStatus struct {
 	mutex           sync.Mutex
+	snapshotID      string
+	upperDirPath    string
 	status          int
 	blobID          string
 	blobTarFilePath string
 	erofsMountPoint string
+	diskFilePath    string
 	dataLoopdev     *losetup.Device
 	metaLoopdev     *losetup.Device
 	wg              *sync.WaitGroup
@@ -327,6 +336,7 @@ func (t *Manager) blobProcess(ctx context.Context, wg *sync.WaitGroup, snapshotI
 		} else {
 			st.status = TarfsStatusReady
 		}
+		t.saveSnapshotStatus(st)
 		log.L.Info(msg)
 	}
 
@@ -349,6 +359,10 @@ func (t *Manager) blobProcess(ctx context.Context, wg *sync.WaitGroup, snapshotI
 		defer wg.Done()
 		defer rc.Close()
 
+		_, span := tracing.StartSpan(ctx, tracing.Name(spanPrefix, "ConvertLayer"),
+			tracing.WithAttribute("snapshot.id", snapshotID))
+		defer span.End()
+
 		ds, err := compression.DecompressStream(rc)
 		if err != nil {
 			epilog(err, "unpack layer blob stream for tarfs")
@@ -388,7 +402,17 @@ func (t *Manager) blobProcess(ctx context.Context, wg *sync.WaitGroup, snapshotI
 	return err
 }
 
-func (t *Manager) PrepareLayer(snapshotID, ref string, manifestDigest, layerDigest digest.Digest, upperDirPath string) error {
+// PrepareLayer starts to convert the layer into tarfs in background, returns once the layer
+// blob is available to download.
+func (t *Manager) PrepareLayer(ctx context.Context, snapshotID, ref string, manifestDigest, layerDigest digest.Digest, upperDirPath string) (err error) {
+	ctx, span := tracing.StartSpan(ctx, tracing.Name(spanPrefix, "PrepareLayer"),
+		tracing.WithAttribute("snapshot.id", snapshotID),
+		tracing.WithAttribute("layer.digest", layerDigest.String()))
+	defer func() {
+		span.SetStatus(err)
+		span.End()
+	}()
+
 	t.mutex.Lock()
 	if _, ok := t.snapshotMap[snapshotID]; ok {
 		t.mutex.Unlock()
@@ -396,14 +420,22 @@ func (t *Manager) PrepareLayer(snapshotID, ref string, manifestDigest, layerDige
 	}
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
-	ctx, cancel := context.WithCancel(context.Background())
-
-	t.snapshotMap[snapshotID] = &snapshotStatus{
-		status: TarfsStatusPrepare,
-		wg:     wg,
-		cancel: cancel,
-	}
+	// The conversion outlives the request preparing the layer, but is still traced as part of it.
+	ctx, cancel := context.WithCancel(trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx)))
+
+	st := &snapshotStatus{
+		snapshotID:   snapshotID,
+		upperDirPath: upperDirPath,
+		blobID:       layerDigest.Hex(),
+		status:       TarfsStatusPrepare,
+		wg:           wg,
+		cancel:       cancel,
+	}
+	t.snapshotMap[snapshotID] = st
+	st.mutex.Lock()
 	t.mutex.Unlock()
+	t.saveSnapshotStatus(st)
+	st.mutex.Unlock()
 
 	return t.blobProcess(ctx, wg, snapshotID, ref, manifestDigest, layerDigest, upperDirPath)
 }
@@ -517,6 +549,7 @@ func (t *Manager) ExportBlockData(s storage.Snapshot, perLayer bool, labels map[
 
 	// Do not regenerate if the disk image already exists.
 	if _, err := os.Stat(diskFileName); err == nil {
+		t.setDiskFilePath(st, diskFileName)
 		return updateFields, nil
 	}
 	diskFileNameTmp := diskFileName + ".tarfs.tmp"
@@ -566,6 +599,7 @@ func (t *Manager) ExportBlockData(s storage.Snapshot, perLayer bool, labels map[
 	if err != nil {
 		return updateFields, errors.Wrap(err, "rename disk image file")
 	}
+	t.setDiskFilePath(st, diskFileName)
 
 	return updateFields, nil
 }
@@ -617,6 +651,7 @@ func (t *Manager) MountTarErofs(snapshotID string, s *storage.Snapshot, labels m
 					return errors.Wrapf(err, "attach layer tar file %s to loopdev", st.blobTarFilePath)
 				}
 				st.dataLoopdev = loopdev
+				t.saveSnapshotStatus(st)
 			}
 			devices = append(devices, "device="+st.dataLoopdev.Path())
 		}
@@ -646,6 +681,7 @@ func (t *Manager) MountTarErofs(snapshotID string, s *storage.Snapshot, labels m
 			return errors.Wrapf(err, "attach merged bootstrap %s to loopdev", mergedBootstrap)
 		}
 		st.metaLoopdev = loopdev
+		t.saveSnapshotStatus(st)
 	}
 	devName := st.metaLoopdev.Path()
 
@@ -658,6 +694,7 @@ func (t *Manager) MountTarErofs(snapshotID string, s *storage.Snapshot, labels m
 		return errors.Wrapf(err, "mount erofs at %s with opts %s", mountPoint, mountOpts)
 	}
 	st.erofsMountPoint = mountPoint
+	t.saveSnapshotStatus(st)
 	rafs.SetMountpoint(mountPoint)
 	return nil
 }
@@ -675,6 +712,7 @@ func (t *Manager) UmountTarErofs(snapshotID string) error {
 			return errors.Wrapf(err, "umount erofs tarfs %s", st.erofsMountPoint)
 		}
 		st.erofsMountPoint = ""
+		t.saveSnapshotStatus(st)
 	}
 
 	return nil
@@ -698,6 +736,7 @@ func (t *Manager) DetachLayer(snapshotID string) error {
 	if st.metaLoopdev != nil {
 		err := st.metaLoopdev.Detach()
 		if err != nil {
+			t.saveSnapshotStatus(st)
 			st.mutex.Unlock()
 			return errors.Wrapf(err, "detach merged bootstrap loopdev for tarfs snapshot %s", snapshotID)
 		}
@@ -707,6 +746,7 @@ func (t *Manager) DetachLayer(snapshotID string) error {
 	if st.dataLoopdev != nil {
 		err := st.dataLoopdev.Detach()
 		if err != nil {
+			t.saveSnapshotStatus(st)
 			st.mutex.Unlock()
 			return errors.Wrapf(err, "detach layer bootstrap loopdev for tarfs snapshot %s", snapshotID)
 		}
@@ -719,10 +759,20 @@ func (t *Manager) DetachLayer(snapshotID string) error {
 
 	t.mutex.Lock()
 	delete(t.snapshotMap, snapshotID)
+	t.deleteSnapshotStatus(snapshotID)
 	t.mutex.Unlock()
 	return nil
 }
 
+func (t *Manager) setDiskFilePath(st *snapshotStatus, diskFilePath string) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	if st.diskFilePath != diskFilePath {
+		st.diskFilePath = diskFilePath
+		t.saveSnapshotStatus(st)
+	}
+}
+
 func (t *Manager) getSnapshotStatus(snapshotID string, lock bool) (*snapshotStatus, error) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
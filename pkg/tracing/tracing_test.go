@@ -0,0 +1,58 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/v2/pkg/tracing"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/containerd/nydus-snapshotter/config"
+)
+
+func TestInit(t *testing.T) {
+	ctx := context.Background()
+
+	shutdown, err := Init(ctx, config.TracingConfig{})
+	require.NoError(t, err)
+	require.NoError(t, shutdown(ctx))
+	require.Empty(t, ServerOptions(config.TracingConfig{}))
+
+	exportFile := filepath.Join(t.TempDir(), "spans.json")
+	cfg := config.TracingConfig{Enable: true, ExportFile: exportFile}
+	shutdown, err = Init(ctx, cfg)
+	require.NoError(t, err)
+	require.Len(t, ServerOptions(cfg), 1)
+
+	// Continue the trace propagated by containerd, like the gRPC server handler.
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	carrier := propagation.MapCarrier{"traceparent": "00-" + traceID + "-00f067aa0ba902b7-01"}
+	ctx = otel.GetTextMapPropagator().Extract(ctx, carrier)
+	ctx, server := otel.Tracer("").Start(ctx, "Prepare")
+	_, span := tracing.StartSpan(ctx, tracing.Name("snapshot", "remoteHandler"))
+	span.End()
+	server.End()
+	require.NoError(t, shutdown(context.Background()))
+
+	data, err := os.ReadFile(exportFile)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[0], `"Name":"snapshot.remoteHandler"`)
+	require.Contains(t, lines[1], `"Name":"Prepare"`)
+	for _, line := range lines {
+		require.Contains(t, line, traceID)
+		require.Contains(t, line, serviceName)
+	}
+}
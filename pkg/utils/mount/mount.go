@@ -39,6 +39,17 @@ func (m *Mounter) Umount(target string) error {
 	return syscall.Unmount(target, 0)
 }
 
+// LazyUmount detaches the mountpoint even if it's busy, e.g. its FUSE daemon is hung, which
+// is umounted once it's no longer in use. It doesn't stat the mountpoint, which may block.
+func LazyUmount(target string) error {
+	err := syscall.Unmount(target, syscall.MNT_DETACH)
+	if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOENT) {
+		// Not a mountpoint at all.
+		return nil
+	}
+	return err
+}
+
 func NormalizePath(path string) (realPath string, err error) {
 	if realPath, err = filepath.Abs(path); err != nil {
 		return "", errors.Wrapf(err, "get absolute path for %s", path)
@@ -9,6 +9,7 @@ package parser
 import (
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/pkg/errors"
@@ -75,3 +76,95 @@ func MemoryConfigToBytes(data string, totalMemoryBytes int) (int64, error) {
 	multiplier := unitMultipliers[unit]
 	return int64(value * float64(multiplier)), nil
 }
+
+// Convert the CPU quota, either a number of CPUs like "1.5" or a percentage of all
+// CPUs like "50%", to the CPU time in microseconds in each period. Returns -1 if no
+// limit is configured.
+func CPUQuotaConfigToMicroseconds(data string, period uint64, numCPU int) (int64, error) {
+	if data == "" {
+		return -1, nil
+	}
+
+	var cpus float64
+	var err error
+	if strings.HasSuffix(data, "%") {
+		cpus, err = strconv.ParseFloat(strings.TrimSuffix(data, "%"), 64)
+		cpus = cpus * float64(numCPU) / 100
+	} else {
+		cpus, err = strconv.ParseFloat(data, 64)
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "Failed to parse CPU quota %s", data)
+	}
+	if cpus <= 0 {
+		return 0, errors.Errorf("Invalid CPU quota %s", data)
+	}
+
+	return int64(cpus*float64(period) + 0.5), nil
+}
+
+// IOMax is the IO limit of a block device, zero means no limit.
+type IOMax struct {
+	// Device path or "major:minor" of the block device.
+	Device    string
+	ReadBps   uint64
+	ReadIOPS  uint64
+	WriteBps  uint64
+	WriteIOPS uint64
+}
+
+// Parse the IO limit in the format of cgroup v2 `io.max`, e.g. "/dev/vda rbps=100MiB riops=1000".
+// Bytes per second accept the same units as memory limit.
+func IOMaxConfigToLimits(data string) (*IOMax, error) {
+	fields := strings.Fields(data)
+	if len(fields) < 2 {
+		return nil, errors.Errorf("Invalid IO limit %q, the device and its limits are required", data)
+	}
+
+	limits := &IOMax{Device: fields[0]}
+	for _, f := range fields[1:] {
+		key, value, ok := strings.Cut(f, "=")
+		if !ok {
+			return nil, errors.Errorf("Invalid IO limit %q in %q", f, data)
+		}
+
+		var rate uint64
+		switch key {
+		case "rbps", "wbps":
+			if strings.HasSuffix(value, "%") {
+				return nil, errors.Errorf("Invalid IO limit %q in %q, percentage is not supported", f, data)
+			}
+			bytes, err := MemoryConfigToBytes(value, 0)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Failed to parse IO limit %q", f)
+			}
+			if bytes > 0 {
+				rate = uint64(bytes)
+			}
+		case "riops", "wiops":
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Failed to parse IO limit %q", f)
+			}
+			rate = n
+		default:
+			return nil, errors.Errorf("Unknown IO limit %q in %q", key, data)
+		}
+		if rate == 0 {
+			return nil, errors.Errorf("Invalid IO limit %q in %q", f, data)
+		}
+
+		switch key {
+		case "rbps":
+			limits.ReadBps = rate
+		case "wbps":
+			limits.WriteBps = rate
+		case "riops":
+			limits.ReadIOPS = rate
+		case "wiops":
+			limits.WriteIOPS = rate
+		}
+	}
+
+	return limits, nil
+}
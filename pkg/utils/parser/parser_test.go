@@ -87,3 +87,57 @@ func TestMemoryLimitToBytes(t *testing.T) {
 		assert.Equal(t, memoryLimitInBytes, test.expected)
 	}
 }
+
+func TestCPUQuotaConfigToMicroseconds(t *testing.T) {
+	for desc, test := range map[string]struct {
+		CPUQuota string
+		expected int64
+		hasError bool
+	}{
+		"cpu quota is empty": {
+			CPUQuota: "",
+			expected: -1,
+		},
+		"cpu quota is a number of CPUs": {
+			CPUQuota: "1.5",
+			expected: 150000,
+		},
+		"cpu quota is a percentage": {
+			CPUQuota: "25%",
+			expected: 200000,
+		},
+		"cpu quota is zero": {
+			CPUQuota: "0",
+			hasError: true,
+		},
+		"cpu quota is invalid": {
+			CPUQuota: "1 core",
+			hasError: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+
+		quota, err := CPUQuotaConfigToMicroseconds(test.CPUQuota, 100000, 8)
+		if test.hasError {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, quota)
+	}
+}
+
+func TestIOMaxConfigToLimits(t *testing.T) {
+	limits, err := IOMaxConfigToLimits("/dev/vda rbps=100MiB riops=1000")
+	assert.NoError(t, err)
+	assert.Equal(t, &IOMax{Device: "/dev/vda", ReadBps: 100 * 1024 * 1024, ReadIOPS: 1000}, limits)
+
+	limits, err = IOMaxConfigToLimits("8:0 wbps=1048576 wiops=10")
+	assert.NoError(t, err)
+	assert.Equal(t, &IOMax{Device: "8:0", WriteBps: 1048576, WriteIOPS: 10}, limits)
+
+	for _, data := range []string{"/dev/vda", "/dev/vda rbps", "/dev/vda rbps=10%", "/dev/vda riops=1k", "/dev/vda rbps=0", "/dev/vda rbps=", "/dev/vda max=1"} {
+		_, err = IOMaxConfigToLimits(data)
+		assert.Error(t, err, data)
+	}
+}
@@ -8,33 +8,83 @@ package signer
 
 import (
 	"crypto"
+	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
+	"errors"
+	"fmt"
 	"io"
 )
 
 type Signer struct {
-	publicKey *rsa.PublicKey
+	publicKey crypto.PublicKey
+	keyID     string
 }
 
+// New creates a signer from a PEM encoded public key, which can be either a PKCS #1
+// RSA public key or a PKIX RSA/ECDSA public key.
 func New(publicKey []byte) (*Signer, error) {
 	block, _ := pem.Decode(publicKey)
-	key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+	if block == nil {
+		return nil, errors.New("no PEM encoded public key found")
+	}
+
+	var key crypto.PublicKey
+	if k, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		key = k
+	} else {
+		k, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch k.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey:
+		default:
+			return nil, fmt.Errorf("unsupported public key type %T", k)
+		}
+		key = k
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(key)
 	if err != nil {
 		return nil, err
 	}
+	sum := sha256.Sum256(der)
+
 	return &Signer{
 		publicKey: key,
+		keyID:     hex.EncodeToString(sum[:]),
 	}, nil
 }
 
+// KeyID is the hex encoded SHA256 digest of the DER encoded PKIX public key.
+func (s *Signer) KeyID() string {
+	return s.keyID
+}
+
 func (s *Signer) Verify(input io.Reader, signature []byte) error {
 	h := sha256.New()
 	_, err := io.Copy(h, input)
 	if err != nil {
 		return err
 	}
-	return rsa.VerifyPKCS1v15(s.publicKey, crypto.SHA256, h.Sum(nil), signature)
+	return s.VerifyDigest(h.Sum(nil), signature)
+}
+
+// VerifyDigest verifies the signature against the SHA256 digest of the signed content.
+func (s *Signer) VerifyDigest(hashed []byte, signature []byte) error {
+	switch key := s.publicKey.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed, signature)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, hashed, signature) {
+			return errors.New("ecdsa: verification error")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
 }
@@ -36,3 +36,13 @@ func GetTotalMemoryBytes() (int, error) {
 
 	return int(sysinfo.Totalram), nil
 }
+
+// Get the size of the filesystem where `path` resides.
+func GetFilesystemTotalBytes(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+
+	return int64(st.Blocks) * st.Bsize, nil
+}
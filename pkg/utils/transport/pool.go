@@ -36,10 +36,59 @@ func NewPool() *Pool {
 }
 
 type Resolve interface {
-	Resolve(ref name.Reference, digest string, keychain authn.Keychain) (string, http.RoundTripper, error)
+	Resolve(ref name.Reference, digest string, keychain authn.Keychain, mirrors []Mirror) (string, http.RoundTripper, error)
 }
 
-func (r *Pool) Resolve(ref name.Reference, digest string, keychain authn.Keychain) (string, http.RoundTripper, error) {
+// Mirror is a registry mirror to fetch blobs from before the registry.
+type Mirror struct {
+	// URL of the mirror, e.g. `https://mirror.example.com`.
+	URL    string
+	Scheme string
+	Host   string
+	// Wraps the transport of requests to the mirror, e.g. to add headers of the mirror.
+	Transport func(http.RoundTripper) http.RoundTripper
+}
+
+// Resolve the URL of the blob and the authenticated transport to fetch it. The mirrors
+// are tried in order before the registry itself.
+func (r *Pool) Resolve(ref name.Reference, digest string, keychain authn.Keychain, mirrors []Mirror) (string, http.RoundTripper, error) {
+	for _, m := range mirrors {
+		mref, err := mirrorReference(ref, m)
+		if err != nil {
+			log.L.WithError(err).Warnf("failed to build reference of %s on mirror %s", ref.Name(), m.URL)
+			continue
+		}
+		transport := r.transport
+		if m.Transport != nil {
+			transport = m.Transport(transport)
+		}
+		url, tr, err := r.resolve(mref, digest, keychain, transport)
+		if err == nil {
+			return url, tr, nil
+		}
+		log.L.WithError(err).Warnf("failed to resolve %s on mirror %s, try next host", ref.Name(), m.URL)
+	}
+
+	return r.resolve(ref, digest, keychain, r.transport)
+}
+
+// The same repository of the reference on the mirror.
+func mirrorReference(ref name.Reference, m Mirror) (name.Reference, error) {
+	opts := []name.Option{name.WeakValidation}
+	if m.Scheme == "http" {
+		opts = append(opts, name.Insecure)
+	}
+	repo, err := name.NewRepository(m.Host+"/"+ref.Context().RepositoryStr(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := ref.(name.Digest); ok {
+		return repo.Digest(d.DigestStr()), nil
+	}
+	return repo.Tag(ref.Identifier()), nil
+}
+
+func (r *Pool) resolve(ref name.Reference, digest string, keychain authn.Keychain, transport http.RoundTripper) (string, http.RoundTripper, error) {
 	r.trPoolMu.Lock()
 	defer r.trPoolMu.Unlock()
 	endpointURL := fmt.Sprintf("%s://%s/v2/%s/blobs/%s",
@@ -56,7 +105,7 @@ func (r *Pool) Resolve(ref name.Reference, digest string, keychain authn.Keychai
 		r.trPool.Remove(ref.Name())
 		log.L.Warnf("redirect %s, failed, err: %s", endpointURL, err)
 	}
-	tr, err := registry.AuthnTransport(ref, r.transport, keychain)
+	tr, err := registry.AuthnTransport(ref, transport, keychain)
 	if err != nil {
 		return "", nil, errors.Wrapf(err, "failed to authn transport")
 	}
@@ -61,13 +61,13 @@ func TestResolve(t *testing.T) {
 		Repository: repo,
 	}
 
-	url1, tr1, err := pool.Resolve(ref, "fake digest", nil)
+	url1, tr1, err := pool.Resolve(ref, "fake digest", nil, nil)
 	require.NoError(t, err)
 	// auth request + redirect request
 	require.Equal(t, 2, callCount)
 	// reset
 	callCount = 0
-	url2, tr2, err := pool.Resolve(ref, "fake digest", nil)
+	url2, tr2, err := pool.Resolve(ref, "fake digest", nil, nil)
 	// get transport from pool and call redirect
 	require.Equal(t, 1, callCount)
 	require.NoError(t, err)
@@ -77,7 +77,7 @@ func TestResolve(t *testing.T) {
 	failed = true
 	// reset
 	callCount = 0
-	url3, tr3, err := pool.Resolve(ref, "fake digest", nil)
+	url3, tr3, err := pool.Resolve(ref, "fake digest", nil, nil)
 	// redirect failed and retry redirect + auth
 	require.Equal(t, 3, callCount)
 	require.NoError(t, err)
@@ -85,3 +85,50 @@ func TestResolve(t *testing.T) {
 	require.Equal(t, url2, url3)
 
 }
+
+func TestResolveMirrors(t *testing.T) {
+	var registryHits, mirrorHits int
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		registryHits++
+	}))
+	defer registry.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrorHits++
+		require.Equal(t, "mirror", r.Header.Get("X-Mirror"))
+	}))
+	defer mirror.Close()
+
+	repo, err := name.NewRepository(strings.TrimPrefix(registry.URL, "http://")+"/nginx", name.WeakValidation, name.Insecure)
+	require.NoError(t, err)
+	ref := &FakeReference{Tag: "latest", Repository: repo}
+	mirrorHost := strings.TrimPrefix(mirror.URL, "http://")
+	mirrors := []Mirror{{
+		URL:    mirror.URL,
+		Scheme: "http",
+		Host:   mirrorHost,
+		Transport: func(rt http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				req.Header.Set("X-Mirror", "mirror")
+				return rt.RoundTrip(req)
+			})
+		},
+	}}
+
+	url, _, err := NewPool().Resolve(ref, "fake digest", nil, mirrors)
+	require.NoError(t, err)
+	require.Equal(t, "http://"+mirrorHost+"/v2/nginx/blobs/fake digest", url)
+	require.NotZero(t, mirrorHits)
+	require.Zero(t, registryHits)
+
+	// Fall back to the registry if the mirror fails.
+	mirror.Close()
+	url, _, err = NewPool().Resolve(ref, "fake digest", nil, mirrors)
+	require.NoError(t, err)
+	require.Equal(t, registry.URL+"/v2/nginx/blobs/fake digest", url)
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
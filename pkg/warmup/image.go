@@ -0,0 +1,140 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package warmup
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/label"
+	"github.com/containerd/nydus-snapshotter/pkg/remote"
+	"github.com/containerd/nydus-snapshotter/pkg/remote/remotes"
+)
+
+// Containerd restricts the max size of manifest index to 8M, follow it.
+const maxManifestSize = 0x800000
+const bootstrapNameInLayer = "image/image.boot"
+
+// A nydus image resolved from the registry.
+type nydusImage struct {
+	remote         *remote.Remote
+	ref            string
+	manifestDigest digest.Digest
+	bootstrap      ocispec.Descriptor
+	blobs          []ocispec.Descriptor
+}
+
+// Resolve the manifest of the image for the current platform, and find out its layers.
+func resolveImage(ctx context.Context, r *remote.Remote, ref string) (*nydusImage, error) {
+	image, err := doResolveImage(ctx, r, ref)
+	if err != nil && r.RetryWithPlainHTTP(ref, err) {
+		return doResolveImage(ctx, r, ref)
+	}
+	return image, err
+}
+
+func doResolveImage(ctx context.Context, r *remote.Remote, ref string) (*nydusImage, error) {
+	resolver := r.Resolve(ctx, ref)
+	_, desc, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolve image %s", ref)
+	}
+	fetcher, err := resolver.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, errors.Wrap(err, "get fetcher")
+	}
+
+	if images.IsIndexType(desc.MediaType) {
+		var index ocispec.Index
+		if err := fetchJSON(ctx, fetcher, desc, &index); err != nil {
+			return nil, errors.Wrap(err, "fetch manifest index")
+		}
+		matcher := platforms.Default()
+		found := false
+		for _, m := range index.Manifests {
+			if m.Platform != nil && matcher.Match(*m.Platform) {
+				desc, found = m, true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.Wrapf(errdefs.ErrNotFound, "manifest of platform %s in image %s",
+				platforms.DefaultString(), ref)
+		}
+	}
+
+	var manifest ocispec.Manifest
+	if err := fetchJSON(ctx, fetcher, desc, &manifest); err != nil {
+		return nil, errors.Wrap(err, "fetch manifest")
+	}
+
+	image := nydusImage{remote: r, ref: ref, manifestDigest: desc.Digest}
+	foundBootstrap := false
+	for _, layer := range manifest.Layers {
+		switch {
+		case label.IsNydusMetaLayer(layer.Annotations):
+			image.bootstrap, foundBootstrap = layer, true
+		case label.IsNydusDataLayer(layer.Annotations):
+			image.blobs = append(image.blobs, layer)
+		}
+	}
+	if !foundBootstrap {
+		return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "image %s is not a nydus image", ref)
+	}
+
+	return &image, nil
+}
+
+func fetchJSON(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor, v interface{}) error {
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, maxManifestSize))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Fetch the bootstrap layer and unpack the bootstrap file to `path`.
+func (i *nydusImage) fetchBootstrap(ctx context.Context, path string) error {
+	handle := func() error {
+		fetcher, err := i.remote.Resolve(ctx, i.ref).Fetcher(ctx, i.ref)
+		if err != nil {
+			return errors.Wrap(err, "get fetcher")
+		}
+		rc, err := fetcher.Fetch(ctx, i.bootstrap)
+		if err != nil {
+			return errors.Wrap(err, "fetch bootstrap layer")
+		}
+		defer rc.Close()
+
+		if err := remote.Unpack(rc, bootstrapNameInLayer, path); err != nil {
+			os.Remove(path)
+			return errors.Wrap(err, "unpack bootstrap from layer")
+		}
+		return nil
+	}
+
+	err := handle()
+	if err != nil && i.remote.RetryWithPlainHTTP(i.ref, err) {
+		return handle()
+	}
+	return err
+}
@@ -0,0 +1,345 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package warmup downloads whole images into the local blob cache before any container
+// starts. An image is warmed up by mounting it as a transient RAFS instance, whose nydusd
+// prefetches all data of the image into the cache directory.
+package warmup
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	snpkg "github.com/containerd/containerd/v2/pkg/snapshotters"
+	"github.com/containerd/log"
+	"github.com/distribution/reference"
+	"github.com/pkg/errors"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/auth"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
+	"github.com/containerd/nydus-snapshotter/pkg/label"
+	"github.com/containerd/nydus-snapshotter/pkg/rafs"
+	"github.com/containerd/nydus-snapshotter/pkg/remote"
+)
+
+const (
+	StateRunning   = "RUNNING"
+	StateCompleted = "COMPLETED"
+	StateFailed    = "FAILED"
+	StateCanceled  = "CANCELED"
+)
+
+const (
+	// Transient RAFS instances of warm-up jobs are named after the job ID with the prefix.
+	snapshotPrefix      = "warmup-"
+	defaultPollInterval = time.Second
+	// Finished jobs are kept for a while so that their results can be queried.
+	finishedJobTTL = time.Hour
+)
+
+// Filesystem mounts RAFS instances, it's implemented by `filesystem.Filesystem`.
+type Filesystem interface {
+	Mount(ctx context.Context, snapshotID string, labels map[string]string, s *storage.Snapshot) error
+	Umount(ctx context.Context, snapshotID string) error
+	CacheMetrics(snapshotID string) (*types.CacheMetrics, error)
+}
+
+// Status of a warm-up job.
+type Status struct {
+	ID    string `json:"id"`
+	Image string `json:"image"`
+	State string `json:"state"`
+	// Number and total size of the data blobs of the image.
+	Blobs      int   `json:"blobs"`
+	TotalBytes int64 `json:"total_bytes"`
+	// Bytes downloaded by nydusd so far.
+	FetchedBytes uint64    `json:"fetched_bytes"`
+	Error        string    `json:"error,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Finished tells whether the job is no longer running.
+func (s *Status) Finished() bool {
+	return s.State != StateRunning
+}
+
+type job struct {
+	mu     sync.Mutex
+	status Status
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (j *job) getStatus() Status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+func (j *job) update(fn func(s *Status)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn(&j.status)
+	j.status.UpdatedAt = time.Now()
+}
+
+type Manager struct {
+	fs           Filesystem
+	pollInterval time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+// NewManager creates a warm-up manager, transient RAFS instances left by warm-up jobs
+// before the snapshotter restarts are umounted.
+func NewManager(fs Filesystem) *Manager {
+	m := &Manager{
+		fs:           fs,
+		pollInterval: defaultPollInterval,
+		jobs:         make(map[string]*job),
+	}
+
+	for snapshotID := range rafs.RafsGlobalCache.List() {
+		if strings.HasPrefix(snapshotID, snapshotPrefix) {
+			log.L.Infof("clean up RAFS instance %s left by warm-up", snapshotID)
+			m.umount(snapshotID)
+		}
+	}
+
+	return m
+}
+
+// Start warming up the image in background. If the image is being warmed up,
+// the running job is returned.
+func (m *Manager) Start(image string) (Status, error) {
+	if config.GetFsDriver() != config.FsDriverFusedev {
+		return Status{}, errors.Wrapf(errdefs.ErrNotImplemented, "warm up image with fs driver %s", config.GetFsDriver())
+	}
+	if image == "" {
+		return Status{}, errors.Wrap(errdefs.ErrInvalidArgument, "image is empty")
+	}
+	// Canonicalize the reference so that the same image can be found by other names.
+	named, err := reference.ParseDockerRef(image)
+	if err != nil {
+		return Status{}, errors.Wrapf(errdefs.ErrInvalidArgument, "parse image %s, %s", image, err)
+	}
+	ref := named.String()
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Status{}, errors.Wrap(err, "generate job ID")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pruneLocked()
+	for _, j := range m.jobs {
+		if s := j.getStatus(); s.Image == ref && !s.Finished() {
+			return s, nil
+		}
+	}
+
+	now := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		status: Status{
+			ID:        hex.EncodeToString(id),
+			Image:     ref,
+			State:     StateRunning,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	m.jobs[j.status.ID] = j
+
+	log.L.Infof("start warm-up job %s for image %s", j.status.ID, ref)
+	go m.run(ctx, j)
+
+	return j.getStatus(), nil
+}
+
+// List warm-up jobs in the order of creation.
+func (m *Manager) List() []Status {
+	m.mu.Lock()
+	m.pruneLocked()
+	result := make([]Status, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		result = append(result, j.getStatus())
+	}
+	m.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result
+}
+
+func (m *Manager) Get(id string) (Status, error) {
+	j, err := m.getJob(id)
+	if err != nil {
+		return Status{}, err
+	}
+	return j.getStatus(), nil
+}
+
+// Cancel the job and wait until its RAFS instance is umounted.
+// Blob caches already downloaded are kept.
+func (m *Manager) Cancel(id string) (Status, error) {
+	j, err := m.getJob(id)
+	if err != nil {
+		return Status{}, err
+	}
+
+	j.cancel()
+	<-j.done
+
+	return j.getStatus(), nil
+}
+
+func (m *Manager) getJob(id string) (*job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	if !ok {
+		return nil, errors.Wrapf(errdefs.ErrNotFound, "warm-up job %s", id)
+	}
+	return j, nil
+}
+
+func (m *Manager) pruneLocked() {
+	for id, j := range m.jobs {
+		if s := j.getStatus(); s.Finished() && time.Since(s.UpdatedAt) > finishedJobTTL {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+func (m *Manager) run(ctx context.Context, j *job) {
+	defer close(j.done)
+
+	err := m.warmup(ctx, j)
+	j.update(func(s *Status) {
+		switch {
+		case ctx.Err() != nil:
+			s.State = StateCanceled
+		case err != nil:
+			s.State = StateFailed
+			s.Error = err.Error()
+		default:
+			s.State = StateCompleted
+		}
+	})
+
+	s := j.getStatus()
+	if err != nil && s.State == StateFailed {
+		log.L.WithError(err).Errorf("warm-up job %s for image %s failed", s.ID, s.Image)
+		return
+	}
+	log.L.Infof("warm-up job %s for image %s is %s, fetched %d of %d bytes",
+		s.ID, s.Image, strings.ToLower(s.State), s.FetchedBytes, s.TotalBytes)
+}
+
+func (m *Manager) warmup(ctx context.Context, j *job) error {
+	s := j.getStatus()
+	snapshotID := snapshotPrefix + s.ID
+
+	keyChain, err := auth.GetKeyChainByRef(s.Image, nil)
+	if err != nil {
+		return errors.Wrap(err, "get keychain")
+	}
+	image, err := resolveImage(ctx, remote.New(keyChain, config.GetSkipSSLVerify()), s.Image)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, b := range image.blobs {
+		total += b.Size
+	}
+	j.update(func(s *Status) {
+		s.Blobs = len(image.blobs)
+		s.TotalBytes = total
+	})
+
+	// Make sure that the bootstrap file is where the RAFS instance expects.
+	snapshotDir := filepath.Join(config.GetSnapshotsRootDir(), snapshotID)
+	defer os.RemoveAll(snapshotDir)
+	bootstrap := filepath.Join(snapshotDir, "fs", "image", "image.boot")
+	if err := os.MkdirAll(filepath.Dir(bootstrap), 0755); err != nil {
+		return errors.Wrapf(err, "create directory for bootstrap %s", bootstrap)
+	}
+	if err := image.fetchBootstrap(ctx, bootstrap); err != nil {
+		return err
+	}
+
+	// Annotations of the bootstrap layer are passed too for signature verification.
+	labels := make(map[string]string, len(image.bootstrap.Annotations)+3)
+	for k, v := range image.bootstrap.Annotations {
+		labels[k] = v
+	}
+	labels[snpkg.TargetRefLabel] = s.Image
+	labels[label.CRIManifestDigest] = image.manifestDigest.String()
+	labels[label.NydusPrefetchAll] = "true"
+	if err := m.fs.Mount(ctx, snapshotID, labels, nil); err != nil {
+		return errors.Wrapf(err, "mount image %s", s.Image)
+	}
+	defer m.umount(snapshotID)
+
+	return m.waitPrefetch(ctx, j, snapshotID)
+}
+
+// Wait until nydusd finishes prefetching all data of the image.
+func (m *Manager) waitPrefetch(ctx context.Context, j *job, snapshotID string) error {
+	ticker := time.NewTicker(m.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		metrics, err := m.fs.CacheMetrics(snapshotID)
+		if err != nil {
+			// The RAFS instance may be not ready yet.
+			log.L.WithError(err).Debugf("failed to get cache metrics of snapshot %s", snapshotID)
+			continue
+		}
+		j.update(func(s *Status) {
+			s.FetchedBytes = metrics.PrefetchDataAmount
+		})
+		if metrics.PrefetchEndTimeSecs != 0 && metrics.PrefetchEndTimeSecs >= metrics.PrefetchBeginTimeSecs {
+			return nil
+		}
+	}
+}
+
+func (m *Manager) umount(snapshotID string) {
+	if err := m.fs.Umount(context.Background(), snapshotID); err != nil {
+		log.L.WithError(err).Warnf("failed to umount warm-up snapshot %s", snapshotID)
+	}
+	rafs.RafsGlobalCache.Remove(snapshotID)
+	if err := os.RemoveAll(filepath.Join(config.GetSnapshotsRootDir(), snapshotID)); err != nil {
+		log.L.WithError(err).Warnf("failed to remove directory of warm-up snapshot %s", snapshotID)
+	}
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright (c) 2024. Nydus Developers. All rights reserved.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package warmup
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/snapshots/storage"
+	snpkg "github.com/containerd/containerd/v2/pkg/snapshotters"
+	"github.com/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/containerd/nydus-snapshotter/config"
+	"github.com/containerd/nydus-snapshotter/pkg/daemon/types"
+	"github.com/containerd/nydus-snapshotter/pkg/label"
+)
+
+type fakeFilesystem struct {
+	mu        sync.Mutex
+	labels    map[string]map[string]string
+	bootstrap map[string][]byte
+	umounted  map[string]bool
+	// Whether nydusd finishes prefetching.
+	prefetched bool
+}
+
+func (fs *fakeFilesystem) Mount(_ context.Context, snapshotID string, labels map[string]string, _ *storage.Snapshot) error {
+	data, err := os.ReadFile(filepath.Join(config.GetSnapshotsRootDir(), snapshotID, "fs", "image", "image.boot"))
+	if err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.labels[snapshotID] = labels
+	fs.bootstrap[snapshotID] = data
+	return nil
+}
+
+func (fs *fakeFilesystem) Umount(_ context.Context, snapshotID string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.umounted[snapshotID] = true
+	return nil
+}
+
+func (fs *fakeFilesystem) CacheMetrics(string) (*types.CacheMetrics, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if !fs.prefetched {
+		return &types.CacheMetrics{PrefetchDataAmount: 100, PrefetchBeginTimeSecs: 1}, nil
+	}
+	return &types.CacheMetrics{PrefetchDataAmount: 300, PrefetchBeginTimeSecs: 1, PrefetchEndTimeSecs: 2}, nil
+}
+
+// Serve a nydus image `library/app:latest` with a bootstrap layer and two data blobs.
+func newRegistry(t *testing.T, bootstrap []byte) *httptest.Server {
+	var layer bytes.Buffer
+	tw := tar.NewWriter(&layer)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: bootstrapNameInLayer, Mode: 0644, Size: int64(len(bootstrap))}))
+	_, err := tw.Write(bootstrap)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	contents := map[digest.Digest][]byte{}
+	put := func(mediaType string, data []byte, annotations map[string]string) ocispec.Descriptor {
+		dgst := digest.FromBytes(data)
+		contents[dgst] = data
+		return ocispec.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(data)), Annotations: annotations}
+	}
+	blob := func(size int64) ocispec.Descriptor {
+		return ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromBytes(make([]byte, size)),
+			Size: size, Annotations: map[string]string{label.NydusDataLayer: "true"}}
+	}
+	marshal := func(v interface{}) []byte {
+		data, err := json.Marshal(v)
+		require.NoError(t, err)
+		return data
+	}
+
+	bootstrapDesc := put(ocispec.MediaTypeImageLayerGzip, layer.Bytes(), map[string]string{label.NydusMetaLayer: "true"})
+	manifest := put(ocispec.MediaTypeImageManifest, marshal(ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    put(ocispec.MediaTypeImageConfig, []byte("{}"), nil),
+		Layers:    []ocispec.Descriptor{blob(100), blob(200), bootstrapDesc},
+	}), nil)
+	platform := platforms.DefaultSpec()
+	manifest.Platform = &platform
+	index := put(ocispec.MediaTypeImageIndex, marshal(ocispec.Index{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{manifest},
+	}), nil)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data []byte
+		mediaType := "application/octet-stream"
+		switch {
+		case r.URL.Path == "/v2/":
+		case r.URL.Path == "/v2/library/app/manifests/latest":
+			data, mediaType = contents[index.Digest], index.MediaType
+		case r.URL.Path == "/v2/library/app/manifests/"+index.Digest.String():
+			data, mediaType = contents[index.Digest], index.MediaType
+		case r.URL.Path == "/v2/library/app/manifests/"+manifest.Digest.String():
+			data, mediaType = contents[manifest.Digest], manifest.MediaType
+		case strings.HasPrefix(r.URL.Path, "/v2/library/app/blobs/"):
+			data = contents[digest.Digest(strings.TrimPrefix(r.URL.Path, "/v2/library/app/blobs/"))]
+		}
+		if r.URL.Path != "/v2/" && data == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", mediaType)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(data).String())
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+}
+
+func TestWarmup(t *testing.T) {
+	require.NoError(t, config.ProcessConfigurations(&config.SnapshotterConfig{
+		Root:         t.TempDir(),
+		DaemonMode:   string(config.DaemonModeDedicated),
+		DaemonConfig: config.DaemonConfig{FsDriver: config.FsDriverFusedev},
+	}))
+
+	bootstrap := []byte("bootstrap")
+	registry := newRegistry(t, bootstrap)
+	defer registry.Close()
+	image := strings.TrimPrefix(registry.URL, "http://") + "/library/app:latest"
+
+	fs := &fakeFilesystem{
+		labels:    map[string]map[string]string{},
+		bootstrap: map[string][]byte{},
+		umounted:  map[string]bool{},
+	}
+	m := NewManager(fs)
+	m.pollInterval = 10 * time.Millisecond
+
+	_, err := m.Start("")
+	require.Error(t, err)
+
+	// Cancel the job while nydusd is prefetching.
+	status, err := m.Start(image)
+	require.NoError(t, err)
+	require.Equal(t, StateRunning, status.State)
+	snapshotID := snapshotPrefix + status.ID
+	require.Eventually(t, func() bool {
+		s, err := m.Get(status.ID)
+		return err == nil && s.FetchedBytes == 100
+	}, 5*time.Second, 10*time.Millisecond)
+	again, err := m.Start(image)
+	require.NoError(t, err)
+	require.Equal(t, status.ID, again.ID)
+
+	status, err = m.Cancel(status.ID)
+	require.NoError(t, err)
+	require.Equal(t, StateCanceled, status.State)
+	require.Equal(t, 2, status.Blobs)
+	require.Equal(t, int64(300), status.TotalBytes)
+	fs.mu.Lock()
+	require.Equal(t, bootstrap, fs.bootstrap[snapshotID])
+	require.Equal(t, image, fs.labels[snapshotID][snpkg.TargetRefLabel])
+	require.True(t, label.IsPrefetchAll(fs.labels[snapshotID]))
+	require.True(t, fs.umounted[snapshotID])
+	fs.prefetched = true
+	fs.mu.Unlock()
+	require.NoDirExists(t, filepath.Join(config.GetSnapshotsRootDir(), snapshotID))
+
+	// A new job is started once the previous one finishes.
+	status, err = m.Start(image)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		s, err := m.Get(status.ID)
+		return err == nil && s.State == StateCompleted && s.FetchedBytes == 300
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Len(t, m.List(), 2)
+
+	_, err = m.Get("unknown")
+	require.Error(t, err)
+}
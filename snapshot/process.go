@@ -16,6 +16,7 @@ import (
 	"github.com/containerd/containerd/v2/core/mount"
 	"github.com/containerd/containerd/v2/core/snapshots/storage"
 	snpkg "github.com/containerd/containerd/v2/pkg/snapshotters"
+	"github.com/containerd/containerd/v2/pkg/tracing"
 	"github.com/containerd/nydus-snapshotter/config"
 	"github.com/containerd/nydus-snapshotter/pkg/label"
 	"github.com/containerd/nydus-snapshotter/pkg/snapshot"
@@ -28,26 +29,52 @@ func chooseProcessor(ctx context.Context, logger *logrus.Entry,
 	storageLocater func() string) (_ func() (bool, []mount.Mount, error), target string, err error) {
 	var handler func() (bool, []mount.Mount, error)
 
+	// Handlers run after the processor is chosen, so their spans are siblings of the span
+	// choosing the processor rather than its children.
+	handlerCtx := ctx
+	ctx, span := tracing.StartSpan(ctx, tracing.Name(spanPrefix, "chooseProcessor"),
+		tracing.WithAttribute("snapshot.id", s.ID))
+	defer func() {
+		span.SetStatus(err)
+		span.End()
+	}()
+
 	// Handler to prepare a directory for containerd to download and unpacking layer.
-	defaultHandler := func() (bool, []mount.Mount, error) {
+	defaultHandler := func() (_ bool, _ []mount.Mount, retErr error) {
+		ctx, span := tracing.StartSpan(handlerCtx, tracing.Name(spanPrefix, "defaultHandler"))
+		defer func() {
+			span.SetStatus(retErr)
+			span.End()
+		}()
+
 		mounts, err := sn.mountNative(ctx, labels, s)
 		return false, mounts, err
 	}
 
 	// Handler to stop containerd from downloading and unpacking layer.
 	skipHandler := func() (bool, []mount.Mount, error) {
+		_, span := tracing.StartSpan(handlerCtx, tracing.Name(spanPrefix, "skipHandler"))
+		defer span.End()
+
 		return true, nil, nil
 	}
 
 	remoteHandler := func(id string, labels map[string]string) func() (bool, []mount.Mount, error) {
-		return func() (bool, []mount.Mount, error) {
+		return func() (_ bool, _ []mount.Mount, retErr error) {
+			ctx, span := tracing.StartSpan(handlerCtx, tracing.Name(spanPrefix, "remoteHandler"),
+				tracing.WithAttribute("snapshot.id", id))
+			defer func() {
+				span.SetStatus(retErr)
+				span.End()
+			}()
+
 			logger.Debugf("Prepare remote snapshot %s", id)
 			if err := sn.fs.Mount(ctx, id, labels, &s); err != nil {
 				return false, nil, err
 			}
 
 			// Let Prepare operation show the rootfs content.
-			if err := sn.fs.WaitUntilReady(id); err != nil {
+			if err := sn.fs.WaitUntilReady(ctx, id); err != nil {
 				return false, nil, err
 			}
 
@@ -57,7 +84,13 @@ func chooseProcessor(ctx context.Context, logger *logrus.Entry,
 		}
 	}
 
-	proxyHandler := func() (bool, []mount.Mount, error) {
+	proxyHandler := func() (_ bool, _ []mount.Mount, retErr error) {
+		ctx, span := tracing.StartSpan(handlerCtx, tracing.Name(spanPrefix, "proxyHandler"))
+		defer func() {
+			span.SetStatus(retErr)
+			span.End()
+		}()
+
 		mounts, err := sn.mountProxy(ctx, s)
 		return false, mounts, err
 	}
@@ -79,6 +112,16 @@ func chooseProcessor(ctx context.Context, logger *logrus.Entry,
 		case label.IsNydusMetaLayer(labels):
 			logger.Debugf("found nydus meta layer")
 			handler = defaultHandler
+			if sn.fs.DecryptionEnabled() {
+				encrypted, err := sn.fs.PrepareEncryptedMetaLayer(ctx, labels, storageLocater())
+				if err != nil {
+					return nil, "", errors.Wrapf(err, "prepare encrypted nydus meta layer of snapshot %s", s.ID)
+				}
+				if encrypted {
+					logger.Debugf("decrypted nydus meta layer")
+					handler = skipHandler
+				}
+			}
 		case label.IsNydusDataLayer(labels):
 			logger.Debugf("found nydus data layer")
 			handler = skipHandler
@@ -89,7 +132,7 @@ func chooseProcessor(ctx context.Context, logger *logrus.Entry,
 			if sn.fs.StargzEnabled() {
 				// Check if the blob is format of estargz
 				if ok, blob := sn.fs.IsStargzDataLayer(labels); ok {
-					err := sn.fs.PrepareStargzMetaLayer(blob, storageLocater(), labels)
+					err := sn.fs.PrepareStargzMetaLayer(ctx, blob, storageLocater(), labels)
 					if err != nil {
 						logger.Errorf("prepare stargz layer of snapshot ID %s, err: %v", s.ID, err)
 					} else {
@@ -13,31 +13,38 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
 
 	"github.com/containerd/containerd/v2/core/mount"
 	"github.com/containerd/containerd/v2/core/snapshots"
 	"github.com/containerd/containerd/v2/core/snapshots/storage"
 	snpkg "github.com/containerd/containerd/v2/pkg/snapshotters"
+	"github.com/containerd/containerd/v2/pkg/tracing"
 	"github.com/containerd/continuity/fs"
 	"github.com/containerd/log"
 	"github.com/containerd/nydus-snapshotter/config"
 	"github.com/containerd/nydus-snapshotter/config/daemonconfig"
 	"github.com/containerd/nydus-snapshotter/pkg/rafs"
 
+	"github.com/containerd/nydus-snapshotter/pkg/accesstrace"
 	"github.com/containerd/nydus-snapshotter/pkg/cache"
 	"github.com/containerd/nydus-snapshotter/pkg/cgroup"
 	v2 "github.com/containerd/nydus-snapshotter/pkg/cgroup/v2"
+	"github.com/containerd/nydus-snapshotter/pkg/encryption"
 	"github.com/containerd/nydus-snapshotter/pkg/errdefs"
 	mgr "github.com/containerd/nydus-snapshotter/pkg/manager"
 	"github.com/containerd/nydus-snapshotter/pkg/metrics"
 	"github.com/containerd/nydus-snapshotter/pkg/metrics/collector"
+	"github.com/containerd/nydus-snapshotter/pkg/p2p"
 	"github.com/containerd/nydus-snapshotter/pkg/pprof"
 	"github.com/containerd/nydus-snapshotter/pkg/referrer"
 	"github.com/containerd/nydus-snapshotter/pkg/system"
 	"github.com/containerd/nydus-snapshotter/pkg/tarfs"
 
+	"github.com/containerd/nydus-snapshotter/pkg/prefetch"
 	"github.com/containerd/nydus-snapshotter/pkg/store"
 
 	"github.com/containerd/nydus-snapshotter/pkg/filesystem"
@@ -48,6 +55,9 @@ import (
 
 var _ snapshots.Snapshotter = &snapshotter{}
 
+// Prefix of span names of snapshotter operations.
+const spanPrefix = "nydus.snapshotter"
+
 type snapshotter struct {
 	root                 string
 	nydusdPath           string
@@ -62,7 +72,7 @@ type snapshotter struct {
 }
 
 func NewSnapshotter(ctx context.Context, cfg *config.SnapshotterConfig) (snapshots.Snapshotter, error) {
-	verifier, err := signature.NewVerifier(cfg.ImageConfig.PublicKeyFile, cfg.ImageConfig.ValidateSignature)
+	verifier, err := signature.NewVerifier(cfg.ImageConfig, cfg.RemoteConfig.SkipSSLVerify)
 	if err != nil {
 		return nil, errors.Wrap(err, "initialize image verifier")
 	}
@@ -72,6 +82,11 @@ func NewSnapshotter(ctx context.Context, cfg *config.SnapshotterConfig) (snapsho
 		return nil, errors.Wrap(err, "create database")
 	}
 
+	// Prefetch lists are persisted so that they survive snapshotter restart.
+	if err := prefetch.Pm.SetStore(db); err != nil {
+		return nil, errors.Wrap(err, "restore prefetch lists")
+	}
+
 	rp, err := config.ParseRecoverPolicy(cfg.DaemonConfig.RecoverPolicy)
 	if err != nil {
 		return nil, errors.Wrap(err, "parse recover policy")
@@ -144,6 +159,7 @@ func NewSnapshotter(ctx context.Context, cfg *config.SnapshotterConfig) (snapsho
 		fsManagers = append(fsManagers, fscacheManager)
 	}
 
+	var credentialRefreshInterval time.Duration
 	if config.GetFsDriver() == config.FsDriverFusedev {
 		fusedevManager, err := mgr.NewManager(mgr.Opt{
 			NydusdBinaryPath: cfg.DaemonConfig.NydusdPath,
@@ -159,6 +175,34 @@ func NewSnapshotter(ctx context.Context, cfg *config.SnapshotterConfig) (snapsho
 			return nil, errors.Wrap(err, "create fusedev manager")
 		}
 		fsManagers = append(fsManagers, fusedevManager)
+
+		if interval := cfg.RemoteConfig.AuthConfig.CredentialRefreshInterval; interval != "" {
+			credentialRefreshInterval, err = time.ParseDuration(interval)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parse credential refresh interval %s", interval)
+			}
+		}
+
+		if watchdog := cfg.DaemonConfig.HungIOWatchdog; watchdog.Enable {
+			interval, err := time.ParseDuration(watchdog.Interval)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parse hung io watchdog interval %s", watchdog.Interval)
+			}
+			threshold, err := time.ParseDuration(watchdog.Threshold)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parse hung io watchdog threshold %s", watchdog.Threshold)
+			}
+			dumpDir := watchdog.DumpDir
+			if dumpDir == "" {
+				dumpDir = filepath.Join(cfg.Root, "diagnostics")
+			}
+			go fusedevManager.WatchHungIO(ctx, mgr.HungIOWatchdogOpt{
+				Interval:  interval,
+				Threshold: threshold,
+				Action:    watchdog.Action,
+				DumpDir:   dumpDir,
+			})
+		}
 	}
 
 	if config.GetFsDriver() == config.FsDriverProxy {
@@ -209,9 +253,14 @@ func NewSnapshotter(ctx context.Context, cfg *config.SnapshotterConfig) (snapsho
 	}
 
 	cacheConfig := &cfg.CacheManagerConfig
+	cacheMaxSize, err := config.ParseCacheMaxSize(*cacheConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse cache max size")
+	}
 	cacheMgr, err := cache.NewManager(cache.Opt{
 		Database: db,
 		Period:   config.GetCacheGCPeriod(),
+		MaxSize:  cacheMaxSize,
 		CacheDir: cacheConfig.CacheDir,
 		Disabled: cacheConfig.Disable,
 	})
@@ -220,8 +269,30 @@ func NewSnapshotter(ctx context.Context, cfg *config.SnapshotterConfig) (snapsho
 	}
 	opts = append(opts, filesystem.WithCacheManager(cacheMgr))
 
+	var referrerOpts []referrer.Opt
+	if cfg.ImageConfig.DecryptionEnabled() {
+		decryptor, err := encryption.NewDecryptor(cfg.ImageConfig.DecryptionKeysDir, cfg.ImageConfig.KeyProviderConfig, skipSSLVerify)
+		if err != nil {
+			return nil, errors.Wrap(err, "create bootstrap decryptor")
+		}
+		opts = append(opts, filesystem.WithDecryptor(decryptor))
+		referrerOpts = append(referrerOpts, referrer.WithDecryptor(decryptor))
+	}
+
 	if cfg.Experimental.EnableReferrerDetect {
-		referrerMgr := referrer.NewManager(skipSSLVerify)
+		referrerConfig := cfg.Experimental.ReferrerConfig
+		referrerOpts = append(referrerOpts, referrer.WithPolicy(referrer.Policy{
+			ArtifactType: referrerConfig.ArtifactType,
+			Annotations:  referrerConfig.Annotations,
+		}))
+		if referrerConfig.CacheTTL != "" {
+			ttl, err := time.ParseDuration(referrerConfig.CacheTTL)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parse referrer cache ttl %s", referrerConfig.CacheTTL)
+			}
+			referrerOpts = append(referrerOpts, referrer.WithCacheTTL(ttl))
+		}
+		referrerMgr := referrer.NewManager(skipSSLVerify, referrerOpts...)
 		opts = append(opts, filesystem.WithReferrerManager(referrerMgr))
 	}
 
@@ -229,14 +300,59 @@ func NewSnapshotter(ctx context.Context, cfg *config.SnapshotterConfig) (snapsho
 		tarfsMgr := tarfs.NewManager(skipSSLVerify, cfg.Experimental.TarfsConfig.TarfsHint,
 			cacheConfig.CacheDir, cfg.DaemonConfig.NydusImagePath,
 			int64(cfg.Experimental.TarfsConfig.MaxConcurrentProc))
+		if err := tarfsMgr.Recover(ctx, db, config.GetSnapshotsRootDir()); err != nil {
+			return nil, errors.Wrap(err, "recover tarfs snapshots")
+		}
 		opts = append(opts, filesystem.WithTarfsManager(tarfsMgr))
 	}
 
+	if cfg.Experimental.P2PConfig.Enable {
+		var compressedCache bool
+		if daemonConfig != nil {
+			if c, ok := (*daemonConfig).(*daemonconfig.FuseDaemonConfig); ok {
+				compressedCache = c.Device.Cache.Compressed
+			}
+		}
+		p2pMgr, err := p2p.NewManager(p2p.Opt{
+			Config:          cfg.Experimental.P2PConfig,
+			CacheDir:        cacheConfig.CacheDir,
+			CompressedCache: compressedCache,
+			RootDir:         cfg.Root,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "create p2p manager")
+		}
+		if err := p2pMgr.Start(ctx); err != nil {
+			return nil, errors.Wrap(err, "start p2p manager")
+		}
+		opts = append(opts, filesystem.WithP2PManager(p2pMgr))
+	}
+
+	if traceConfig := cfg.AccessTraceConfig; traceConfig.Enable {
+		window, err := time.ParseDuration(traceConfig.Window)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse access trace window %q", traceConfig.Window)
+		}
+		recorder, err := accesstrace.NewRecorder(filepath.Join(cfg.Root, "access-traces"), window, traceConfig.Source)
+		if err != nil {
+			return nil, errors.Wrap(err, "create access trace recorder")
+		}
+		opts = append(opts, filesystem.WithAccessTraceRecorder(recorder))
+	}
+
 	nydusFs, err := filesystem.NewFileSystem(ctx, opts...)
 	if err != nil {
 		return nil, errors.Wrap(err, "initialize filesystem thin layer")
 	}
 
+	// Start refreshing auth once nydusd daemons are recovered, so that auth of their
+	// instances is refreshed right away.
+	if credentialRefreshInterval > 0 {
+		for _, m := range fsManagers {
+			go m.RefreshCredentials(ctx, credentialRefreshInterval)
+		}
+	}
+
 	if config.IsSystemControllerEnabled() {
 		systemController, err := system.NewSystemController(nydusFs, fsManagers, config.SystemControllerAddress(), cfg.SystemControllerConfig.UID, cfg.SystemControllerConfig.GID)
 		if err != nil {
@@ -284,7 +400,7 @@ func NewSnapshotter(ctx context.Context, cfg *config.SnapshotterConfig) (snapsho
 		syncRemove = true
 	}
 
-	return &snapshotter{
+	sn := &snapshotter{
 		root:                 cfg.Root,
 		nydusdPath:           cfg.DaemonConfig.NydusdPath,
 		ms:                   ms,
@@ -295,10 +411,19 @@ func NewSnapshotter(ctx context.Context, cfg *config.SnapshotterConfig) (snapsho
 		nydusOverlayFSPath:   cfg.SnapshotsConfig.NydusOverlayFSPath,
 		enableKataVolume:     cfg.SnapshotsConfig.EnableKataVolume,
 		cleanupOnClose:       cfg.CleanupOnClose,
-	}, nil
+	}
+
+	go cacheMgr.Run(ctx, sn.walkCommittedBlobs)
+
+	return sn, nil
 }
 
-func (o *snapshotter) Cleanup(ctx context.Context) error {
+func (o *snapshotter) Cleanup(ctx context.Context) (retErr error) {
+	ctx, span := tracing.StartSpan(ctx, tracing.Name(spanPrefix, "Cleanup"))
+	defer func() {
+		span.SetStatus(retErr)
+		span.End()
+	}()
 	log.L.Debugf("[Cleanup] snapshots")
 	if timer := collector.NewSnapshotMetricsTimer(collector.SnapshotMethodCleanup); timer != nil {
 		defer timer.ObserveDuration()
@@ -311,6 +436,9 @@ func (o *snapshotter) Cleanup(ctx context.Context) error {
 
 	log.L.Infof("[Cleanup] orphan directories %v", cleanup)
 
+	// Containerd calls Cleanup after its own GC, a good chance to reclaim blob caches.
+	o.fs.SchedCacheGC()
+
 	for _, dir := range cleanup {
 		if err := o.cleanupSnapshotDirectory(ctx, dir); err != nil {
 			log.L.WithError(err).Warnf("failed to remove directory %s", dir)
@@ -319,16 +447,34 @@ func (o *snapshotter) Cleanup(ctx context.Context) error {
 	return nil
 }
 
-func (o *snapshotter) Stat(ctx context.Context, key string) (snapshots.Info, error) {
+func (o *snapshotter) Stat(ctx context.Context, key string) (_ snapshots.Info, retErr error) {
+	ctx, span := tracing.StartSpan(ctx, tracing.Name(spanPrefix, "Stat"),
+		tracing.WithAttribute("snapshot.key", key))
+	defer func() {
+		span.SetStatus(retErr)
+		span.End()
+	}()
 	_, info, _, err := snapshot.GetSnapshotInfo(ctx, o.ms, key)
 	return info, err
 }
 
-func (o *snapshotter) Update(ctx context.Context, info snapshots.Info, fieldpaths ...string) (snapshots.Info, error) {
+func (o *snapshotter) Update(ctx context.Context, info snapshots.Info, fieldpaths ...string) (_ snapshots.Info, retErr error) {
+	ctx, span := tracing.StartSpan(ctx, tracing.Name(spanPrefix, "Update"),
+		tracing.WithAttribute("snapshot.key", info.Name))
+	defer func() {
+		span.SetStatus(retErr)
+		span.End()
+	}()
 	return snapshot.UpdateSnapshotInfo(ctx, o.ms, info, fieldpaths...)
 }
 
-func (o *snapshotter) Usage(ctx context.Context, key string) (snapshots.Usage, error) {
+func (o *snapshotter) Usage(ctx context.Context, key string) (_ snapshots.Usage, retErr error) {
+	ctx, span := tracing.StartSpan(ctx, tracing.Name(spanPrefix, "Usage"),
+		tracing.WithAttribute("snapshot.key", key))
+	defer func() {
+		span.SetStatus(retErr)
+		span.End()
+	}()
 	id, info, usage, err := snapshot.GetSnapshotInfo(ctx, o.ms, key)
 	if err != nil {
 		return snapshots.Usage{}, err
@@ -361,7 +507,13 @@ func (o *snapshotter) Usage(ctx context.Context, key string) (snapshots.Usage, e
 	return usage, nil
 }
 
-func (o *snapshotter) Mounts(ctx context.Context, key string) ([]mount.Mount, error) {
+func (o *snapshotter) Mounts(ctx context.Context, key string) (_ []mount.Mount, retErr error) {
+	ctx, span := tracing.StartSpan(ctx, tracing.Name(spanPrefix, "Mounts"),
+		tracing.WithAttribute("snapshot.key", key))
+	defer func() {
+		span.SetStatus(retErr)
+		span.End()
+	}()
 	log.L.Debugf("[Mounts] snapshot %s", key)
 	if timer := collector.NewSnapshotMetricsTimer(collector.SnapshotMethodMount); timer != nil {
 		defer timer.ObserveDuration()
@@ -380,7 +532,7 @@ func (o *snapshotter) Mounts(ctx context.Context, key string) ([]mount.Mount, er
 	switch info.Kind {
 	case snapshots.KindView:
 		if label.IsNydusMetaLayer(info.Labels) {
-			err = o.fs.WaitUntilReady(id)
+			err = o.fs.WaitUntilReady(ctx, id)
 			if err != nil {
 				// Skip waiting if clients is unpacking nydus artifacts to `mounts`
 				// For example, nydus-snapshotter's client like Buildkit is calling snapshotter in below workflow:
@@ -405,7 +557,7 @@ func (o *snapshotter) Mounts(ctx context.Context, key string) ([]mount.Mount, er
 			pKey := info.Parent
 			if pID, pInfo, _, err := snapshot.GetSnapshotInfo(ctx, o.ms, pKey); err == nil {
 				if label.IsNydusMetaLayer(pInfo.Labels) {
-					if err = o.fs.WaitUntilReady(pID); err != nil {
+					if err = o.fs.WaitUntilReady(ctx, pID); err != nil {
 						return nil, errors.Wrapf(err, "mounts: snapshot %s is not ready, err: %v", pID, err)
 					}
 					needRemoteMounts = true
@@ -446,7 +598,14 @@ func (o *snapshotter) Mounts(ctx context.Context, key string) ([]mount.Mount, er
 	return o.mountNative(ctx, info.Labels, *snap)
 }
 
-func (o *snapshotter) Prepare(ctx context.Context, key, parent string, opts ...snapshots.Opt) ([]mount.Mount, error) {
+func (o *snapshotter) Prepare(ctx context.Context, key, parent string, opts ...snapshots.Opt) (_ []mount.Mount, retErr error) {
+	ctx, span := tracing.StartSpan(ctx, tracing.Name(spanPrefix, "Prepare"),
+		tracing.WithAttribute("snapshot.key", key),
+		tracing.WithAttribute("snapshot.parent", parent))
+	defer func() {
+		span.SetStatus(retErr)
+		span.End()
+	}()
 	log.L.Infof("[Prepare] snapshot with key %s parent %s", key, parent)
 
 	if timer := collector.NewSnapshotMetricsTimer(collector.SnapshotMethodPrepare); timer != nil {
@@ -482,7 +641,14 @@ func (o *snapshotter) Prepare(ctx context.Context, key, parent string, opts ...s
 // The work on supporting View operation for nydus-snapshotter is divided into 2 parts:
 // 1. View on the topmost layer of nydus images or zran images
 // 2. View on the any layer of nydus images or zran images
-func (o *snapshotter) View(ctx context.Context, key, parent string, opts ...snapshots.Opt) ([]mount.Mount, error) {
+func (o *snapshotter) View(ctx context.Context, key, parent string, opts ...snapshots.Opt) (_ []mount.Mount, retErr error) {
+	ctx, span := tracing.StartSpan(ctx, tracing.Name(spanPrefix, "View"),
+		tracing.WithAttribute("snapshot.key", key),
+		tracing.WithAttribute("snapshot.parent", parent))
+	defer func() {
+		span.SetStatus(retErr)
+		span.End()
+	}()
 	log.L.Infof("[View] snapshot with key %s parent %s", key, parent)
 
 	pID, pInfo, _, err := snapshot.GetSnapshotInfo(ctx, o.ms, parent)
@@ -497,13 +663,13 @@ func (o *snapshotter) View(ctx context.Context, key, parent string, opts ...snap
 
 	if label.IsNydusMetaLayer(pInfo.Labels) {
 		// Nydusd might not be running. We should run nydusd to reflect the rootfs.
-		if err = o.fs.WaitUntilReady(pID); err != nil {
+		if err = o.fs.WaitUntilReady(ctx, pID); err != nil {
 			if errors.Is(err, errdefs.ErrNotFound) {
 				if err := o.fs.Mount(ctx, pID, pInfo.Labels, nil); err != nil {
 					return nil, errors.Wrapf(err, "mount rafs, instance id %s", pID)
 				}
 
-				if err := o.fs.WaitUntilReady(pID); err != nil {
+				if err := o.fs.WaitUntilReady(ctx, pID); err != nil {
 					return nil, errors.Wrapf(err, "wait for instance id %s", pID)
 				}
 			} else {
@@ -543,7 +709,14 @@ func (o *snapshotter) View(ctx context.Context, key, parent string, opts ...snap
 	return o.mountNative(ctx, base.Labels, s)
 }
 
-func (o *snapshotter) Commit(ctx context.Context, name, key string, opts ...snapshots.Opt) error {
+func (o *snapshotter) Commit(ctx context.Context, name, key string, opts ...snapshots.Opt) (retErr error) {
+	ctx, span := tracing.StartSpan(ctx, tracing.Name(spanPrefix, "Commit"),
+		tracing.WithAttribute("snapshot.name", name),
+		tracing.WithAttribute("snapshot.key", key))
+	defer func() {
+		span.SetStatus(retErr)
+		span.End()
+	}()
 	log.L.Debugf("[Commit] snapshot with key %s", key)
 
 	ctx, t, err := o.ms.TransactionContext(ctx, true)
@@ -587,7 +760,13 @@ func (o *snapshotter) Commit(ctx context.Context, name, key string, opts ...snap
 	return err
 }
 
-func (o *snapshotter) Remove(ctx context.Context, key string) error {
+func (o *snapshotter) Remove(ctx context.Context, key string) (retErr error) {
+	ctx, span := tracing.StartSpan(ctx, tracing.Name(spanPrefix, "Remove"),
+		tracing.WithAttribute("snapshot.key", key))
+	defer func() {
+		span.SetStatus(retErr)
+		span.End()
+	}()
 	log.L.Debugf("[Remove] snapshot with key %s", key)
 	if timer := collector.NewSnapshotMetricsTimer(collector.SnapshotMethodRemove); timer != nil {
 		defer timer.ObserveDuration()
@@ -660,7 +839,12 @@ func (o *snapshotter) Remove(ctx context.Context, key string) error {
 	return t.Commit()
 }
 
-func (o *snapshotter) Walk(ctx context.Context, fn snapshots.WalkFunc, fs ...string) error {
+func (o *snapshotter) Walk(ctx context.Context, fn snapshots.WalkFunc, fs ...string) (retErr error) {
+	ctx, span := tracing.StartSpan(ctx, tracing.Name(spanPrefix, "Walk"))
+	defer func() {
+		span.SetStatus(retErr)
+		span.End()
+	}()
 	ctx, t, err := o.ms.TransactionContext(ctx, false)
 	if err != nil {
 		return err
@@ -695,6 +879,31 @@ func (o *snapshotter) Close() error {
 	return o.ms.Close()
 }
 
+// Report blobs of all committed snapshots, their caches must survive cache manager GC.
+func (o *snapshotter) walkCommittedBlobs(ctx context.Context, fn func(blobID string)) error {
+	ctx, t, err := o.ms.TransactionContext(ctx, false)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := t.Rollback(); err != nil {
+			log.L.WithError(err).Warn("failed to rollback transaction")
+		}
+	}()
+
+	return storage.WalkInfo(ctx, func(_ context.Context, info snapshots.Info) error {
+		if info.Kind != snapshots.KindCommitted {
+			return nil
+		}
+		if blobDigest, ok := info.Labels[snpkg.TargetLayerDigestLabel]; ok {
+			if d, err := digest.Parse(blobDigest); err == nil {
+				fn(d.Encoded())
+			}
+		}
+		return nil
+	})
+}
+
 func (o *snapshotter) upperPath(id string) string {
 	return filepath.Join(o.root, "snapshots", id, "fs")
 }